@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -17,6 +18,9 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -26,6 +30,7 @@ import (
 	"infernosim/pkg/bundlev2"
 	"infernosim/pkg/capture"
 	"infernosim/pkg/contract"
+	"infernosim/pkg/dnssim"
 	"infernosim/pkg/event"
 	"infernosim/pkg/generator"
 	"infernosim/pkg/grpcsim"
@@ -43,8 +48,11 @@ import (
 	"infernosim/pkg/simserver"
 	"infernosim/pkg/simtemplate"
 	"infernosim/pkg/stubproxy"
+	"infernosim/pkg/synth"
 	"infernosim/pkg/testgen"
 	"infernosim/pkg/workflow"
+
+	"github.com/quic-go/quic-go/http3"
 )
 
 // Version variables set by goreleaser during build
@@ -98,10 +106,20 @@ func main() {
 		os.Exit(runKafka(os.Args[2:]))
 	case "workflow":
 		os.Exit(runWorkflow(os.Args[2:]))
+	case "ca":
+		os.Exit(runCA(os.Args[2:]))
 	case "lint":
 		os.Exit(runLint(os.Args[2:]))
 	case "match":
 		os.Exit(runMatch(os.Args[2:]))
+	case "minimize":
+		os.Exit(runMinimize(os.Args[2:]))
+	case "slice":
+		os.Exit(runSlice(os.Args[2:]))
+	case "merge":
+		os.Exit(runMerge(os.Args[2:]))
+	case "synth":
+		os.Exit(runSynth(os.Args[2:]))
 	case "version":
 		fmt.Printf("infernosim version %s, commit %s, built at %s by %s\n", version, commit, date, versionBy)
 		os.Exit(0)
@@ -140,8 +158,13 @@ Commands:
   heal     Propose explainable, collision-checked semantic matcher rules
   kafka    Capture, replay, and contract-test Kafka-compatible messages
   workflow Verify ordered HTTP, gRPC, and Kafka causal workflows
+  ca       Create, show, export, rotate, or import the HTTPS MITM CA
   lint     Validate a replay configuration and report design problems
   match    Explain semantic matcher decisions for a captured incident
+  minimize Shrink a failing incident to the smallest reproducer
+  slice    Cut an incident down by time range, path, trace ID, or status
+  merge    Interleave several incidents into one, resequencing events
+  synth    Fabricate a shareable incident with the captured shape, no real values
   version  Print version information
 
 General Flags:
@@ -244,15 +267,23 @@ func runSearch(args []string) {
 }
 
 func runAgent() {
-	mode := flag.String("mode", "inbound", "Mode: 'inbound' or 'proxy'")
+	mode := flag.String("mode", "inbound", "Mode: 'inbound', 'proxy', or 'tcp'")
 	listen := flag.String("listen", "127.0.0.1:8080", "Listen address (default: loopback; use 0.0.0.0 to expose externally)")
-	forward := flag.String("forward", "", "Forward address (inbound mode)")
+	forward := flag.String("forward", "", "Forward address (inbound and tcp modes)")
 	logFile := flag.String("log", "events.log", "Event log file")
 	httpsMode := flag.String("https-mode", "tunnel", "Outbound HTTPS behavior: 'tunnel' or 'mitm'")
+	http3Listen := flag.String("http3-listen", "", "UDP address terminating HTTP/3 dependency calls in proxy mode; requires --https-mode mitm")
+	http3Upstreams := multiFlag{}
+	flag.Var(&http3Upstreams, "http3-upstream", "Upstream of an HTTP/3 dependency resolved to the proxy: host=addr:port (repeatable)")
+	mitmClientCA := flag.String("mitm-client-ca", "", "PEM bundle of CAs; MITM then requires application client certificates and records their identity")
+	caDir := flag.String("ca-dir", "", "MITM CA directory (default ~/.infernosim/ca; manage with infernosim ca)")
+	upstreamClientCerts := multiFlag{}
+	flag.Var(&upstreamClientCerts, "upstream-client-cert", "Client certificate for an mTLS dependency: host=cert.pem,key.pem (repeatable)")
 	injectParam := flag.String("inject", "", "Fault injection config (e.g. jitter=50ms,drop=5%,reset=5%,status=503,rate=10%)")
 	injectSeed := flag.Int64("inject-seed", 0, "Deterministic fault-injection seed (0 uses a random seed)")
 	insecureUpstream := flag.Bool("insecure-upstream", false, "Skip TLS verification for upstream connections (UNSAFE — never use in production)")
-	mitmAllowHosts := flag.String("mitm-allow-hosts", "", "Comma-separated hostnames permitted to receive MITM certs (default: localhost only)")
+	mitmAllowHosts := flag.String("mitm-allow-hosts", "", "Comma-separated host patterns permitted to receive MITM certs, e.g. *.payments.internal (default: localhost only)")
+	hostPolicyPath := flag.String("host-policy", "", "Host policy YAML with per-host intercept, tunnel, or block actions, privacy policies, and body limits")
 	allowPrivate := flag.Bool("allow-private-destinations", false, "Allow proxy access to loopback/private destinations (local development only)")
 	captureSensitive := flag.Bool("capture-sensitive-data", false, "Store raw headers and bodies, including credentials and PII (UNSAFE)")
 	privacyPolicyPath := flag.String("privacy-policy", "", "Privacy policy YAML for redaction and deterministic tokenization")
@@ -281,15 +312,27 @@ func runAgent() {
 	}
 
 	useMITM := (*httpsMode == "mitm")
+	if *mitmClientCA != "" && !useMITM {
+		log.Fatal("--mitm-client-ca requires --https-mode mitm")
+	}
+	clientCerts, clientCAs, err := loadMutualTLS(upstreamClientCerts, *mitmClientCA)
+	if err != nil {
+		log.Fatalf("Failed to load mutual TLS configuration: %v", err)
+	}
+	hostPolicies, allowHosts, err := loadHostPolicies(*hostPolicyPath, *mitmAllowHosts)
+	if err != nil {
+		log.Fatalf("Failed to load host policies: %v", err)
+	}
 	var caStore *capture.CAStore
 	if useMITM {
-		caStore, err = capture.NewCAStore()
+		caStore, err = openCAStore(*caDir)
 		if err != nil {
 			log.Fatalf("Failed to initialize CA store: %v", err)
 		}
-		if *mitmAllowHosts != "" {
-			caStore.AllowedHosts = strings.Split(*mitmAllowHosts, ",")
+		if len(allowHosts) > 0 {
+			caStore.AllowedHosts = allowHosts
 		}
+		caStore.Hosts = hostPolicies
 		log.Println("HTTPS MITM Inspection ENABLED")
 	} else {
 		log.Println("HTTPS Tunneling only (No MITM inspection)")
@@ -304,6 +347,9 @@ func runAgent() {
 		AllowPrivateDestinations: *allowPrivate,
 		CaptureSensitiveData:     *captureSensitive,
 		Privacy:                  privacyPolicy,
+		ClientCertificates:       clientCerts,
+		ClientCAs:                clientCAs,
+		Hosts:                    hostPolicies,
 	}
 
 	stop := make(chan os.Signal, 1)
@@ -332,9 +378,40 @@ func runAgent() {
 			log.Fatalf("Failed to start forward proxy: %v", err)
 		}
 		log.Printf("Outbound proxy active")
+		var http3Server *http3.Server
+		if *http3Listen != "" {
+			if !useMITM {
+				log.Fatal("--http3-listen requires --https-mode mitm")
+			}
+			upstreams, err := parseHTTP3Upstreams(http3Upstreams)
+			if err != nil {
+				log.Fatalf("Invalid --http3-upstream: %v", err)
+			}
+			http3Server, err = capture.StartHTTP3ProxyWithOptions(*http3Listen, ctx, capture.HTTP3Options{Upstreams: upstreams})
+			if err != nil {
+				log.Fatalf("Failed to start HTTP/3 proxy: %v", err)
+			}
+			log.Printf("HTTP/3 proxy active on udp://%s", http3Server.Addr)
+		}
 		<-stop
 		log.Println("Shutting down outbound proxy")
 		_ = server.Close()
+		if http3Server != nil {
+			_ = http3Server.Close()
+		}
+
+	case "tcp":
+		if *forward == "" {
+			log.Fatal("TCP mode requires --forward host:port")
+		}
+		listener, err := capture.StartTCPProxy(*listen, *forward, ctx)
+		if err != nil {
+			log.Fatalf("Failed to start TCP proxy: %v", err)
+		}
+		log.Printf("TCP stream capture active → %s", *forward)
+		<-stop
+		log.Println("Shutting down TCP proxy")
+		_ = listener.Close()
 
 	default:
 		log.Fatalf("Unknown mode: %s", *mode)
@@ -342,16 +419,22 @@ func runAgent() {
 
 	log.Println("InfernoSIM agent stopped")
 }
-func runReplay(args []string) (code int) {
-	summary := NewReplaySummary()
+func runReplay(args []string) int {
+	summary := replayIncident(args)
+	summary.Print()
+	return summary.ExitStatus
+}
+
+// replayIncident parses replay flags, replays the incident, and returns the
+// finalized summary without printing it.
+func replayIncident(args []string) (summary ReplaySummary) {
+	summary = NewReplaySummary()
 	defer func() {
 		if r := recover(); r != nil {
 			summary.PrimaryFailureReason = fmt.Sprintf("panic: %v", r)
 			summary.Outcome = "FAIL_INVALID_ENV"
 		}
 		summary.Finalize()
-		summary.Print()
-		code = summary.ExitStatus
 	}()
 
 	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
@@ -437,6 +520,14 @@ func runReplay(args []string) (code int) {
 	httpsStub := fs.Bool("https-stub", false, "Enable native HTTPS CONNECT response stubbing with the InfernoSIM CA")
 	stubCADir := fs.String("stub-ca-dir", "", "Directory containing the HTTPS stub CA (default: ~/.infernosim/ca)")
 	stubAllowHosts := fs.String("stub-mitm-allow-hosts", "", "Comma-separated HTTPS dependency hosts allowed for TLS stubbing")
+	stubHTTP3Listen := fs.String("stub-http3-listen", "", "UDP address that also serves dependency calls over HTTP/3 (requires HTTPS stubbing)")
+	stubClientCA := fs.String("stub-client-ca", "", "PEM bundle of CAs; HTTPS stubbing then requires and verifies application client certificates")
+	stubHostPolicy := fs.String("stub-host-policy", "", "Host policy YAML whose tunnel and block actions HTTPS stubbing applies before the allowlist")
+	stubLatency := fs.String("stub-latency", "", "Replay captured dependency latency: off, captured, or sampled (default: replay.yaml stub.latency)")
+	stubTCPFlags := multiFlag{}
+	fs.Var(&stubTCPFlags, "stub-tcp", "Replay raw TCP streams captured for TARGET on LISTEN: LISTEN=TARGET (repeatable)")
+	stubDNSListen := fs.String("stub-dns-listen", "", "UDP address for a DNS stub answering names observed in the incident")
+	stubDNSAnswer := fs.String("stub-dns-answer", "", "IP address the DNS stub answers observed names with (default 127.0.0.1)")
 	diff := fs.Bool("diff", false, "Show detailed differences between captured and replayed events")
 	safeMode := fs.Bool("safe-mode", true, "Skip non-idempotent requests (POST/PUT/PATCH/DELETE) during replay")
 	allowWrites := fs.Bool("allow-writes", false, "Permit replay of POST/PUT/PATCH/DELETE requests (requires an explicit safe target)")
@@ -445,12 +536,27 @@ func runReplay(args []string) (code int) {
 	reportFormats := fs.String("report-formats", "", "Comma-separated report formats: junit,sarif,html")
 	reportDir := fs.String("report-dir", "", "Report output directory (default: <incident>/reports)")
 
+	loadProfile := fs.String("load-profile", "", "Open-loop load profile instead of timing replay: constant, ramp, step, or poisson")
+	loadRPS := fs.Float64("load-rps", 0, "Target requests per second for constant and poisson load profiles")
+	loadStartRPS := fs.Float64("load-start-rps", 0, "Starting requests per second for the ramp load profile")
+	loadEndRPS := fs.Float64("load-end-rps", 0, "Final requests per second for the ramp load profile")
+	loadSteps := fs.String("load-steps", "", `Step load profile stages, e.g. "10@30s,50@30s"`)
+	loadDuration := fs.Duration("load-duration", 0, "Length of constant, ramp, and poisson load profiles")
+	loadSeed := fs.Int64("load-seed", 0, "Seed for Poisson arrivals and request-mix sampling")
+	loadMaxInFlight := fs.Int("load-max-in-flight", 0, "Maximum concurrent load requests; arrivals beyond it are dropped (0 = 256)")
+
 	injectFlags := multiFlag{}
 	fs.Var(
 		&injectFlags,
 		"inject",
 		`Injection rule, e.g. --inject "dep=worldtimeapi.org latency=+200ms"`,
 	)
+	latencyFlags := multiFlag{}
+	fs.Var(
+		&latencyFlags,
+		"latency-threshold",
+		`Latency gate, e.g. "p99<=250ms", "p90<=+20%", or "GET /orders/{id} p99<=+25% min-delta=5ms"`,
+	)
 
 	if err := fs.Parse(args); err != nil {
 		summary.PrimaryFailureReason = fmt.Sprintf("Flag parse error: %v", err)
@@ -502,6 +608,11 @@ func runReplay(args []string) (code int) {
 	var scenariosCfg []scenario.Config
 	var templatesCfg simtemplate.Config
 	var httpsCfg replaydriver.HTTPSStubConfig
+	var latencyCfg replaydriver.StubLatencyConfig
+	var tcpCfg replaydriver.StubTCPConfig
+	var dnsCfg replaydriver.StubDNSConfig
+	var loadCfg replaydriver.LoadConfig
+	var latencyThresholds []replaydriver.LatencyThreshold
 	if resolvedConfigFile != "" {
 		yamlCfg, err := replaydriver.LoadReplayConfig(resolvedConfigFile)
 		if err != nil {
@@ -527,6 +638,11 @@ func runReplay(args []string) (code int) {
 		scenariosCfg = yamlCfg.Scenarios
 		templatesCfg = yamlCfg.Templates
 		httpsCfg = yamlCfg.Stub.HTTPS
+		latencyCfg = yamlCfg.Stub.Latency
+		tcpCfg = yamlCfg.Stub.TCP
+		dnsCfg = yamlCfg.Stub.DNS
+		loadCfg = yamlCfg.Load
+		latencyThresholds, _ = yamlCfg.Latency.LatencyThresholds()
 		if yamlCfg.State.File != "" {
 			statePath := yamlCfg.State.File
 			if !filepath.IsAbs(statePath) {
@@ -544,6 +660,53 @@ func runReplay(args []string) (code int) {
 	if *stubAllowHosts != "" {
 		httpsCfg.AllowHosts = splitNonEmpty(*stubAllowHosts)
 	}
+	if *stubClientCA != "" {
+		httpsCfg.ClientCA = *stubClientCA
+	}
+	if *stubHostPolicy != "" {
+		httpsCfg.HostPolicy = *stubHostPolicy
+	}
+	if *stubLatency != "" {
+		latencyCfg.Mode = *stubLatency
+	}
+	if latencyCfg.Scale == 0 {
+		latencyCfg.Scale = *timeScale
+	}
+	tcpCfg.Listen = append(tcpCfg.Listen, stubTCPFlags...)
+	if *stubDNSListen != "" {
+		dnsCfg.Listen = *stubDNSListen
+	}
+	if *stubDNSAnswer != "" {
+		dnsCfg.Answer = *stubDNSAnswer
+	}
+	streamMasks := make([]stubproxy.StreamMask, 0, len(tcpCfg.Ignore))
+	for _, ignore := range tcpCfg.Ignore {
+		streamMasks = append(streamMasks, stubproxy.StreamMask{Host: ignore.Host, Offset: ignore.Offset, Length: ignore.Length})
+	}
+	for _, spec := range latencyFlags {
+		threshold, err := replaydriver.ParseLatencyThreshold(spec)
+		if err != nil {
+			summary.PrimaryFailureReason = err.Error()
+			summary.Outcome = "FAIL_INVALID_ENV"
+			return
+		}
+		latencyThresholds = append(latencyThresholds, threshold)
+	}
+	loadRun, err := resolveLoadConfig(fs, loadCfg, loadFlags{
+		profile:     *loadProfile,
+		rps:         *loadRPS,
+		startRPS:    *loadStartRPS,
+		endRPS:      *loadEndRPS,
+		steps:       *loadSteps,
+		duration:    *loadDuration,
+		seed:        *loadSeed,
+		maxInFlight: *loadMaxInFlight,
+	})
+	if err != nil {
+		summary.PrimaryFailureReason = err.Error()
+		summary.Outcome = "FAIL_INVALID_ENV"
+		return
+	}
 
 	executeReplay(replayExecutionInput{
 		Runs:          *runs,
@@ -571,11 +734,79 @@ func runReplay(args []string) (code int) {
 		Scenarios:     scenariosCfg,
 		Templates:     templatesCfg,
 		HTTPSStub:     httpsCfg,
+		StubHTTP3:     *stubHTTP3Listen,
+		StubLatency:   stubproxy.LatencyOptions{Mode: latencyCfg.Mode, Scale: latencyCfg.Scale},
+		StubTCP:       tcpCfg.Listen,
+		StreamMasks:   streamMasks,
+		StubDNS:       dnsCfg,
 		OpenAPIFile:   *openAPIFile,
+		Load:          loadRun,
+		Latency:       latencyThresholds,
 	}, &summary)
 	return
 }
 
+type loadFlags struct {
+	profile     string
+	rps         float64
+	startRPS    float64
+	endRPS      float64
+	steps       string
+	duration    time.Duration
+	seed        int64
+	maxInFlight int
+}
+
+// resolveLoadConfig merges the replay.yaml load section with explicitly set
+// --load-* flags. It returns nil when no load profile was requested.
+func resolveLoadConfig(fs *flag.FlagSet, cfg replaydriver.LoadConfig, flags loadFlags) (*replaydriver.OpenLoopConfig, error) {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	profile, err := cfg.LoadProfile()
+	if err != nil {
+		return nil, err
+	}
+	seed := cfg.Seed
+	maxInFlight := cfg.MaxInFlight
+	if set["load-profile"] {
+		profile.Kind = strings.ToLower(strings.TrimSpace(flags.profile))
+	}
+	if set["load-rps"] {
+		profile.RPS = flags.rps
+	}
+	if set["load-start-rps"] {
+		profile.StartRPS = flags.startRPS
+	}
+	if set["load-end-rps"] {
+		profile.EndRPS = flags.endRPS
+	}
+	if set["load-duration"] {
+		profile.Duration = flags.duration
+	}
+	if set["load-steps"] {
+		profile.Stages, err = replaydriver.ParseLoadStages(flags.steps)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if set["load-seed"] {
+		seed = flags.seed
+	}
+	if set["load-max-in-flight"] {
+		maxInFlight = flags.maxInFlight
+	}
+	if profile.Kind == "" {
+		return nil, nil
+	}
+	if err := profile.Validate(); err != nil {
+		return nil, err
+	}
+	if maxInFlight < 0 {
+		return nil, fmt.Errorf("load max in-flight must be >= 0")
+	}
+	return &replaydriver.OpenLoopConfig{Profile: profile, Seed: seed, MaxInFlight: maxInFlight}, nil
+}
+
 /*
 HELPER: multi-value --inject flag
 */
@@ -663,6 +894,15 @@ type ReplaySummary struct {
 	Findings               []reporting.Finding
 	ReportFormats          []string
 	ReportDir              string
+	LoadProfile            string
+	LoadPlanned            int
+	LoadDropped            int
+	LoadErrors             int
+	LoadLatency            *replaydriver.LatencyHistogram
+	LoadEndpoints          *replaydriver.EndpointHistograms
+	BaselineLatency        []replaydriver.LatencyStat
+	RunLatency             [][]replaydriver.LatencyStat
+	LatencyViolations      []string
 }
 
 type ReplaySnapshot struct {
@@ -673,6 +913,8 @@ type ReplaySnapshot struct {
 	OutboundObserved int       `json:"outbound_observed"`
 	OutboundTarget   int       `json:"outbound_target"`
 	MaxLatencyMS     int64     `json:"max_latency_ms"`
+	// Latency holds baseline and per-run endpoint percentiles.
+	Latency []reporting.LatencyRow `json:"latency,omitempty"`
 }
 
 type replayExecutionInput struct {
@@ -701,7 +943,14 @@ type replayExecutionInput struct {
 	Scenarios     []scenario.Config
 	Templates     simtemplate.Config
 	HTTPSStub     replaydriver.HTTPSStubConfig
+	StubHTTP3     string
+	StubLatency   stubproxy.LatencyOptions
+	StubTCP       []string
+	StreamMasks   []stubproxy.StreamMask
+	StubDNS       replaydriver.StubDNSConfig
 	OpenAPIFile   string
+	Load          *replaydriver.OpenLoopConfig
+	Latency       []replaydriver.LatencyThreshold
 }
 
 func NewReplaySummary() ReplaySummary {
@@ -741,6 +990,8 @@ func (s *ReplaySummary) Print() {
 			Summary:   primaryFailureOrNone(s.PrimaryFailureReason),
 			Generated: time.Now().UTC(),
 			Findings:  s.Findings,
+			Latency:   latencyRows(s),
+			BodyDiffs: bodyDiffRows(s.DiffResults),
 		})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "report generation failed: %v\n", err)
@@ -791,6 +1042,7 @@ func executeReplay(input replayExecutionInput, summary *ReplaySummary) {
 	if input.MaxEvents > 0 && len(events) > input.MaxEvents {
 		events = events[:input.MaxEvents]
 	}
+	summary.BaselineLatency = replaydriver.LatencyStats(events)
 	var openAPIValidator *contract.Validator
 	if input.OpenAPIFile != "" {
 		openAPIValidator, err = contract.Load(input.OpenAPIFile)
@@ -809,6 +1061,24 @@ func executeReplay(input replayExecutionInput, summary *ReplaySummary) {
 	}
 	summary.TargetInbound = len(events) * input.Runs * input.Fanout
 	summary.TargetOutbound = expectedOutboundPerReplay * input.Runs * input.Fanout
+	if input.Load != nil {
+		arrivals, err := input.Load.Profile.Arrivals(input.Load.Seed)
+		if err != nil {
+			summary.PrimaryFailureReason = fmt.Sprintf("Invalid load profile: %v", err)
+			summary.Outcome = "FAIL_INVALID_ENV"
+			return
+		}
+		input.Load.SafeMode = input.SafeMode
+		input.Load.StateAdapters = input.StateAdapters
+		summary.LoadProfile = input.Load.Profile.Kind
+		summary.LoadPlanned = len(arrivals) * input.Runs
+		summary.LoadLatency = replaydriver.NewLatencyHistogram()
+		summary.LoadEndpoints = replaydriver.NewEndpointHistograms()
+		summary.TargetInbound = summary.LoadPlanned
+		// Sampled mixes do not preserve per-event call counts, so outbound
+		// coverage is verified without enforcing captured cardinality.
+		summary.TargetOutbound = expectedOutboundPerReplay * input.Runs
+	}
 	summary.OutboundEventsExpected = summary.TargetOutbound
 
 	rules, err := inject.ParseRules(input.InjectFlags)
@@ -827,6 +1097,11 @@ func executeReplay(input replayExecutionInput, summary *ReplaySummary) {
 		}
 	}
 
+	if input.StubHTTP3 != "" && !input.HTTPSStub.Enabled {
+		summary.PrimaryFailureReason = "--stub-http3-listen requires HTTPS stubbing (--https-stub)"
+		summary.Outcome = "FAIL_INVALID_ENV"
+		return
+	}
 	// Do not append observed replay traffic into the captured outbound incident log.
 	var stubCA *capture.CAStore
 	if input.HTTPSStub.Enabled {
@@ -841,13 +1116,37 @@ func executeReplay(input replayExecutionInput, summary *ReplaySummary) {
 			return
 		}
 		stubCA.AllowedHosts = input.HTTPSStub.AllowHosts
+		if input.HTTPSStub.HostPolicy != "" {
+			if stubCA.Hosts, err = capture.LoadHostPolicies(input.HTTPSStub.HostPolicy); err != nil {
+				summary.PrimaryFailureReason = fmt.Sprintf("HTTPS stub host policy: %v", err)
+				summary.Outcome = "FAIL_INVALID_ENV"
+				return
+			}
+		}
 		fmt.Printf("HTTPS dependency stubbing enabled; trust CA %s\n", stubCA.CertificatePath())
 	}
+	var stubClientCAs *x509.CertPool
+	if input.HTTPSStub.ClientCA != "" {
+		if stubCA == nil {
+			summary.PrimaryFailureReason = "stub client CA requires HTTPS stubbing (--https-stub)"
+			summary.Outcome = "FAIL_INVALID_ENV"
+			return
+		}
+		stubClientCAs, err = capture.LoadCertPool(input.HTTPSStub.ClientCA)
+		if err != nil {
+			summary.PrimaryFailureReason = fmt.Sprintf("HTTPS stub client CA load failed: %v", err)
+			summary.Outcome = "FAIL_INVALID_ENV"
+			return
+		}
+	}
 	stub, err := stubproxy.NewWithOptions(input.OutboundLog, "", rules, stubproxy.Options{
-		Matching:  input.Matching,
-		Scenarios: input.Scenarios,
-		Templates: input.Templates,
-		TLSCA:     stubCA,
+		Matching:    input.Matching,
+		Scenarios:   input.Scenarios,
+		Templates:   input.Templates,
+		TLSCA:       stubCA,
+		ClientCAs:   stubClientCAs,
+		Latency:     input.StubLatency,
+		StreamMasks: input.StreamMasks,
 	})
 	if err != nil {
 		summary.PrimaryFailureReason = fmt.Sprintf("Stub proxy init failed: %v", err)
@@ -889,13 +1188,85 @@ func executeReplay(input replayExecutionInput, summary *ReplaySummary) {
 			return
 		}
 		stubServer := &http.Server{Handler: stub.Handler()}
-		if err := stubServer.Serve(listener); err != nil && !isExpectedShutdownErr(err) {
+		if err := stubServer.Serve(capture.ListenSOCKS5(listener, stub.SOCKSConnect)); err != nil && !isExpectedShutdownErr(err) {
 			log.Printf("Stub proxy error: %v", err)
 		}
 	}()
 	defer func() {
 		_ = listener.Close()
 	}()
+	if input.StubHTTP3 != "" {
+		http3Conn, err := net.ListenPacket("udp", input.StubHTTP3)
+		if err != nil {
+			summary.ProxyStatus = "FAILED"
+			summary.PrimaryFailureReason = fmt.Sprintf("Stub HTTP/3 bind failed: %v", err)
+			summary.Outcome = "FAIL_INVALID_ENV"
+			return
+		}
+		http3Server := stubproxy.NewHTTP3Server(stub, stubCA, stubClientCAs)
+		go func() {
+			log.Printf("Stub proxy HTTP/3 active on udp://%s", input.StubHTTP3)
+			if err := http3Server.Serve(http3Conn); err != nil && !isExpectedShutdownErr(err) {
+				log.Printf("Stub proxy HTTP/3 error: %v", err)
+			}
+		}()
+		defer func() {
+			_ = http3Server.Close()
+			_ = http3Conn.Close()
+		}()
+	}
+	for _, spec := range input.StubTCP {
+		tcpListen, target, err := splitListenTarget(spec)
+		if err != nil {
+			summary.ProxyStatus = "FAILED"
+			summary.PrimaryFailureReason = fmt.Sprintf("Stub TCP listener: %v", err)
+			summary.Outcome = "FAIL_INVALID_ENV"
+			return
+		}
+		tcpListener, err := net.Listen("tcp", tcpListen)
+		if err != nil {
+			summary.ProxyStatus = "FAILED"
+			summary.PrimaryFailureReason = fmt.Sprintf("Stub TCP bind failed: %v", err)
+			summary.Outcome = "FAIL_INVALID_ENV"
+			return
+		}
+		go func() {
+			log.Printf("Stub TCP streams for %s active on %s", target, tcpListen)
+			if err := stub.ServeTCP(tcpListener, target); err != nil && !isExpectedShutdownErr(err) {
+				log.Printf("Stub TCP error: %v", err)
+			}
+		}()
+		defer func() {
+			_ = tcpListener.Close()
+		}()
+	}
+	if input.StubDNS.Listen != "" {
+		resolver, err := newDNSStub(input.OutboundLog, input.StubDNS, stubListen, input.StubLatency)
+		if err != nil {
+			summary.PrimaryFailureReason = fmt.Sprintf("DNS stub init failed: %v", err)
+			summary.Outcome = "FAIL_INVALID_ENV"
+			return
+		}
+		dnsConn, err := net.ListenPacket("udp", input.StubDNS.Listen)
+		if err != nil {
+			summary.ProxyStatus = "FAILED"
+			summary.PrimaryFailureReason = fmt.Sprintf("DNS stub bind failed: %v", err)
+			summary.Outcome = "FAIL_INVALID_ENV"
+			return
+		}
+		go func() {
+			log.Printf("DNS stub answering %d observed names on udp://%s", len(resolver.Names()), input.StubDNS.Listen)
+			if err := resolver.Serve(dnsConn); err != nil && !isExpectedShutdownErr(err) {
+				log.Printf("DNS stub error: %v", err)
+			}
+		}()
+		defer func() {
+			_ = dnsConn.Close()
+			if misses := resolver.Misses(); len(misses) > 0 {
+				log.Printf("DNS stub answered NXDOMAIN for names not observed in the incident: %s", strings.Join(misses, ", "))
+			}
+		}()
+	}
 	if !summary.TransparentMode {
 		compatListen := strings.TrimSpace(input.StubCompat)
 		if compatListen != "" && compatListen != stubListen {
@@ -906,7 +1277,7 @@ func executeReplay(input replayExecutionInput, summary *ReplaySummary) {
 				go func() {
 					log.Printf("Stub proxy compat active on %s", compatListen)
 					stubServer := &http.Server{Handler: stub.Handler()}
-					if err := stubServer.Serve(compatListener); err != nil && !isExpectedShutdownErr(err) {
+					if err := stubServer.Serve(capture.ListenSOCKS5(compatListener, stub.SOCKSConnect)); err != nil && !isExpectedShutdownErr(err) {
 						log.Printf("Stub proxy compat error: %v", err)
 					}
 				}()
@@ -931,6 +1302,13 @@ func executeReplay(input replayExecutionInput, summary *ReplaySummary) {
 			summary.PrimaryFailureReason = "Replay exceeded max wall time before run start"
 			break
 		}
+		if input.Load != nil {
+			stub.ConfigureReplayCardinality(true, expectedOutboundPerReplay*summary.LoadPlanned)
+			if !executeOpenLoopRun(input, events, stub, i, runStart, summary) {
+				break
+			}
+			continue
+		}
 
 		type replayWaveResult struct {
 			result replaydriver.ReplayResult
@@ -966,6 +1344,7 @@ func executeReplay(input replayExecutionInput, summary *ReplaySummary) {
 		summary.RunsExecuted++
 		waveComplete := true
 		waveInbound := 0
+		var waveReplayed []event.Event
 		for wr := range results {
 			if wr.err != nil {
 				summary.PrimaryFailureReason = fmt.Sprintf("Replay failed: %v", wr.err)
@@ -982,6 +1361,7 @@ func executeReplay(input replayExecutionInput, summary *ReplaySummary) {
 				waveComplete = false
 			}
 			waveInbound += wr.result.CompletedEvents
+			waveReplayed = append(waveReplayed, wr.result.ReplayedEvents...)
 			if !referenceSet {
 				referenceFingerprint = wr.result.Fingerprint
 				referenceSet = true
@@ -992,7 +1372,7 @@ func executeReplay(input replayExecutionInput, summary *ReplaySummary) {
 			if input.Diff && len(wr.result.ReplayedEvents) > 0 {
 				for idx, replayed := range wr.result.ReplayedEvents {
 					if idx < len(events) {
-						d := replaydriver.CompareEvents(events[idx], replayed, idx+1)
+						d := replaydriver.CompareEventsWithOptions(events[idx], replayed, idx+1, diffOptions(input.Matching.IgnoredJSONPaths))
 						if d != nil {
 							summary.DiffResults = append(summary.DiffResults, d)
 						}
@@ -1006,6 +1386,7 @@ func executeReplay(input replayExecutionInput, summary *ReplaySummary) {
 			}
 		}
 		summary.InboundEventsReplayed += waveInbound
+		summary.RunLatency = append(summary.RunLatency, replaydriver.LatencyStats(waveReplayed))
 		outboundObserved := stub.ObservedCount()
 		summary.OutboundEventsObserved += outboundObserved
 		summary.DependenciesExercised = summary.OutboundEventsObserved > 0
@@ -1043,6 +1424,7 @@ func executeReplay(input replayExecutionInput, summary *ReplaySummary) {
 			summary.PrimaryFailureReason = fmt.Sprintf("%d replay response divergence(s) detected", len(summary.DiffResults))
 		}
 	}
+	applyLatencyThresholds(summary, input.Latency)
 	if len(summary.Findings) > 0 && !strings.HasPrefix(summary.Outcome, "FAIL_") {
 		summary.Outcome = "FAIL_CONTRACT_DRIFT"
 		summary.PrimaryFailureReason = fmt.Sprintf("%d OpenAPI or contract drift finding(s) detected", len(summary.Findings))
@@ -1079,6 +1461,141 @@ func executeReplay(input replayExecutionInput, summary *ReplaySummary) {
 	}
 }
 
+// executeOpenLoopRun performs one open-loop load run and folds its counts and
+// latency histograms into summary. It returns false when the run failed.
+func executeOpenLoopRun(input replayExecutionInput, events []event.Event, stub *stubproxy.StubProxy, runIndex int, runStart time.Time, summary *ReplaySummary) bool {
+	summary.RunsExecuted++
+	result, err := replaydriver.ReplayOpenLoop(events, input.TargetBase, *input.Load)
+	if err != nil {
+		summary.PrimaryFailureReason = fmt.Sprintf("Load replay failed: %v", err)
+		summary.Outcome = "FAIL_STALLED"
+		return false
+	}
+	summary.InboundEventsReplayed += result.Completed
+	summary.LoadDropped += result.Dropped
+	summary.LoadErrors += result.Errors
+	summary.LoadLatency.Merge(result.Latency)
+	summary.LoadEndpoints.Merge(result.Endpoints)
+	summary.RunLatency = append(summary.RunLatency, result.Endpoints.Stats())
+	outboundObserved := stub.ObservedCount()
+	summary.OutboundEventsObserved += outboundObserved
+	summary.DependenciesExercised = summary.OutboundEventsObserved > 0
+	if stub.ForwardErrors() > 0 {
+		summary.PrimaryFailureReason = "Proxy forwarding failed"
+		summary.Outcome = "FAIL_PROXY_FORWARDING"
+		return false
+	}
+	completed := true
+	if reasons := stub.DivergenceReasons(); len(reasons) > 0 {
+		summary.PrimaryFailureReason = reasons[0]
+		summary.Outcome = "FAIL_NON_DETERMINISTIC"
+		completed = false
+	}
+	if completed {
+		summary.RunsCompleted++
+	}
+	summary.Outcomes = append(summary.Outcomes, ReplayOutcome{
+		RunIndex:        runIndex + 1,
+		TotalEvents:     result.Planned,
+		CompletedEvents: result.Completed,
+		WallTime:        time.Since(runStart),
+		Completed:       completed,
+		Detail: fmt.Sprintf(
+			"load=%s sent=%d dropped=%d errors=%d achieved=%.2f req/s",
+			input.Load.Profile.Kind, result.Sent, result.Dropped, result.Errors, result.AchievedRPS,
+		),
+	})
+	return completed
+}
+
+// applyLatencyThresholds checks every run's endpoint percentiles against the
+// configured thresholds and fails the replay on any violation.
+func applyLatencyThresholds(summary *ReplaySummary, thresholds []replaydriver.LatencyThreshold) {
+	if len(thresholds) == 0 {
+		return
+	}
+	for runIndex, stats := range summary.RunLatency {
+		for _, violation := range replaydriver.CheckLatency(summary.BaselineLatency, stats, thresholds) {
+			reason := fmt.Sprintf("run %d: %s", runIndex+1, violation.Reason)
+			summary.LatencyViolations = append(summary.LatencyViolations, reason)
+			summary.Findings = append(summary.Findings, reporting.Finding{
+				RuleID:   "INFERNOSIM_LATENCY_REGRESSION",
+				Level:    "error",
+				Title:    "Latency threshold exceeded: " + violation.Threshold.String(),
+				Message:  reason,
+				Location: violation.Endpoint,
+			})
+		}
+	}
+	if len(summary.LatencyViolations) > 0 && !strings.HasPrefix(summary.Outcome, "FAIL_") {
+		summary.Outcome = "FAIL_LATENCY_REGRESSION"
+		summary.PrimaryFailureReason = summary.LatencyViolations[0]
+	}
+}
+
+// latencyRows flattens baseline and per-run percentiles for reports and the
+// saved replay snapshot.
+func latencyRows(summary *ReplaySummary) []reporting.LatencyRow {
+	var rows []reporting.LatencyRow
+	appendStats := func(scope string, stats []replaydriver.LatencyStat) {
+		for _, stat := range stats {
+			rows = append(rows, reporting.LatencyRow{
+				Scope:    scope,
+				Endpoint: stat.Endpoint,
+				Count:    stat.Count,
+				P50MS:    durationMS(stat.P50),
+				P90MS:    durationMS(stat.P90),
+				P99MS:    durationMS(stat.P99),
+				MaxMS:    durationMS(stat.Max),
+			})
+		}
+	}
+	appendStats("baseline", summary.BaselineLatency)
+	for i, stats := range summary.RunLatency {
+		appendStats(fmt.Sprintf("run %d", i+1), stats)
+	}
+	return rows
+}
+
+// bodyDiffRows lists the field-level JSON differences of response diffs for
+// reports.
+func bodyDiffRows(diffs []*replaydriver.EventDiff) []reporting.BodyDiff {
+	var rows []reporting.BodyDiff
+	for _, d := range diffs {
+		if len(d.JSONChanges) == 0 {
+			continue
+		}
+		row := reporting.BodyDiff{Request: fmt.Sprintf("#%d %s %s", d.Index, d.Captured.Method, d.Captured.URL)}
+		for _, change := range d.JSONChanges {
+			row.Changes = append(row.Changes, reporting.FieldChange(change))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// diffOptions ignores the given JSONPaths and the fields heal classifies as
+// volatile when comparing replayed response bodies.
+func diffOptions(ignoredJSONPaths []string) replaydriver.DiffOptions {
+	return replaydriver.DiffOptions{IgnoredJSONPaths: ignoredJSONPaths, Volatile: heal.VolatileField}
+}
+
+func durationMS(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func latencyStatLine(stat replaydriver.LatencyStat) string {
+	return fmt.Sprintf(
+		"  %s: n=%d p50=%s p90=%s p99=%s max=%s",
+		stat.Endpoint,
+		stat.Count,
+		stat.P50.Round(time.Microsecond),
+		stat.P90.Round(time.Microsecond),
+		stat.P99.Round(time.Microsecond),
+		stat.Max.Round(time.Microsecond),
+	)
+}
+
 func computeOutcome(summary *ReplaySummary) string {
 	if strings.HasPrefix(summary.Outcome, "FAIL_") {
 		return summary.Outcome
@@ -1173,10 +1690,47 @@ func buildSummaryLines(summary *ReplaySummary) []string {
 		fmt.Sprintf("- Fanout: %s", summary.DeltaFanout),
 		fmt.Sprintf("- Achieved rate: %s", summary.DeltaRate),
 		fmt.Sprintf("- Outbound completion: %s", summary.DeltaOutbound),
-		"",
-		"WHAT THIS RUN DID NOT TEST",
 	}
 
+	if summary.LoadProfile != "" {
+		lines = append(lines,
+			"",
+			"OPEN-LOOP LOAD",
+			fmt.Sprintf("- Profile: %s", summary.LoadProfile),
+			fmt.Sprintf("- Planned requests: %d", summary.LoadPlanned),
+			fmt.Sprintf("- Dropped (in-flight limit): %d", summary.LoadDropped),
+			fmt.Sprintf("- Errors: %d", summary.LoadErrors),
+		)
+		if summary.LoadLatency != nil && summary.LoadLatency.Count() > 0 {
+			lines = append(lines, fmt.Sprintf("- All endpoints: %s", summary.LoadLatency.Summary()))
+		}
+		if summary.LoadEndpoints != nil {
+			for _, endpoint := range summary.LoadEndpoints.Sorted() {
+				lines = append(lines, fmt.Sprintf("- %s: %s", endpoint.Endpoint, endpoint.Histogram.Summary()))
+			}
+		}
+	}
+
+	if len(summary.BaselineLatency) > 0 || len(summary.RunLatency) > 0 {
+		lines = append(lines, "", "LATENCY BY ENDPOINT (p50/p90/p99/max)", "- Captured baseline:")
+		if len(summary.BaselineLatency) == 0 {
+			lines = append(lines, "  none (no captured responses)")
+		}
+		for _, stat := range summary.BaselineLatency {
+			lines = append(lines, latencyStatLine(stat))
+		}
+		for i, stats := range summary.RunLatency {
+			lines = append(lines, fmt.Sprintf("- Run %d:", i+1))
+			for _, stat := range stats {
+				lines = append(lines, latencyStatLine(stat))
+			}
+		}
+		for _, violation := range summary.LatencyViolations {
+			lines = append(lines, fmt.Sprintf("- Threshold violated: %s", violation))
+		}
+	}
+
+	lines = append(lines, "", "WHAT THIS RUN DID NOT TEST")
 	if len(summary.WhatNotTested) == 0 {
 		lines = append(lines, "- None")
 	} else {
@@ -1237,6 +1791,8 @@ func recommendationForOutcome(outcome string) string {
 		return "Lower fanout or increase window; then inspect app saturation limits and outbound dependency latency."
 	case "FAIL_CONTRACT_DRIFT":
 		return "Review the OpenAPI and response-drift findings before accepting the release."
+	case "FAIL_LATENCY_REGRESSION":
+		return "Profile the regressed endpoints or adjust the latency thresholds if the change is expected."
 	default:
 		return "Inspect logs for additional details."
 	}
@@ -1344,6 +1900,8 @@ func exitCodeFromOutcome(outcome string) int {
 		return 1
 	case "FAIL_SLO_MISSED":
 		return 1
+	case "FAIL_LATENCY_REGRESSION":
+		return 1
 	default:
 		return 2
 	}
@@ -1411,6 +1969,7 @@ func saveReplaySnapshot(summary *ReplaySummary) {
 		OutboundObserved: summary.OutboundEventsObserved,
 		OutboundTarget:   summary.TargetOutbound,
 		MaxLatencyMS:     summary.MaxInjectedLatency.Milliseconds(),
+		Latency:          latencyRows(summary),
 	}
 	b, err := json.MarshalIndent(s, "", "  ")
 	if err != nil {
@@ -1427,25 +1986,65 @@ func runRecord(args []string) int {
 	fs := flag.NewFlagSet("record", flag.ContinueOnError)
 	listen := fs.String("listen", "127.0.0.1:8080", "Listen address for inbound proxy (default: loopback; use 0.0.0.0 to expose externally)")
 	outboundListen := fs.String("outbound-listen", "127.0.0.1:8084", "Forward-proxy address for capturing application dependencies (empty disables)")
+	outboundHTTP3Listen := fs.String("outbound-http3-listen", "", "UDP address terminating HTTP/3 dependency calls; requires --https-mode mitm (empty disables)")
+	outboundHTTP3Upstreams := multiFlag{}
+	fs.Var(&outboundHTTP3Upstreams, "outbound-http3-upstream", "Upstream of an HTTP/3 dependency resolved to the proxy: host=addr:port (repeatable)")
+	mitmClientCA := fs.String("mitm-client-ca", "", "PEM bundle of CAs; MITM then requires application client certificates and records their identity")
+	caDir := fs.String("ca-dir", "", "MITM CA directory (default ~/.infernosim/ca; manage with infernosim ca)")
+	upstreamClientCerts := multiFlag{}
+	fs.Var(&upstreamClientCerts, "upstream-client-cert", "Client certificate for an mTLS dependency: host=cert.pem,key.pem (repeatable)")
 	forward := fs.String("forward", "", "Backend host:port to forward to (required)")
 	out := fs.String("out", "./incident", "Output directory for the incident bundle")
 	env := fs.String("env", "", "Environment label (e.g. production, staging)")
 	httpsMode := fs.String("https-mode", "tunnel", "HTTPS mode: tunnel or mitm")
 	insecureUpstream := fs.Bool("insecure-upstream", false, "Skip TLS verification for upstream connections (UNSAFE)")
-	mitmAllowHosts := fs.String("mitm-allow-hosts", "", "Comma-separated hostnames permitted to receive MITM certs (default: localhost only)")
+	mitmAllowHosts := fs.String("mitm-allow-hosts", "", "Comma-separated host patterns permitted to receive MITM certs, e.g. *.payments.internal (default: localhost only)")
+	hostPolicyPath := fs.String("host-policy", "", "Host policy YAML with per-host intercept, tunnel, or block actions, privacy policies, and body limits")
 	allowPrivate := fs.Bool("allow-private-destinations", false, "Allow outbound capture to reach loopback/private destinations (local development only)")
 	captureSensitive := fs.Bool("capture-sensitive-data", false, "Store raw headers and bodies, including credentials and PII (UNSAFE)")
 	privacyPolicyPath := fs.String("privacy-policy", "", "Privacy policy YAML for redaction and deterministic tokenization")
 	appendLogs := fs.Bool("append", false, "Append to an existing incident bundle instead of requiring empty logs")
+	metricsListen := fs.String("metrics-listen", "", "Address to serve Prometheus metrics on /metrics (empty disables)")
+	tcpFlags := multiFlag{}
+	fs.Var(&tcpFlags, "tcp", "Record raw TCP streams: accept on LISTEN and relay to TARGET, LISTEN=TARGET (repeatable)")
 
 	if err := fs.Parse(args); err != nil {
 		fmt.Fprintf(os.Stderr, "record: %v\n", err)
 		return 1
 	}
+	for _, spec := range tcpFlags {
+		if _, _, err := splitListenTarget(spec); err != nil {
+			fmt.Fprintf(os.Stderr, "record: --tcp %v\n", err)
+			return 1
+		}
+	}
 	if *forward == "" {
 		fmt.Fprintln(os.Stderr, "record: --forward host:port is required")
 		return 1
 	}
+	if strings.TrimSpace(*outboundHTTP3Listen) != "" && *httpsMode != "mitm" {
+		fmt.Fprintln(os.Stderr, "record: --outbound-http3-listen requires --https-mode mitm")
+		return 1
+	}
+	outboundUpstreams, err := parseHTTP3Upstreams(outboundHTTP3Upstreams)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "record: --outbound-http3-upstream: %v\n", err)
+		return 1
+	}
+	if *mitmClientCA != "" && *httpsMode != "mitm" {
+		fmt.Fprintln(os.Stderr, "record: --mitm-client-ca requires --https-mode mitm")
+		return 1
+	}
+	clientCerts, clientCAs, err := loadMutualTLS(upstreamClientCerts, *mitmClientCA)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "record: %v\n", err)
+		return 1
+	}
+	hostPolicies, allowHosts, err := loadHostPolicies(*hostPolicyPath, *mitmAllowHosts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "record: %v\n", err)
+		return 1
+	}
 
 	if *insecureUpstream {
 		fmt.Fprintln(os.Stderr, "\u26a0️  WARNING: --insecure-upstream disables TLS certificate verification. Never use in production.")
@@ -1493,16 +2092,18 @@ func runRecord(args []string) int {
 	useMITM := *httpsMode == "mitm"
 	var caStore *capture.CAStore
 	if useMITM {
-		caStore, err = capture.NewCAStore()
+		caStore, err = openCAStore(*caDir)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "record: CA store: %v\n", err)
 			return 1
 		}
-		if *mitmAllowHosts != "" {
-			caStore.AllowedHosts = strings.Split(*mitmAllowHosts, ",")
+		if len(allowHosts) > 0 {
+			caStore.AllowedHosts = allowHosts
 		}
+		caStore.Hosts = hostPolicies
 	}
 
+	captureMetrics := capture.NewMetrics()
 	ctx := &capture.ProxyContext{
 		Logger:                   inboundLogger,
 		CA:                       caStore,
@@ -1511,6 +2112,7 @@ func runRecord(args []string) int {
 		AllowPrivateDestinations: *allowPrivate,
 		CaptureSensitiveData:     *captureSensitive,
 		Privacy:                  privacyPolicy,
+		Metrics:                  captureMetrics,
 	}
 
 	targetURL := &url.URL{Scheme: "http", Host: *forward}
@@ -1528,6 +2130,10 @@ func runRecord(args []string) int {
 		AllowPrivateDestinations: *allowPrivate,
 		CaptureSensitiveData:     *captureSensitive,
 		Privacy:                  privacyPolicy,
+		Metrics:                  captureMetrics,
+		ClientCertificates:       clientCerts,
+		ClientCAs:                clientCAs,
+		Hosts:                    hostPolicies,
 	}
 	var outServer *http.Server
 	if strings.TrimSpace(*outboundListen) != "" {
@@ -1537,6 +2143,35 @@ func runRecord(args []string) int {
 			return 1
 		}
 	}
+	var outHTTP3Server *http3.Server
+	if strings.TrimSpace(*outboundHTTP3Listen) != "" {
+		outHTTP3Server, err = capture.StartHTTP3ProxyWithOptions(*outboundHTTP3Listen, outCtx, capture.HTTP3Options{Upstreams: outboundUpstreams})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "record: start HTTP/3 outbound capture: %v\n", err)
+			return 1
+		}
+		log.Printf("Terminating HTTP/3 dependency calls on udp://%s; resolve dependency hosts to this address", outHTTP3Server.Addr)
+	}
+	var metricsServer *http.Server
+	if strings.TrimSpace(*metricsListen) != "" {
+		metricsServer, err = capture.StartMetricsServer(*metricsListen, captureMetrics)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "record: start metrics server: %v\n", err)
+			return 1
+		}
+		log.Printf("Serving capture metrics on http://%s/metrics", metricsServer.Addr)
+	}
+	var tcpListeners []net.Listener
+	for _, spec := range tcpFlags {
+		tcpListen, target, _ := splitListenTarget(spec)
+		tcpListener, err := capture.StartTCPProxy(tcpListen, target, outCtx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "record: start TCP capture: %v\n", err)
+			return 1
+		}
+		tcpListeners = append(tcpListeners, tcpListener)
+		log.Printf("Recording raw TCP streams on %s → %s", tcpListen, target)
+	}
 
 	log.Printf("Recording | Inbound: %s → %s | Outbound proxy: %s", *listen, *forward, *outboundListen)
 	if *outboundListen != "" {
@@ -1552,6 +2187,15 @@ func runRecord(args []string) int {
 	if outServer != nil {
 		_ = outServer.Close()
 	}
+	if outHTTP3Server != nil {
+		_ = outHTTP3Server.Close()
+	}
+	if metricsServer != nil {
+		_ = metricsServer.Close()
+	}
+	for _, tcpListener := range tcpListeners {
+		_ = tcpListener.Close()
+	}
 	_ = inboundLogger.Close()
 	_ = outboundLogger.Close()
 
@@ -1580,65 +2224,424 @@ func runRecord(args []string) int {
 	return 0
 }
 
-// countEvents counts JSONL lines in a log file, optionally filtered by event type.
-func countEvents(path, eventType string) int {
-	f, err := os.Open(path)
+// splitListenTarget parses a LISTEN=TARGET raw TCP specification.
+func splitListenTarget(spec string) (string, string, error) {
+	listen, target, ok := strings.Cut(spec, "=")
+	listen, target = strings.TrimSpace(listen), strings.TrimSpace(target)
+	if !ok || listen == "" || target == "" {
+		return "", "", fmt.Errorf("%q must be LISTEN=TARGET", spec)
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		return "", "", fmt.Errorf("%q: target must be host:port", spec)
+	}
+	return listen, target, nil
+}
+
+// newDNSStub builds the replay DNS stub. SRV answers point at the stub
+// proxy's port, and captured lookup times are replayed with stub latency.
+func newDNSStub(outboundLog string, cfg replaydriver.StubDNSConfig, stubListen string, latency stubproxy.LatencyOptions) (*dnssim.Resolver, error) {
+	faults, err := cfg.DNSFaults()
 	if err != nil {
-		return 0
+		return nil, err
 	}
-	defer f.Close()
-	dec := json.NewDecoder(f)
-	count := 0
-	for {
-		var e event.Event
-		if err := dec.Decode(&e); err != nil {
-			break
+	opts := dnssim.Options{
+		Faults:        faults,
+		ReplayLatency: latency.Mode == "captured" || latency.Mode == "sampled",
+		LatencyScale:  latency.Scale,
+	}
+	if cfg.Answer != "" {
+		ip := net.ParseIP(cfg.Answer)
+		if ip == nil {
+			return nil, fmt.Errorf("DNS answer %q is not an IP address", cfg.Answer)
 		}
-		if eventType == "" || e.Type == eventType {
-			count++
+		if ip.To4() != nil {
+			opts.AnswerIPv4 = ip
+		} else {
+			opts.AnswerIPv6 = ip
 		}
 	}
-	return count
+	if _, portText, err := net.SplitHostPort(stubListen); err == nil {
+		if port, err := strconv.ParseUint(portText, 10, 16); err == nil {
+			opts.SRVPort = uint16(port)
+		}
+	}
+	return dnssim.New(outboundLog, opts)
 }
 
-// ---------------------------------------------------------------------------
-// infernosim inspect
-// ---------------------------------------------------------------------------
-
-func runInspect(args []string) int {
-	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
-	if err := fs.Parse(args); err != nil || fs.NArg() < 1 {
-		fmt.Fprintln(os.Stderr, "Usage: infernosim inspect <incident-dir>")
-		return 1
+// parseHTTP3Upstreams reads host=addr:port upstreams of HTTP/3 dependencies.
+func parseHTTP3Upstreams(specs []string) (map[string]string, error) {
+	upstreams := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		host, upstream, ok := strings.Cut(spec, "=")
+		if !ok || strings.TrimSpace(host) == "" || strings.TrimSpace(upstream) == "" {
+			return nil, fmt.Errorf("upstream %q must be host=addr:port", spec)
+		}
+		upstreams[strings.TrimSpace(host)] = strings.TrimSpace(upstream)
 	}
-	dir := fs.Arg(0)
+	return upstreams, nil
+}
 
-	bundle, err := replaydriver.OpenBundle(dir)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "inspect: %v\n", err)
-		return 1
+// loadMutualTLS loads --upstream-client-cert specifications and the optional
+// CA bundle that MITM uses to verify application client certificates.
+func loadMutualTLS(specs []string, clientCA string) (*capture.ClientCertificates, *x509.CertPool, error) {
+	var certs *capture.ClientCertificates
+	if len(specs) > 0 {
+		certs = capture.NewClientCertificates()
+		for _, spec := range specs {
+			if err := certs.AddSpec(spec); err != nil {
+				return nil, nil, err
+			}
+		}
 	}
-
-	result, err := replaydriver.InspectIncident(bundle.InboundLog)
+	if clientCA == "" {
+		return certs, nil, nil
+	}
+	pool, err := capture.LoadCertPool(clientCA)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "inspect: %v\n", err)
-		return 1
+		return nil, nil, fmt.Errorf("MITM client CA: %w", err)
 	}
+	return certs, pool, nil
+}
 
-	if meta, err := bundle.ReadMetadata(); err == nil && !meta.CapturedAt.IsZero() {
-		fmt.Printf("Captured: %s  Env: %s  Host: %s\n\n", meta.CapturedAt.Format(time.RFC3339), meta.Env, meta.Host)
+// openCAStore loads the MITM CA in dir, or in the default directory when dir
+// is empty, generating one when none exists.
+func openCAStore(dir string) (*capture.CAStore, error) {
+	if dir == "" {
+		return capture.NewCAStore()
 	}
+	return capture.NewCAStoreAt(dir)
+}
 
-	replaydriver.PrintInspectResult(result)
-	return 0
+func runCA(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: infernosim ca <init|show|export|rotate|import> [flags]")
+		return 2
+	}
+	switch args[0] {
+	case "init":
+		return runCAInit(args[1:])
+	case "show":
+		return runCAShow(args[1:])
+	case "export":
+		return runCAExport(args[1:])
+	case "rotate":
+		return runCARotate(args[1:])
+	case "import":
+		return runCAImport(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "ca: unknown subcommand %q\n", args[0])
+		return 2
+	}
 }
 
-// ---------------------------------------------------------------------------
-// infernosim verify
-// ---------------------------------------------------------------------------
+// addCADirFlag registers --dir and returns a function resolving it to the
+// default CA directory when unset.
+func addCADirFlag(fs *flag.FlagSet) func() (string, error) {
+	dir := fs.String("dir", "", "CA directory (default ~/.infernosim/ca)")
+	return func() (string, error) {
+		if *dir != "" {
+			return *dir, nil
+		}
+		return capture.DefaultCADir()
+	}
+}
 
-func runVerify(args []string) int {
-	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+// addCASettingsFlags registers --key-type and --leaf-validity and returns a
+// function applying the flags that were set to the stored settings.
+func addCASettingsFlags(fs *flag.FlagSet) func(capture.CASettings) (capture.CASettings, bool) {
+	keyType := fs.String("key-type", "", "Key type for the CA and leaves: rsa or ecdsa (default: keep the stored setting, initially rsa)")
+	leafValidity := fs.Duration("leaf-validity", 0, "Validity of generated leaf certificates (default: keep the stored setting, initially 720h)")
+	return func(settings capture.CASettings) (capture.CASettings, bool) {
+		changed := false
+		if *keyType != "" && *keyType != settings.KeyType {
+			settings.KeyType, changed = strings.ToLower(*keyType), true
+		}
+		if *leafValidity != 0 && *leafValidity != settings.LeafValidity {
+			settings.LeafValidity, changed = *leafValidity, true
+		}
+		return settings, changed
+	}
+}
+
+func runCAInit(args []string) int {
+	fs := flag.NewFlagSet("ca init", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	caDir := addCADirFlag(fs)
+	applySettings := addCASettingsFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	dir, err := caDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ca init: %v\n", err)
+		return 1
+	}
+	settings, err := capture.LoadCASettings(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ca init: %v\n", err)
+		return 1
+	}
+	settings, _ = applySettings(settings)
+	if err := settings.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "ca init: %v\n", err)
+		return 2
+	}
+	store, created, err := capture.InitCAStoreAt(dir, settings)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ca init: %v\n", err)
+		return 1
+	}
+	if created {
+		fmt.Printf("Generated CA in %s\n", dir)
+	} else {
+		fmt.Printf("CA already exists in %s; settings updated\n", dir)
+	}
+	printCAInfo(store.Info())
+	return 0
+}
+
+func runCAShow(args []string) int {
+	fs := flag.NewFlagSet("ca show", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	caDir := addCADirFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	store, code := loadExistingCA("ca show", caDir)
+	if store == nil {
+		return code
+	}
+	printCAInfo(store.Info())
+	return 0
+}
+
+func runCAExport(args []string) int {
+	fs := flag.NewFlagSet("ca export", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	caDir := addCADirFlag(fs)
+	format := fs.String("format", "pem", "Export format: pem (CA certificate) or bundle (system trust store plus the CA)")
+	systemBundle := fs.String("system-bundle", "", "System CA bundle for --format bundle (default: SSL_CERT_FILE or the OS bundle)")
+	out := fs.String("out", "", "Output file (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "pem" && *format != "bundle" {
+		fmt.Fprintf(os.Stderr, "ca export: --format must be pem or bundle, got %q\n", *format)
+		return 2
+	}
+	store, code := loadExistingCA("ca export", caDir)
+	if store == nil {
+		return code
+	}
+	data := store.CertificatePEM()
+	if *format == "bundle" {
+		var err error
+		if data, err = store.TrustBundle(*systemBundle); err != nil {
+			fmt.Fprintf(os.Stderr, "ca export: %v\n", err)
+			return 1
+		}
+	}
+	if *out == "" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "ca export: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s to %s\n", *format, *out)
+	return 0
+}
+
+func runCARotate(args []string) int {
+	fs := flag.NewFlagSet("ca rotate", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	caDir := addCADirFlag(fs)
+	applySettings := addCASettingsFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	store, code := loadExistingCA("ca rotate", caDir)
+	if store == nil {
+		return code
+	}
+	if settings, changed := applySettings(store.Settings()); changed {
+		if err := store.Configure(settings); err != nil {
+			fmt.Fprintf(os.Stderr, "ca rotate: %v\n", err)
+			return 2
+		}
+	}
+	retired, err := store.Rotate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ca rotate: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Retired previous CA to %s\n", retired)
+	printCAInfo(store.Info())
+	fmt.Println("Clients must trust the new CA certificate: infernosim ca export")
+	return 0
+}
+
+func runCAImport(args []string) int {
+	fs := flag.NewFlagSet("ca import", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	caDir := addCADirFlag(fs)
+	certPath := fs.String("cert", "", "PEM CA certificate, optionally followed by its issuing chain")
+	keyPath := fs.String("key", "", "PEM private key of the CA certificate (PKCS#1, PKCS#8, or EC)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *certPath == "" || *keyPath == "" {
+		fmt.Fprintln(os.Stderr, "ca import: --cert and --key are required")
+		return 2
+	}
+	dir, err := caDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ca import: %v\n", err)
+		return 1
+	}
+	certPEM, err := os.ReadFile(*certPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ca import: %v\n", err)
+		return 1
+	}
+	keyPEM, err := os.ReadFile(*keyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ca import: %v\n", err)
+		return 1
+	}
+	store, retired, err := capture.ImportCAAt(dir, certPEM, keyPEM)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ca import: %v\n", err)
+		return 1
+	}
+	if retired != "" {
+		fmt.Printf("Retired previous CA to %s\n", retired)
+	}
+	fmt.Printf("Imported CA into %s\n", dir)
+	printCAInfo(store.Info())
+	return 0
+}
+
+// loadExistingCA loads the CA for commands that must not generate one. A nil
+// store comes with the exit code to return.
+func loadExistingCA(command string, caDir func() (string, error)) (*capture.CAStore, int) {
+	dir, err := caDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", command, err)
+		return nil, 1
+	}
+	store, ok, err := capture.LoadCAStoreAt(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", command, err)
+		return nil, 1
+	}
+	if !ok {
+		fmt.Fprintf(os.Stderr, "%s: no CA in %s; run infernosim ca init\n", command, dir)
+		return nil, 1
+	}
+	return store, 0
+}
+
+func printCAInfo(info capture.CAInfo) {
+	leafKeyType := info.Settings.KeyType
+	if leafKeyType == "" {
+		leafKeyType = capture.KeyTypeRSA
+	}
+	leafValidity := info.Settings.LeafValidity
+	if leafValidity == 0 {
+		leafValidity = capture.DefaultLeafValidity
+	}
+	fmt.Printf("  Certificate:   %s\n", info.Path)
+	fmt.Printf("  Subject:       %s\n", info.Subject)
+	if info.Intermediate {
+		fmt.Printf("  Issuer:        %s (intermediate, chain of %d)\n", info.Issuer, info.ChainLength)
+	}
+	fmt.Printf("  Serial:        %s\n", info.Serial)
+	fmt.Printf("  Valid:         %s to %s\n", info.NotBefore.UTC().Format(time.RFC3339), info.NotAfter.UTC().Format(time.RFC3339))
+	fmt.Printf("  Key type:      %s\n", info.KeyType)
+	fmt.Printf("  SHA-256:       %s\n", info.SHA256)
+	fmt.Printf("  Leaves:        %s keys, valid %s, %d cached\n", leafKeyType, leafValidity, info.CachedLeaves)
+}
+
+// loadHostPolicies loads the optional host policy file and validates the
+// comma-separated allowlist patterns.
+func loadHostPolicies(path, allowHosts string) (*capture.HostPolicies, []string, error) {
+	patterns := splitNonEmpty(allowHosts)
+	for _, pattern := range patterns {
+		if err := capture.ValidateHostPattern(pattern); err != nil {
+			return nil, nil, err
+		}
+	}
+	if path == "" {
+		return nil, patterns, nil
+	}
+	policies, err := capture.LoadHostPolicies(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return policies, patterns, nil
+}
+
+// countEvents counts JSONL lines in a log file, optionally filtered by event type.
+func countEvents(path, eventType string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	count := 0
+	for {
+		var e event.Event
+		if err := dec.Decode(&e); err != nil {
+			break
+		}
+		if eventType == "" || e.Type == eventType {
+			count++
+		}
+	}
+	return count
+}
+
+// ---------------------------------------------------------------------------
+// infernosim inspect
+// ---------------------------------------------------------------------------
+
+func runInspect(args []string) int {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil || fs.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: infernosim inspect <incident-dir>")
+		return 1
+	}
+	dir := fs.Arg(0)
+
+	bundle, err := replaydriver.OpenBundle(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "inspect: %v\n", err)
+		return 1
+	}
+
+	result, err := replaydriver.InspectIncident(bundle.InboundLog)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "inspect: %v\n", err)
+		return 1
+	}
+
+	if meta, err := bundle.ReadMetadata(); err == nil && !meta.CapturedAt.IsZero() {
+		fmt.Printf("Captured: %s  Env: %s  Host: %s\n\n", meta.CapturedAt.Format(time.RFC3339), meta.Env, meta.Host)
+	}
+
+	replaydriver.PrintInspectResult(result)
+	return 0
+}
+
+// ---------------------------------------------------------------------------
+// infernosim verify
+// ---------------------------------------------------------------------------
+
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
 	if err := fs.Parse(args); err != nil || fs.NArg() < 1 {
 		fmt.Fprintln(os.Stderr, "Usage: infernosim verify <incident-dir>")
 		return 1
@@ -1679,9 +2682,15 @@ func runDiff(args []string) int {
 	targetBase := fs.String("target", "http://localhost:8080", "Target base URL to replay against")
 	maxWall := fs.Duration("max-wall-time", 60*time.Second, "Max wall-clock time for the replay run")
 	allowWrites := fs.Bool("allow-writes", false, "Permit diff replay of POST/PUT/PATCH/DELETE requests")
+	asJSON := fs.Bool("json", false, "Emit machine-readable JSON")
+	keepVolatile := fs.Bool("keep-volatile", false, "Report changes to JSON fields heal classifies as volatile")
+	reportFormats := fs.String("report-formats", "", "Comma-separated report formats: junit,sarif,html")
+	reportDir := fs.String("report-dir", "", "Report output directory (default: <incident>/reports)")
+	ignoredJSONPaths := multiFlag{}
+	fs.Var(&ignoredJSONPaths, "ignore-json-path", "JSONPath excluded from the response body diff (repeatable)")
 
 	if err := fs.Parse(args); err != nil || (positionalIncident == "" && fs.NArg() < 1) {
-		fmt.Fprintln(os.Stderr, "Usage: infernosim diff <incident-dir> [--target http://...]")
+		fmt.Fprintln(os.Stderr, "Usage: infernosim diff <incident-dir> [--target http://...] [--json] [--ignore-json-path $.field]")
 		return 1
 	}
 	dir := positionalIncident
@@ -1696,11 +2705,25 @@ func runDiff(args []string) int {
 	}
 
 	// If a replay.yaml exists, load target from it (CLI flag takes precedence if explicitly provided).
+	// Its ignored JSONPaths apply to the body diff as well.
 	if bundle.HasConfig() {
 		cfg, err := replaydriver.LoadReplayConfig(bundle.ConfigPath)
 		if err == nil && cfg.Target != "" && *targetBase == "http://localhost:8080" {
 			*targetBase = cfg.Target
 		}
+		if err == nil {
+			ignoredJSONPaths = append(ignoredJSONPaths, cfg.Matching.IgnoredJSONPaths...)
+		}
+	}
+	for _, path := range ignoredJSONPaths {
+		if err := matcher.ValidateJSONPath(path); err != nil {
+			fmt.Fprintf(os.Stderr, "diff: ignored JSONPath %q: %v\n", path, err)
+			return 1
+		}
+	}
+	opts := diffOptions(ignoredJSONPaths)
+	if *keepVolatile {
+		opts.Volatile = nil
 	}
 
 	// Start stub proxy for outbound calls if outbound.log exists
@@ -1712,7 +2735,9 @@ func runDiff(args []string) int {
 				_ = server.ListenAndServe()
 			}()
 			defer server.Close()
-			fmt.Printf("Started stub proxy for outbound calls on :8084 using %s\n", bundle.OutboundLog)
+			if !*asJSON {
+				fmt.Printf("Started stub proxy for outbound calls on :8084 using %s\n", bundle.OutboundLog)
+			}
 		}
 	}
 
@@ -1737,21 +2762,72 @@ func runDiff(args []string) int {
 	var diffs []*replaydriver.EventDiff
 	for i, replayed := range result.ReplayedEvents {
 		if i < len(events) {
-			if d := replaydriver.CompareEvents(events[i], replayed, i+1); d != nil {
+			if d := replaydriver.CompareEventsWithOptions(events[i], replayed, i+1, opts); d != nil {
 				diffs = append(diffs, d)
 			}
 		}
 	}
 
-	fmt.Printf("Diff Summary\n")
-	fmt.Printf("------------\n")
-	fmt.Printf("Requests replayed: %d\n", result.CompletedEvents)
-	fmt.Printf("Divergences found: %d\n", len(diffs))
-	if result.SafeModeSkipped > 0 {
-		fmt.Printf("Safe-mode skipped: %d\n", result.SafeModeSkipped)
+	if formats := splitNonEmpty(*reportFormats); len(formats) > 0 {
+		if *reportDir == "" {
+			*reportDir = filepath.Join(dir, "reports")
+		}
+		outcome := "PASS_DIFF"
+		if len(diffs) > 0 {
+			outcome = "FAIL_DIFF"
+		}
+		written, err := reporting.WriteFormats(*reportDir, formats, reporting.Result{
+			Tool:      "InfernoSIM",
+			Outcome:   outcome,
+			Summary:   fmt.Sprintf("%d request(s) replayed; %d divergence(s)", result.CompletedEvents, len(diffs)),
+			Generated: time.Now().UTC(),
+			BodyDiffs: bodyDiffRows(diffs),
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "diff: write reports: %v\n", err)
+			return 1
+		}
+		for _, path := range written {
+			fmt.Fprintf(os.Stderr, "Report: %s\n", path)
+		}
+	}
+
+	if *asJSON {
+		output := diffOutput{
+			Target:           *targetBase,
+			RequestsReplayed: result.CompletedEvents,
+			SafeModeSkipped:  result.SafeModeSkipped,
+			Divergences:      make([]diffDivergence, 0, len(diffs)),
+		}
+		for _, d := range diffs {
+			output.Divergences = append(output.Divergences, diffDivergence{
+				Index:       d.Index,
+				Method:      d.Captured.Method,
+				URL:         d.Captured.URL,
+				Status:      d.StatusChange,
+				Headers:     d.HeaderDiff,
+				Body:        d.BodyDiff,
+				Latency:     d.LatencyDiff,
+				JSONChanges: d.JSONChanges,
+			})
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(output); err != nil {
+			fmt.Fprintf(os.Stderr, "diff: %v\n", err)
+			return 1
+		}
+	} else {
+		fmt.Printf("Diff Summary\n")
+		fmt.Printf("------------\n")
+		fmt.Printf("Requests replayed: %d\n", result.CompletedEvents)
+		fmt.Printf("Divergences found: %d\n", len(diffs))
+		if result.SafeModeSkipped > 0 {
+			fmt.Printf("Safe-mode skipped: %d\n", result.SafeModeSkipped)
+		}
+		fmt.Println()
+		replaydriver.PrintDiffs(diffs)
 	}
-	fmt.Println()
-	replaydriver.PrintDiffs(diffs)
 
 	if len(diffs) > 0 {
 		return 1
@@ -1759,6 +2835,25 @@ func runDiff(args []string) int {
 	return 0
 }
 
+// diffOutput is the --json form of infernosim diff.
+type diffOutput struct {
+	Target           string           `json:"target"`
+	RequestsReplayed int              `json:"requests_replayed"`
+	SafeModeSkipped  int              `json:"safe_mode_skipped,omitempty"`
+	Divergences      []diffDivergence `json:"divergences"`
+}
+
+type diffDivergence struct {
+	Index       int                       `json:"index"`
+	Method      string                    `json:"method"`
+	URL         string                    `json:"url"`
+	Status      string                    `json:"status,omitempty"`
+	Headers     []string                  `json:"headers,omitempty"`
+	Body        string                    `json:"body,omitempty"`
+	Latency     string                    `json:"latency,omitempty"`
+	JSONChanges []replaydriver.JSONChange `json:"json_changes,omitempty"`
+}
+
 // ---------------------------------------------------------------------------
 // infernosim bundle
 // ---------------------------------------------------------------------------
@@ -1958,6 +3053,21 @@ func runServe(args []string) int {
 	httpsStub := fs.Bool("https-stub", false, "Enable native HTTPS response stubbing")
 	caDir := fs.String("stub-ca-dir", "", "Directory containing the HTTPS stub CA")
 	allowHosts := fs.String("stub-mitm-allow-hosts", "", "Comma-separated HTTPS dependency hosts allowed for TLS stubbing")
+	http3Listen := fs.String("http3-listen", "", "UDP address that also serves dependency calls over HTTP/3 (requires HTTPS stubbing)")
+	clientCA := fs.String("stub-client-ca", "", "PEM bundle of CAs; HTTPS stubbing then requires and verifies application client certificates")
+	hostPolicy := fs.String("stub-host-policy", "", "Host policy YAML whose tunnel and block actions HTTPS stubbing applies before the allowlist")
+	recordOnMiss := fs.Bool("record-on-miss", false, "Forward unmatched dependency calls and append them to the incident's outbound.log")
+	recordUpstream := fs.String("record-upstream", "", "Base URL that receives unmatched calls (default: the originally requested host)")
+	allowPrivate := fs.Bool("allow-private-destinations", false, "Allow record-on-miss and hosts tunnelled by a host policy glob to reach loopback/private destinations (local development only)")
+	captureSensitive := fs.Bool("capture-sensitive-data", false, "Keep credential headers and raw body values in recorded calls, the request journal, and near-miss diagnostics (UNSAFE)")
+	privacyPolicyPath := fs.String("privacy-policy", "", "Privacy policy YAML applied to recorded calls, the request journal, and near-miss diagnostics")
+	journalSize := fs.Int("journal-size", stubproxy.DefaultJournalSize, "Requests kept for the verification API (0 disables the journal)")
+	stubLatency := fs.String("stub-latency", "", "Replay captured dependency latency: off, captured, or sampled (default: replay.yaml stub.latency)")
+	stubLatencyScale := fs.Float64("stub-latency-scale", 0, "Multiplier for replayed dependency latency (0 = replay.yaml or 1)")
+	incidentFlags := multiFlag{}
+	routeFlags := multiFlag{}
+	fs.Var(&incidentFlags, "incident", "Serve a named incident: name=<incident-dir> (repeatable, replaces the positional incident)")
+	fs.Var(&routeFlags, "route", "Route dependency calls to a named incident: name=<host> or name=/<path-prefix> (repeatable)")
 	positionalIncident := ""
 	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
 		positionalIncident = args[0]
@@ -1969,19 +3079,67 @@ func runServe(args []string) int {
 	if positionalIncident == "" && fs.NArg() > 0 {
 		positionalIncident = fs.Arg(0)
 	}
-	if positionalIncident == "" {
+	incidents, err := parseServeIncidents(incidentFlags, routeFlags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "serve: %v\n", err)
+		return 2
+	}
+	if len(incidents) > 0 && (positionalIncident != "" || *configPath != "") {
+		fmt.Fprintln(os.Stderr, "serve: --incident cannot be combined with a positional incident or --config")
+		return 2
+	}
+	if positionalIncident == "" && len(incidents) == 0 {
 		fmt.Fprintln(os.Stderr, "Usage: infernosim serve <incident-dir> [--listen 127.0.0.1:19000] [--admin-listen 127.0.0.1:19001]")
+		fmt.Fprintln(os.Stderr, "       infernosim serve --incident name=<incident-dir> --route name=<host>|/<prefix> ...")
+		return 2
+	}
+	var policy *privacy.Policy
+	if *privacyPolicyPath != "" {
+		loaded, err := privacy.Load(*privacyPolicyPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "serve: privacy policy: %v\n", err)
+			return 1
+		}
+		policy = loaded
+	}
+	journal := stubproxy.JournalOptions{Size: *journalSize}
+	if *journalSize <= 0 {
+		journal.Size = -1
+	}
+	var passthrough *stubproxy.RecordOnMiss
+	if *recordOnMiss {
+		passthrough = &stubproxy.RecordOnMiss{AllowPrivateDestinations: *allowPrivate}
+		if *recordUpstream != "" {
+			upstream, err := url.Parse(*recordUpstream)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "serve: --record-upstream: %v\n", err)
+				return 2
+			}
+			passthrough.Upstream = upstream
+		}
+	} else if *recordUpstream != "" {
+		fmt.Fprintln(os.Stderr, "serve: --record-upstream requires --record-on-miss")
 		return 2
 	}
 	server, err := simserver.New(simserver.Options{
-		IncidentDir: positionalIncident,
-		ConfigPath:  *configPath,
-		Listen:      *listen,
-		AdminListen: *adminListen,
-		ObservedLog: *observedLog,
-		HTTPS:       *httpsStub,
-		CADir:       *caDir,
-		AllowHosts:  splitNonEmpty(*allowHosts),
+		IncidentDir:              positionalIncident,
+		ConfigPath:               *configPath,
+		Incidents:                incidents,
+		Listen:                   *listen,
+		AdminListen:              *adminListen,
+		ObservedLog:              *observedLog,
+		HTTPS:                    *httpsStub,
+		CADir:                    *caDir,
+		AllowHosts:               splitNonEmpty(*allowHosts),
+		ClientCA:                 *clientCA,
+		HostPolicy:               *hostPolicy,
+		AllowPrivateDestinations: *allowPrivate,
+		HTTP3Listen:              *http3Listen,
+		RecordOnMiss:             passthrough,
+		Journal:                  journal,
+		Privacy:                  policy,
+		CaptureSensitiveData:     *captureSensitive,
+		Latency:                  stubproxy.LatencyOptions{Mode: *stubLatency, Scale: *stubLatencyScale},
 	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "serve: %v\n", err)
@@ -1992,6 +3150,22 @@ func runServe(args []string) int {
 		return 1
 	}
 	fmt.Printf("InfernoSIM simulator ready | proxy=%s admin=%s\n", server.StubAddress(), server.AdminAddress())
+	if address := server.HTTP3Address(); address != "" {
+		fmt.Printf("Serving HTTP/3 dependency calls on udp://%s\n", address)
+	}
+	for _, served := range incidents {
+		routes := append([]string(nil), served.Hosts...)
+		if served.PathPrefix != "" {
+			routes = append(routes, served.PathPrefix)
+		}
+		if len(routes) == 0 {
+			routes = []string{"(default)"}
+		}
+		fmt.Printf("  incident %s <- %s\n", served.Name, strings.Join(routes, ", "))
+	}
+	if passthrough != nil {
+		fmt.Println("Record-on-miss enabled: unmatched dependency calls are forwarded and appended to outbound.log")
+	}
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 	select {
@@ -2012,6 +3186,45 @@ func runServe(args []string) int {
 	return 0
 }
 
+// parseServeIncidents pairs --incident name=dir flags with --route
+// name=host and name=/prefix flags, keeping flag order.
+func parseServeIncidents(incidentFlags, routeFlags []string) ([]simserver.Incident, error) {
+	var incidents []simserver.Incident
+	index := make(map[string]int)
+	for _, value := range incidentFlags {
+		name, dir, ok := strings.Cut(value, "=")
+		name, dir = strings.TrimSpace(name), strings.TrimSpace(dir)
+		if !ok || name == "" || dir == "" {
+			return nil, fmt.Errorf("--incident %q must be name=<incident-dir>", value)
+		}
+		if _, exists := index[name]; exists {
+			return nil, fmt.Errorf("--incident %q is given more than once", name)
+		}
+		index[name] = len(incidents)
+		incidents = append(incidents, simserver.Incident{Name: name, Dir: dir})
+	}
+	for _, value := range routeFlags {
+		name, route, ok := strings.Cut(value, "=")
+		name, route = strings.TrimSpace(name), strings.TrimSpace(route)
+		if !ok || route == "" {
+			return nil, fmt.Errorf("--route %q must be name=<host> or name=/<path-prefix>", value)
+		}
+		position, exists := index[name]
+		if !exists {
+			return nil, fmt.Errorf("--route %q names an incident without --incident", value)
+		}
+		if strings.HasPrefix(route, "/") {
+			if incidents[position].PathPrefix != "" {
+				return nil, fmt.Errorf("incident %q has more than one path prefix route", name)
+			}
+			incidents[position].PathPrefix = route
+			continue
+		}
+		incidents[position].Hosts = append(incidents[position].Hosts, route)
+	}
+	return incidents, nil
+}
+
 func runTestgen(args []string) int {
 	fs := flag.NewFlagSet("testgen", flag.ContinueOnError)
 	fs.SetOutput(os.Stderr)
@@ -2104,6 +3317,173 @@ func runHeal(args []string) int {
 	return 0
 }
 
+func runMinimize(args []string) int {
+	fs := flag.NewFlagSet("minimize", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	out := fs.String("out", "", "Directory for the minimized incident (default: <incident>-min)")
+	maxReplays := fs.Int("max-replays", 200, "Maximum subset replays; stops with the smallest reproducer so far (0 = no limit)")
+	verbose := fs.Bool("verbose", false, "Show replay logs for every attempt")
+	positionalIncident := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		positionalIncident = args[0]
+		args = args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if positionalIncident == "" {
+		fmt.Fprintln(os.Stderr, "Usage: infernosim minimize <incident-dir> [--out DIR] [--max-replays N] [-- replay flags]")
+		return 2
+	}
+	replayArgs := fs.Args()
+
+	bundle, err := replaydriver.OpenBundle(positionalIncident)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "minimize: %v\n", err)
+		return 1
+	}
+	logs, err := replaydriver.LoadIncidentLogs(bundle)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "minimize: %v\n", err)
+		return 1
+	}
+	if len(logs.Inbound) == 0 {
+		fmt.Fprintln(os.Stderr, "minimize: no inbound requests found in incident")
+		return 1
+	}
+	dst := *out
+	if dst == "" {
+		dst = filepath.Clean(positionalIncident) + "-min"
+	}
+	if _, err := os.Stat(filepath.Join(dst, "inbound.log")); err == nil {
+		fmt.Fprintf(os.Stderr, "minimize: %s already holds an incident\n", dst)
+		return 1
+	}
+	// Subsets are replayed from scratch directories, so relative paths in
+	// the incident's replay.yaml must still resolve against the original.
+	if bundle.HasConfig() && !hasFlag(replayArgs, "config") {
+		configPath, err := filepath.Abs(bundle.ConfigPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "minimize: %v\n", err)
+			return 1
+		}
+		replayArgs = append([]string{"--config", configPath}, replayArgs...)
+	}
+	work, err := os.MkdirTemp("", "infernosim-minimize-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "minimize: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(work)
+	if !*verbose {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+
+	attempt := 0
+	replaySubset := func(keep []int) (ReplaySummary, error) {
+		attempt++
+		dir := filepath.Join(work, fmt.Sprintf("attempt-%d", attempt))
+		defer os.RemoveAll(dir)
+		if _, err := logs.WriteSubset(dir, keep); err != nil {
+			return ReplaySummary{}, err
+		}
+		return replayIncident(append([]string{dir}, replayArgs...)), nil
+	}
+	all := make([]int, len(logs.Inbound))
+	for i := range all {
+		all[i] = i
+	}
+	baseline, err := replaySubset(all)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "minimize: %v\n", err)
+		return 1
+	}
+	if !strings.HasPrefix(baseline.Outcome, "FAIL_") || baseline.Outcome == "FAIL_INVALID_ENV" {
+		fmt.Fprintf(os.Stderr, "minimize: the incident does not reproduce a failure to minimize (%s: %s)\n", baseline.Outcome, primaryFailureOrNone(baseline.PrimaryFailureReason))
+		return 1
+	}
+	target := failureSignatures(baseline)[0]
+	fmt.Printf("Failure signature: %s\n", target)
+
+	result, err := replaydriver.Minimize(logs.Inbound, *maxReplays, func(keep []int) (bool, error) {
+		summary, err := replaySubset(keep)
+		if err != nil {
+			return false, err
+		}
+		reproduced := slices.Contains(failureSignatures(summary), target)
+		verdict := "reproduced"
+		if !reproduced {
+			verdict = summary.Outcome
+		}
+		fmt.Printf("  replay %d: %d request(s) -> %s\n", attempt-1, len(keep), verdict)
+		return reproduced, nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "minimize: %v\n", err)
+		return 1
+	}
+	counts, err := logs.WriteSubset(dst, result.Keep)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "minimize: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Minimized incident: %s (%d of %d inbound requests, %d outbound events, %d replays)\n", dst, counts.Inbound, len(logs.Inbound), counts.Outbound, result.Replays)
+	fmt.Printf("Kept requests: %s\n", replaydriver.FormatKept(result.Keep))
+	if result.Exhausted {
+		fmt.Printf("Replay budget of %d exhausted; a larger --max-replays may shrink the incident further\n", *maxReplays)
+	}
+	return 0
+}
+
+// failureSignatures describes a replay failure independently of how many
+// requests the incident holds: the outcome with the first stub divergence
+// reason, then with each response diff, then alone. A subset reproduces a
+// failure when its signatures include the original's first.
+func failureSignatures(s ReplaySummary) []string {
+	var signatures []string
+	if i := strings.Index(s.PrimaryFailureReason, "why="); i >= 0 {
+		signatures = append(signatures, s.Outcome+" "+s.PrimaryFailureReason[i:])
+	}
+	for _, d := range s.DiffResults {
+		path := d.Captured.URL
+		if u, err := url.Parse(path); err == nil {
+			path = u.Path
+		}
+		var aspects []string
+		if d.StatusChange != "" {
+			aspects = append(aspects, "status "+d.StatusChange)
+		}
+		if len(d.HeaderDiff) > 0 {
+			aspects = append(aspects, "headers")
+		}
+		if d.BodyDiff != "" {
+			aspects = append(aspects, "body")
+		}
+		// Latency is too noisy to identify a failure unless it is all
+		// that differs.
+		if len(aspects) == 0 && d.LatencyDiff != "" {
+			aspects = append(aspects, "latency")
+		}
+		signatures = append(signatures, fmt.Sprintf("%s diff %s %s: %s", s.Outcome, d.Captured.Method, path, strings.Join(aspects, ", ")))
+	}
+	return append(signatures, s.Outcome)
+}
+
+// hasFlag reports whether args set the flag name before any "--".
+func hasFlag(args []string, name string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		trimmed := strings.TrimLeft(arg, "-")
+		if trimmed != arg && (trimmed == name || strings.HasPrefix(trimmed, name+"=")) {
+			return true
+		}
+	}
+	return false
+}
+
 type kafkaAuthFlags struct {
 	tls           *bool
 	caFile        *string
@@ -2588,7 +3968,7 @@ func runMatch(args []string) int {
 		fmt.Fprintf(os.Stderr, "match explain: %v\n", err)
 		return 2
 	}
-	explanations := semanticMatcher.Explain(events, request, body)
+	explanations := semanticMatcher.NearMisses(events, request, body, 0)
 	if *asJSON {
 		encoder := json.NewEncoder(os.Stdout)
 		encoder.SetIndent("", "  ")
@@ -2602,6 +3982,24 @@ func runMatch(args []string) int {
 				detail = "all configured predicates satisfied"
 			}
 			fmt.Printf("%s [%d] %s %s — %s\n", status, explanation.Index, explanation.Method, explanation.URL, detail)
+			for _, diff := range explanation.Diffs {
+				name := diff.Field
+				if diff.Name != "" {
+					name += " " + diff.Name
+				}
+				expected := fmt.Sprintf("%q", diff.Expected)
+				switch {
+				case diff.Regex:
+					expected = "/" + diff.Expected + "/"
+				case diff.ExpectedMissing:
+					expected = "(absent)"
+				}
+				actual := fmt.Sprintf("%q", diff.Actual)
+				if diff.ActualMissing {
+					actual = "(absent)"
+				}
+				fmt.Printf("    %s: expected %s, got %s\n", name, expected, actual)
+			}
 		}
 	}
 	for _, explanation := range explanations {
@@ -2622,3 +4020,188 @@ func bundlePassphrase(environmentVariable string) ([]byte, error) {
 	}
 	return []byte(value), nil
 }
+
+func runSlice(args []string) int {
+	fs := flag.NewFlagSet("slice", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	out := fs.String("out", "", "Directory for the sliced incident (required)")
+	from := fs.String("from", "", "Keep requests at or after this time (RFC3339, or HH:MM[:SS] UTC on the incident's first day)")
+	to := fs.String("to", "", "Keep requests at or before this time (same formats as --from)")
+	path := fs.String("path", "", "Keep requests whose path matches this regular expression")
+	var traceIDs, statuses multiFlag
+	fs.Var(&traceIDs, "trace-id", "Keep requests with this trace ID (repeatable)")
+	fs.Var(&statuses, "status", "Keep requests whose response status matches, e.g. 503 or 5xx (repeatable or comma-separated)")
+	positionalIncident := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		positionalIncident = args[0]
+		args = args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if positionalIncident == "" || *out == "" {
+		fmt.Fprintln(os.Stderr, "Usage: infernosim slice <incident-dir> --out DIR [--from T] [--to T] [--path REGEX] [--trace-id ID] [--status CODE]")
+		return 2
+	}
+
+	bundle, err := replaydriver.OpenBundle(positionalIncident)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "slice: %v\n", err)
+		return 1
+	}
+	logs, err := replaydriver.LoadIncidentLogs(bundle)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "slice: %v\n", err)
+		return 1
+	}
+	if _, err := os.Stat(filepath.Join(*out, "inbound.log")); err == nil {
+		fmt.Fprintf(os.Stderr, "slice: %s already holds an incident\n", *out)
+		return 1
+	}
+	var day time.Time
+	if len(logs.Inbound) > 0 {
+		day = logs.Inbound[0].Timestamp
+	}
+	filter := replaydriver.SliceFilter{TraceIDs: traceIDs}
+	for _, status := range statuses {
+		filter.Statuses = append(filter.Statuses, splitNonEmpty(status)...)
+	}
+	if filter.From, err = parseSliceTime(*from, day); err != nil {
+		fmt.Fprintf(os.Stderr, "slice: --from: %v\n", err)
+		return 2
+	}
+	if filter.To, err = parseSliceTime(*to, day); err != nil {
+		fmt.Fprintf(os.Stderr, "slice: --to: %v\n", err)
+		return 2
+	}
+	if *path != "" {
+		if filter.Path, err = regexp.Compile(*path); err != nil {
+			fmt.Fprintf(os.Stderr, "slice: --path: %v\n", err)
+			return 2
+		}
+	}
+	if err := filter.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "slice: %v\n", err)
+		return 2
+	}
+	counts, err := logs.Slice(*out, filter)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "slice: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Sliced incident: %s (%d of %d inbound requests, %d outbound events, %d messages)\n", *out, counts.Inbound, len(logs.Inbound), counts.Outbound, counts.Messages)
+	if counts.Inbound == 0 {
+		fmt.Fprintln(os.Stderr, "slice: warning: no inbound requests matched")
+	}
+	return 0
+}
+
+// parseSliceTime accepts RFC3339 or a clock time, which is taken in UTC on
+// day's date so that "14:02" means 14:02 on the day the incident began.
+func parseSliceTime(value string, day time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		clock, err := time.Parse(layout, value)
+		if err != nil {
+			continue
+		}
+		if day.IsZero() {
+			return time.Time{}, fmt.Errorf("clock time %q needs an incident with at least one inbound request", value)
+		}
+		y, m, d := day.UTC().Date()
+		return time.Date(y, m, d, clock.Hour(), clock.Minute(), clock.Second(), 0, time.UTC), nil
+	}
+	return time.Time{}, fmt.Errorf("time %q must be RFC3339 or HH:MM[:SS]", value)
+}
+
+func runMerge(args []string) int {
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	out := fs.String("out", "", "Directory for the merged incident (required)")
+	var incidents []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return 2
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		incidents = append(incidents, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(incidents) < 2 || *out == "" {
+		fmt.Fprintln(os.Stderr, "Usage: infernosim merge --out DIR <incident-dir> <incident-dir>...")
+		return 2
+	}
+	result, err := replaydriver.MergeIncidents(*out, incidents)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "merge: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Merged incident: %s (%d inbound requests, %d outbound events, %d messages from %d incidents)\n", *out, result.Inbound, result.Outbound, result.Messages, len(incidents))
+	if len(result.ConfigConflicts) > 0 {
+		fmt.Fprintf(os.Stderr, "merge: warning: kept replay.yaml from %s; differing replay.yaml in %s was dropped\n", result.ConfigFrom, strings.Join(result.ConfigConflicts, ", "))
+	}
+	return 0
+}
+
+func runSynth(args []string) int {
+	fs := flag.NewFlagSet("synth", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	out := fs.String("out", "", "Directory for the synthetic incident (default: <incident>-synthetic)")
+	keyEnv := fs.String("token-key-env", "INFERNOSIM_TOKEN_KEY", "Environment variable holding the token key; unset fabricates with a random key")
+	profilePath := fs.String("profile", "", "Write the per-endpoint schema of the synthetic incident as JSON to this file")
+	positionalIncident := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		positionalIncident = args[0]
+		args = args[1:]
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if positionalIncident == "" {
+		fmt.Fprintln(os.Stderr, "Usage: infernosim synth <incident-dir> [--out DIR] [--token-key-env NAME] [--profile FILE]")
+		return 2
+	}
+	dst := *out
+	if dst == "" {
+		dst = filepath.Clean(positionalIncident) + "-synthetic"
+	}
+	key := []byte(os.Getenv(*keyEnv))
+	if len(key) == 0 {
+		key = []byte(event.GenerateID())
+		fmt.Fprintf(os.Stderr, "synth: %s is not set; using a random key, so values will differ on every run\n", *keyEnv)
+	}
+	tokenizer, err := privacy.NewTokenizer(key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "synth: %s: %v\n", *keyEnv, err)
+		return 2
+	}
+	result, err := synth.Synthesize(positionalIncident, dst, synth.Options{Tokenizer: tokenizer})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "synth: %v\n", err)
+		return 1
+	}
+	if *profilePath != "" {
+		data, err := json.MarshalIndent(result.Endpoints, "", "  ")
+		if err == nil {
+			err = os.WriteFile(*profilePath, append(data, '\n'), 0o600)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "synth: %v\n", err)
+			return 1
+		}
+	}
+	fields := 0
+	for _, endpoint := range result.Endpoints {
+		fields += len(endpoint.Fields)
+	}
+	fmt.Printf("Synthetic incident: %s (%d inbound requests, %d outbound events, %d messages)\n", dst, result.Inbound, result.Outbound, result.Messages)
+	fmt.Printf("Schema: %d endpoints, %d JSON fields; %d captured words fabricated, timestamps shifted by %s\n", len(result.Endpoints), fields, result.Words, result.Shift)
+	return 0
+}
@@ -2,13 +2,17 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	"infernosim/pkg/capture"
 	"infernosim/pkg/event"
 	"infernosim/pkg/replaydriver"
 )
@@ -120,6 +124,72 @@ func TestOutcomeSLOMissed(t *testing.T) {
 	}
 }
 
+func TestLoadFlagsOverrideReplayYAML(t *testing.T) {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	rps := fs.Float64("load-rps", 0, "")
+	steps := fs.String("load-steps", "", "")
+	profile := fs.String("load-profile", "", "")
+	if err := fs.Parse([]string{"--load-profile", "step", "--load-steps", "5@1s,10@2s"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := resolveLoadConfig(fs, replaydriver.LoadConfig{Profile: "constant", RPS: 40, Duration: "10s", Seed: 9}, loadFlags{
+		profile: *profile,
+		rps:     *rps,
+		steps:   *steps,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Profile.Kind != "step" || len(cfg.Profile.Stages) != 2 || cfg.Profile.RPS != 40 || cfg.Seed != 9 {
+		t.Fatalf("unexpected merged load config: %+v", cfg)
+	}
+
+	none, err := resolveLoadConfig(flag.NewFlagSet("replay", flag.ContinueOnError), replaydriver.LoadConfig{}, loadFlags{})
+	if err != nil || none != nil {
+		t.Fatalf("expected no load config, got %+v, %v", none, err)
+	}
+
+	summary := NewReplaySummary()
+	summary.LoadProfile = "step"
+	summary.LoadPlanned = 25
+	summary.LoadLatency = replaydriver.NewLatencyHistogram()
+	summary.LoadEndpoints = replaydriver.NewEndpointHistograms()
+	summary.LoadLatency.Record(12 * time.Millisecond)
+	summary.LoadEndpoints.Record("GET", "http://svc/orders/7", 12*time.Millisecond)
+	summary.Finalize()
+	joined := strings.Join(summary.Lines, "\n")
+	if !strings.Contains(joined, "OPEN-LOOP LOAD") || !strings.Contains(joined, "- GET /orders/{id}: count=1 p50=") {
+		t.Fatalf("summary missing load histograms:\n%s", joined)
+	}
+}
+
+func TestLatencyThresholdFailsReplay(t *testing.T) {
+	summary := NewReplaySummary()
+	summary.ProxyStatus = "BOUND"
+	summary.RunsRequested = 1
+	summary.RunsExecuted = 1
+	summary.RunsCompleted = 1
+	summary.BaselineLatency = []replaydriver.LatencyStat{{Endpoint: "GET /orders/{id}", Count: 2, P50: 10 * time.Millisecond, P90: 12 * time.Millisecond, P99: 12 * time.Millisecond, Max: 12 * time.Millisecond}}
+	summary.RunLatency = [][]replaydriver.LatencyStat{{{Endpoint: "GET /orders/{id}", Count: 2, P50: 40 * time.Millisecond, P90: 50 * time.Millisecond, P99: 50 * time.Millisecond, Max: 50 * time.Millisecond}}}
+	threshold, err := replaydriver.ParseLatencyThreshold("p99<=+25%")
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyLatencyThresholds(&summary, []replaydriver.LatencyThreshold{threshold})
+	summary.Finalize()
+	if summary.Outcome != "FAIL_LATENCY_REGRESSION" || summary.ExitStatus != 1 || len(summary.Findings) != 1 {
+		t.Fatalf("outcome=%s exit=%d findings=%d", summary.Outcome, summary.ExitStatus, len(summary.Findings))
+	}
+	joined := strings.Join(summary.Lines, "\n")
+	if !strings.Contains(joined, "LATENCY BY ENDPOINT") || !strings.Contains(joined, "GET /orders/{id}: n=2 p50=40ms") {
+		t.Fatalf("summary missing latency breakdown:\n%s", joined)
+	}
+	rows := latencyRows(&summary)
+	if len(rows) != 2 || rows[0].Scope != "baseline" || rows[1].Scope != "run 1" || rows[1].P99MS != 50 {
+		t.Fatalf("latency rows = %+v", rows)
+	}
+}
+
 func TestGenerateLintAndExplainCLI(t *testing.T) {
 	output := filepath.Join(t.TempDir(), "replay.yaml")
 	protoPath, err := filepath.Abs(filepath.Join("..", "..", "examples", "grpcapp", "echo", "echo.proto"))
@@ -178,3 +248,97 @@ func TestReportFormatsAreRejectedBeforeKafkaReplay(t *testing.T) {
 		t.Fatalf("invalid report format code=%d", code)
 	}
 }
+
+func TestParseServeIncidentsPairsRoutes(t *testing.T) {
+	incidents, err := parseServeIncidents(
+		[]string{"payments=./incidents/pay", "inventory=./incidents/stock"},
+		[]string{"payments=payments.internal", "inventory=/inventory", "payments=billing.internal"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(incidents) != 2 || incidents[0].Dir != "./incidents/pay" || len(incidents[0].Hosts) != 2 || incidents[1].PathPrefix != "/inventory" {
+		t.Fatalf("incidents = %+v", incidents)
+	}
+	if _, err := parseServeIncidents([]string{"payments=./pay"}, []string{"missing=host.test"}); err == nil {
+		t.Fatal("expected a route to an unknown incident to be rejected")
+	}
+}
+
+func TestCACommandsKeepSettingsAndExport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "ca")
+	if code := runCA([]string{"show", "--dir", dir}); code != 1 {
+		t.Fatalf("show before init code=%d", code)
+	}
+	if code := runCA([]string{"init", "--dir", dir, "--key-type", "ecdsa", "--leaf-validity", "24h"}); code != 0 {
+		t.Fatalf("init code=%d", code)
+	}
+	if code := runCA([]string{"init", "--dir", dir, "--key-type", "dsa"}); code != 2 {
+		t.Fatalf("invalid key type code=%d", code)
+	}
+	if code := runCA([]string{"rotate", "--dir", dir, "--leaf-validity", "48h"}); code != 0 {
+		t.Fatalf("rotate code=%d", code)
+	}
+	settings, err := capture.LoadCASettings(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.KeyType != capture.KeyTypeECDSA || settings.LeafValidity != 48*time.Hour {
+		t.Fatalf("settings = %+v", settings)
+	}
+	out := filepath.Join(t.TempDir(), "ca.pem")
+	if code := runCA([]string{"export", "--dir", dir, "--out", out}); code != 0 {
+		t.Fatalf("export code=%d", code)
+	}
+	if data, err := os.ReadFile(out); err != nil || !strings.Contains(string(data), "BEGIN CERTIFICATE") {
+		t.Fatalf("export = %q, %v", data, err)
+	}
+	if code := runCA([]string{"export", "--dir", dir, "--format", "der"}); code != 2 {
+		t.Fatalf("invalid format code=%d", code)
+	}
+}
+
+func TestMinimizeShrinksIncidentToFailingRequest(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bad" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer target.Close()
+
+	incident := t.TempDir()
+	base := time.Now().UTC().Add(-time.Minute)
+	var lines []string
+	for i, path := range []string{"/a", "/b", "/c", "/bad", "/d", "/e"} {
+		trace := "trace-" + strconv.Itoa(i)
+		at := base.Add(time.Duration(i) * time.Millisecond)
+		for _, e := range []event.Event{
+			{ID: "req-" + strconv.Itoa(i), Type: "InboundRequest", TraceID: trace, Timestamp: at, Method: "GET", URL: path},
+			{ID: "resp-" + strconv.Itoa(i), Type: "InboundResponse", TraceID: trace, Timestamp: at, Status: http.StatusOK},
+		} {
+			line, _ := json.Marshal(e)
+			lines = append(lines, string(line))
+		}
+	}
+	if err := os.WriteFile(filepath.Join(incident, "inbound.log"), []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(t.TempDir(), "minimal")
+	code := runMinimize([]string{incident, "--out", out, "--",
+		"--target-base", target.URL, "--runs", "1", "--diff", "--min-gap", "0",
+		"--stub-listen", "127.0.0.1:0", "--stub-compat-listen", ""})
+	if code != 0 {
+		t.Fatalf("minimize exit code = %d", code)
+	}
+	kept, err := replaydriver.LoadInboundEvents(filepath.Join(out, "inbound.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != 1 || kept[0].URL != "/bad" || kept[0].Status != http.StatusOK {
+		t.Fatalf("kept = %+v", kept)
+	}
+	if signatures := failureSignatures(replayIncident([]string{out, "--target-base", target.URL, "--runs", "1", "--diff", "--stub-listen", "127.0.0.1:0", "--stub-compat-listen", ""})); signatures[0] != "FAIL_NON_DETERMINISTIC diff GET /bad: status 200 -> 500" {
+		t.Fatalf("minimized incident signatures = %v", signatures)
+	}
+}
@@ -4,6 +4,7 @@ go 1.25.12
 
 require (
 	github.com/bufbuild/protocompile v0.14.1
+	github.com/quic-go/quic-go v0.59.1
 	github.com/twmb/franz-go v1.21.6
 	github.com/twmb/franz-go/pkg/kfake v0.0.0-20260812150843-c7ff0052662a
 	golang.org/x/net v0.55.0
@@ -16,7 +17,9 @@ require (
 require (
 	github.com/klauspost/compress v1.18.7 // indirect
 	github.com/pierrec/lz4/v4 v4.1.26 // indirect
+	github.com/quic-go/qpack v0.6.0 // indirect
 	github.com/twmb/franz-go/pkg/kmsg v1.13.1 // indirect
+	golang.org/x/crypto v0.51.0 // indirect
 	golang.org/x/sync v0.21.0 // indirect
 	golang.org/x/text v0.39.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20260526163538-3dc84a4a5aaa // indirect
@@ -5,9 +5,11 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -28,6 +30,79 @@ func TestIncidentFilesRejectsSymlink(t *testing.T) {
 	}
 }
 
+func TestVerifyRequestsQueriesJournal(t *testing.T) {
+	var seen url.Values
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.URL.Query()
+		switch r.URL.Path {
+		case "/__infernosim/requests/count":
+			_, _ = w.Write([]byte(`{"count":2}`))
+		case "/__infernosim/requests":
+			_, _ = w.Write([]byte(`{"count":1,"requests":[{"sequence":3,"method":"POST","url":"http://payments.test/pay","result":"matched","event_index":0}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer admin.Close()
+	container := &Container{AdminURL: admin.URL}
+	query := RequestQuery{
+		Methods:  []string{http.MethodPost},
+		Path:     "^/pay$",
+		Headers:  map[string]string{"X-Tenant": "^acme$"},
+		JSONPath: map[string]string{"$.amount": "^42$"},
+	}
+	if err := container.VerifyRequests(context.Background(), query, 2); err != nil {
+		t.Fatal(err)
+	}
+	if seen.Get("method") != "POST" || seen.Get("header") != "X-Tenant:^acme$" || seen.Get("jsonpath") != "$.amount=^42$" {
+		t.Fatalf("query parameters = %v", seen)
+	}
+	if err := container.VerifyRequests(context.Background(), query, 1); err == nil || !strings.Contains(err.Error(), "got 2") {
+		t.Fatalf("expected count mismatch, got %v", err)
+	}
+	requests, err := container.Requests(context.Background(), RequestQuery{Result: "matched"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 || requests[0].EventIndex == nil || requests[0].Sequence != 3 {
+		t.Fatalf("requests = %+v", requests)
+	}
+}
+
+func TestSetScenarioStateForcesState(t *testing.T) {
+	states := map[string]string{"checkout": "cart"}
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/__infernosim/scenarios":
+			_ = json.NewEncoder(w).Encode(map[string]any{"scenarios": []any{}, "states": states})
+		case r.Method == http.MethodPut && r.URL.Path == "/__infernosim/scenarios/checkout/state":
+			var body struct{ State string }
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body.State != "payment_pending" {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				_, _ = w.Write([]byte(`{"error":"scenario \"checkout\" has no state"}`))
+				return
+			}
+			states["checkout"] = body.State
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer admin.Close()
+	container := &Container{AdminURL: admin.URL}
+	if err := container.SetScenarioState(context.Background(), "checkout", "payment_pending"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := container.ScenarioStates(context.Background())
+	if err != nil || got["checkout"] != "payment_pending" {
+		t.Fatalf("states = %v err=%v", got, err)
+	}
+	if err := container.SetScenarioState(context.Background(), "checkout", "refunded"); err == nil || !strings.Contains(err.Error(), "has no state") {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+}
+
 func TestContainerIntegration(t *testing.T) {
 	image := os.Getenv("INFERNOSIM_TEST_IMAGE")
 	if image == "" {
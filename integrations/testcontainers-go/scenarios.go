@@ -0,0 +1,50 @@
+package infernosim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ScenarioStates returns the current state of every scenario by name.
+func (container *Container) ScenarioStates(ctx context.Context) (map[string]string, error) {
+	var response struct {
+		States map[string]string `json:"states"`
+	}
+	if err := container.getJSON(ctx, "/__infernosim/scenarios", &response); err != nil {
+		return nil, err
+	}
+	return response.States, nil
+}
+
+// SetScenarioState forces a scenario into a named state, so a test can start
+// mid-workflow without replaying the calls that lead there. Reset restores
+// the initial state.
+func (container *Container) SetScenarioState(ctx context.Context, scenario, state string) error {
+	body, err := json.Marshal(map[string]string{"state": state})
+	if err != nil {
+		return err
+	}
+	path := "/__infernosim/scenarios/" + url.PathEscape(scenario) + "/state"
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, container.AdminURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(response.Body).Decode(&failure)
+		return fmt.Errorf("InfernoSIM %s returned %s: %s", path, response.Status, failure.Error)
+	}
+	return nil
+}
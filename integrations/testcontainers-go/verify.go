@@ -0,0 +1,147 @@
+package infernosim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RequestQuery filters the simulator's request journal. Host, Path, and map
+// values are regular expressions; empty fields match every request.
+type RequestQuery struct {
+	Methods []string
+	Host    string
+	Path    string
+	// ClientIdentity matches the verified mTLS client identity.
+	ClientIdentity string
+	// Headers, Query, and JSONPath map a header name, query parameter, or
+	// JSONPath such as "$.amount" to a regular expression.
+	Headers  map[string]string
+	Query    map[string]string
+	JSONPath map[string]string
+	// Result is matched, scenario, recorded, unmatched, or invalid.
+	Result string
+}
+
+// LoggedRequest is one privacy-filtered request from the journal.
+type LoggedRequest struct {
+	Sequence       int64               `json:"sequence"`
+	Time           time.Time           `json:"time"`
+	Method         string              `json:"method"`
+	URL            string              `json:"url"`
+	Headers        map[string][]string `json:"headers"`
+	Body           string              `json:"body"`
+	ClientIdentity string              `json:"client_identity"`
+	Result         string              `json:"result"`
+	EventIndex     *int                `json:"event_index"`
+	EventID        string              `json:"event_id"`
+	Scenario       string              `json:"scenario"`
+	Step           string              `json:"step"`
+	MissReason     string              `json:"miss_reason"`
+}
+
+// Requests returns the journaled requests that match query, oldest first.
+func (container *Container) Requests(ctx context.Context, query RequestQuery) ([]LoggedRequest, error) {
+	var result struct {
+		Requests []LoggedRequest `json:"requests"`
+	}
+	if err := container.getJSON(ctx, "/__infernosim/requests?"+query.values().Encode(), &result); err != nil {
+		return nil, err
+	}
+	return result.Requests, nil
+}
+
+// CountRequests returns how many journaled requests match query.
+func (container *Container) CountRequests(ctx context.Context, query RequestQuery) (int, error) {
+	var result struct {
+		Count int `json:"count"`
+	}
+	if err := container.getJSON(ctx, "/__infernosim/requests/count?"+query.values().Encode(), &result); err != nil {
+		return 0, err
+	}
+	return result.Count, nil
+}
+
+// VerifyRequests returns an error unless exactly times journaled requests
+// match query, for assertions such as "payments was called twice".
+func (container *Container) VerifyRequests(ctx context.Context, query RequestQuery, times int) error {
+	count, err := container.CountRequests(ctx, query)
+	if err != nil {
+		return err
+	}
+	if count != times {
+		return fmt.Errorf("expected %d requests matching %s, got %d", times, query, count)
+	}
+	return nil
+}
+
+func (query RequestQuery) values() url.Values {
+	values := url.Values{}
+	if len(query.Methods) > 0 {
+		values.Set("method", strings.Join(query.Methods, ","))
+	}
+	for name, value := range map[string]string{"host": query.Host, "path": query.Path, "client_identity": query.ClientIdentity, "result": query.Result} {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+	for _, selector := range []struct {
+		param     string
+		separator string
+		patterns  map[string]string
+	}{
+		{"header", ":", query.Headers},
+		{"query", "=", query.Query},
+		{"jsonpath", "=", query.JSONPath},
+	} {
+		for _, name := range sortedKeys(selector.patterns) {
+			values.Add(selector.param, name+selector.separator+selector.patterns[name])
+		}
+	}
+	return values
+}
+
+func (query RequestQuery) String() string {
+	if encoded := query.values().Encode(); encoded != "" {
+		unescaped, err := url.QueryUnescape(encoded)
+		if err == nil {
+			return "{" + unescaped + "}"
+		}
+		return "{" + encoded + "}"
+	}
+	return "{any request}"
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (container *Container) getJSON(ctx context.Context, path string, value any) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, container.AdminURL+path, nil)
+	if err != nil {
+		return err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(response.Body).Decode(&failure)
+		return fmt.Errorf("InfernoSIM %s returned %s: %s", strings.SplitN(path, "?", 2)[0], response.Status, failure.Error)
+	}
+	return json.NewDecoder(response.Body).Decode(value)
+}
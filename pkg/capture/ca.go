@@ -0,0 +1,433 @@
+package capture
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Key types for generated CAs and leaves.
+const (
+	KeyTypeRSA   = "rsa"
+	KeyTypeECDSA = "ecdsa"
+)
+
+// DefaultLeafValidity is how long generated leaves are valid unless the CA
+// settings say otherwise.
+const DefaultLeafValidity = 30 * 24 * time.Hour
+
+const caSettingsFile = "settings.json"
+
+// CASettings configure the key type of generated CAs and leaves and how long
+// leaves are valid. They are stored beside the CA in settings.json, so every
+// command using the directory applies them.
+type CASettings struct {
+	// KeyType is rsa, the default, or ecdsa.
+	KeyType string
+	// LeafValidity is zero for DefaultLeafValidity. Leaves never outlive
+	// the CA.
+	LeafValidity time.Duration
+}
+
+type caSettingsJSON struct {
+	KeyType      string `json:"key_type,omitempty"`
+	LeafValidity string `json:"leaf_validity,omitempty"`
+}
+
+// Validate reports an unknown key type or a negative leaf validity.
+func (c CASettings) Validate() error {
+	switch c.KeyType {
+	case "", KeyTypeRSA, KeyTypeECDSA:
+	default:
+		return fmt.Errorf("key type %q must be rsa or ecdsa", c.KeyType)
+	}
+	if c.LeafValidity < 0 {
+		return fmt.Errorf("leaf validity must not be negative")
+	}
+	return nil
+}
+
+func (c CASettings) leafValidity() time.Duration {
+	if c.LeafValidity == 0 {
+		return DefaultLeafValidity
+	}
+	return c.LeafValidity
+}
+
+func (c CASettings) keyType() string {
+	if c.KeyType == "" {
+		return KeyTypeRSA
+	}
+	return c.KeyType
+}
+
+// LoadCASettings reads the settings stored in a CA directory. A directory
+// without settings.json has the zero settings.
+func LoadCASettings(dir string) (CASettings, error) {
+	data, err := os.ReadFile(filepath.Join(dir, caSettingsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return CASettings{}, nil
+	}
+	if err != nil {
+		return CASettings{}, fmt.Errorf("read CA settings: %w", err)
+	}
+	var stored caSettingsJSON
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return CASettings{}, fmt.Errorf("parse CA settings: %w", err)
+	}
+	settings := CASettings{KeyType: stored.KeyType}
+	if stored.LeafValidity != "" {
+		if settings.LeafValidity, err = time.ParseDuration(stored.LeafValidity); err != nil {
+			return CASettings{}, fmt.Errorf("parse CA settings: leaf_validity: %w", err)
+		}
+	}
+	if err := settings.Validate(); err != nil {
+		return CASettings{}, fmt.Errorf("CA settings: %w", err)
+	}
+	return settings, nil
+}
+
+// InitCAStoreAt stores settings in caDir and loads its CA, generating one
+// with those settings when none exists. The bool reports whether a CA was
+// generated.
+func InitCAStoreAt(caDir string, settings CASettings) (*CAStore, bool, error) {
+	if err := settings.Validate(); err != nil {
+		return nil, false, err
+	}
+	if err := os.MkdirAll(caDir, 0700); err != nil {
+		return nil, false, fmt.Errorf("failed to create CA dir: %w", err)
+	}
+	store, err := openCAStore(caDir)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := store.Configure(settings); err != nil {
+		return nil, false, err
+	}
+	created := !store.exists()
+	if err := store.loadOrGenerateCA(); err != nil {
+		return nil, false, err
+	}
+	return store, created, nil
+}
+
+// ImportCAAt installs an existing CA certificate, such as a corporate
+// intermediate, and its private key in caDir. certPEM may carry the
+// certificate's issuers after it; they are sent with every leaf so clients
+// that trust only the corporate root accept the leaves. A CA already in
+// caDir is retired first, and the retired directory is returned.
+func ImportCAAt(caDir string, certPEM, keyPEM []byte) (*CAStore, string, error) {
+	if err := os.MkdirAll(caDir, 0700); err != nil {
+		return nil, "", fmt.Errorf("failed to create CA dir: %w", err)
+	}
+	store, err := openCAStore(caDir)
+	if err != nil {
+		return nil, "", err
+	}
+	retired, err := store.importCA(certPEM, keyPEM)
+	if err != nil {
+		return nil, retired, err
+	}
+	return store, retired, nil
+}
+
+// Settings returns the store's CA settings.
+func (s *CAStore) Settings() CASettings {
+	return s.settings
+}
+
+// Configure validates and stores settings. Cached leaves are discarded when
+// the settings change, so new leaves use them.
+func (s *CAStore) Configure(settings CASettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	if settings == s.settings {
+		return nil
+	}
+	stored := caSettingsJSON{KeyType: settings.KeyType}
+	if settings.LeafValidity != 0 {
+		stored.LeafValidity = settings.LeafValidity.String()
+	}
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, caSettingsFile), append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("write CA settings: %w", err)
+	}
+	s.settings = settings
+	return s.clearLeaves()
+}
+
+// CAInfo describes a CA for display. ChainLength counts the CA certificate
+// and the issuers stored after it.
+type CAInfo struct {
+	Path         string
+	Subject      string
+	Issuer       string
+	Serial       string
+	NotBefore    time.Time
+	NotAfter     time.Time
+	KeyType      string
+	SHA256       string
+	Intermediate bool
+	ChainLength  int
+	CachedLeaves int
+	Settings     CASettings
+}
+
+// Info describes the store's CA.
+func (s *CAStore) Info() CAInfo {
+	fingerprint := sha256.Sum256(s.caCert.Raw)
+	info := CAInfo{
+		Path:         s.certPath,
+		Subject:      s.caCert.Subject.String(),
+		Issuer:       s.caCert.Issuer.String(),
+		Serial:       s.caCert.SerialNumber.Text(16),
+		NotBefore:    s.caCert.NotBefore,
+		NotAfter:     s.caCert.NotAfter,
+		KeyType:      keyTypeOf(s.caCert.PublicKey),
+		SHA256:       hex.EncodeToString(fingerprint[:]),
+		Intermediate: !bytes.Equal(s.caCert.RawIssuer, s.caCert.RawSubject),
+		ChainLength:  len(s.caChain),
+		Settings:     s.settings,
+	}
+	if entries, err := os.ReadDir(s.leafDir()); err == nil {
+		info.CachedLeaves = len(entries)
+	}
+	return info
+}
+
+func keyTypeOf(key crypto.PublicKey) string {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return KeyTypeRSA
+	case *ecdsa.PublicKey:
+		return KeyTypeECDSA
+	}
+	return fmt.Sprintf("%T", key)
+}
+
+// CertificatePEM returns the CA certificate followed by any stored issuers.
+func (s *CAStore) CertificatePEM() []byte {
+	var out bytes.Buffer
+	for _, der := range s.caChain {
+		_ = pem.Encode(&out, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	return out.Bytes()
+}
+
+// systemBundlePaths are the usual locations of the system trust bundle.
+var systemBundlePaths = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// TrustBundle returns the system trust bundle followed by the CA and any
+// stored issuers it lacks, for applications configured with a single CA file
+// such as SSL_CERT_FILE. When systemBundle is empty, SSL_CERT_FILE and then
+// the usual system locations are tried.
+func (s *CAStore) TrustBundle(systemBundle string) ([]byte, error) {
+	candidates := systemBundlePaths
+	if env := os.Getenv("SSL_CERT_FILE"); env != "" {
+		candidates = append([]string{env}, candidates...)
+	}
+	if systemBundle != "" {
+		candidates = []string{systemBundle}
+	}
+	for _, path := range candidates {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if systemBundle != "" {
+				return nil, fmt.Errorf("read system trust bundle: %w", err)
+			}
+			continue
+		}
+		data = append(bytes.TrimRight(data, "\n"), '\n')
+		// A previous export used as SSL_CERT_FILE already holds the CA, and
+		// a corporate root may already be in the system bundle.
+		for _, der := range s.caChain {
+			block := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+			if !bytes.Contains(data, block) {
+				data = append(data, block...)
+			}
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("no system trust bundle found; pass its path explicitly")
+}
+
+// Rotate retires the current CA and generates a new one with the configured
+// key type. Cached leaves are discarded, and applications must trust the new
+// CA. It returns the directory holding the retired CA.
+func (s *CAStore) Rotate() (string, error) {
+	retired, err := s.retire()
+	if err != nil {
+		return "", err
+	}
+	if err := s.generateCA(); err != nil {
+		return retired, err
+	}
+	return retired, nil
+}
+
+func (s *CAStore) importCA(certPEM, keyPEM []byte) (string, error) {
+	cert, chain, key, err := parseCAMaterial(certPEM, keyPEM)
+	if err != nil {
+		return "", err
+	}
+	if !cert.IsCA || (cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageCertSign == 0) {
+		return "", fmt.Errorf("certificate %q cannot sign certificates", cert.Subject.String())
+	}
+	if time.Now().After(cert.NotAfter) {
+		return "", fmt.Errorf("certificate %q expired at %s", cert.Subject.String(), cert.NotAfter.Format(time.RFC3339))
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	var chainPEM bytes.Buffer
+	for _, der := range chain {
+		_ = pem.Encode(&chainPEM, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	retired, err := s.retire()
+	if err != nil {
+		return "", err
+	}
+	if err := writeCAFiles(s.certPath, s.keyPath, chainPEM.Bytes(), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})); err != nil {
+		return retired, err
+	}
+	s.caCert, s.caChain, s.caKey = cert, chain, key
+	return retired, nil
+}
+
+// retire moves the CA files to retired/<UTC timestamp>/ and discards cached
+// leaves. It returns "" when there was no CA to retire.
+func (s *CAStore) retire() (string, error) {
+	if err := s.clearLeaves(); err != nil {
+		return "", err
+	}
+	if !s.exists() {
+		return "", nil
+	}
+	dir := filepath.Join(s.dir, "retired", time.Now().UTC().Format("20060102T150405.000000000Z"))
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("retire CA: %w", err)
+	}
+	for _, path := range []string{s.certPath, s.keyPath} {
+		if err := os.Rename(path, filepath.Join(dir, filepath.Base(path))); err != nil {
+			return "", fmt.Errorf("retire CA: %w", err)
+		}
+	}
+	return dir, nil
+}
+
+func (s *CAStore) leafDir() string {
+	if s.dir == "" {
+		return ""
+	}
+	return filepath.Join(s.dir, "leaves")
+}
+
+func (s *CAStore) leafPath(host string) string {
+	name := strings.NewReplacer(":", "_", "/", "_", "\\", "_").Replace(host)
+	return filepath.Join(s.leafDir(), name+".pem")
+}
+
+// leafUsable reports whether a cached leaf has more than a tenth of the
+// configured validity left.
+func (s *CAStore) leafUsable(cert *tls.Certificate) bool {
+	return cert.Leaf != nil && time.Until(cert.Leaf.NotAfter) > s.settings.leafValidity()/10
+}
+
+// loadLeaf returns the persisted leaf for host when it was issued by the
+// current CA with the configured key type and is still usable.
+func (s *CAStore) loadLeaf(host string) *tls.Certificate {
+	if s.leafDir() == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.leafPath(host))
+	if err != nil {
+		return nil
+	}
+	var certDER, keyDER []byte
+	for rest := data; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE":
+			certDER = block.Bytes
+		case "PRIVATE KEY":
+			keyDER = block.Bytes
+		}
+	}
+	leaf, err := x509.ParseCertificate(certDER)
+	if err != nil || leaf.CheckSignatureFrom(s.caCert) != nil || leaf.VerifyHostname(host) != nil {
+		return nil
+	}
+	key, err := parsePrivateKey(keyDER)
+	if err != nil || keyTypeOf(key.Public()) != s.settings.keyType() {
+		return nil
+	}
+	public, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !public.Equal(leaf.PublicKey) {
+		return nil
+	}
+	cert := &tls.Certificate{
+		Certificate: append([][]byte{certDER}, s.caChain...),
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	if !s.leafUsable(cert) {
+		return nil
+	}
+	return cert
+}
+
+// storeLeaf persists a leaf for later runs. The cache is best effort: a
+// leaf that cannot be written is simply issued again next time.
+func (s *CAStore) storeLeaf(host string, cert *tls.Certificate) {
+	if s.leafDir() == "" {
+		return
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil || os.MkdirAll(s.leafDir(), 0700) != nil {
+		return
+	}
+	var out bytes.Buffer
+	_ = pem.Encode(&out, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	_ = pem.Encode(&out, &pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	_ = os.WriteFile(s.leafPath(host), out.Bytes(), 0600)
+}
+
+func (s *CAStore) clearLeaves() error {
+	s.cacheMu.Lock()
+	s.leafCert = make(map[string]*tls.Certificate)
+	s.cacheMu.Unlock()
+	if s.leafDir() == "" {
+		return nil
+	}
+	if err := os.RemoveAll(s.leafDir()); err != nil {
+		return fmt.Errorf("clear leaf cache: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package capture
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCAStorePersistsECDSALeavesAcrossRunsAndRotates(t *testing.T) {
+	dir := t.TempDir()
+	store, created, err := InitCAStoreAt(dir, CASettings{KeyType: KeyTypeECDSA, LeafValidity: 48 * time.Hour})
+	if err != nil || !created {
+		t.Fatalf("init: created=%v err=%v", created, err)
+	}
+	if store.Info().KeyType != KeyTypeECDSA {
+		t.Fatalf("CA key type = %s, want ecdsa", store.Info().KeyType)
+	}
+	leaf, err := store.GenerateLeafCert("api.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := leaf.Leaf.PublicKey.(*ecdsa.PublicKey); !ok {
+		t.Fatalf("leaf key = %T, want ECDSA", leaf.Leaf.PublicKey)
+	}
+	if validity := time.Until(leaf.Leaf.NotAfter); validity > 48*time.Hour || validity < 47*time.Hour {
+		t.Fatalf("leaf validity = %s, want about 48h", validity)
+	}
+
+	reopened, ok, err := LoadCAStoreAt(dir)
+	if err != nil || !ok {
+		t.Fatalf("reload: ok=%v err=%v", ok, err)
+	}
+	if reopened.Settings() != store.Settings() {
+		t.Fatalf("settings = %+v, want %+v", reopened.Settings(), store.Settings())
+	}
+	if reopened.Info().CachedLeaves != 1 {
+		t.Fatalf("cached leaves = %d, want 1", reopened.Info().CachedLeaves)
+	}
+	cached, err := reopened.GenerateLeafCert("api.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cached.Certificate[0], leaf.Certificate[0]) {
+		t.Fatal("expected the persisted leaf to be reused by a new run")
+	}
+
+	before := reopened.Info().SHA256
+	retired, err := reopened.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(retired, "infernosim-ca.key")); err != nil {
+		t.Fatalf("retired key: %v", err)
+	}
+	if info := reopened.Info(); info.SHA256 == before || info.CachedLeaves != 0 {
+		t.Fatalf("after rotate: %+v", info)
+	}
+	rotatedLeaf, err := reopened.GenerateLeafCert("api.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rotatedLeaf.Leaf.CheckSignatureFrom(reopened.caCert); err != nil {
+		t.Fatalf("leaf not signed by rotated CA: %v", err)
+	}
+}
+
+func TestImportIntermediateCAServesChainAndBuildsTrustBundle(t *testing.T) {
+	root := newTestPKI(t, "corporate root")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "corporate proxy intermediate"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, root.cert, &key.PublicKey, root.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.cert.Raw})...)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	dir := t.TempDir()
+	clientFile, clientKey := root.issue(t, "not-a-ca", "")
+	clientCertPEM, _ := os.ReadFile(clientFile)
+	clientKeyPEM, _ := os.ReadFile(clientKey)
+	if _, _, err := ImportCAAt(dir, clientCertPEM, clientKeyPEM); err == nil {
+		t.Fatal("expected a certificate that cannot sign to be rejected")
+	}
+	if _, _, err := ImportCAAt(dir, certPEM, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: mustPKCS8(t)})); err == nil {
+		t.Fatal("expected a key that does not match the certificate to be rejected")
+	}
+
+	store, retired, err := ImportCAAt(dir, certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retired != "" {
+		t.Fatalf("retired = %q, want nothing to retire", retired)
+	}
+	if info := store.Info(); !info.Intermediate || info.ChainLength != 2 {
+		t.Fatalf("info = %+v", info)
+	}
+	leaf, err := store.GenerateLeafCert("payments.internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leaf.Certificate) != 3 {
+		t.Fatalf("leaf chain length = %d, want leaf, intermediate, root", len(leaf.Certificate))
+	}
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(store.caCert)
+	if _, err := leaf.Leaf.Verify(x509.VerifyOptions{DNSName: "payments.internal", Roots: root.pool, Intermediates: intermediates}); err != nil {
+		t.Fatalf("leaf does not verify against the corporate root: %v", err)
+	}
+
+	systemBundle := filepath.Join(t.TempDir(), "system.pem")
+	if err := os.WriteFile(systemBundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.cert.Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := store.TrustBundle(systemBundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := bytes.Count(bundle, []byte("BEGIN CERTIFICATE")); got != 2 {
+		t.Fatalf("bundle has %d certificates, want system root plus CA", got)
+	}
+	if again, _ := store.TrustBundle(systemBundle); !bytes.Equal(again, bundle) {
+		t.Fatal("expected the trust bundle to be stable")
+	}
+}
+
+func mustPKCS8(t *testing.T) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
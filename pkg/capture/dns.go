@@ -0,0 +1,48 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"infernosim/pkg/event"
+)
+
+type resolutionLogKey struct{}
+
+// withResolutionLog makes destination lookups made with the returned context
+// log DNSResolution events through proxy.
+func withResolutionLog(ctx context.Context, proxy *ProxyContext) context.Context {
+	return context.WithValue(ctx, resolutionLogKey{}, proxy)
+}
+
+// logResolution records a lookup of host as a DNSResolution event when ctx
+// carries a proxy. IP literals need no lookup and are not recorded. A name
+// that does not exist is recorded with the error NXDOMAIN so replays can
+// reproduce it.
+func logResolution(ctx context.Context, host string, start time.Time, addrs []net.IPAddr, err error) {
+	proxy, ok := ctx.Value(resolutionLogKey{}).(*ProxyContext)
+	if !ok || proxy == nil || net.ParseIP(host) != nil {
+		return
+	}
+	evt := &event.Event{
+		ID:        event.GenerateID(),
+		Type:      "DNSResolution",
+		Timestamp: start.UTC(),
+		Service:   host,
+		URL:       "dns://" + host,
+		Duration:  time.Since(start),
+	}
+	for _, addr := range addrs {
+		evt.Addresses = append(evt.Addresses, addr.IP.String())
+	}
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		evt.Error = "NXDOMAIN"
+	case err != nil:
+		evt.Error = err.Error()
+	}
+	proxy.writeEvent(evt)
+}
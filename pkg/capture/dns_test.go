@@ -0,0 +1,66 @@
+package capture
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"infernosim/pkg/event"
+)
+
+func TestForwardProxyRecordsDNSResolutions(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	logPath := filepath.Join(t.TempDir(), "outbound.log")
+	logger, err := event.NewLogger(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+	proxy, err := StartForwardProxy("127.0.0.1:0", &ProxyContext{
+		Logger:                   logger,
+		AllowPrivateDestinations: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse("http://" + proxy.Addr)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	for _, target := range []string{strings.Replace(upstream.URL, "127.0.0.1", "localhost", 1), upstream.URL} {
+		resp, err := client.Get(target + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resolutions []event.Event
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var evt event.Event
+		if json.Unmarshal([]byte(line), &evt) == nil && evt.Type == "DNSResolution" {
+			resolutions = append(resolutions, evt)
+		}
+	}
+	if len(resolutions) != 1 {
+		t.Fatalf("resolutions = %+v, want one for localhost only", resolutions)
+	}
+	if got := resolutions[0]; got.URL != "dns://localhost" || got.Error != "" || len(got.Addresses) == 0 {
+		t.Fatalf("resolution = %+v", got)
+	}
+}
@@ -0,0 +1,195 @@
+package capture
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"infernosim/pkg/privacy"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Host policy actions.
+const (
+	// HostIntercept terminates TLS with a generated leaf and records the
+	// exchanges.
+	HostIntercept = "intercept"
+	// HostTunnel relays the connection to the destination without
+	// inspecting it.
+	HostTunnel = "tunnel"
+	// HostBlock refuses the connection.
+	HostBlock = "block"
+)
+
+// HostPolicy decides how traffic to matching hosts is handled and how
+// intercepted exchanges with them are recorded.
+type HostPolicy struct {
+	// Host is an exact hostname; a glob whose wildcards stay within one
+	// label, such as *.payments.internal or api-?.example.test; a suffix with
+	// a leading dot, such as .payments.internal, which matches the domain and
+	// every subdomain; or * for every host.
+	Host string `yaml:"host"`
+	// Action is intercept, the default, tunnel, or block.
+	Action string `yaml:"action"`
+	// PrivacyPolicy is a privacy policy file that replaces the proxy's
+	// policy for matching hosts. A relative path is resolved against the
+	// host policy file.
+	PrivacyPolicy string `yaml:"privacy_policy"`
+	// MaxBodyBytes bounds the captured request and response bodies. Zero
+	// keeps the 256 KiB default.
+	MaxBodyBytes int `yaml:"max_body_bytes"`
+
+	privacy *privacy.Policy
+}
+
+// HostPolicies is an ordered list of host policies. The first policy whose
+// pattern matches a host applies. A nil *HostPolicies matches nothing.
+type HostPolicies struct {
+	policies []HostPolicy
+}
+
+type hostPolicyFile struct {
+	Version int          `yaml:"version"`
+	Hosts   []HostPolicy `yaml:"hosts"`
+}
+
+// LoadHostPolicies reads a host policy file:
+//
+//	version: 1
+//	hosts:
+//	  - host: "*.payments.internal"
+//	    privacy_policy: payments-privacy.yaml
+//	    max_body_bytes: 65536
+//	  - host: .cdn.example.test
+//	    action: tunnel
+func LoadHostPolicies(file string) (*HostPolicies, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("load host policy %q: %w", file, err)
+	}
+	var parsed hostPolicyFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("parse host policy %q: %w", file, err)
+	}
+	if parsed.Version != 1 {
+		return nil, fmt.Errorf("host policy version must be 1")
+	}
+	policies, err := NewHostPolicies(parsed.Hosts, filepath.Dir(file))
+	if err != nil {
+		return nil, fmt.Errorf("host policy %q: %w", file, err)
+	}
+	return policies, nil
+}
+
+// NewHostPolicies validates policies and loads their privacy policies,
+// resolving relative paths against baseDir.
+func NewHostPolicies(policies []HostPolicy, baseDir string) (*HostPolicies, error) {
+	out := &HostPolicies{policies: make([]HostPolicy, 0, len(policies))}
+	for _, policy := range policies {
+		policy.Host = strings.ToLower(strings.TrimSpace(policy.Host))
+		if err := ValidateHostPattern(policy.Host); err != nil {
+			return nil, err
+		}
+		switch policy.Action {
+		case "":
+			policy.Action = HostIntercept
+		case HostIntercept, HostTunnel, HostBlock:
+		default:
+			return nil, fmt.Errorf("host %q: action %q must be intercept, tunnel, or block", policy.Host, policy.Action)
+		}
+		if policy.MaxBodyBytes < 0 {
+			return nil, fmt.Errorf("host %q: max_body_bytes must not be negative", policy.Host)
+		}
+		if policy.PrivacyPolicy != "" {
+			file := policy.PrivacyPolicy
+			if !filepath.IsAbs(file) && baseDir != "" {
+				file = filepath.Join(baseDir, file)
+			}
+			loaded, err := privacy.Load(file)
+			if err != nil {
+				return nil, fmt.Errorf("host %q: %w", policy.Host, err)
+			}
+			policy.privacy = loaded
+		}
+		out.policies = append(out.policies, policy)
+	}
+	return out, nil
+}
+
+// Lookup returns the first policy matching host, which may carry a port.
+func (p *HostPolicies) Lookup(host string) (HostPolicy, bool) {
+	if p == nil {
+		return HostPolicy{}, false
+	}
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	for _, policy := range p.policies {
+		if MatchHost(policy.Host, host) {
+			return policy, true
+		}
+	}
+	return HostPolicy{}, false
+}
+
+// Exact reports whether the policy names a single host rather than a
+// pattern. Only an exact policy authorizes loopback and private destinations.
+func (p HostPolicy) Exact() bool {
+	return IsExactHostPattern(p.Host)
+}
+
+// IsExactHostPattern reports whether pattern names a single host: it has no
+// wildcard and no leading dot.
+func IsExactHostPattern(pattern string) bool {
+	pattern = strings.TrimSpace(pattern)
+	return pattern != "" && !strings.HasPrefix(pattern, ".") && !strings.ContainsAny(pattern, "*?[")
+}
+
+// ValidateHostPattern reports patterns MatchHost cannot use.
+func ValidateHostPattern(pattern string) error {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" || pattern == "." {
+		return fmt.Errorf("host pattern is empty")
+	}
+	for _, label := range strings.Split(strings.TrimPrefix(pattern, "."), ".") {
+		if _, err := path.Match(label, ""); err != nil {
+			return fmt.Errorf("host pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// MatchHost reports whether host matches pattern, ignoring case and a
+// trailing dot. See HostPolicy.Host for the pattern forms.
+func MatchHost(pattern, host string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	host = strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+	switch {
+	case pattern == "" || host == "":
+		return false
+	case pattern == "*":
+		return true
+	case strings.HasPrefix(pattern, "."):
+		return host == pattern[1:] || strings.HasSuffix(host, pattern)
+	case !strings.ContainsAny(pattern, "*?["):
+		return pattern == host
+	}
+	patternLabels := strings.Split(pattern, ".")
+	hostLabels := strings.Split(host, ".")
+	if len(patternLabels) != len(hostLabels) {
+		return false
+	}
+	for i, label := range patternLabels {
+		if matched, err := path.Match(label, hostLabels[i]); err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,217 @@
+package capture
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"infernosim/pkg/event"
+)
+
+func TestMatchHostPatterns(t *testing.T) {
+	cases := []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"api.example.test", "API.example.test", true},
+		{"api.example.test", "api.example.test.", true},
+		{"api.example.test", "v2.api.example.test", false},
+		{"*.payments.internal", "ledger.payments.internal", true},
+		{"*.payments.internal", "payments.internal", false},
+		{"*.payments.internal", "a.ledger.payments.internal", false},
+		{"api-?.example.test", "api-1.example.test", true},
+		{".payments.internal", "payments.internal", true},
+		{".payments.internal", "a.ledger.payments.internal", true},
+		{".payments.internal", "evilpayments.internal", false},
+		{"*", "anything.example.test", true},
+		{"127.0.0.*", "127.0.0.1", true},
+	}
+	for _, tc := range cases {
+		if got := MatchHost(tc.pattern, tc.host); got != tc.want {
+			t.Errorf("MatchHost(%q, %q) = %v, want %v", tc.pattern, tc.host, got, tc.want)
+		}
+	}
+	if err := ValidateHostPattern("[.example.test"); err == nil {
+		t.Fatal("expected a malformed glob to be rejected")
+	}
+	ca := &CAStore{AllowedHosts: []string{"*.payments.internal"}}
+	if !ca.IsAllowed("ledger.payments.internal") || ca.IsAllowed("localhost") {
+		t.Fatal("expected the CA allowlist to use host patterns")
+	}
+}
+
+func TestCANamesHostOnlyForExactEntries(t *testing.T) {
+	ca := &CAStore{AllowedHosts: []string{"*", "api.example.test", ".corp.test"}}
+	if !ca.NamesHost("API.example.test") {
+		t.Fatal("expected an exact allowlist entry to name the host")
+	}
+	for _, host := range []string{"db.corp.test", "127.0.0.1", "other.example.test"} {
+		if !ca.IsAllowed(host) || ca.NamesHost(host) {
+			t.Fatalf("expected %s to be allowed only by a pattern", host)
+		}
+	}
+	if !(&CAStore{}).NamesHost("localhost") {
+		t.Fatal("expected the default localhost allowlist to name localhost")
+	}
+	policies, err := NewHostPolicies([]HostPolicy{{Host: "*.payments.internal"}, {Host: "ledger.test"}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca = &CAStore{Hosts: policies, AllowedHosts: []string{"ledger.payments.internal"}}
+	if ca.NamesHost("ledger.payments.internal") || !ca.NamesHost("ledger.test") {
+		t.Fatal("expected the first matching host policy to decide")
+	}
+}
+
+func TestLoadHostPoliciesFirstMatchWins(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "payments-privacy.yaml"), []byte("version: 1\ncapture_bodies: true\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "hosts.yaml")
+	if err := os.WriteFile(file, []byte(`version: 1
+hosts:
+  - host: legacy.payments.internal
+    action: tunnel
+  - host: "*.payments.internal"
+    privacy_policy: payments-privacy.yaml
+    max_body_bytes: 1024
+  - host: .ads.example.test
+    action: block
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	policies, err := LoadHostPolicies(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy, ok := policies.Lookup("legacy.payments.internal:443"); !ok || policy.Action != HostTunnel {
+		t.Fatalf("legacy policy = %+v, %v", policy, ok)
+	}
+	policy, ok := policies.Lookup("ledger.payments.internal")
+	if !ok || policy.Action != HostIntercept || policy.MaxBodyBytes != 1024 || policy.privacy == nil || !policy.privacy.CaptureBodies {
+		t.Fatalf("ledger policy = %+v, %v", policy, ok)
+	}
+	if policy, ok := policies.Lookup("cdn.ads.example.test"); !ok || policy.Action != HostBlock {
+		t.Fatalf("ads policy = %+v, %v", policy, ok)
+	}
+	if _, ok := policies.Lookup("example.test"); ok {
+		t.Fatal("expected no policy for an unmatched host")
+	}
+
+	if err := os.WriteFile(file, []byte("version: 1\nhosts:\n  - host: a.test\n    action: inspect\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadHostPolicies(file); err == nil || !strings.Contains(err.Error(), "action") {
+		t.Fatalf("expected an unknown action to be rejected, got %v", err)
+	}
+}
+
+func TestForwardProxyAppliesHostPolicyActions(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Session-Token", "upstream-secret")
+		_, _ = w.Write([]byte("policy-response-body"))
+	}))
+	defer upstream.Close()
+
+	ca, err := NewCAStoreAt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.AllowedHosts = []string{"unrelated.example.test"}
+	mitmRoots := x509.NewCertPool()
+	mitmRoots.AppendCertsFromPEM(ca.CertificatePEM())
+	upstreamRoots := upstream.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "privacy.yaml"), []byte("version: 1\nheaders:\n  - name: X-Session-Token\n    action: redact\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(t *testing.T, action string, roots *x509.CertPool) (*http.Response, []event.Event, error) {
+		t.Helper()
+		policies, err := NewHostPolicies([]HostPolicy{{
+			Host: "127.0.0.*", Action: action, PrivacyPolicy: "privacy.yaml", MaxBodyBytes: 6,
+		}}, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		logPath := filepath.Join(t.TempDir(), "outbound.log")
+		logger, err := event.NewLogger(logPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer logger.Close()
+		proxy, err := StartForwardProxy("127.0.0.1:0", &ProxyContext{
+			Logger:                   logger,
+			CA:                       ca,
+			UseMITM:                  true,
+			AllowPrivateDestinations: true,
+			AllowInsecureUpstream:    true,
+			CaptureSensitiveData:     true,
+			Hosts:                    policies,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer proxy.Close()
+		proxyURL, _ := url.Parse("http://" + proxy.Addr)
+		client := &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12},
+		}}
+		resp, getErr := client.Get(upstream.URL + "/policy")
+		if getErr == nil {
+			_ = resp.Body.Close()
+		}
+		data, err := os.ReadFile(logPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var events []event.Event
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var evt event.Event
+			if line != "" && json.Unmarshal([]byte(line), &evt) == nil {
+				events = append(events, evt)
+			}
+		}
+		return resp, events, getErr
+	}
+
+	t.Run("block", func(t *testing.T) {
+		if _, events, err := run(t, HostBlock, mitmRoots); err == nil || len(events) != 0 {
+			t.Fatalf("expected a refused CONNECT, got err=%v events=%+v", err, events)
+		}
+	})
+	t.Run("tunnel", func(t *testing.T) {
+		resp, events, err := run(t, HostTunnel, upstreamRoots)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("tunnel through the upstream's own certificate: %v", err)
+		}
+		if len(events) != 1 || events[0].Method != http.MethodConnect {
+			t.Fatalf("events = %+v, want only the CONNECT", events)
+		}
+	})
+	t.Run("intercept", func(t *testing.T) {
+		resp, events, err := run(t, HostIntercept, mitmRoots)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("intercept despite the CA allowlist: %v", err)
+		}
+		if len(events) != 2 || events[1].Method != http.MethodGet {
+			t.Fatalf("events = %+v, want the CONNECT and the decrypted GET", events)
+		}
+		if !events[1].ResponseBodyTruncated {
+			t.Fatal("expected the host's body bound to truncate the capture")
+		}
+		if got := http.Header(events[1].ResponseHeaders).Get("X-Session-Token"); got == "upstream-secret" {
+			t.Fatal("expected the host's privacy policy to redact the header")
+		}
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
@@ -48,6 +49,46 @@ type ProxyContext struct {
 	// Privacy applies configurable redaction/tokenization before data is
 	// written. A policy may explicitly permit storage of transformed bodies.
 	Privacy *privacy.Policy
+	// Metrics, when set, counts every event written by the proxy.
+	Metrics *Metrics
+	// ClientCertificates presents per-host client certificates to upstreams
+	// that require mutual TLS.
+	ClientCertificates *ClientCertificates
+	// ClientCAs, when set, makes MITM require a client certificate issued by
+	// one of these CAs and records the verified identity on each event.
+	ClientCAs *x509.CertPool
+	// Hosts applies per-host policies. CONNECT requests to hosts matching a
+	// block policy are refused and those matching a tunnel policy are relayed
+	// uninspected, even in MITM mode. A matching intercept policy authorizes
+	// MITM like the CA allowlist does, and its privacy policy and body bound
+	// replace Privacy and the 256 KiB default for that host's exchanges.
+	Hosts *HostPolicies
+
+	maxBodyBytes int
+	// http3Upstream is the configured upstream of an HTTP/3 request's host.
+	http3Upstream string
+}
+
+// forHost returns ctx with the privacy policy and body bound of the host
+// policy matching host, or ctx itself when none overrides them.
+func (ctx *ProxyContext) forHost(host string) *ProxyContext {
+	policy, ok := ctx.Hosts.Lookup(host)
+	if !ok || (policy.privacy == nil && policy.MaxBodyBytes == 0) {
+		return ctx
+	}
+	scoped := *ctx
+	if policy.privacy != nil {
+		scoped.Privacy = policy.privacy
+	}
+	scoped.maxBodyBytes = policy.MaxBodyBytes
+	return &scoped
+}
+
+func (ctx *ProxyContext) bodyLimit() int {
+	if ctx.maxBodyBytes > 0 {
+		return ctx.maxBodyBytes
+	}
+	return maxBodySize
 }
 
 type replayReadCloser struct {
@@ -72,22 +113,22 @@ func (c *idleDeadlineConn) Write(p []byte) (int, error) {
 	return c.Conn.Write(p)
 }
 
-// peekBody snapshots at most maxBodySize bytes while preserving the complete
+// peekBody snapshots at most limit bytes while preserving the complete
 // original stream for forwarding. Memory usage is bounded even for arbitrarily
 // large or streaming request bodies.
-func peekBody(rc io.ReadCloser) (logSnap []byte, truncated bool, restoredRC io.ReadCloser, err error) {
+func peekBody(rc io.ReadCloser, limit int) (logSnap []byte, truncated bool, restoredRC io.ReadCloser, err error) {
 	if rc == nil {
 		return nil, false, nil, nil
 	}
-	prefix, readErr := io.ReadAll(io.LimitReader(rc, int64(maxBodySize)+1))
+	prefix, readErr := io.ReadAll(io.LimitReader(rc, int64(limit)+1))
 	if readErr != nil {
 		_ = rc.Close()
 		return nil, false, nil, readErr
 	}
-	truncated = len(prefix) > maxBodySize
+	truncated = len(prefix) > limit
 	logSnap = prefix
 	if truncated {
-		logSnap = prefix[:maxBodySize]
+		logSnap = prefix[:limit]
 	}
 	restoredRC = &replayReadCloser{
 		Reader: io.MultiReader(bytes.NewReader(prefix), rc),
@@ -103,6 +144,24 @@ func isBlockedIP(ip net.IP) bool {
 // dialDestination resolves once, validates the resolved address, and dials
 // that exact IP. This avoids a DNS check/dial time-of-check race.
 func dialDestination(ctx context.Context, network, address, defaultPort string, allowPrivate bool) (net.Conn, error) {
+	targets, err := resolveDestination(ctx, address, defaultPort, allowPrivate)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	for _, target := range targets {
+		conn, dialErr := dialer.DialContext(ctx, network, target)
+		if dialErr == nil {
+			return conn, nil
+		}
+		err = dialErr
+	}
+	return nil, err
+}
+
+// resolveDestination resolves address once and returns the ip:port targets
+// that may be dialed, in resolver order.
+func resolveDestination(ctx context.Context, address, defaultPort string, allowPrivate bool) ([]string, error) {
 	host, port, err := net.SplitHostPort(address)
 	if err != nil {
 		host = strings.Trim(address, "[]")
@@ -112,30 +171,27 @@ func dialDestination(ctx context.Context, network, address, defaultPort string,
 		return nil, fmt.Errorf("invalid destination %q", address)
 	}
 
+	lookupStart := time.Now()
 	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	logResolution(ctx, host, lookupStart, addrs, err)
 	if err != nil {
 		return nil, fmt.Errorf("resolve %s: %w", host, err)
 	}
-	var blocked []string
-	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var targets, blocked []string
 	for _, addr := range addrs {
 		if !allowPrivate && isBlockedIP(addr.IP) {
 			blocked = append(blocked, addr.IP.String())
 			continue
 		}
-		conn, dialErr := dialer.DialContext(ctx, network, net.JoinHostPort(addr.IP.String(), port))
-		if dialErr == nil {
-			return conn, nil
-		}
-		err = dialErr
+		targets = append(targets, net.JoinHostPort(addr.IP.String(), port))
 	}
-	if len(blocked) > 0 && err == nil {
+	if len(targets) == 0 && len(blocked) > 0 {
 		return nil, fmt.Errorf("destination %s resolves only to blocked address ranges: %s", host, strings.Join(blocked, ", "))
 	}
-	if err != nil {
-		return nil, err
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("destination %s has no usable addresses", host)
 	}
-	return nil, fmt.Errorf("destination %s has no usable addresses", host)
+	return targets, nil
 }
 
 func newUpstreamTransport(allowPrivate, insecure bool) *http.Transport {
@@ -157,7 +213,10 @@ var (
 	privateInsecureTransport = newUpstreamTransport(true, true)
 )
 
-func upstreamTransport(ctx *ProxyContext) *http.Transport {
+func upstreamTransport(ctx *ProxyContext, host string) *http.Transport {
+	if t := ctx.ClientCertificates.transport(host, ctx); t != nil {
+		return t
+	}
 	switch {
 	case ctx.AllowPrivateDestinations && ctx.AllowInsecureUpstream:
 		return privateInsecureTransport
@@ -178,6 +237,17 @@ var sensitiveHeaderNames = map[string]struct{}{
 	"x-api-key":           {},
 }
 
+// SanitizeHeaders returns h as capture would store it: the privacy policy is
+// applied and credential headers are redacted unless captureSensitive is set.
+func SanitizeHeaders(h http.Header, captureSensitive bool, policy *privacy.Policy) http.Header {
+	return headersForLog(h, captureSensitive, policy)
+}
+
+// SanitizeURL returns u as capture would store it under policy.
+func SanitizeURL(u *url.URL, policy *privacy.Policy) string {
+	return urlForLog(u, policy)
+}
+
 func headersForLog(h http.Header, captureSensitive bool, policy *privacy.Policy) http.Header {
 	out := cloneHeaders(h)
 	if policy != nil {
@@ -245,7 +315,7 @@ func StartInboundProxy(listenAddr string, targetURL *url.URL, ctx *ProxyContext)
 		}
 
 		// Read response body
-		bodyBytes, truncated, newRc, _ := peekBody(resp.Body)
+		bodyBytes, truncated, newRc, _ := peekBody(resp.Body, ctx.bodyLimit())
 		resp.Body = newRc
 
 		logBody, storeBody, transformed := payloadForLog(bodyBytes, ctx)
@@ -280,7 +350,7 @@ func StartInboundProxy(listenAddr string, targetURL *url.URL, ctx *ProxyContext)
 			evt.GrpcStatus = extractGRPCStatus(resp)
 		}
 
-		writeEvent(ctx.Logger, evt)
+		ctx.writeEvent(evt)
 		log.Printf("Logged response for inbound request %s -> %d", req.URL.Path, statusCode)
 		return nil
 	}
@@ -295,7 +365,7 @@ func StartInboundProxy(listenAddr string, targetURL *url.URL, ctx *ProxyContext)
 		}
 
 		// Read request body
-		bodyBytes, truncated, newRc, _ := peekBody(req.Body)
+		bodyBytes, truncated, newRc, _ := peekBody(req.Body, ctx.bodyLimit())
 		req.Body = newRc
 
 		logBody, storeBody, transformed := payloadForLog(bodyBytes, ctx)
@@ -329,7 +399,7 @@ func StartInboundProxy(listenAddr string, targetURL *url.URL, ctx *ProxyContext)
 			evt.GrpcServiceMethod = req.URL.Path
 		}
 
-		writeEvent(ctx.Logger, evt)
+		ctx.writeEvent(evt)
 		log.Printf("Logged inbound request %s %s", req.Method, req.URL.Path)
 	}
 
@@ -353,14 +423,20 @@ func StartInboundProxy(listenAddr string, targetURL *url.URL, ctx *ProxyContext)
 	return server, nil
 }
 
-// StartForwardProxy starts a forward (outbound) proxy on listenAddr.
+// StartForwardProxy starts a forward (outbound) proxy on listenAddr. The
+// port accepts both HTTP proxy and SOCKS5 clients.
 func StartForwardProxy(listenAddr string, ctx *ProxyContext) (*http.Server, error) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		if req.Method == http.MethodConnect {
-			if ctx.UseMITM && ctx.CA != nil {
+			policy, matched := ctx.Hosts.Lookup(req.Host)
+			switch {
+			case matched && policy.Action == HostBlock:
+				log.Printf("CONNECT refused by host policy: %s", req.Host)
+				http.Error(w, "Host blocked by policy", http.StatusForbidden)
+			case matched && policy.Action == HostTunnel, !ctx.UseMITM || ctx.CA == nil:
+				TunnelConnect(w, req, ctx)
+			default:
 				mitmConnect(w, req, ctx)
-			} else {
-				tunnelConnect(w, req, ctx)
 			}
 		} else {
 			handleHTTP(w, req, ctx)
@@ -371,6 +447,7 @@ func StartForwardProxy(listenAddr string, ctx *ProxyContext) (*http.Server, erro
 	if err != nil {
 		return nil, err
 	}
+	listener = ListenSOCKS5(listener, ctx.socksConnect)
 	server := &http.Server{
 		Addr:              listener.Addr().String(),
 		Handler:           h2c.NewHandler(handler, &http2.Server{}),
@@ -389,6 +466,11 @@ func StartForwardProxy(listenAddr string, ctx *ProxyContext) (*http.Server, erro
 
 func handleHTTP(w http.ResponseWriter, req *http.Request, ctx *ProxyContext) {
 	startTime := time.Now().UTC()
+	if policy, ok := ctx.Hosts.Lookup(req.Host); ok && policy.Action == HostBlock {
+		log.Printf("Request refused by host policy: %s", req.Host)
+		http.Error(w, "Host blocked by policy", http.StatusForbidden)
+		return
+	}
 
 	// Evaluate injection
 	action := ctx.Inject.Evaluate(true)
@@ -401,6 +483,7 @@ func handleHTTP(w http.ResponseWriter, req *http.Request, ctx *ProxyContext) {
 		// Just drop the connection silently
 		hj, ok := w.(http.Hijacker)
 		if ok {
+			ctx.Metrics.injected("outbound", "drop")
 			conn, _, _ := hj.Hijack()
 			if conn != nil {
 				conn.Close()
@@ -413,6 +496,7 @@ func handleHTTP(w http.ResponseWriter, req *http.Request, ctx *ProxyContext) {
 		// Send RST if possible, or just close abruptly
 		hj, ok := w.(http.Hijacker)
 		if ok {
+			ctx.Metrics.injected("outbound", "reset")
 			conn, _, _ := hj.Hijack()
 			if conn != nil {
 				if tcpConn, ok := conn.(*net.TCPConn); ok {
@@ -437,7 +521,7 @@ func handleHTTP(w http.ResponseWriter, req *http.Request, ctx *ProxyContext) {
 			Duration:         time.Since(startTime),
 			InjectionApplied: action.Applied,
 		}
-		writeEvent(ctx.Logger, evt)
+		ctx.writeEvent(evt)
 		return
 	}
 
@@ -449,10 +533,49 @@ func handleHTTP(w http.ResponseWriter, req *http.Request, ctx *ProxyContext) {
 		req.URL.Scheme = "http"
 		req.URL.Host = req.Host
 	}
+	forwardExchange(w, req, nil, ctx, startTime, action.Applied)
+}
+
+// ForwardAndRecord forwards req to upstream, or to the requested host when
+// upstream is nil, copies the response to w, and logs the exchange as an
+// OutboundCall under the request's original URL. Redaction, the privacy
+// policy, and destination checks are the same as for the forward proxy.
+func ForwardAndRecord(w http.ResponseWriter, req *http.Request, upstream *url.URL, ctx *ProxyContext) {
+	startTime := time.Now().UTC()
+	if !req.URL.IsAbs() {
+		original := *req.URL
+		original.Scheme = "http"
+		if req.TLS != nil {
+			original.Scheme = "https"
+		}
+		original.Host = req.Host
+		req.URL = &original
+	}
+	forwardExchange(w, req, upstream, ctx, startTime, "")
+}
+
+// upstreamURL rebases original onto upstream's scheme, host, and path prefix.
+func upstreamURL(original, upstream *url.URL) *url.URL {
+	target := *original
+	if upstream == nil {
+		return &target
+	}
+	target.Scheme = upstream.Scheme
+	target.Host = upstream.Host
+	if prefix := strings.TrimSuffix(upstream.Path, "/"); prefix != "" {
+		target.Path = prefix + original.Path
+		if original.RawPath != "" {
+			target.RawPath = strings.TrimSuffix(upstream.EscapedPath(), "/") + original.RawPath
+		}
+	}
+	return &target
+}
 
-	bodyBytes, truncated, newRc, _ := peekBody(req.Body)
+func forwardExchange(w http.ResponseWriter, req *http.Request, upstream *url.URL, ctx *ProxyContext, startTime time.Time, applied string) {
+	ctx = ctx.forHost(req.URL.Hostname())
+	bodyBytes, truncated, newRc, _ := peekBody(req.Body, ctx.bodyLimit())
 
-	outReq, err := http.NewRequest(req.Method, req.URL.String(), newRc)
+	outReq, err := http.NewRequestWithContext(withResolutionLog(context.Background(), ctx), req.Method, upstreamURL(req.URL, upstream).String(), newRc)
 	if err != nil {
 		http.Error(w, "Bad Gateway", http.StatusBadGateway)
 		return
@@ -472,7 +595,9 @@ func handleHTTP(w http.ResponseWriter, req *http.Request, ctx *ProxyContext) {
 	}
 
 	var resp *http.Response
-	if IsGRPCRequest(req) {
+	if req.ProtoMajor == 3 && strings.EqualFold(outReq.URL.Scheme, "https") {
+		resp, err = upstreamHTTP3Transport(ctx, outReq.URL.Host).RoundTrip(outReq)
+	} else if IsGRPCRequest(req) {
 		// gRPC requires an explicit HTTP/2 transport. For HTTPS, retain the
 		// validated destination dial and then perform a real TLS handshake;
 		// returning a raw socket here would silently break gRPC over TLS.
@@ -494,27 +619,12 @@ func handleHTTP(w http.ResponseWriter, req *http.Request, ctx *ProxyContext) {
 				if dialErr != nil || !useTLS {
 					return rawConn, dialErr
 				}
-				tlsConfig := &tls.Config{}
-				if cfg != nil {
-					tlsConfig = cfg.Clone()
-				}
-				tlsConfig.InsecureSkipVerify = proxyCtx.AllowInsecureUpstream //nolint:gosec // explicit CLI opt-in
-				tlsConfig.MinVersion = tls.VersionTLS12
-				tlsConfig.NextProtos = []string{"h2"}
-				if tlsConfig.ServerName == "" {
-					tlsConfig.ServerName = outReq.URL.Hostname()
-				}
-				tlsConn := tls.Client(rawConn, tlsConfig)
-				if handshakeErr := tlsConn.HandshakeContext(dialCtx); handshakeErr != nil {
-					_ = rawConn.Close()
-					return nil, handshakeErr
-				}
-				return tlsConn, nil
+				return dialUpstreamTLS(dialCtx, rawConn, addr, cfg, proxyCtx, outReq.URL.Hostname())
 			},
 		}
 		resp, err = t2.RoundTrip(outReq)
 	} else {
-		resp, err = upstreamTransport(ctx).RoundTrip(outReq)
+		resp, err = upstreamTransport(ctx, outReq.URL.Host).RoundTrip(outReq)
 	}
 
 	var statusCode int
@@ -528,7 +638,7 @@ func handleHTTP(w http.ResponseWriter, req *http.Request, ctx *ProxyContext) {
 		http.Error(w, "Bad Gateway", http.StatusBadGateway)
 	} else {
 		statusCode = resp.StatusCode
-		respBodyBytes, respBodyTruncated, resp.Body, _ = peekBody(resp.Body)
+		respBodyBytes, respBodyTruncated, resp.Body, _ = peekBody(resp.Body, ctx.bodyLimit())
 		copyResponse(w, resp)
 		if IsGRPCRequest(req) {
 			grpcStatus = extractGRPCStatus(resp)
@@ -548,7 +658,11 @@ func handleHTTP(w http.ResponseWriter, req *http.Request, ctx *ProxyContext) {
 		BodySize:         req.ContentLength,
 		Status:           statusCode,
 		Duration:         time.Since(startTime),
-		InjectionApplied: action.Applied,
+		InjectionApplied: applied,
+	}
+	if req.TLS != nil {
+		evt.Protocol = req.TLS.NegotiatedProtocol
+		evt.ClientIdentity = event.ClientIdentity(req.TLS)
 	}
 	evt.ResponseBodyTruncated = respBodyTruncated
 
@@ -594,11 +708,13 @@ func handleHTTP(w http.ResponseWriter, req *http.Request, ctx *ProxyContext) {
 		evt.GrpcStatus = grpcStatus
 	}
 
-	writeEvent(ctx.Logger, evt)
+	ctx.writeEvent(evt)
 	log.Printf("Logged outbound call: %s %s -> %d", req.Method, req.URL, statusCode)
 }
 
-func tunnelConnect(w http.ResponseWriter, req *http.Request, ctx *ProxyContext) {
+// TunnelConnect relays a CONNECT request to its destination without
+// inspecting the traffic and logs the tunnel as an OutboundCall.
+func TunnelConnect(w http.ResponseWriter, req *http.Request, ctx *ProxyContext) {
 	startTime := time.Now().UTC()
 	dest := req.Host
 
@@ -611,17 +727,17 @@ func tunnelConnect(w http.ResponseWriter, req *http.Request, ctx *ProxyContext)
 
 	if action.Drop || action.Reset {
 		http.Error(w, "Connection failed", http.StatusServiceUnavailable)
-		logEventTunnel(ctx.Logger, startTime, dest, 0, action.Applied, "Injected drop/reset")
+		logEventTunnel(ctx, startTime, dest, 0, action.Applied, "Injected drop/reset")
 		return
 	}
 
 	log.Printf("Handling CONNECT (tunnel) to %s", dest)
-	dialCtx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	dialCtx, cancel := context.WithTimeout(withResolutionLog(req.Context(), ctx), 10*time.Second)
 	targetConn, err := dialDestination(dialCtx, "tcp", dest, "443", ctx.AllowPrivateDestinations)
 	cancel()
 	if err != nil {
 		http.Error(w, "Destination unavailable", http.StatusServiceUnavailable)
-		logEventTunnel(ctx.Logger, startTime, dest, 0, action.Applied, err.Error())
+		logEventTunnel(ctx, startTime, dest, 0, action.Applied, err.Error())
 		return
 	}
 
@@ -629,7 +745,7 @@ func tunnelConnect(w http.ResponseWriter, req *http.Request, ctx *ProxyContext)
 	if !ok {
 		http.Error(w, "Proxy error", http.StatusInternalServerError)
 		targetConn.Close()
-		logEventTunnel(ctx.Logger, startTime, dest, 500, action.Applied, "Hijack failed")
+		logEventTunnel(ctx, startTime, dest, 500, action.Applied, "Hijack failed")
 		return
 	}
 
@@ -637,16 +753,25 @@ func tunnelConnect(w http.ResponseWriter, req *http.Request, ctx *ProxyContext)
 	if err != nil {
 		log.Println("Hijack error:", err)
 		targetConn.Close()
-		logEventTunnel(ctx.Logger, startTime, dest, 500, action.Applied, err.Error())
+		logEventTunnel(ctx, startTime, dest, 500, action.Applied, err.Error())
 		return
 	}
 	if _, err := io.WriteString(clientConn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
 		_ = clientConn.Close()
 		_ = targetConn.Close()
-		logEventTunnel(ctx.Logger, startTime, dest, 0, action.Applied, err.Error())
+		logEventTunnel(ctx, startTime, dest, 0, action.Applied, err.Error())
 		return
 	}
 
+	relayTunnel(clientConn, targetConn, ctx)
+
+	logEventTunnel(ctx, startTime, dest, 200, action.Applied, "")
+	log.Printf("Logged outbound CONNECT to %s", dest)
+}
+
+// relayTunnel copies bytes both ways until either side closes or the tunnel
+// idles out.
+func relayTunnel(clientConn, targetConn net.Conn, ctx *ProxyContext) {
 	tunnelTimeout := 5 * time.Minute
 	if ctx.TunnelIdleTimeout > 0 {
 		tunnelTimeout = ctx.TunnelIdleTimeout
@@ -663,12 +788,9 @@ func tunnelConnect(w http.ResponseWriter, req *http.Request, ctx *ProxyContext)
 		defer clientConn.Close()
 		_, _ = io.Copy(idleClient, idleTarget)
 	}()
-
-	logEventTunnel(ctx.Logger, startTime, dest, 200, action.Applied, "")
-	log.Printf("Logged outbound CONNECT to %s", dest)
 }
 
-func logEventTunnel(logger *event.Logger, start time.Time, dest string, status int, applied string, errStr string) {
+func logEventTunnel(ctx *ProxyContext, start time.Time, dest string, status int, applied string, errStr string) {
 	evt := &event.Event{
 		ID:               event.GenerateID(),
 		Type:             "OutboundCall",
@@ -680,7 +802,7 @@ func logEventTunnel(logger *event.Logger, start time.Time, dest string, status i
 		InjectionApplied: applied,
 		Error:            errStr,
 	}
-	writeEvent(logger, evt)
+	ctx.writeEvent(evt)
 }
 
 func mitmConnect(w http.ResponseWriter, req *http.Request, ctx *ProxyContext) {
@@ -694,7 +816,7 @@ func mitmConnect(w http.ResponseWriter, req *http.Request, ctx *ProxyContext) {
 	log.Printf("Handling CONNECT (MITM) to %s", dest)
 
 	// VULN-002: Only issue a MITM cert for explicitly allowlisted hosts.
-	if ctx.CA != nil && !ctx.CA.isAllowed(host) {
+	if !ctx.interceptAllowed(host) {
 		log.Printf("MITM cert refused for non-allowlisted host: %s", host)
 		http.Error(w, "MITM not permitted for this host", http.StatusForbidden)
 		return
@@ -715,7 +837,21 @@ func mitmConnect(w http.ResponseWriter, req *http.Request, ctx *ProxyContext) {
 		_ = clientConn.Close()
 		return
 	}
+	serveMITM(clientConn, dest, host, ctx, startTime)
+}
+
+// interceptAllowed reports whether host may be intercepted: an intercept host
+// policy or the CA allowlist authorizes it.
+func (ctx *ProxyContext) interceptAllowed(host string) bool {
+	if policy, ok := ctx.Hosts.Lookup(host); ok {
+		return policy.Action == HostIntercept
+	}
+	return ctx.CA == nil || ctx.CA.isAllowed(host)
+}
 
+// serveMITM terminates TLS on an established client connection to dest with a
+// leaf for host and records the exchanges inside it.
+func serveMITM(clientConn net.Conn, dest, host string, ctx *ProxyContext, startTime time.Time) {
 	// Generate leaf cert dynamically
 	cert, err := ctx.CA.GenerateLeafCert(host)
 	if err != nil {
@@ -724,10 +860,10 @@ func mitmConnect(w http.ResponseWriter, req *http.Request, ctx *ProxyContext) {
 		return
 	}
 
-	tlsConfig := &tls.Config{
+	tlsConfig := RequireClientCertificates(&tls.Config{
 		Certificates: []tls.Certificate{*cert},
 		NextProtos:   []string{"h2", "http/1.1"},
-	}
+	}, ctx.ClientCAs)
 
 	tlsConn := tls.Server(clientConn, tlsConfig)
 	if err := tlsConn.Handshake(); err != nil {
@@ -740,9 +876,10 @@ func mitmConnect(w http.ResponseWriter, req *http.Request, ctx *ProxyContext) {
 		mreq.URL.Scheme = "https"
 		mreq.URL.Host = dest
 		mitmCtx := *ctx
-		// An explicitly MITM-allowlisted host is also an explicit authorization
-		// to connect to that host when it resolves to a local/private address.
-		mitmCtx.AllowPrivateDestinations = true
+		// A host the allowlist names exactly is also an explicit authorization
+		// to connect to it when it resolves to a local/private address; a
+		// glob or * entry is not.
+		mitmCtx.AllowPrivateDestinations = ctx.AllowPrivateDestinations || ctx.CA.NamesHost(host)
 		handleHTTP(mw, mreq, &mitmCtx)
 	})
 
@@ -758,7 +895,7 @@ func mitmConnect(w http.ResponseWriter, req *http.Request, ctx *ProxyContext) {
 		}
 	}()
 
-	logEventTunnel(ctx.Logger, startTime, dest, 200, "", "MITM Tunnel Established")
+	logEventTunnel(ctx, startTime, dest, 200, "", "MITM Tunnel Established")
 }
 
 // singleConnListener allows us to run standard http.Serve over a single hijacked connection
@@ -805,6 +942,12 @@ func cloneHeaders(h http.Header) http.Header {
 	return c
 }
 
+// writeEvent records evt in the proxy metrics and appends it to the log.
+func (ctx *ProxyContext) writeEvent(evt *event.Event) {
+	ctx.Metrics.observe(evt)
+	writeEvent(ctx.Logger, evt)
+}
+
 func writeEvent(logger *event.Logger, evt *event.Event) {
 	if logger == nil {
 		return
@@ -0,0 +1,199 @@
+package capture
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+func newUpstreamHTTP3Transport(allowPrivate, insecure bool) *http3.Transport {
+	return &http3.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure}, //nolint:gosec // explicit CLI opt-in
+		Dial: func(dialCtx context.Context, address string, tlsConfig *tls.Config, config *quic.Config) (*quic.Conn, error) {
+			targets, err := resolveDestination(dialCtx, address, "443", allowPrivate)
+			if err != nil {
+				return nil, err
+			}
+			for _, target := range targets {
+				conn, dialErr := quic.DialAddrEarly(dialCtx, target, tlsConfig, config)
+				if dialErr == nil {
+					return conn, nil
+				}
+				err = dialErr
+			}
+			return nil, err
+		},
+	}
+}
+
+var (
+	publicVerifiedHTTP3Transport  = newUpstreamHTTP3Transport(false, false)
+	publicInsecureHTTP3Transport  = newUpstreamHTTP3Transport(false, true)
+	privateVerifiedHTTP3Transport = newUpstreamHTTP3Transport(true, false)
+	privateInsecureHTTP3Transport = newUpstreamHTTP3Transport(true, true)
+)
+
+type mappedHTTP3TransportKey struct {
+	host, upstream string
+	insecure       bool
+}
+
+// mappedHTTP3Transports holds one transport per mapped host and upstream,
+// as a transport reuses connections by host name.
+var (
+	mappedHTTP3TransportsMu sync.Mutex
+	mappedHTTP3Transports   = make(map[mappedHTTP3TransportKey]*http3.Transport)
+)
+
+// mappedHTTP3Transport dials upstream for host while keeping host as the TLS
+// server name. The upstream is explicit configuration, so it may be private.
+func mappedHTTP3Transport(ctx *ProxyContext, host, upstream string) *http3.Transport {
+	key := mappedHTTP3TransportKey{host: strings.ToLower(host), upstream: upstream, insecure: ctx.AllowInsecureUpstream}
+	mappedHTTP3TransportsMu.Lock()
+	defer mappedHTTP3TransportsMu.Unlock()
+	if t, ok := mappedHTTP3Transports[key]; ok {
+		return t
+	}
+	t := newUpstreamHTTP3Transport(true, key.insecure)
+	dial := t.Dial
+	t.Dial = func(dialCtx context.Context, _ string, tlsConfig *tls.Config, config *quic.Config) (*quic.Conn, error) {
+		return dial(dialCtx, upstream, tlsConfig, config)
+	}
+	if cert := ctx.ClientCertificates.lookup(host); cert != nil {
+		t.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+	}
+	mappedHTTP3Transports[key] = t
+	return t
+}
+
+func upstreamHTTP3Transport(ctx *ProxyContext, host string) *http3.Transport {
+	if ctx.http3Upstream != "" {
+		return mappedHTTP3Transport(ctx, host, ctx.http3Upstream)
+	}
+	if t := ctx.ClientCertificates.http3Transport(host, ctx); t != nil {
+		return t
+	}
+	switch {
+	case ctx.AllowPrivateDestinations && ctx.AllowInsecureUpstream:
+		return privateInsecureHTTP3Transport
+	case ctx.AllowPrivateDestinations:
+		return privateVerifiedHTTP3Transport
+	case ctx.AllowInsecureUpstream:
+		return publicInsecureHTTP3Transport
+	default:
+		return publicVerifiedHTTP3Transport
+	}
+}
+
+// HTTP3Options configures StartHTTP3ProxyWithOptions.
+type HTTP3Options struct {
+	// Upstreams maps dependency host names to the host:port their calls are
+	// forwarded to. A name pointed at the proxy through /etc/hosts resolves
+	// to the proxy itself, so such names need an upstream.
+	Upstreams map[string]string
+}
+
+// StartHTTP3Proxy starts an HTTP/3 proxy without upstream mappings.
+func StartHTTP3Proxy(listenAddr string, ctx *ProxyContext) (*http3.Server, error) {
+	return StartHTTP3ProxyWithOptions(listenAddr, ctx, HTTP3Options{})
+}
+
+// StartHTTP3ProxyWithOptions terminates HTTP/3 on the UDP listenAddr for
+// dependencies whose names resolve to the proxy, for example through
+// /etc/hosts, since HTTP/3 has no CONNECT-style forward proxying. Each
+// connection receives a leaf for its SNI host from ctx.CA, which must
+// allowlist the host, and must present a client certificate when
+// ctx.ClientCAs is set. Requests are forwarded over HTTP/3 to the host's
+// upstream in opts, or else to the named host, and logged as OutboundCall
+// events with protocol h3. A host without an upstream that resolves back to
+// the proxy is refused rather than looped.
+func StartHTTP3ProxyWithOptions(listenAddr string, ctx *ProxyContext, opts HTTP3Options) (*http3.Server, error) {
+	if ctx.CA == nil {
+		return nil, fmt.Errorf("HTTP/3 capture requires a MITM CA")
+	}
+	upstreams := make(map[string]string, len(opts.Upstreams))
+	for host, upstream := range opts.Upstreams {
+		if _, _, err := net.SplitHostPort(upstream); err != nil {
+			upstream = net.JoinHostPort(upstream, "443")
+		}
+		if strings.TrimSpace(host) == "" {
+			return nil, fmt.Errorf("HTTP/3 upstream %q has no host name", upstream)
+		}
+		upstreams[strings.ToLower(strings.TrimSpace(host))] = upstream
+	}
+	conn, err := net.ListenPacket("udp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	listen := conn.LocalAddr().(*net.UDPAddr)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.URL.Scheme = "https"
+		req.URL.Host = req.Host
+		h3Ctx := *ctx
+		h3Ctx.http3Upstream = upstreams[strings.ToLower(req.URL.Hostname())]
+		if h3Ctx.http3Upstream == "" && resolvesToListener(req.Context(), req.URL.Host, listen) {
+			http.Error(w, fmt.Sprintf("HTTP/3 dependency %s resolves to this proxy; map it to an upstream", req.URL.Hostname()), http.StatusBadGateway)
+			return
+		}
+		// As for MITM, a host the allowlist names exactly is an explicit
+		// authorization to reach it on a local/private address.
+		h3Ctx.AllowPrivateDestinations = ctx.AllowPrivateDestinations || ctx.CA.NamesHost(req.URL.Hostname())
+		handleHTTP(w, req, &h3Ctx)
+	})
+	server := &http3.Server{
+		Addr:      conn.LocalAddr().String(),
+		Handler:   handler,
+		TLSConfig: RequireClientCertificates(ctx.CA.SNITLSConfig(), ctx.ClientCAs),
+	}
+	go func() {
+		if err := server.Serve(conn); err != nil && err != http.ErrServerClosed {
+			log.Printf("HTTP/3 proxy server error: %v", err)
+		}
+		_ = conn.Close()
+	}()
+	return server, nil
+}
+
+// resolvesToListener reports whether address, with port 443 by default,
+// resolves to the UDP listener listen.
+func resolvesToListener(ctx context.Context, address string, listen *net.UDPAddr) bool {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = address, "443"
+	}
+	if port != fmt.Sprint(listen.Port) {
+		return false
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, strings.Trim(host, "[]"))
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if addr.IP.Equal(listen.IP) || listen.IP.IsUnspecified() && (addr.IP.IsLoopback() || isLocalAddress(addr.IP)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLocalAddress reports whether ip belongs to a local interface.
+func isLocalAddress(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if prefix, ok := addr.(*net.IPNet); ok && prefix.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
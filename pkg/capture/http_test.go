@@ -25,6 +25,8 @@ import (
 	"infernosim/pkg/event"
 	"infernosim/pkg/privacy"
 
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 )
@@ -46,7 +48,7 @@ func TestPeekBodyBoundsMemoryAndPreservesStream(t *testing.T) {
 	original := bytes.Repeat([]byte("x"), maxBodySize*4)
 	source := &countingReadCloser{reader: bytes.NewReader(original)}
 
-	snapshot, truncated, restored, err := peekBody(source)
+	snapshot, truncated, restored, err := peekBody(source, maxBodySize)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -93,9 +95,11 @@ func TestForwardProxyCapturesExchangeAndRedactsSecrets(t *testing.T) {
 	}
 	defer logger.Close()
 
+	captureMetrics := NewMetrics()
 	proxy, err := StartForwardProxy("127.0.0.1:0", &ProxyContext{
 		Logger:                   logger,
 		AllowPrivateDestinations: true,
+		Metrics:                  captureMetrics,
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -144,6 +148,19 @@ func TestForwardProxyCapturesExchangeAndRedactsSecrets(t *testing.T) {
 	if runtime.GOOS != "windows" && info.Mode().Perm() != 0o600 {
 		t.Fatalf("log permissions = %v", info.Mode().Perm())
 	}
+
+	var exposition strings.Builder
+	if err := captureMetrics.Registry().WriteText(&exposition); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`infernosim_capture_events_total{proxy="outbound",type="OutboundCall",dependency="127.0.0.1",status_class="2xx"} 1`,
+		`infernosim_capture_upstream_duration_seconds_count{proxy="outbound",dependency="127.0.0.1"} 1`,
+	} {
+		if !strings.Contains(exposition.String(), want) {
+			t.Fatalf("metrics missing %q:\n%s", want, exposition.String())
+		}
+	}
 }
 
 func TestForwardProxyAppliesPrivacyPolicyBeforeStorage(t *testing.T) {
@@ -502,3 +519,168 @@ type captureBufferedConn struct {
 func (c *captureBufferedConn) Read(buffer []byte) (int, error) {
 	return c.reader.Read(buffer)
 }
+
+func TestHTTP3ProxyCapturesExchangeWithProtocol(t *testing.T) {
+	ca, err := NewCAStoreAt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstreamCert, err := ca.GenerateLeafCert("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstreamConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := &http3.Server{
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{*upstreamCert}},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte("h3-ok " + r.URL.Path))
+		}),
+	}
+	go func() { _ = upstream.Serve(upstreamConn) }()
+	defer upstream.Close()
+
+	logPath := filepath.Join(t.TempDir(), "h3.log")
+	logger, err := event.NewLogger(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+	proxy, err := StartHTTP3Proxy("127.0.0.1:0", &ProxyContext{
+		Logger:                logger,
+		CA:                    ca,
+		UseMITM:               true,
+		AllowInsecureUpstream: true,
+		CaptureSensitiveData:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+
+	caPEM, err := os.ReadFile(ca.CertificatePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		t.Fatal("failed to trust generated CA")
+	}
+	// The dependency name resolves to the proxy, as /etc/hosts would arrange.
+	transport := &http3.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost"},
+		Dial: func(ctx context.Context, _ string, tlsConfig *tls.Config, config *quic.Config) (*quic.Conn, error) {
+			return quic.DialAddrEarly(ctx, proxy.Addr, tlsConfig, config)
+		},
+	}
+	defer transport.Close()
+	client := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+	resp, err := client.Get("https://" + upstreamConn.LocalAddr().String() + "/quic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "h3-ok /quic" {
+		t.Fatalf("status=%d body=%q", resp.StatusCode, body)
+	}
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var evt event.Event
+	if err := json.NewDecoder(f).Decode(&evt); err != nil {
+		t.Fatal(err)
+	}
+	if evt.Type != "OutboundCall" || evt.Protocol != http3.NextProtoH3 || evt.Status != http.StatusOK {
+		t.Fatalf("event = %+v", evt)
+	}
+	if !strings.HasSuffix(evt.URL, "/quic") || !strings.HasPrefix(evt.URL, "https://") {
+		t.Fatalf("url = %q", evt.URL)
+	}
+}
+
+func TestHTTP3ProxyForwardsHostnamesToMappedUpstream(t *testing.T) {
+	ca, err := NewCAStoreAt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.AllowedHosts = []string{"api.h3.test", "localhost"}
+	upstreamCert, err := ca.GenerateLeafCert("api.h3.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstreamConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := &http3.Server{
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{*upstreamCert}},
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("h3-ok " + r.Host))
+		}),
+	}
+	go func() { _ = upstream.Serve(upstreamConn) }()
+	defer upstream.Close()
+
+	logger, err := event.NewLogger(filepath.Join(t.TempDir(), "h3.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+	proxy, err := StartHTTP3ProxyWithOptions("127.0.0.1:0", &ProxyContext{
+		Logger:                logger,
+		CA:                    ca,
+		UseMITM:               true,
+		AllowInsecureUpstream: true,
+	}, HTTP3Options{Upstreams: map[string]string{"API.h3.test": upstreamConn.LocalAddr().String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+
+	caPEM, err := os.ReadFile(ca.CertificatePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		t.Fatal("failed to trust generated CA")
+	}
+	// Every dependency name resolves to the proxy, as /etc/hosts would
+	// arrange, so only the upstream mapping keeps the proxy from calling
+	// itself.
+	transport := &http3.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+		Dial: func(ctx context.Context, _ string, tlsConfig *tls.Config, config *quic.Config) (*quic.Conn, error) {
+			return quic.DialAddrEarly(ctx, proxy.Addr, tlsConfig, config)
+		},
+	}
+	defer transport.Close()
+	client := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+	resp, err := client.Get("https://api.h3.test/quic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "h3-ok api.h3.test" {
+		t.Fatalf("status=%d body=%q", resp.StatusCode, body)
+	}
+
+	_, port, _ := net.SplitHostPort(proxy.Addr)
+	resp, err = client.Get("https://localhost:" + port + "/loop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway || !strings.Contains(string(body), "resolves to this proxy") {
+		t.Fatalf("unmapped self-resolving host: status=%d body=%q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,128 @@
+package capture
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"infernosim/pkg/event"
+	"infernosim/pkg/metrics"
+)
+
+// Metrics counts captured traffic for Prometheus scraping. One value can be
+// shared by the inbound and forward proxies; series are labelled by proxy.
+type Metrics struct {
+	registry *metrics.Registry
+	events   *metrics.CounterVec
+	faults   *metrics.CounterVec
+	errors   *metrics.CounterVec
+	latency  *metrics.HistogramVec
+}
+
+// NewMetrics registers the capture metric families.
+func NewMetrics() *Metrics {
+	registry := metrics.NewRegistry()
+	return &Metrics{
+		registry: registry,
+		events: registry.Counter(
+			"infernosim_capture_events_total",
+			"Events written to the incident logs, by proxy, event type, dependency host, and status class.",
+			"proxy", "type", "dependency", "status_class",
+		),
+		faults: registry.Counter(
+			"infernosim_capture_injected_faults_total",
+			"Captured exchanges that had a fault injected, by proxy and injection.",
+			"proxy", "injection",
+		),
+		errors: registry.Counter(
+			"infernosim_capture_errors_total",
+			"Captured exchanges that failed before a response was received, by proxy and dependency host.",
+			"proxy", "dependency",
+		),
+		latency: registry.Histogram(
+			"infernosim_capture_upstream_duration_seconds",
+			"Upstream round-trip time of captured outbound exchanges, by dependency host.",
+			nil,
+			"proxy", "dependency",
+		),
+	}
+}
+
+// Registry returns the registry to expose on /metrics.
+func (m *Metrics) Registry() *metrics.Registry {
+	return m.registry
+}
+
+func (m *Metrics) observe(evt *event.Event) {
+	if m == nil || evt == nil {
+		return
+	}
+	proxy := "outbound"
+	dependency := eventDependency(evt)
+	if strings.HasPrefix(evt.Type, "Inbound") {
+		proxy = "inbound"
+		dependency = evt.Service
+	}
+	m.events.Inc(proxy, evt.Type, dependency, statusClass(evt))
+	if evt.InjectionApplied != "" {
+		m.faults.Inc(proxy, evt.InjectionApplied)
+	}
+	if evt.Error != "" {
+		m.errors.Inc(proxy, dependency)
+	}
+	if evt.Duration > 0 {
+		m.latency.Observe(evt.Duration.Seconds(), proxy, dependency)
+	}
+}
+
+func (m *Metrics) injected(proxy, injection string) {
+	if m == nil || injection == "" {
+		return
+	}
+	m.faults.Inc(proxy, injection)
+}
+
+func eventDependency(evt *event.Event) string {
+	host := evt.URL
+	if parsed, err := url.Parse(evt.URL); err == nil && parsed.Host != "" {
+		host = parsed.Host
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host
+}
+
+func statusClass(evt *event.Event) string {
+	if evt.Status <= 0 {
+		if evt.Type == "InboundRequest" {
+			return "request"
+		}
+		return "error"
+	}
+	return string(rune('0'+evt.Status/100)) + "xx"
+}
+
+// StartMetricsServer serves the capture metrics on listenAddr at /metrics.
+func StartMetricsServer(listenAddr string, m *Metrics) (*http.Server, error) {
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Registry().Handler())
+	server := &http.Server{
+		Addr:              listener.Addr().String(),
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Metrics server error: %v", err)
+		}
+	}()
+	return server, nil
+}
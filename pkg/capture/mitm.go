@@ -1,6 +1,9 @@
 package capture
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1"
@@ -20,27 +23,46 @@ import (
 
 // CAStore manages the MITM Root CA and dynamic leaf certificates.
 type CAStore struct {
+	dir      string
 	certPath string
 	keyPath  string
 	caCert   *x509.Certificate
-	caKey    *rsa.PrivateKey
+	caKey    crypto.Signer
+	// caChain is the DER chain sent after each leaf: the signing CA and, for
+	// an imported intermediate, its issuers.
+	caChain  [][]byte
+	settings CASettings
 	cacheMu  sync.RWMutex
 	leafCert map[string]*tls.Certificate
 
 	// AllowedHosts restricts which hostnames may receive a dynamically-signed
-	// MITM leaf certificate. When nil or empty, only localhost variants are
+	// MITM leaf certificate. Entries are host patterns as described on
+	// HostPolicy.Host. When nil or empty, only localhost variants are
 	// permitted. Populate via --mitm-allow-hosts to extend the list.
 	AllowedHosts []string
+	// Hosts, when set, is consulted before AllowedHosts: a host matching an
+	// intercept policy is allowed and one matching a tunnel or block policy
+	// is refused.
+	Hosts *HostPolicies
 }
 
 // NewCAStore initializes or loads a CA from ~/.infernosim/ca
 func NewCAStore() (*CAStore, error) {
-	home, err := os.UserHomeDir()
+	dir, err := DefaultCADir()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get home dir: %w", err)
+		return nil, err
 	}
+	return NewCAStoreAt(dir)
+}
 
-	return NewCAStoreAt(filepath.Join(home, ".infernosim", "ca"))
+// DefaultCADir returns ~/.infernosim/ca, the CA directory used when none is
+// configured.
+func DefaultCADir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home dir: %w", err)
+	}
+	return filepath.Join(home, ".infernosim", "ca"), nil
 }
 
 // NewCAStoreAt initializes or loads a CA in dir. It is useful for isolated
@@ -50,17 +72,44 @@ func NewCAStoreAt(caDir string) (*CAStore, error) {
 	if err := os.MkdirAll(caDir, 0700); err != nil {
 		return nil, fmt.Errorf("failed to create CA dir: %w", err)
 	}
+	store, err := openCAStore(caDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.loadOrGenerateCA(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// LoadCAStoreAt loads the CA in caDir without generating one. The bool is
+// false when the directory holds no CA.
+func LoadCAStoreAt(caDir string) (*CAStore, bool, error) {
+	store, err := openCAStore(caDir)
+	if err != nil {
+		return nil, false, err
+	}
+	if !store.exists() {
+		return nil, false, nil
+	}
+	if err := store.loadCA(); err != nil {
+		return nil, false, err
+	}
+	return store, true, nil
+}
 
+func openCAStore(caDir string) (*CAStore, error) {
 	store := &CAStore{
+		dir:      caDir,
 		certPath: filepath.Join(caDir, "infernosim-ca.crt"),
 		keyPath:  filepath.Join(caDir, "infernosim-ca.key"),
 		leafCert: make(map[string]*tls.Certificate),
 	}
-
-	if err := store.loadOrGenerateCA(); err != nil {
+	settings, err := LoadCASettings(caDir)
+	if err != nil {
 		return nil, err
 	}
-
+	store.settings = settings
 	return store, nil
 }
 
@@ -75,15 +124,20 @@ func (s *CAStore) IsAllowed(host string) bool {
 	return s.isAllowed(host)
 }
 
-func (s *CAStore) loadOrGenerateCA() error {
+func (s *CAStore) exists() bool {
 	_, errCert := os.Stat(s.certPath)
 	_, errKey := os.Stat(s.keyPath)
+	return errCert == nil && errKey == nil
+}
 
-	if os.IsNotExist(errCert) || os.IsNotExist(errKey) {
+func (s *CAStore) loadOrGenerateCA() error {
+	if !s.exists() {
 		return s.generateCA()
 	}
+	return s.loadCA()
+}
 
-	// Load existing
+func (s *CAStore) loadCA() error {
 	certPEM, err := os.ReadFile(s.certPath)
 	if err != nil {
 		return err
@@ -92,40 +146,79 @@ func (s *CAStore) loadOrGenerateCA() error {
 	if err != nil {
 		return err
 	}
-
-	// Parse Cert
-	block, _ := pem.Decode(certPEM)
-	if block == nil {
-		return fmt.Errorf("failed to parse CA cert PEM")
-	}
-	cert, err := x509.ParseCertificate(block.Bytes)
+	cert, chain, key, err := parseCAMaterial(certPEM, keyPEM)
 	if err != nil {
 		return err
 	}
+	s.caCert, s.caChain, s.caKey = cert, chain, key
+	return nil
+}
 
-	// Parse Key
-	block, _ = pem.Decode(keyPEM)
+// parseCAMaterial parses a PEM CA certificate, optionally followed by its
+// issuers, and the matching PKCS#1, SEC 1, or PKCS#8 private key.
+func parseCAMaterial(certPEM, keyPEM []byte) (*x509.Certificate, [][]byte, crypto.Signer, error) {
+	var chain [][]byte
+	for rest := certPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			chain = append(chain, block.Bytes)
+		}
+	}
+	if len(chain) == 0 {
+		return nil, nil, nil, fmt.Errorf("failed to parse CA cert PEM")
+	}
+	cert, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	block, _ := pem.Decode(keyPEM)
 	if block == nil {
-		return fmt.Errorf("failed to parse CA key PEM")
+		return nil, nil, nil, fmt.Errorf("failed to parse CA key PEM")
 	}
-	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	key, err := parsePrivateKey(block.Bytes)
 	if err != nil {
-		return err
+		return nil, nil, nil, fmt.Errorf("parse CA key: %w", err)
+	}
+	public, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !public.Equal(cert.PublicKey) {
+		return nil, nil, nil, fmt.Errorf("CA key does not match the CA certificate")
 	}
+	return cert, chain, key, nil
+}
 
-	s.caCert = cert
-	s.caKey = key
-	return nil
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported private key encoding")
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	switch signer.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		return signer, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", key)
 }
 
 func (s *CAStore) generateCA() error {
-	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	priv, err := generateKey(s.settings.KeyType)
 	if err != nil {
 		return err
 	}
 
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	serialNumber, err := randomSerial()
 	if err != nil {
 		return err
 	}
@@ -145,7 +238,7 @@ func (s *CAStore) generateCA() error {
 		MaxPathLen:            1,
 	}
 
-	certBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	certBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
 	if err != nil {
 		return err
 	}
@@ -153,52 +246,98 @@ func (s *CAStore) generateCA() error {
 	if err != nil {
 		return err
 	}
-
-	// Write Cert
-	certOut, err := os.Create(s.certPath)
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(priv)
 	if err != nil {
 		return err
 	}
-	defer certOut.Close()
-	pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
-
-	// Write Key
-	keyOut, err := os.OpenFile(s.keyPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
+	if err := writeCAFiles(s.certPath, s.keyPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyBytes})); err != nil {
 		return err
 	}
-	defer keyOut.Close()
-	pem.Encode(keyOut, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
 
 	s.caCert = parsedCert
+	s.caChain = [][]byte{certBytes}
 	s.caKey = priv
 	return nil
 }
 
-// GenerateLeafCert creates a valid TLS certificate for a specific host dynamically
+func writeCAFiles(certPath, keyPath string, certPEM, keyPEM []byte) error {
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certPath, certPEM, 0644)
+}
+
+func generateKey(keyType string) (crypto.Signer, error) {
+	if keyType == KeyTypeECDSA {
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	return rsa.GenerateKey(rand.Reader, 2048)
+}
+
+func randomSerial() (*big.Int, error) {
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	return rand.Int(rand.Reader, serialNumberLimit)
+}
+
+// GenerateLeafCert creates a valid TLS certificate for a specific host
+// dynamically. Leaves are cached in memory and, unless the store has no
+// directory, under leaves/ so later runs reuse them.
 func (s *CAStore) GenerateLeafCert(host string) (*tls.Certificate, error) {
 	host = strings.ToLower(strings.TrimSpace(host))
 	s.cacheMu.RLock()
-	if cached := s.leafCert[host]; cached != nil {
+	if cached := s.leafCert[host]; cached != nil && s.leafUsable(cached) {
 		s.cacheMu.RUnlock()
 		return cached, nil
 	}
 	s.cacheMu.RUnlock()
 
-	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	tlsCert := s.loadLeaf(host)
+	if tlsCert == nil {
+		var err error
+		if tlsCert, err = s.issueLeaf(host); err != nil {
+			return nil, err
+		}
+		s.storeLeaf(host, tlsCert)
+	}
+	s.cacheMu.Lock()
+	if cached := s.leafCert[host]; cached != nil && s.leafUsable(cached) {
+		s.cacheMu.Unlock()
+		return cached, nil
+	}
+	if s.leafCert == nil {
+		s.leafCert = make(map[string]*tls.Certificate)
+	}
+	s.leafCert[host] = tlsCert
+	s.cacheMu.Unlock()
+
+	return tlsCert, nil
+}
+
+func (s *CAStore) issueLeaf(host string) (*tls.Certificate, error) {
+	priv, err := generateKey(s.settings.KeyType)
 	if err != nil {
 		return nil, err
 	}
 
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	serialNumber, err := randomSerial()
 	if err != nil {
 		return nil, err
 	}
 
-	pubBytes := x509.MarshalPKCS1PublicKey(&priv.PublicKey)
+	pubBytes, err := x509.MarshalPKIXPublicKey(priv.Public())
+	if err != nil {
+		return nil, err
+	}
 	hash := sha1.Sum(pubBytes)
 
+	keyUsage := x509.KeyUsageDigitalSignature
+	if _, ok := priv.(*rsa.PrivateKey); ok {
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	notAfter := time.Now().Add(s.settings.leafValidity())
+	if notAfter.After(s.caCert.NotAfter) {
+		notAfter = s.caCert.NotAfter
+	}
 	template := x509.Certificate{
 		SerialNumber: serialNumber,
 		Subject: pkix.Name{
@@ -206,8 +345,8 @@ func (s *CAStore) GenerateLeafCert(host string) (*tls.Certificate, error) {
 			CommonName:   host,
 		},
 		NotBefore:             time.Now().Add(-24 * time.Hour),
-		NotAfter:              time.Now().Add(30 * 24 * time.Hour), // 30 days
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		NotAfter:              notAfter,
+		KeyUsage:              keyUsage,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
 		SubjectKeyId:          hash[:],
@@ -218,41 +357,74 @@ func (s *CAStore) GenerateLeafCert(host string) (*tls.Certificate, error) {
 		template.DNSNames = []string{host}
 	}
 
-	certBytes, err := x509.CreateCertificate(rand.Reader, &template, s.caCert, &priv.PublicKey, s.caKey)
+	certBytes, err := x509.CreateCertificate(rand.Reader, &template, s.caCert, priv.Public(), s.caKey)
 	if err != nil {
 		return nil, err
 	}
-
-	tlsCert := &tls.Certificate{
-		Certificate: [][]byte{certBytes, s.caCert.Raw},
+	leaf, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: append([][]byte{certBytes}, s.caChain...),
 		PrivateKey:  priv,
-		Leaf:        &template,
+		Leaf:        leaf,
+	}, nil
+}
+
+// SNITLSConfig returns a server TLS configuration that presents a generated
+// leaf for the SNI host of each handshake. It is used where no CONNECT
+// request names the host, such as HTTP/3. Handshakes without SNI or for hosts
+// outside AllowedHosts fail.
+func (s *CAStore) SNITLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS13,
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			host := strings.ToLower(hello.ServerName)
+			if host == "" {
+				return nil, fmt.Errorf("client sent no server name")
+			}
+			if !s.isAllowed(host) {
+				return nil, fmt.Errorf("host %q is not allowlisted for MITM", host)
+			}
+			return s.GenerateLeafCert(host)
+		},
 	}
-	s.cacheMu.Lock()
-	if cached := s.leafCert[host]; cached != nil {
-		s.cacheMu.Unlock()
-		return cached, nil
+}
+
+// NamesHost reports whether host is allowed by an exact entry rather than a
+// glob, a suffix, or *. Traffic for such a host may reach it on a loopback or
+// private address.
+func (s *CAStore) NamesHost(host string) bool {
+	if policy, ok := s.Hosts.Lookup(host); ok {
+		return policy.Action == HostIntercept && policy.Exact()
 	}
-	if s.leafCert == nil {
-		s.leafCert = make(map[string]*tls.Certificate)
+	allowed := s.AllowedHosts
+	if len(allowed) == 0 {
+		allowed = []string{"localhost", "127.0.0.1", "::1"}
 	}
-	s.leafCert[host] = tlsCert
-	s.cacheMu.Unlock()
-
-	return tlsCert, nil
+	for _, h := range allowed {
+		if IsExactHostPattern(h) && MatchHost(h, host) {
+			return true
+		}
+	}
+	return false
 }
 
 // isAllowed reports whether the given host is permitted to receive a dynamically
-// signed MITM leaf certificate. When AllowedHosts is empty the default policy
-// restricts signing to localhost variants only.
+// signed MITM leaf certificate. When neither Hosts nor AllowedHosts match, the
+// default policy restricts signing to localhost variants only.
 func (s *CAStore) isAllowed(host string) bool {
+	if policy, ok := s.Hosts.Lookup(host); ok {
+		return policy.Action == HostIntercept
+	}
 	allowed := s.AllowedHosts
 	if len(allowed) == 0 {
 		// Secure default: only permit localhost-class hostnames.
 		allowed = []string{"localhost", "127.0.0.1", "::1"}
 	}
 	for _, h := range allowed {
-		if strings.EqualFold(strings.TrimSpace(h), host) {
+		if MatchHost(h, host) {
 			return true
 		}
 	}
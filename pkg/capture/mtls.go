@@ -0,0 +1,167 @@
+package capture
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// ClientCertificates holds the client certificates presented to upstream
+// hosts that require mutual TLS. Hosts without a certificate use the shared
+// upstream transports.
+type ClientCertificates struct {
+	mu         sync.Mutex
+	certs      map[string]*tls.Certificate
+	transports map[clientTransportKey]*http.Transport
+	http3      map[clientTransportKey]*http3.Transport
+}
+
+type clientTransportKey struct {
+	host         string
+	allowPrivate bool
+	insecure     bool
+}
+
+func NewClientCertificates() *ClientCertificates {
+	return &ClientCertificates{
+		certs:      make(map[string]*tls.Certificate),
+		transports: make(map[clientTransportKey]*http.Transport),
+		http3:      make(map[clientTransportKey]*http3.Transport),
+	}
+}
+
+// Add loads the PEM certificate and key presented when connecting to host.
+func (c *ClientCertificates) Add(host, certFile, keyFile string) error {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if host == "" {
+		return fmt.Errorf("client certificate host is required")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("load client certificate for %s: %w", host, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.certs[host] = &cert
+	return nil
+}
+
+// AddSpec adds a certificate from a host=cert.pem,key.pem specification.
+func (c *ClientCertificates) AddSpec(spec string) error {
+	host, files, ok := strings.Cut(spec, "=")
+	certFile, keyFile, hasKey := strings.Cut(files, ",")
+	if !ok || !hasKey || certFile == "" || keyFile == "" {
+		return fmt.Errorf("client certificate %q must be host=cert.pem,key.pem", spec)
+	}
+	return c.Add(host, certFile, keyFile)
+}
+
+func (c *ClientCertificates) lookup(address string) *tls.Certificate {
+	if c == nil {
+		return nil
+	}
+	host := address
+	if parsed, _, err := net.SplitHostPort(address); err == nil {
+		host = parsed
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.certs[host]
+}
+
+// transport returns a transport presenting the client certificate for host,
+// or nil when host has none.
+func (c *ClientCertificates) transport(host string, ctx *ProxyContext) *http.Transport {
+	cert := c.lookup(host)
+	if cert == nil {
+		return nil
+	}
+	key := clientTransportKey{host: strings.ToLower(host), allowPrivate: ctx.AllowPrivateDestinations, insecure: ctx.AllowInsecureUpstream}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.transports[key]; ok {
+		return t
+	}
+	t := newUpstreamTransport(key.allowPrivate, key.insecure)
+	t.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: key.insecure, //nolint:gosec // explicit CLI opt-in
+		Certificates:       []tls.Certificate{*cert},
+	}
+	c.transports[key] = t
+	return t
+}
+
+// http3Transport is the HTTP/3 counterpart of transport.
+func (c *ClientCertificates) http3Transport(host string, ctx *ProxyContext) *http3.Transport {
+	cert := c.lookup(host)
+	if cert == nil {
+		return nil
+	}
+	key := clientTransportKey{host: strings.ToLower(host), allowPrivate: ctx.AllowPrivateDestinations, insecure: ctx.AllowInsecureUpstream}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.http3[key]; ok {
+		return t
+	}
+	t := newUpstreamHTTP3Transport(key.allowPrivate, key.insecure)
+	t.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+	c.http3[key] = t
+	return t
+}
+
+// LoadCertPool reads a PEM bundle of CA certificates, such as the CAs that
+// issue the client certificates an application presents.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("CA bundle %s contains no PEM certificates", path)
+	}
+	return pool, nil
+}
+
+// RequireClientCertificates makes config require a client certificate issued
+// by one of pool's CAs. A nil pool leaves config unchanged.
+func RequireClientCertificates(config *tls.Config, pool *x509.CertPool) *tls.Config {
+	if pool != nil {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = pool
+	}
+	return config
+}
+
+// dialUpstreamTLS completes a TLS handshake for the gRPC transport on a
+// validated connection, presenting the client certificate for addr if any.
+func dialUpstreamTLS(dialCtx context.Context, rawConn net.Conn, addr string, cfg *tls.Config, ctx *ProxyContext, serverName string) (net.Conn, error) {
+	tlsConfig := &tls.Config{}
+	if cfg != nil {
+		tlsConfig = cfg.Clone()
+	}
+	tlsConfig.InsecureSkipVerify = ctx.AllowInsecureUpstream //nolint:gosec // explicit CLI opt-in
+	tlsConfig.MinVersion = tls.VersionTLS12
+	tlsConfig.NextProtos = []string{"h2"}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = serverName
+	}
+	if cert := ctx.ClientCertificates.lookup(addr); cert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*cert}
+	}
+	tlsConn := tls.Client(rawConn, tlsConfig)
+	if err := tlsConn.HandshakeContext(dialCtx); err != nil {
+		_ = rawConn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
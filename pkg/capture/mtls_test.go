@@ -0,0 +1,202 @@
+package capture
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"infernosim/pkg/event"
+)
+
+// testPKI is a throwaway CA issuing client certificates.
+type testPKI struct {
+	dir    string
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	caFile string
+	pool   *x509.CertPool
+}
+
+func newTestPKI(t *testing.T, name string) *testPKI {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pki := &testPKI{dir: t.TempDir(), cert: cert, key: key, pool: x509.NewCertPool()}
+	pki.pool.AddCert(cert)
+	pki.caFile = filepath.Join(pki.dir, "ca.pem")
+	writePEM(t, pki.caFile, "CERTIFICATE", der)
+	return pki
+}
+
+// issue writes a client certificate and key and returns their paths.
+func (p *testPKI) issue(t *testing.T, commonName, uri string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if uri != "" {
+		parsed, _ := url.Parse(uri)
+		template.URIs = []*url.URL{parsed}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, p.cert, &key.PublicKey, p.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(p.dir, commonName+".pem")
+	keyFile := filepath.Join(p.dir, commonName+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, path, kind string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMITMMutualTLSOnBothSides(t *testing.T) {
+	upstreamPKI := newTestPKI(t, "upstream clients")
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("upstream saw " + r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	upstream.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: upstreamPKI.pool}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	clientCerts := NewClientCertificates()
+	proxyCert, proxyKey := upstreamPKI.issue(t, "capture-proxy", "")
+	if err := clientCerts.AddSpec("127.0.0.1=" + proxyCert + "," + proxyKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := clientCerts.AddSpec("127.0.0.1=" + proxyCert); err == nil {
+		t.Fatal("expected a specification without a key to fail")
+	}
+
+	appPKI := newTestPKI(t, "app clients")
+	appCertFile, appKeyFile := appPKI.issue(t, "orders", "spiffe://example.test/orders")
+	appCert, err := tls.LoadX509KeyPair(appCertFile, appKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs, err := LoadCertPool(appPKI.caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := NewCAStoreAt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.AllowedHosts = []string{"127.0.0.1"}
+	logPath := filepath.Join(t.TempDir(), "mtls.log")
+	logger, err := event.NewLogger(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+	proxy, err := StartForwardProxy("127.0.0.1:0", &ProxyContext{
+		Logger:                   logger,
+		CA:                       ca,
+		UseMITM:                  true,
+		AllowPrivateDestinations: true,
+		AllowInsecureUpstream:    true,
+		ClientCertificates:       clientCerts,
+		ClientCAs:                clientCAs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+
+	caPEM, err := os.ReadFile(ca.CertificatePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+	proxyURL, _ := url.Parse("http://" + proxy.Addr)
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+		}}
+	}
+
+	resp, err := newClient(appCert).Get(upstream.URL + "/orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if string(body) != "upstream saw capture-proxy" {
+		t.Fatalf("body = %q", body)
+	}
+	if _, err := newClient().Get(upstream.URL + "/orders"); err == nil {
+		t.Fatal("expected MITM to refuse an application without a client certificate")
+	}
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	identity := ""
+	for {
+		var evt event.Event
+		if err := dec.Decode(&evt); err != nil {
+			break
+		}
+		if evt.Method == http.MethodGet {
+			identity = evt.ClientIdentity
+		}
+	}
+	if identity != "spiffe://example.test/orders" {
+		t.Fatalf("client identity = %q", identity)
+	}
+}
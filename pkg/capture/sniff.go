@@ -0,0 +1,65 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"time"
+)
+
+// Protocols reported by SniffConn.
+const (
+	ProtocolTLS  = "tls"
+	ProtocolHTTP = "http"
+	// ProtocolOpaque is any other protocol, including those where the
+	// server speaks first and the client sends nothing within the timeout.
+	ProtocolOpaque = "opaque"
+)
+
+// sniffTimeout bounds how long SniffConn waits for the client's first bytes.
+const sniffTimeout = 500 * time.Millisecond
+
+var httpRequestPrefixes = [][]byte{
+	[]byte("GET "), []byte("HEAD "), []byte("POST "), []byte("PUT "), []byte("PATCH "),
+	[]byte("DELETE "), []byte("OPTIONS "), []byte("TRACE "), []byte("CONNECT "),
+	[]byte("PRI * HTTP/2"),
+}
+
+// peekedConn replays bytes buffered while sniffing before reading from the
+// underlying connection.
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) { return c.reader.Read(p) }
+
+// SniffConn peeks at the first bytes the client sends and reports whether
+// they open a TLS handshake or an HTTP/1 request or HTTP/2 preface. The
+// returned connection replays the peeked bytes and must be used instead of
+// conn.
+func SniffConn(conn net.Conn) (net.Conn, string) {
+	reader := bufio.NewReader(conn)
+	peeked := &peekedConn{Conn: conn, reader: reader}
+	_ = conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	first, err := reader.Peek(1)
+	if err != nil {
+		return peeked, ProtocolOpaque
+	}
+	if first[0] == 0x16 {
+		return peeked, ProtocolTLS
+	}
+	if first[0] < 'A' || first[0] > 'Z' {
+		return peeked, ProtocolOpaque
+	}
+	// Every request line is longer than the longest prefix, so a short
+	// peek only happens for other protocols.
+	head, _ := reader.Peek(len("PRI * HTTP/2"))
+	for _, prefix := range httpRequestPrefixes {
+		if bytes.HasPrefix(head, prefix) {
+			return peeked, ProtocolHTTP
+		}
+	}
+	return peeked, ProtocolOpaque
+}
@@ -0,0 +1,285 @@
+package capture
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// SOCKS5 reply codes (RFC 1928).
+const (
+	socksSucceeded          = 0x00
+	socksNotAllowed         = 0x02
+	socksCommandUnsupported = 0x07
+	socksAddressUnsupported = 0x08
+)
+
+// SOCKSConnect decides a SOCKS5 CONNECT to dest, a host:port. It returns nil
+// to refuse the connection, or a function that serves the client connection
+// after the success reply.
+type SOCKSConnect func(dest string) func(net.Conn)
+
+// socksListener routes connections that open with a SOCKS5 greeting to a
+// SOCKSConnect and returns every other connection from Accept.
+type socksListener struct {
+	net.Listener
+	connect SOCKSConnect
+	conns   chan net.Conn
+	errs    chan error
+	done    chan struct{}
+	once    sync.Once
+}
+
+// ListenSOCKS5 wraps an HTTP proxy listener so the same port also accepts
+// SOCKS5 clients without authentication. A first byte of 0x05 never starts
+// an HTTP request, so both kinds of client share the port.
+func ListenSOCKS5(inner net.Listener, connect SOCKSConnect) net.Listener {
+	l := &socksListener{
+		Listener: inner,
+		connect:  connect,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error, 1),
+		done:     make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+func (l *socksListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+			}
+			return
+		}
+		go l.route(conn)
+	}
+}
+
+func (l *socksListener) route(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	first, err := reader.Peek(1)
+	_ = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	peeked := &peekedConn{Conn: conn, reader: reader}
+	if first[0] == 0x05 {
+		serveSOCKS5(peeked, l.connect)
+		return
+	}
+	select {
+	case l.conns <- peeked:
+	case <-l.done:
+		_ = conn.Close()
+	}
+}
+
+func (l *socksListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *socksListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// serveSOCKS5 performs the SOCKS5 handshake and hands the connection to
+// connect's server. Only the CONNECT command is supported.
+func serveSOCKS5(conn net.Conn, connect SOCKSConnect) {
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	dest, err := socksHandshake(conn)
+	if err != nil {
+		log.Printf("SOCKS5 handshake failed: %v", err)
+		_ = conn.Close()
+		return
+	}
+	serve := connect(dest)
+	if serve == nil {
+		_ = socksReply(conn, socksNotAllowed)
+		_ = conn.Close()
+		return
+	}
+	if err := socksReply(conn, socksSucceeded); err != nil {
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+	serve(conn)
+}
+
+// socksHandshake negotiates no authentication, reads a CONNECT request, and
+// returns its destination. Unsupported requests are answered before the
+// error is returned.
+func socksHandshake(conn net.Conn) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	noAuth := false
+	for _, method := range methods {
+		noAuth = noAuth || method == 0x00
+	}
+	if !noAuth {
+		_, _ = conn.Write([]byte{0x05, 0xff})
+		return "", errors.New("client offers no unauthenticated method")
+	}
+	if _, err := conn.Write([]byte{0x05, 0x00}); err != nil {
+		return "", err
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+	if request[0] != 0x05 {
+		return "", fmt.Errorf("unexpected SOCKS version %d", request[0])
+	}
+	if request[1] != 0x01 {
+		_ = socksReply(conn, socksCommandUnsupported)
+		return "", fmt.Errorf("unsupported SOCKS command %d", request[1])
+	}
+	var host string
+	switch request[3] {
+	case 0x01, 0x04:
+		addr := make([]byte, net.IPv4len)
+		if request[3] == 0x04 {
+			addr = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return "", err
+		}
+		host = net.IP(addr).String()
+	case 0x03:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		_ = socksReply(conn, socksAddressUnsupported)
+		return "", fmt.Errorf("unsupported SOCKS address type %d", request[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+func socksReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{0x05, code, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// socksConnect handles SOCKS5 clients of the forward proxy with the same
+// host policies, MITM, and tunnel logic as HTTP CONNECT. After the handshake
+// the client's first bytes decide: TLS is intercepted when allowed and
+// tunneled otherwise, plain HTTP is recorded as proxy requests are, and other
+// protocols are recorded as raw TCP streams.
+func (ctx *ProxyContext) socksConnect(dest string) func(net.Conn) {
+	host, _, err := net.SplitHostPort(dest)
+	if err != nil {
+		return nil
+	}
+	policy, matched := ctx.Hosts.Lookup(host)
+	if matched && policy.Action == HostBlock {
+		log.Printf("SOCKS5 CONNECT refused by host policy: %s", dest)
+		return nil
+	}
+	if matched && policy.Action == HostTunnel {
+		return func(conn net.Conn) { TunnelConn(conn, dest, ctx) }
+	}
+	mitm := ctx.UseMITM && ctx.CA != nil
+	return func(conn net.Conn) {
+		conn, protocol := SniffConn(conn)
+		switch {
+		case protocol == ProtocolHTTP:
+			serveHTTPConn(conn, dest, ctx)
+		case mitm && protocol == ProtocolTLS && ctx.interceptAllowed(host):
+			log.Printf("Handling SOCKS5 CONNECT (MITM) to %s", dest)
+			serveMITM(conn, dest, host, ctx, time.Now().UTC())
+		case mitm && !ctx.interceptAllowed(host):
+			// As with HTTP CONNECT, MITM mode only opens allowlisted hosts.
+			log.Printf("SOCKS5 CONNECT refused for non-allowlisted host: %s", host)
+			_ = conn.Close()
+		case protocol == ProtocolOpaque:
+			recordStream(conn, dest, ctx)
+		default:
+			TunnelConn(conn, dest, ctx)
+		}
+	}
+}
+
+// TunnelConn relays a client connection to dest uninspected and logs it like
+// an HTTP CONNECT tunnel.
+func TunnelConn(clientConn net.Conn, dest string, ctx *ProxyContext) {
+	startTime := time.Now().UTC()
+	action := ctx.Inject.Evaluate(false)
+	if action.Delay > 0 {
+		time.Sleep(action.Delay)
+	}
+	if action.Drop || action.Reset {
+		_ = clientConn.Close()
+		logEventTunnel(ctx, startTime, dest, 0, action.Applied, "Injected drop/reset")
+		return
+	}
+	dialCtx, cancel := context.WithTimeout(withResolutionLog(context.Background(), ctx), 10*time.Second)
+	targetConn, err := dialDestination(dialCtx, "tcp", dest, "", ctx.AllowPrivateDestinations)
+	cancel()
+	if err != nil {
+		_ = clientConn.Close()
+		logEventTunnel(ctx, startTime, dest, 0, action.Applied, err.Error())
+		return
+	}
+	relayTunnel(clientConn, targetConn, ctx)
+	logEventTunnel(ctx, startTime, dest, 200, action.Applied, "")
+}
+
+// serveHTTPConn serves plain HTTP requests a client sends over a connection
+// it opened to dest, recording them like forward proxy requests.
+func serveHTTPConn(conn net.Conn, dest string, ctx *ProxyContext) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !req.URL.IsAbs() {
+			req.URL.Scheme = "http"
+			req.URL.Host = dest
+		}
+		handleHTTP(w, req, ctx)
+	})
+	server := &http.Server{Handler: h2c.NewHandler(handler, &http2.Server{}), ReadHeaderTimeout: 10 * time.Second}
+	if err := server.Serve(&singleConnListener{conn: conn}); err != nil && err != http.ErrServerClosed && err != io.EOF {
+		log.Printf("SOCKS5 HTTP connection error: %v", err)
+	}
+}
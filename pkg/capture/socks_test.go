@@ -0,0 +1,110 @@
+package capture
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"infernosim/pkg/event"
+)
+
+func TestForwardProxyServesSOCKS5OnTheSamePort(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("socks-" + r.URL.Path[1:]))
+	})
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	secure := httptest.NewTLSServer(handler)
+	defer secure.Close()
+
+	ca, err := NewCAStoreAt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.AllowedHosts = []string{"127.0.0.1"}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.CertificatePEM())
+	policies, err := NewHostPolicies([]HostPolicy{{Host: "localhost", Action: HostBlock}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logPath := filepath.Join(t.TempDir(), "outbound.log")
+	logger, err := event.NewLogger(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+	proxy, err := StartForwardProxy("127.0.0.1:0", &ProxyContext{
+		Logger:                   logger,
+		CA:                       ca,
+		UseMITM:                  true,
+		AllowPrivateDestinations: true,
+		AllowInsecureUpstream:    true,
+		CaptureSensitiveData:     true,
+		Hosts:                    policies,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxy.Close()
+
+	client := func(scheme string) *http.Client {
+		proxyURL, _ := url.Parse(scheme + "://" + proxy.Addr)
+		return &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12},
+		}}
+	}
+	get := func(c *http.Client, target string) string {
+		t.Helper()
+		resp, err := c.Get(target)
+		if err != nil {
+			t.Fatalf("GET %s: %v", target, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	socks := client("socks5")
+	if body := get(socks, plain.URL+"/plain"); body != "socks-plain" {
+		t.Fatalf("plain body = %q", body)
+	}
+	if body := get(socks, secure.URL+"/secure"); body != "socks-secure" {
+		t.Fatalf("secure body = %q", body)
+	}
+	if body := get(client("http"), plain.URL+"/proxied"); body != "socks-proxied" {
+		t.Fatalf("HTTP proxy body = %q", body)
+	}
+	blocked := strings.Replace(plain.URL, "127.0.0.1", "localhost", 1)
+	if resp, err := socks.Get(blocked + "/blocked"); err == nil {
+		_ = resp.Body.Close()
+		t.Fatal("expected the host policy to refuse the SOCKS5 CONNECT")
+	}
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	captured := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var evt event.Event
+		if json.Unmarshal([]byte(line), &evt) == nil && evt.Method == http.MethodGet {
+			captured[evt.URL] = true
+		}
+	}
+	for _, want := range []string{plain.URL + "/plain", secure.URL + "/secure", plain.URL + "/proxied"} {
+		if !captured[want] {
+			t.Fatalf("captured %v, missing %s", captured, want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package capture
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"infernosim/pkg/event"
+)
+
+// StartTCPProxy starts a raw TCP capture proxy on listenAddr that relays
+// every connection to target and records it as a TCPStream event. It is for
+// protocols InfernoSIM cannot parse; point the application at listenAddr
+// instead of target.
+func StartTCPProxy(listenAddr, target string, ctx *ProxyContext) (net.Listener, error) {
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go recordStream(conn, target, ctx)
+		}
+	}()
+	return listener, nil
+}
+
+// streamRecorder collects the chunks read from both sides of a connection.
+// Payload bytes are kept when the proxy stores bodies, up to its body bound
+// across the whole connection.
+type streamRecorder struct {
+	mu        sync.Mutex
+	start     time.Time
+	store     bool
+	limit     int
+	captured  int
+	truncated bool
+	chunks    []event.StreamChunk
+	sent      int64
+	received  int64
+}
+
+func (r *streamRecorder) record(direction string, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if direction == event.StreamClient {
+		r.sent += int64(len(data))
+	} else {
+		r.received += int64(len(data))
+	}
+	if r.truncated {
+		return
+	}
+	if r.captured+len(data) > r.limit {
+		r.truncated = true
+		return
+	}
+	r.captured += len(data)
+	hash := sha256.Sum256(data)
+	chunk := event.StreamChunk{
+		Direction: direction,
+		Offset:    time.Since(r.start),
+		Size:      len(data),
+		Sha256:    hex.EncodeToString(hash[:]),
+	}
+	if r.store {
+		chunk.DataB64 = base64.StdEncoding.EncodeToString(data)
+	}
+	r.chunks = append(r.chunks, chunk)
+}
+
+// recordingConn records every read from its connection as a chunk.
+type recordingConn struct {
+	net.Conn
+	recorder  *streamRecorder
+	direction string
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.recorder.record(c.direction, p[:n])
+	}
+	return n, err
+}
+
+// recordStream relays clientConn to dest and logs the connection as a
+// TCPStream event once both sides have closed. Raw payloads cannot be
+// redacted, so they are stored only when the proxy stores bodies.
+func recordStream(clientConn net.Conn, dest string, ctx *ProxyContext) {
+	ctx = ctx.forHost(dest)
+	startTime := time.Now().UTC()
+	recorder := &streamRecorder{
+		start: time.Now(),
+		store: ctx.CaptureSensitiveData || (ctx.Privacy != nil && ctx.Privacy.CaptureBodies),
+		limit: ctx.bodyLimit(),
+	}
+	evt := &event.Event{
+		ID:        event.GenerateID(),
+		Type:      "TCPStream",
+		Timestamp: startTime,
+		URL:       "tcp://" + dest,
+		Service:   dest,
+	}
+	if host, _, err := net.SplitHostPort(dest); err == nil {
+		evt.Service = host
+	}
+
+	action := ctx.Inject.Evaluate(false)
+	evt.InjectionApplied = action.Applied
+	if action.Delay > 0 {
+		time.Sleep(action.Delay)
+	}
+	if action.Drop || action.Reset {
+		_ = clientConn.Close()
+		evt.Error = "Injected drop/reset"
+		evt.Duration = time.Since(recorder.start)
+		ctx.writeEvent(evt)
+		return
+	}
+	dialCtx, cancel := context.WithTimeout(withResolutionLog(context.Background(), ctx), 10*time.Second)
+	targetConn, err := dialDestination(dialCtx, "tcp", dest, "", ctx.AllowPrivateDestinations)
+	cancel()
+	if err != nil {
+		_ = clientConn.Close()
+		evt.Error = err.Error()
+		evt.Duration = time.Since(recorder.start)
+		ctx.writeEvent(evt)
+		return
+	}
+
+	tunnelTimeout := 5 * time.Minute
+	if ctx.TunnelIdleTimeout > 0 {
+		tunnelTimeout = ctx.TunnelIdleTimeout
+	}
+	client := &idleDeadlineConn{Conn: &recordingConn{Conn: clientConn, recorder: recorder, direction: event.StreamClient}, timeout: tunnelTimeout}
+	target := &idleDeadlineConn{Conn: &recordingConn{Conn: targetConn, recorder: recorder, direction: event.StreamServer}, timeout: tunnelTimeout}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	relay := func(dst, src net.Conn, half net.Conn) {
+		defer wg.Done()
+		_, _ = io.Copy(dst, src)
+		// Pass the half-close on so request-then-EOF protocols still work.
+		if closer, ok := half.(interface{ CloseWrite() error }); ok {
+			_ = closer.CloseWrite()
+		} else {
+			_ = half.Close()
+		}
+	}
+	go relay(target, client, targetConn)
+	go relay(client, target, clientConn)
+	wg.Wait()
+	_ = clientConn.Close()
+	_ = targetConn.Close()
+
+	recorder.mu.Lock()
+	evt.Chunks = recorder.chunks
+	evt.BytesSent = recorder.sent
+	evt.BytesReceived = recorder.received
+	evt.BodyTruncated = recorder.truncated
+	recorder.mu.Unlock()
+	evt.Duration = time.Since(recorder.start)
+	ctx.writeEvent(evt)
+	log.Printf("Recorded TCP stream to %s: %d bytes sent, %d received", dest, evt.BytesSent, evt.BytesReceived)
+}
@@ -0,0 +1,115 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"infernosim/pkg/event"
+)
+
+// startGreetingServer speaks first, then answers each line with its reverse.
+func startGreetingServer(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.WriteString(conn, "HELLO\n")
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					runes := []rune(strings.TrimSuffix(line, "\n"))
+					for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+						runes[i], runes[j] = runes[j], runes[i]
+					}
+					_, _ = io.WriteString(conn, string(runes)+"\n")
+				}
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestTCPProxyRecordsTimestampedChunks(t *testing.T) {
+	upstream := startGreetingServer(t)
+	defer upstream.Close()
+
+	for _, sensitive := range []bool{true, false} {
+		logPath := filepath.Join(t.TempDir(), "outbound.log")
+		logger, err := event.NewLogger(logPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener, err := StartTCPProxy("127.0.0.1:0", upstream.Addr().String(), &ProxyContext{
+			Logger:                   logger,
+			AllowPrivateDestinations: true,
+			CaptureSensitiveData:     sensitive,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader := bufio.NewReader(conn)
+		if greeting, _ := reader.ReadString('\n'); greeting != "HELLO\n" {
+			t.Fatalf("greeting = %q", greeting)
+		}
+		_, _ = io.WriteString(conn, "ping\n")
+		if reply, _ := reader.ReadString('\n'); reply != "gnip\n" {
+			t.Fatalf("reply = %q", reply)
+		}
+		_ = conn.Close()
+
+		var evt event.Event
+		deadline := time.Now().Add(5 * time.Second)
+		for evt.Type == "" && time.Now().Before(deadline) {
+			data, _ := os.ReadFile(logPath)
+			_ = json.Unmarshal(data, &evt)
+			time.Sleep(20 * time.Millisecond)
+		}
+		_ = listener.Close()
+		_ = logger.Close()
+
+		if evt.Type != "TCPStream" || evt.URL != "tcp://"+upstream.Addr().String() || evt.BytesSent != 5 || evt.BytesReceived != 11 {
+			t.Fatalf("event = %+v", evt)
+		}
+		var directions []string
+		var stored strings.Builder
+		for i, chunk := range evt.Chunks {
+			directions = append(directions, chunk.Direction)
+			if chunk.Sha256 == "" || (i > 0 && chunk.Offset < evt.Chunks[i-1].Offset) {
+				t.Fatalf("chunk %d = %+v", i, chunk)
+			}
+			data, _ := base64.StdEncoding.DecodeString(chunk.DataB64)
+			stored.Write(data)
+		}
+		if got := strings.Join(directions, ","); got != "server,client,server" {
+			t.Fatalf("directions = %s", got)
+		}
+		if want := map[bool]string{true: "HELLO\nping\ngnip\n", false: ""}[sensitive]; stored.String() != want {
+			t.Fatalf("sensitive=%v stored %q, want %q", sensitive, stored.String(), want)
+		}
+	}
+}
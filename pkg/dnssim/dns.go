@@ -0,0 +1,309 @@
+// Package dnssim is a deterministic DNS stub for offline replays. It answers
+// A, AAAA, and SRV queries for the names observed in an incident with the
+// simulator's address, and NXDOMAIN for everything else.
+package dnssim
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"infernosim/pkg/capture"
+	"infernosim/pkg/event"
+)
+
+// Fault makes lookups of names matching Name, a host pattern as in host
+// policies, fail with NXDOMAIN or answer after Delay.
+type Fault struct {
+	Name     string
+	NXDomain bool
+	Delay    time.Duration
+}
+
+// Validate reports faults that match nothing or do nothing.
+func (f Fault) Validate() error {
+	if err := capture.ValidateHostPattern(f.Name); err != nil {
+		return err
+	}
+	if f.Delay < 0 {
+		return fmt.Errorf("DNS fault delay for %q must be >= 0", f.Name)
+	}
+	if !f.NXDomain && f.Delay == 0 {
+		return fmt.Errorf("DNS fault for %q needs nxdomain or a delay", f.Name)
+	}
+	return nil
+}
+
+type Options struct {
+	// AnswerIPv4 is the address A queries resolve to (default 127.0.0.1).
+	AnswerIPv4 net.IP
+	// AnswerIPv6, when set, is the address AAAA queries resolve to. Without
+	// it AAAA queries get an empty answer so clients fall back to IPv4.
+	AnswerIPv6 net.IP
+	// SRVPort is the port SRV answers point at, normally the stub's.
+	SRVPort uint16
+	// ReplayLatency delays each answer by the lookup time captured for the
+	// name, multiplied by LatencyScale (0 means 1).
+	ReplayLatency bool
+	LatencyScale  float64
+	Faults        []Fault
+}
+
+// name is what capture saw for one name.
+type name struct {
+	rcode dnsmessage.RCode
+	delay time.Duration
+}
+
+// Resolver answers queries from the names observed in an incident.
+type Resolver struct {
+	opts  Options
+	names map[string]name
+
+	mu     sync.Mutex
+	misses map[string]bool
+}
+
+// New collects the names the incident's outbound log connected to or
+// resolved. A name whose every captured lookup failed keeps failing: with
+// NXDOMAIN when capture saw NXDOMAIN, else with SERVFAIL.
+func New(outboundLog string, opts Options) (*Resolver, error) {
+	for _, fault := range opts.Faults {
+		if err := fault.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.AnswerIPv4 == nil {
+		opts.AnswerIPv4 = net.IPv4(127, 0, 0, 1)
+	}
+	if opts.AnswerIPv4.To4() == nil {
+		return nil, fmt.Errorf("DNS answer address %s is not IPv4", opts.AnswerIPv4)
+	}
+	if opts.AnswerIPv6 != nil && (opts.AnswerIPv6.To16() == nil || opts.AnswerIPv6.To4() != nil) {
+		return nil, fmt.Errorf("DNS answer address %s is not IPv6", opts.AnswerIPv6)
+	}
+
+	f, err := os.Open(outboundLog)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := &Resolver{opts: opts, names: map[string]name{}, misses: map[string]bool{}}
+	resolved := map[string]bool{}
+	dec := json.NewDecoder(f)
+	for {
+		var e event.Event
+		if err := dec.Decode(&e); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("outbound log parse error: %w", err)
+		}
+		host := eventHost(e)
+		if host == "" {
+			continue
+		}
+		entry := r.names[host]
+		switch {
+		case e.Type != "DNSResolution":
+			resolved[host] = true
+		case e.Error == "":
+			resolved[host] = true
+			entry.delay = max(entry.delay, e.Duration)
+		case e.Error == "NXDOMAIN":
+			entry.rcode = dnsmessage.RCodeNameError
+			entry.delay = max(entry.delay, e.Duration)
+		default:
+			if entry.rcode != dnsmessage.RCodeNameError {
+				entry.rcode = dnsmessage.RCodeServerFailure
+			}
+			entry.delay = max(entry.delay, e.Duration)
+		}
+		r.names[host] = entry
+	}
+	for host := range resolved {
+		entry := r.names[host]
+		entry.rcode = dnsmessage.RCodeSuccess
+		r.names[host] = entry
+	}
+	return r, nil
+}
+
+// eventHost returns the DNS name an outbound event depended on, or "" for
+// IP literals and events without a destination.
+func eventHost(e event.Event) string {
+	var host string
+	switch e.Type {
+	case "OutboundCall", "TCPStream", "DNSResolution":
+	default:
+		return ""
+	}
+	if u, err := url.Parse(e.URL); err == nil && u.Host != "" {
+		host = u.Hostname()
+	} else if h, _, err := net.SplitHostPort(e.URL); err == nil {
+		host = h
+	}
+	host = normalize(host)
+	if host == "" || net.ParseIP(host) != nil {
+		return ""
+	}
+	return host
+}
+
+func normalize(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// Names returns the names the resolver answers for, sorted.
+func (r *Resolver) Names() []string {
+	out := make([]string, 0, len(r.names))
+	for host := range r.names {
+		out = append(out, host)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Misses returns the names queried that the incident never saw, sorted.
+func (r *Resolver) Misses() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]string, 0, len(r.misses))
+	for host := range r.misses {
+		out = append(out, host)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Serve answers queries arriving on conn until it is closed.
+func (r *Resolver) Serve(conn net.PacketConn) error {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		query := append([]byte(nil), buf[:n]...)
+		go func() {
+			reply, delay, err := r.respond(query)
+			if err != nil {
+				return
+			}
+			if delay > 0 {
+				time.Sleep(delay)
+			}
+			_, _ = conn.WriteTo(reply, addr)
+		}()
+	}
+}
+
+// respond builds the reply to query and how long to wait before sending it.
+func (r *Resolver) respond(query []byte) ([]byte, time.Duration, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, 0, err
+	}
+	reply := dnsmessage.Message{Header: dnsmessage.Header{
+		ID:               header.ID,
+		Response:         true,
+		Authoritative:    true,
+		RecursionDesired: header.RecursionDesired,
+	}}
+	question, err := parser.Question()
+	if err != nil {
+		reply.RCode = dnsmessage.RCodeFormatError
+		out, err := reply.Pack()
+		return out, 0, err
+	}
+	reply.Questions = []dnsmessage.Question{question}
+
+	host := normalize(question.Name.String())
+	if question.Type == dnsmessage.TypeSRV {
+		host = srvTarget(host)
+	}
+	entry, known := r.names[host]
+	if !known {
+		r.mu.Lock()
+		r.misses[host] = true
+		r.mu.Unlock()
+		entry.rcode = dnsmessage.RCodeNameError
+	}
+	delay := time.Duration(0)
+	if known && r.opts.ReplayLatency {
+		scale := r.opts.LatencyScale
+		if scale == 0 {
+			scale = 1
+		}
+		delay = time.Duration(float64(entry.delay) * scale)
+	}
+	for _, fault := range r.opts.Faults {
+		if !capture.MatchHost(fault.Name, host) {
+			continue
+		}
+		if fault.NXDomain {
+			entry.rcode = dnsmessage.RCodeNameError
+		}
+		if fault.Delay > 0 {
+			delay = fault.Delay
+		}
+	}
+	reply.RCode = entry.rcode
+	if entry.rcode == dnsmessage.RCodeSuccess {
+		reply.Answers, reply.Additionals = r.answers(question, host)
+	}
+	out, err := reply.Pack()
+	return out, delay, err
+}
+
+// answers points A and AAAA questions at the simulator, and SRV questions
+// at host on the simulator's port with its address as glue.
+func (r *Resolver) answers(question dnsmessage.Question, host string) ([]dnsmessage.Resource, []dnsmessage.Resource) {
+	ipv4 := func(name dnsmessage.Name) dnsmessage.Resource {
+		var a dnsmessage.AResource
+		copy(a.A[:], r.opts.AnswerIPv4.To4())
+		return dnsmessage.Resource{Header: dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET}, Body: &a}
+	}
+	switch question.Type {
+	case dnsmessage.TypeA:
+		return []dnsmessage.Resource{ipv4(question.Name)}, nil
+	case dnsmessage.TypeAAAA:
+		if r.opts.AnswerIPv6 == nil {
+			return nil, nil
+		}
+		var aaaa dnsmessage.AAAAResource
+		copy(aaaa.AAAA[:], r.opts.AnswerIPv6.To16())
+		return []dnsmessage.Resource{{Header: dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET}, Body: &aaaa}}, nil
+	case dnsmessage.TypeSRV:
+		target, err := dnsmessage.NewName(host + ".")
+		if err != nil {
+			return nil, nil
+		}
+		srv := &dnsmessage.SRVResource{Target: target, Port: r.opts.SRVPort}
+		return []dnsmessage.Resource{{Header: dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET}, Body: srv}},
+			[]dnsmessage.Resource{ipv4(target)}
+	}
+	return nil, nil
+}
+
+// srvTarget strips the _service._proto labels from an SRV query name.
+func srvTarget(name string) string {
+	for strings.HasPrefix(name, "_") {
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			return name
+		}
+		name = name[i+1:]
+	}
+	return name
+}
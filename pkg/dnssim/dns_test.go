@@ -0,0 +1,81 @@
+package dnssim
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolverAnswersObservedNamesAndFaults(t *testing.T) {
+	outbound := filepath.Join(t.TempDir(), "outbound.log")
+	lines := []string{
+		`{"type":"OutboundCall","method":"GET","url":"https://api.example.com/v1/orders"}`,
+		`{"type":"OutboundCall","method":"CONNECT","url":"payments.example.com:443"}`,
+		`{"type":"TCPStream","url":"tcp://cache.example.com:6379"}`,
+		`{"type":"OutboundCall","method":"GET","url":"http://10.0.0.7/health"}`,
+		`{"type":"DNSResolution","url":"dns://gone.example.com","error":"NXDOMAIN"}`,
+		`{"type":"DNSResolution","url":"dns://api.example.com","duration":5000000,"addresses":["203.0.113.9"]}`,
+	}
+	if err := os.WriteFile(outbound, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	resolver, err := New(outbound, Options{
+		AnswerIPv4: net.IPv4(127, 0, 0, 2),
+		SRVPort:    19000,
+		Faults: []Fault{
+			{Name: "payments.example.com", NXDomain: true},
+			{Name: "cache.example.com", Delay: 200 * time.Millisecond},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(resolver.Names(), ","); got != "api.example.com,cache.example.com,gone.example.com,payments.example.com" {
+		t.Fatalf("names = %s", got)
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() { _ = resolver.Serve(conn) }()
+	client := &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "udp", conn.LocalAddr().String())
+	}}
+	ctx := context.Background()
+
+	addrs, err := client.LookupHost(ctx, "api.example.com.")
+	if err != nil || strings.Join(addrs, ",") != "127.0.0.2" {
+		t.Fatalf("api lookup = %v, %v", addrs, err)
+	}
+	_, srvs, err := client.LookupSRV(ctx, "http", "tcp", "api.example.com.")
+	if err != nil || len(srvs) != 1 || srvs[0].Target != "api.example.com." || srvs[0].Port != 19000 {
+		t.Fatalf("SRV lookup = %+v, %v", srvs, err)
+	}
+
+	start := time.Now()
+	if _, err := client.LookupHost(ctx, "cache.example.com."); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("slow-DNS fault answered after %s", elapsed)
+	}
+
+	for _, host := range []string{"gone.example.com.", "payments.example.com.", "unseen.example.com."} {
+		_, err := client.LookupHost(ctx, host)
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			t.Fatalf("%s lookup err = %v, want NXDOMAIN", host, err)
+		}
+	}
+	if got := strings.Join(resolver.Misses(), ","); got != "unseen.example.com" {
+		t.Fatalf("misses = %s", got)
+	}
+}
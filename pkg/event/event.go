@@ -2,6 +2,7 @@ package event
 
 import (
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/hex"
 	"fmt"
 	"regexp"
@@ -25,6 +26,11 @@ type Event struct {
 	Headers  map[string][]string `json:"headers,omitempty"`
 	BodySize int64               `json:"bodySize,omitempty"`
 	TraceID  string              `json:"traceId,omitempty"`
+	// Protocol is the ALPN protocol negotiated with the client, such as h2
+	// or h3, when the call arrived over TLS.
+	Protocol string `json:"protocol,omitempty"`
+	// ClientIdentity names the verified client certificate of an mTLS call.
+	ClientIdentity string `json:"clientIdentity,omitempty"`
 
 	// Payload tracking
 	BodyB64       string `json:"bodyB64,omitempty"`
@@ -45,6 +51,9 @@ type Event struct {
 	ResponseBodyTruncated bool                `json:"responseBodyTruncated,omitempty"`
 	ResponseBodyRedacted  bool                `json:"responseBodyRedacted,omitempty"`
 	ResponseCaptured      bool                `json:"responseCaptured,omitempty"`
+	// ResponseAlternatives replace the captured response for a weighted
+	// share of replayed calls. They are never written by capture.
+	ResponseAlternatives []ResponseAlternative `json:"responseAlternatives,omitempty"`
 
 	// gRPC specific
 	GrpcServiceMethod string `json:"grpcServiceMethod,omitempty"`
@@ -52,6 +61,42 @@ type Event struct {
 
 	// Fault injection flag (from pkg/inject)
 	InjectionApplied string `json:"injectionApplied,omitempty"`
+
+	// Addresses lists the IPs a DNSResolution event resolved to.
+	Addresses []string `json:"addresses,omitempty"`
+
+	// Chunks holds the reads of a TCPStream event in the order they
+	// happened on either side of the connection.
+	Chunks []StreamChunk `json:"chunks,omitempty"`
+}
+
+// Directions of a StreamChunk.
+const (
+	StreamClient = "client"
+	StreamServer = "server"
+)
+
+// StreamChunk is one read from a side of a raw TCP connection. DataB64 is
+// empty when payload bytes were not captured.
+type StreamChunk struct {
+	Direction string `json:"direction"`
+	// Offset is the time since the connection opened.
+	Offset  time.Duration `json:"offset"`
+	Size    int           `json:"size"`
+	Sha256  string        `json:"sha256"`
+	DataB64 string        `json:"dataB64,omitempty"`
+}
+
+// ResponseAlternative is a weighted replacement for an exchange's captured
+// response. Weight is a percentage of calls; the captured response answers
+// the remainder.
+type ResponseAlternative struct {
+	Weight           int                 `json:"weight"`
+	Status           int                 `json:"status"`
+	ResponseHeaders  map[string][]string `json:"responseHeaders,omitempty"`
+	ResponseTrailers map[string][]string `json:"responseTrailers,omitempty"`
+	ResponseBodyB64  string              `json:"responseBodyB64,omitempty"`
+	GrpcStatus       string              `json:"grpcStatus,omitempty"`
 }
 
 // GenerateID returns a cryptographically random 32-character hex string.
@@ -78,3 +123,22 @@ func SanitizeTraceID(raw string) string {
 	}
 	return ""
 }
+
+// ClientIdentity names the verified client certificate of a TLS connection:
+// its first URI SAN (such as a SPIFFE ID), else its subject common name, else
+// its first DNS SAN. It is empty when no client certificate was verified.
+func ClientIdentity(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	cert := state.VerifiedChains[0][0]
+	switch {
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	}
+	return ""
+}
@@ -394,6 +394,19 @@ func classify(rule, kind, location string, values []string) Proposal {
 	return proposal
 }
 
+// VolatileField reports whether the values observed at a header or JSONPath
+// location are volatile by the rules heal proposes matchers for: the name
+// marks a request ID, nonce, or timestamp, every value has the same narrow
+// form, and the name is not a protected security or business field.
+func VolatileField(location string, values ...string) bool {
+	name := strings.ToLower(location)
+	if len(values) == 0 || protectedNamePart.MatchString(strings.NewReplacer("[", ".", "]", "", "$", "").Replace(name)) {
+		return false
+	}
+	pattern, _, _ := commonPattern(values, name)
+	return pattern != ""
+}
+
 func commonPattern(values []string, location string) (string, float64, string) {
 	all := func(predicate func(string) bool) bool {
 		for _, value := range values {
@@ -242,3 +242,15 @@ func FuzzFlattenJSON(f *testing.F) {
 		}
 	})
 }
+
+func TestVolatileFieldProtectsBusinessFields(t *testing.T) {
+	if !VolatileField("$.meta.request_id", "4f0c8a0e-8c4e-4d7a-9b1e-2f1a3c5d7e9f", "0b7e6d5c-4a39-4281-9f0e-1d2c3b4a5968") {
+		t.Fatal("request ID UUIDs were not volatile")
+	}
+	if !VolatileField("$.created_at", "2026-03-04T14:02:00Z", "2026-03-04T14:05:10.5Z") {
+		t.Fatal("creation timestamps were not volatile")
+	}
+	if VolatileField("$.order_status", "2026-03-04T14:02:00Z", "2026-03-04T14:05:10Z") || VolatileField("$.total", "12.5", "13") || VolatileField("$.time", "noon", "dusk") {
+		t.Fatal("a protected or free-form field was volatile")
+	}
+}
@@ -0,0 +1,330 @@
+package matcher
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"infernosim/pkg/event"
+)
+
+// Field names reported by FieldDiff.
+const (
+	FieldMethod         = "method"
+	FieldHost           = "host"
+	FieldPath           = "path"
+	FieldQuery          = "query"
+	FieldHeader         = "header"
+	FieldJSONPath       = "jsonpath"
+	FieldProtobuf       = "protobuf"
+	FieldBody           = "body"
+	FieldClientIdentity = "client_identity"
+)
+
+// fieldWeights rank near misses: a different method or host is much further
+// from the request than a single differing query parameter.
+var fieldWeights = map[string]int{
+	FieldMethod: 8,
+	FieldHost:   8,
+	FieldPath:   4,
+	FieldBody:   2,
+}
+
+// FieldDiff is one field on which a request and a captured call differ.
+// Name is the query parameter, header, or JSONPath for keyed fields. When
+// Regex is set, Expected is the rule pattern the actual value failed.
+// Missing values are reported as empty with the Missing flags set.
+type FieldDiff struct {
+	Field           string `json:"field"`
+	Name            string `json:"name,omitempty"`
+	Expected        string `json:"expected,omitempty"`
+	Actual          string `json:"actual,omitempty"`
+	Regex           bool   `json:"regex,omitempty"`
+	ExpectedMissing bool   `json:"expected_missing,omitempty"`
+	ActualMissing   bool   `json:"actual_missing,omitempty"`
+}
+
+// Diff lists every field on which req differs from captured under the
+// configured rules. Unlike Match it does not stop at the first mismatch, so
+// it is intended for diagnostics rather than the hot matching path.
+func (m *Matcher) Diff(captured event.Event, req *http.Request, body []byte) []FieldDiff {
+	if req == nil || req.URL == nil {
+		return []FieldDiff{{Field: FieldPath, ActualMissing: true, Expected: captured.URL}}
+	}
+	capturedURL, err := url.Parse(captured.URL)
+	if err != nil {
+		capturedURL = &url.URL{}
+	}
+	reqHost := req.URL.Host
+	if reqHost == "" {
+		reqHost = req.Host
+	}
+	var diffs []FieldDiff
+	if !strings.EqualFold(req.Method, captured.Method) {
+		diffs = append(diffs, FieldDiff{Field: FieldMethod, Expected: captured.Method, Actual: req.Method})
+	}
+	cr := m.ruleFor(req.Method, reqHost, escapedPath(req.URL))
+	if cr != nil && cr.host != nil {
+		if !cr.host.MatchString(stripPort(reqHost)) {
+			diffs = append(diffs, FieldDiff{Field: FieldHost, Expected: cr.rule.HostRegex, Actual: reqHost, Regex: true})
+		}
+	} else if !equalHost(reqHost, capturedURL.Host) {
+		diffs = append(diffs, FieldDiff{Field: FieldHost, Expected: capturedURL.Host, Actual: reqHost})
+	}
+	if cr != nil && cr.path != nil {
+		if !cr.path.MatchString(req.URL.Path) {
+			diffs = append(diffs, FieldDiff{Field: FieldPath, Expected: cr.rule.PathRegex, Actual: req.URL.Path, Regex: true})
+		}
+	} else if escapedPath(req.URL) != escapedPath(capturedURL) {
+		diffs = append(diffs, FieldDiff{Field: FieldPath, Expected: escapedPath(capturedURL), Actual: escapedPath(req.URL)})
+	}
+
+	ignoredQuery := append([]string{}, m.cfg.IgnoredQueryParameters...)
+	if cr != nil {
+		ignoredQuery = append(ignoredQuery, cr.rule.IgnoredQueryParameters...)
+		for name := range cr.query {
+			ignoredQuery = append(ignoredQuery, name)
+		}
+	}
+	diffs = append(diffs, diffValues(FieldQuery, capturedURL.Query(), req.URL.Query(), ignoredQuery, func(name string) string { return name })...)
+	if cr == nil {
+		return diffs
+	}
+	for _, name := range sortedKeys(cr.query) {
+		values, present := req.URL.Query()[name]
+		actual := ""
+		if present {
+			actual = values[0]
+		}
+		if !cr.query[name].MatchString(actual) {
+			diffs = append(diffs, FieldDiff{Field: FieldQuery, Name: name, Expected: cr.rule.QueryRegex[name], Actual: actual, Regex: true, ActualMissing: !present})
+		}
+	}
+	for _, name := range sortedKeys(cr.headers) {
+		actual := req.Header.Get(name)
+		if !cr.headers[name].MatchString(actual) {
+			diffs = append(diffs, FieldDiff{Field: FieldHeader, Name: name, Expected: patternFor(cr.rule.HeaderRegex, name), Actual: actual, Regex: true, ActualMissing: len(req.Header.Values(name)) == 0})
+		}
+	}
+	if cr.clientIdentity != nil {
+		actual := event.ClientIdentity(req.TLS)
+		if !cr.clientIdentity.MatchString(actual) {
+			diffs = append(diffs, FieldDiff{Field: FieldClientIdentity, Expected: cr.rule.ClientIdentityRegex, Actual: actual, Regex: true, ActualMissing: actual == ""})
+		}
+	}
+	if cr.rule.CompareHeaders {
+		ignoredHeaders := append(append([]string{}, m.cfg.IgnoredHeaders...), cr.rule.IgnoredHeaders...)
+		for name := range cr.headers {
+			ignoredHeaders = append(ignoredHeaders, name)
+		}
+		diffs = append(diffs, diffValues(FieldHeader, url.Values(captured.Headers), url.Values(req.Header), ignoredHeaders, http.CanonicalHeaderKey)...)
+	}
+
+	if len(cr.jsonValues) > 0 || cr.rule.CompareJSON {
+		var requestJSON any
+		if err := json.Unmarshal(body, &requestJSON); err != nil {
+			diffs = append(diffs, FieldDiff{Field: FieldBody, Expected: "JSON", Actual: "invalid JSON"})
+		} else {
+			diffs = append(diffs, regexDiffs(FieldJSONPath, cr.rule.JSONPathRegex, cr.jsonValues, requestJSON)...)
+			if cr.rule.CompareJSON {
+				ignoredPaths := append(append([]string{}, m.cfg.IgnoredJSONPaths...), cr.rule.IgnoredJSONPaths...)
+				for path := range cr.jsonValues {
+					ignoredPaths = append(ignoredPaths, path)
+				}
+				diffs = append(diffs, m.diffDocuments(FieldJSONPath, captured, requestJSON, ignoredPaths, func(raw []byte) (any, error) {
+					var value any
+					return value, json.Unmarshal(raw, &value)
+				})...)
+			}
+		}
+	}
+	if (len(cr.protobufValues) > 0 || cr.rule.CompareProtobuf) && m.grpc != nil {
+		methodPath := req.URL.Path
+		if cr.rule.GRPCMethod != "" {
+			methodPath = cr.rule.GRPCMethod
+		}
+		requestProtobuf, err := m.grpc.DecodeRequest(methodPath, body)
+		if err != nil {
+			diffs = append(diffs, FieldDiff{Field: FieldBody, Expected: "Protobuf " + methodPath, Actual: "undecodable Protobuf"})
+		} else {
+			diffs = append(diffs, regexDiffs(FieldProtobuf, cr.rule.ProtobufFieldRegex, cr.protobufValues, requestProtobuf)...)
+			if cr.rule.CompareProtobuf {
+				ignoredPaths := append([]string{}, cr.rule.IgnoredProtobufFields...)
+				for path := range cr.protobufValues {
+					ignoredPaths = append(ignoredPaths, path)
+				}
+				diffs = append(diffs, m.diffDocuments(FieldProtobuf, captured, requestProtobuf, ignoredPaths, func(raw []byte) (any, error) {
+					return m.grpc.DecodeRequest(methodPath, raw)
+				})...)
+			}
+		}
+	}
+	return diffs
+}
+
+// Distance scores diffs for ranking near misses; zero means no difference.
+func Distance(diffs []FieldDiff) int {
+	total := 0
+	for _, diff := range diffs {
+		if weight, ok := fieldWeights[diff.Field]; ok {
+			total += weight
+		} else {
+			total++
+		}
+	}
+	return total
+}
+
+// NearMisses explains every candidate with its field-level diff and returns
+// at most limit of them, closest first. Ties keep capture order. A limit of
+// zero or less returns all candidates.
+func (m *Matcher) NearMisses(candidates []event.Event, req *http.Request, body []byte, limit int) []Explanation {
+	explanations := m.Explain(candidates, req, body)
+	for i := range explanations {
+		explanations[i].Diffs = m.Diff(candidates[i], req, body)
+		explanations[i].Distance = Distance(explanations[i].Diffs)
+	}
+	sort.SliceStable(explanations, func(i, j int) bool {
+		if explanations[i].Matched != explanations[j].Matched {
+			return explanations[i].Matched
+		}
+		return explanations[i].Distance < explanations[j].Distance
+	})
+	if limit > 0 && len(explanations) > limit {
+		explanations = explanations[:limit]
+	}
+	return explanations
+}
+
+func (m *Matcher) diffDocuments(field string, captured event.Event, actual any, ignoredPaths []string, decode func([]byte) (any, error)) []FieldDiff {
+	capturedBody, err := base64.StdEncoding.DecodeString(captured.BodyB64)
+	if err != nil || len(capturedBody) == 0 {
+		return []FieldDiff{{Field: FieldBody, ExpectedMissing: true, Actual: "present"}}
+	}
+	expected, err := decode(capturedBody)
+	if err != nil {
+		return []FieldDiff{{Field: FieldBody, Expected: "undecodable captured body", Actual: "present"}}
+	}
+	// Round-trip actual so removing ignored paths does not mutate the caller's value.
+	encoded, _ := json.Marshal(actual)
+	var actualCopy any
+	_ = json.Unmarshal(encoded, &actualCopy)
+	encoded, _ = json.Marshal(expected)
+	var expectedCopy any
+	_ = json.Unmarshal(encoded, &expectedCopy)
+	for _, path := range ignoredPaths {
+		removeJSONPath(actualCopy, path)
+		removeJSONPath(expectedCopy, path)
+	}
+	want := make(map[string]string)
+	got := make(map[string]string)
+	flattenJSON("$", expectedCopy, want)
+	flattenJSON("$", actualCopy, got)
+	return diffFlat(field, want, got)
+}
+
+func regexDiffs(field string, patterns map[string]string, compiled map[string]*regexp.Regexp, document any) []FieldDiff {
+	var diffs []FieldDiff
+	for _, path := range sortedKeys(compiled) {
+		value, ok := JSONPathValue(document, path)
+		actual := stringValue(value)
+		if !ok || !compiled[path].MatchString(actual) {
+			diffs = append(diffs, FieldDiff{Field: field, Name: path, Expected: patterns[path], Actual: actual, Regex: true, ActualMissing: !ok})
+		}
+	}
+	return diffs
+}
+
+// diffValues compares multi-valued maps after canonicalizing names and
+// dropping ignored ones. Repeated values are compared in sorted order.
+func diffValues(field string, expected, actual url.Values, ignored []string, canonical func(string) string) []FieldDiff {
+	ignore := make(map[string]struct{}, len(ignored))
+	for _, name := range ignored {
+		ignore[canonical(name)] = struct{}{}
+	}
+	flatten := func(values url.Values) map[string]string {
+		out := make(map[string]string)
+		for name, vals := range values {
+			name = canonical(name)
+			if _, skip := ignore[name]; skip {
+				continue
+			}
+			copied := append([]string(nil), vals...)
+			sort.Strings(copied)
+			if existing, ok := out[name]; ok {
+				copied = append([]string{existing}, copied...)
+			}
+			out[name] = strings.Join(copied, ",")
+		}
+		return out
+	}
+	return diffFlat(field, flatten(expected), flatten(actual))
+}
+
+func diffFlat(field string, expected, actual map[string]string) []FieldDiff {
+	names := make(map[string]struct{}, len(expected)+len(actual))
+	for name := range expected {
+		names[name] = struct{}{}
+	}
+	for name := range actual {
+		names[name] = struct{}{}
+	}
+	var diffs []FieldDiff
+	for _, name := range sortedKeys(names) {
+		want, wantOK := expected[name]
+		got, gotOK := actual[name]
+		if wantOK && gotOK && want == got {
+			continue
+		}
+		diffs = append(diffs, FieldDiff{Field: field, Name: name, Expected: want, Actual: got, ExpectedMissing: !wantOK, ActualMissing: !gotOK})
+	}
+	return diffs
+}
+
+// flattenJSON maps every leaf of value to its JSONPath in the subset that
+// JSONPathValue accepts. Empty objects and arrays are leaves.
+func flattenJSON(path string, value any, out map[string]string) {
+	switch typed := value.(type) {
+	case map[string]any:
+		if len(typed) == 0 {
+			out[path] = "{}"
+			return
+		}
+		for key, child := range typed {
+			flattenJSON(path+"."+key, child, out)
+		}
+	case []any:
+		if len(typed) == 0 {
+			out[path] = "[]"
+			return
+		}
+		for index, child := range typed {
+			flattenJSON(path+"["+strconv.Itoa(index)+"]", child, out)
+		}
+	default:
+		out[path] = stringValue(typed)
+	}
+}
+
+func patternFor(patterns map[string]string, canonicalName string) string {
+	for name, pattern := range patterns {
+		if http.CanonicalHeaderKey(name) == canonicalName {
+			return pattern
+		}
+	}
+	return ""
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -30,7 +30,9 @@ type Config struct {
 
 // Rule applies semantic constraints to requests whose method and path match.
 // Regex values use Go's RE2 syntax. JSONPath supports $, dotted object keys,
-// and numeric array indexes, for example $.orders[0].id.
+// and numeric array indexes, for example $.orders[0].id. ClientIdentityRegex
+// matches the verified mTLS client identity of the request, as named by
+// event.ClientIdentity.
 type Rule struct {
 	Name                   string            `yaml:"name" json:"name,omitempty"`
 	Methods                []string          `yaml:"methods" json:"methods,omitempty"`
@@ -39,6 +41,7 @@ type Rule struct {
 	HeaderRegex            map[string]string `yaml:"header_regex" json:"header_regex,omitempty"`
 	QueryRegex             map[string]string `yaml:"query_regex" json:"query_regex,omitempty"`
 	JSONPathRegex          map[string]string `yaml:"jsonpath_regex" json:"jsonpath_regex,omitempty"`
+	ClientIdentityRegex    string            `yaml:"client_identity_regex" json:"client_identity_regex,omitempty"`
 	GRPCMethod             string            `yaml:"grpc_method" json:"grpc_method,omitempty"`
 	ProtobufFieldRegex     map[string]string `yaml:"protobuf_field_regex" json:"protobuf_field_regex,omitempty"`
 	IgnoredProtobufFields  []string          `yaml:"ignored_protobuf_fields" json:"ignored_protobuf_fields,omitempty"`
@@ -54,6 +57,7 @@ type compiledRule struct {
 	rule           Rule
 	host           *regexp.Regexp
 	path           *regexp.Regexp
+	clientIdentity *regexp.Regexp
 	headers        map[string]*regexp.Regexp
 	query          map[string]*regexp.Regexp
 	jsonValues     map[string]*regexp.Regexp
@@ -101,6 +105,11 @@ func NewWithRegistry(cfg Config, registry *grpcsim.Registry) (*Matcher, error) {
 				return nil, fmt.Errorf("matching.rules[%d].path_regex: %w", i, err)
 			}
 		}
+		if rule.ClientIdentityRegex != "" {
+			if cr.clientIdentity, err = regexp.Compile(rule.ClientIdentityRegex); err != nil {
+				return nil, fmt.Errorf("matching.rules[%d].client_identity_regex: %w", i, err)
+			}
+		}
 		for name, pattern := range rule.HeaderRegex {
 			re, compileErr := regexp.Compile(pattern)
 			if compileErr != nil {
@@ -218,6 +227,9 @@ func (m *Matcher) Match(captured event.Event, req *http.Request, body []byte) (b
 			return false, "query regex mismatch: " + name
 		}
 	}
+	if cr.clientIdentity != nil && !cr.clientIdentity.MatchString(event.ClientIdentity(req.TLS)) {
+		return false, "client identity regex mismatch"
+	}
 	for name, re := range cr.headers {
 		if !re.MatchString(req.Header.Get(name)) {
 			return false, "header regex mismatch: " + name
@@ -358,6 +370,9 @@ func (compiled *RuleMatcher) Match(req *http.Request, body []byte) (bool, string
 	if cr == nil {
 		return false, "rule selector mismatch"
 	}
+	if cr.clientIdentity != nil && !cr.clientIdentity.MatchString(event.ClientIdentity(req.TLS)) {
+		return false, "client identity regex mismatch"
+	}
 	for name, re := range cr.headers {
 		if !re.MatchString(req.Header.Get(name)) {
 			return false, "header regex mismatch: " + name
@@ -429,12 +444,16 @@ func (m *Matcher) GRPCRegistry() *grpcsim.Registry {
 	return m.grpc
 }
 
+// Explanation reports whether one captured candidate matches a request.
+// Diffs and Distance are populated only by NearMisses.
 type Explanation struct {
-	Index   int    `json:"index"`
-	Method  string `json:"method"`
-	URL     string `json:"url"`
-	Matched bool   `json:"matched"`
-	Reason  string `json:"reason,omitempty"`
+	Index    int         `json:"index"`
+	Method   string      `json:"method"`
+	URL      string      `json:"url"`
+	Matched  bool        `json:"matched"`
+	Reason   string      `json:"reason,omitempty"`
+	Distance int         `json:"distance,omitempty"`
+	Diffs    []FieldDiff `json:"diffs,omitempty"`
 }
 
 func (m *Matcher) Explain(candidates []event.Event, req *http.Request, body []byte) []Explanation {
@@ -592,6 +611,13 @@ func parseJSONPath(path string) ([]jsonPathToken, error) {
 	return tokens, nil
 }
 
+// ValidateJSONPath reports whether path is in the JSONPath subset that
+// JSONPathValue understands.
+func ValidateJSONPath(path string) error {
+	_, err := parseJSONPath(path)
+	return err
+}
+
 // JSONPathValue extracts a value using InfernoSIM's deliberately small,
 // deterministic JSONPath subset.
 func JSONPathValue(root any, path string) (any, bool) {
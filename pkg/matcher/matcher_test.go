@@ -68,6 +68,50 @@ func TestSemanticMatcherRegexHeadersJSONPathAndIgnoredFields(t *testing.T) {
 	}
 }
 
+func TestNearMissesRankCandidatesByFieldDiffs(t *testing.T) {
+	m, err := New(Config{Rules: []Rule{{
+		PathRegex:   `^/v1/orders$`,
+		CompareJSON: true,
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidates := []event.Event{
+		{Method: http.MethodGet, URL: "http://orders.test/v1/items"},
+		{
+			Method:  http.MethodPost,
+			URL:     "http://orders.test/v1/orders?region=eu",
+			BodyB64: base64.StdEncoding.EncodeToString([]byte(`{"items":[{"sku":"a"},{"sku":"b"}],"total":3}`)),
+		},
+	}
+	body := []byte(`{"items":[{"sku":"a"},{"sku":"c"}],"total":3,"coupon":"x"}`)
+	req := httptest.NewRequest(http.MethodPost, "http://orders.test/v1/orders?region=us", strings.NewReader(string(body)))
+
+	got := m.NearMisses(candidates, req, body, 1)
+	if len(got) != 1 || got[0].Index != 1 || got[0].Matched || got[0].Reason != "query mismatch" {
+		t.Fatalf("closest candidate = %+v", got)
+	}
+	want := []FieldDiff{
+		{Field: FieldQuery, Name: "region", Expected: "eu", Actual: "us"},
+		{Field: FieldJSONPath, Name: "$.coupon", Actual: "x", ExpectedMissing: true},
+		{Field: FieldJSONPath, Name: "$.items[1].sku", Expected: "b", Actual: "c"},
+	}
+	if len(got[0].Diffs) != len(want) {
+		t.Fatalf("diffs = %+v", got[0].Diffs)
+	}
+	for i := range want {
+		if got[0].Diffs[i] != want[i] {
+			t.Fatalf("diff[%d] = %+v, want %+v", i, got[0].Diffs[i], want[i])
+		}
+	}
+	if got[0].Distance != Distance(want) {
+		t.Fatalf("distance = %d", got[0].Distance)
+	}
+	if all := m.NearMisses(candidates, req, body, 0); len(all) != 2 || all[1].Diffs[0].Field != FieldMethod {
+		t.Fatalf("farthest candidate = %+v", all)
+	}
+}
+
 func TestMatcherRejectsInvalidRegex(t *testing.T) {
 	_, err := New(Config{Rules: []Rule{{PathRegex: "["}}})
 	if err == nil {
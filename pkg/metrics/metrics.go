@@ -0,0 +1,344 @@
+// Package metrics implements the small subset of the Prometheus text
+// exposition format that InfernoSIM needs: labelled counters and histograms.
+// It has no dependencies so simulators and capture proxies can expose
+// /metrics without pulling a client library into every build.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the Prometheus text exposition format version 0.0.4.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// maxSeriesPerFamily bounds label cardinality. Labels such as dependency hosts
+// come from runtime traffic, so an unbounded set could exhaust memory.
+const maxSeriesPerFamily = 1000
+
+// overflowLabel replaces every label value once a family reaches its limit.
+const overflowLabel = "_overflow"
+
+// DefaultLatencyBuckets covers sub-millisecond stub responses through
+// multi-second injected timeouts, in seconds.
+var DefaultLatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry holds metric families in registration order.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+	names    map[string]struct{}
+}
+
+type family interface {
+	writeHeader(w *bufio.Writer)
+	// writeSeries writes every series, adding the extra label name/value
+	// pairs to each.
+	writeSeries(w *bufio.Writer, extra ...string)
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]struct{})}
+}
+
+func (r *Registry) register(name string, f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.names[name]; exists {
+		panic(fmt.Sprintf("metrics: duplicate metric %q", name))
+	}
+	r.names[name] = struct{}{}
+	r.families = append(r.families, f)
+}
+
+// Counter registers a monotonically increasing counter family.
+func (r *Registry) Counter(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{vec: newVec(name, help, labelNames), values: make(map[string]float64)}
+	r.register(name, c)
+	return c
+}
+
+// Histogram registers a cumulative histogram family. Buckets must be sorted
+// in increasing order; nil uses DefaultLatencyBuckets.
+func (r *Registry) Histogram(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultLatencyBuckets
+	}
+	h := &HistogramVec{vec: newVec(name, help, labelNames), buckets: append([]float64(nil), buckets...), values: make(map[string]*histogramValue)}
+	r.register(name, h)
+	return h
+}
+
+// WriteText writes every family in the Prometheus text format.
+func (r *Registry) WriteText(w io.Writer) error {
+	buffered := bufio.NewWriter(w)
+	for _, f := range r.snapshot() {
+		f.writeHeader(buffered)
+		f.writeSeries(buffered)
+	}
+	return buffered.Flush()
+}
+
+func (r *Registry) snapshot() []family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]family(nil), r.families...)
+}
+
+// WriteMerged writes registries that register the same families, such as
+// one per simulated incident, as a single exposition. Each series gains a
+// label named label whose value is the registry's key. Families follow the
+// registration order of the first registry by key.
+func WriteMerged(w io.Writer, label string, registries map[string]*Registry) error {
+	keys := make([]string, 0, len(registries))
+	for key := range registries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(keys) == 0 {
+		return nil
+	}
+	buffered := bufio.NewWriter(w)
+	snapshots := make([][]family, len(keys))
+	for i, key := range keys {
+		snapshots[i] = registries[key].snapshot()
+	}
+	for index, f := range snapshots[0] {
+		f.writeHeader(buffered)
+		for i, key := range keys {
+			if index < len(snapshots[i]) {
+				snapshots[i][index].writeSeries(buffered, label, escapeLabel(key))
+			}
+		}
+	}
+	return buffered.Flush()
+}
+
+// MergedHandler serves WriteMerged for GET and HEAD requests.
+func MergedHandler(label string, registries map[string]*Registry) http.Handler {
+	return exposition(func(w io.Writer) error { return WriteMerged(w, label, registries) })
+}
+
+// Handler serves the registry for GET and HEAD requests.
+func (r *Registry) Handler() http.Handler {
+	return exposition(r.WriteText)
+}
+
+func exposition(write func(io.Writer) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", ContentType)
+		w.Header().Set("Cache-Control", "no-store")
+		if req.Method == http.MethodHead {
+			return
+		}
+		_ = write(w)
+	})
+}
+
+type vec struct {
+	name       string
+	help       string
+	labelNames []string
+	mu         sync.Mutex
+	labels     map[string][]string
+}
+
+func newVec(name, help string, labelNames []string) vec {
+	return vec{name: name, help: help, labelNames: append([]string(nil), labelNames...), labels: make(map[string][]string)}
+}
+
+// key returns the series key for labelValues, folding new series into the
+// overflow series once the family is full. The caller must hold v.mu.
+func (v *vec) key(labelValues []string) string {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	if _, ok := v.labels[key]; ok {
+		return key
+	}
+	if len(v.labels) >= maxSeriesPerFamily {
+		overflow := make([]string, len(labelValues))
+		for i := range overflow {
+			overflow[i] = overflowLabel
+		}
+		labelValues = overflow
+		key = strings.Join(overflow, "\xff")
+		if _, ok := v.labels[key]; ok {
+			return key
+		}
+	}
+	v.labels[key] = append([]string(nil), labelValues...)
+	return key
+}
+
+func (v *vec) sortedKeys() []string {
+	keys := make([]string, 0, len(v.labels))
+	for key := range v.labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (v *vec) headerText(w *bufio.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escapeHelp(v.help), v.name, kind)
+}
+
+// labelText formats the labels of a series followed by extra name/value
+// pairs, whose values must already be escaped.
+func (v *vec) labelText(key string, extra ...string) string {
+	values := v.labels[key]
+	if len(values) == 0 && len(extra) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(values)+len(extra)/2)
+	for i, value := range values {
+		parts = append(parts, v.labelNames[i]+`="`+escapeLabel(value)+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, extra[i]+`="`+extra[i+1]+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// CounterVec is a counter family partitioned by label values.
+type CounterVec struct {
+	vec
+	values map[string]float64
+}
+
+// Inc adds one to the series identified by labelValues.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta, which must be non-negative, to a series.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if c == nil || delta < 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[c.key(labelValues)] += delta
+}
+
+// Value returns the current value of a series.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[strings.Join(labelValues, "\xff")]
+}
+
+func (c *CounterVec) writeHeader(w *bufio.Writer) {
+	c.headerText(w, "counter")
+}
+
+func (c *CounterVec) writeSeries(w *bufio.Writer, extra ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelText(key, extra...), formatValue(c.values[key]))
+	}
+}
+
+// HistogramVec is a histogram family partitioned by label values.
+type HistogramVec struct {
+	vec
+	buckets []float64
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Observe records one sample, typically a duration in seconds.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := h.key(labelValues)
+	series := h.values[key]
+	if series == nil {
+		series = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = series
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+// Count returns the number of samples recorded for a series.
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if series := h.values[strings.Join(labelValues, "\xff")]; series != nil {
+		return series.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) writeHeader(w *bufio.Writer) {
+	h.headerText(w, "histogram")
+}
+
+func (h *HistogramVec) writeSeries(w *bufio.Writer, extra ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range h.sortedKeys() {
+		series := h.values[key]
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelText(key, append(extra[:len(extra):len(extra)], "le", formatValue(bound))...), series.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelText(key, append(extra[:len(extra):len(extra)], "le", "+Inf")...), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelText(key, extra...), formatValue(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelText(key, extra...), series.count)
+	}
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func escapeHelp(value string) string {
+	return helpEscaper.Replace(value)
+}
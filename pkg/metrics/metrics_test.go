@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWritesPrometheusText(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.Counter("infernosim_test_requests_total", "Requests by result.", "dependency", "result")
+	latency := registry.Histogram("infernosim_test_duration_seconds", "Latency.", []float64{0.1, 1}, "dependency")
+	requests.Inc("payments", "matched")
+	requests.Add(2, "payments", "matched")
+	requests.Inc(`we"ird`+"\n", "unmatched")
+	latency.Observe(0.05, "payments")
+	latency.Observe(0.5, "payments")
+
+	recorder := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if got := recorder.Header().Get("Content-Type"); got != ContentType {
+		t.Fatalf("content type = %q", got)
+	}
+	want := strings.Join([]string{
+		"# HELP infernosim_test_requests_total Requests by result.",
+		"# TYPE infernosim_test_requests_total counter",
+		`infernosim_test_requests_total{dependency="payments",result="matched"} 3`,
+		`infernosim_test_requests_total{dependency="we\"ird\n",result="unmatched"} 1`,
+		"# HELP infernosim_test_duration_seconds Latency.",
+		"# TYPE infernosim_test_duration_seconds histogram",
+		`infernosim_test_duration_seconds_bucket{dependency="payments",le="0.1"} 1`,
+		`infernosim_test_duration_seconds_bucket{dependency="payments",le="1"} 2`,
+		`infernosim_test_duration_seconds_bucket{dependency="payments",le="+Inf"} 2`,
+		`infernosim_test_duration_seconds_sum{dependency="payments"} 0.55`,
+		`infernosim_test_duration_seconds_count{dependency="payments"} 2`,
+		"",
+	}, "\n")
+	if recorder.Body.String() != want {
+		t.Fatalf("exposition mismatch:\n%s\nwant:\n%s", recorder.Body.String(), want)
+	}
+
+	post := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(post, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if post.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST status = %d", post.Code)
+	}
+}
+
+func TestRegistryBoundsLabelCardinality(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.Counter("infernosim_test_hosts_total", "Hosts.", "host")
+	for i := 0; i < maxSeriesPerFamily+10; i++ {
+		counter.Inc(fmt.Sprintf("host-%d", i))
+	}
+	if got := counter.Value(overflowLabel); got != 10 {
+		t.Fatalf("overflow series = %v, want 10", got)
+	}
+}
+
+func TestWriteMergedLabelsSeriesByRegistry(t *testing.T) {
+	registries := map[string]*Registry{}
+	for _, name := range []string{"payments", "inventory"} {
+		registry := NewRegistry()
+		registry.Counter("infernosim_test_requests_total", "Requests.", "result").Inc("matched")
+		registry.Histogram("infernosim_test_duration_seconds", "Latency.", []float64{1}).Observe(0.5)
+		registries[name] = registry
+	}
+	var out strings.Builder
+	if err := WriteMerged(&out, "incident", registries); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"# HELP infernosim_test_requests_total Requests.",
+		"# TYPE infernosim_test_requests_total counter",
+		`infernosim_test_requests_total{result="matched",incident="inventory"} 1`,
+		`infernosim_test_requests_total{result="matched",incident="payments"} 1`,
+		"# HELP infernosim_test_duration_seconds Latency.",
+		"# TYPE infernosim_test_duration_seconds histogram",
+		`infernosim_test_duration_seconds_bucket{incident="inventory",le="1"} 1`,
+		`infernosim_test_duration_seconds_bucket{incident="inventory",le="+Inf"} 1`,
+		`infernosim_test_duration_seconds_sum{incident="inventory"} 0.5`,
+		`infernosim_test_duration_seconds_count{incident="inventory"} 1`,
+		`infernosim_test_duration_seconds_bucket{incident="payments",le="1"} 1`,
+		`infernosim_test_duration_seconds_bucket{incident="payments",le="+Inf"} 1`,
+		`infernosim_test_duration_seconds_sum{incident="payments"} 0.5`,
+		`infernosim_test_duration_seconds_count{incident="payments"} 1`,
+		"",
+	}, "\n")
+	if out.String() != want {
+		t.Fatalf("merged exposition mismatch:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
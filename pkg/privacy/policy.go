@@ -2,9 +2,6 @@ package privacy
 
 import (
 	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -226,6 +223,29 @@ func (p *Policy) HeaderRule(name string) (Action, bool) {
 	return "", false
 }
 
+// JSONFieldRule returns the action of the JSON rule that covers path,
+// either exactly or through an ancestor object or array.
+func (p *Policy) JSONFieldRule(path string) (Action, bool) {
+	if p == nil {
+		return "", false
+	}
+	for _, rule := range p.JSONFields {
+		if path == rule.Path || strings.HasPrefix(path, rule.Path+".") || strings.HasPrefix(path, rule.Path+"[") {
+			return rule.Action, true
+		}
+	}
+	return "", false
+}
+
+// ApplyValue applies action to a single value the way the policy rewrites it
+// in place. Dropped values become empty.
+func (p *Policy) ApplyValue(value string, action Action) string {
+	if action == ActionTokenize && p == nil {
+		return "[REDACTED]"
+	}
+	return string(p.applyBytes([]byte(value), action))
+}
+
 func (p *Policy) ApplyHeaders(headers http.Header) http.Header {
 	out := headers.Clone()
 	if p == nil {
@@ -298,9 +318,7 @@ func (p *Policy) ApplyBody(body []byte) ([]byte, error) {
 }
 
 func (p *Policy) token(value string) string {
-	mac := hmac.New(sha256.New, p.tokenKey)
-	_, _ = mac.Write([]byte(value))
-	return "tok_" + hex.EncodeToString(mac.Sum(nil)[:16])
+	return (&Tokenizer{key: p.tokenKey}).Token(value)
 }
 
 type pathPart struct {
@@ -0,0 +1,187 @@
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Tokenizer derives keyed, irreversible pseudonyms for captured values. The
+// same key always yields the same pseudonyms.
+type Tokenizer struct {
+	key []byte
+}
+
+// NewTokenizer returns a tokenizer for key, which must hold at least 16
+// bytes.
+func NewTokenizer(key []byte) (*Tokenizer, error) {
+	if len(key) < 16 {
+		return nil, fmt.Errorf("token key must contain at least 16 bytes")
+	}
+	return &Tokenizer{key: append([]byte(nil), key...)}, nil
+}
+
+// Tokenizer returns the tokenizer of the policy's token key, or nil when the
+// policy has no tokenize rule.
+func (p *Policy) Tokenizer() *Tokenizer {
+	if p == nil || len(p.tokenKey) == 0 {
+		return nil
+	}
+	return &Tokenizer{key: p.tokenKey}
+}
+
+// Token returns "tok_" plus a keyed HMAC-SHA256 digest of value.
+func (t *Tokenizer) Token(value string) string {
+	mac := hmac.New(sha256.New, t.key)
+	_, _ = mac.Write([]byte(value))
+	return "tok_" + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func (t *Tokenizer) mac(parts ...string) []byte {
+	mac := hmac.New(sha256.New, t.key)
+	for _, part := range parts {
+		var length [8]byte
+		binary.BigEndian.PutUint64(length[:], uint64(len(part)))
+		_, _ = mac.Write(length[:])
+		_, _ = mac.Write([]byte(part))
+	}
+	return mac.Sum(nil)
+}
+
+// Shape returns a keyed pseudonym of word with the same length and character
+// classes: digits stay digits, upper and lower case letters keep their case,
+// and lowercase hex of eight or more characters stays hex. A number that
+// does not start with zero never gains a leading zero. attempt selects an
+// alternative pseudonym; every 32 attempts add a character so that small
+// domains such as single digits cannot run out.
+func (t *Tokenizer) Shape(word string, attempt int) string {
+	runes := []rune(word)
+	if len(runes) == 0 {
+		return ""
+	}
+	for extra := attempt / 32; extra > 0; extra-- {
+		runes = append(runes, runes[len(runes)-1])
+	}
+	hexWord := len(word) >= 8 && strings.Trim(word, "0123456789abcdef") == "" && strings.ContainsAny(word, "abcdef")
+	stream := t.mac("shape", fmt.Sprint(attempt), word)
+	var out strings.Builder
+	for i, r := range runes {
+		if i > 0 && i%len(stream) == 0 {
+			stream = t.mac("shape", fmt.Sprint(attempt), word, fmt.Sprint(i))
+		}
+		b := int(stream[i%len(stream)])
+		switch {
+		case hexWord:
+			out.WriteByte("0123456789abcdef"[b%16])
+		case unicode.IsDigit(r):
+			if i == 0 && len(runes) > 1 && r != '0' {
+				out.WriteByte(byte('1' + b%9))
+			} else {
+				out.WriteByte(byte('0' + b%10))
+			}
+		case unicode.IsUpper(r):
+			out.WriteByte(byte('A' + b%26))
+		default:
+			out.WriteByte(byte('a' + b%26))
+		}
+	}
+	return out.String()
+}
+
+// Pseudonyms replaces the words of captured values, maximal runs of letters
+// and digits, with Shape pseudonyms. Every value must be observed before the
+// first replacement: a fabricated word never equals an observed word or the
+// pseudonym of another word, so equal values stay equal, distinct values
+// stay distinct, and no observed value containing a letter or digit is ever
+// produced verbatim. Pseudonyms are assigned to the observed words in sorted
+// order, so they do not depend on the order of replacements.
+type Pseudonyms struct {
+	tokenizer *Tokenizer
+	captured  map[string]bool
+	forward   map[string]string
+	used      map[string]bool
+	assigned  bool
+}
+
+// NewPseudonyms returns an empty word dictionary for t.
+func NewPseudonyms(t *Tokenizer) *Pseudonyms {
+	return &Pseudonyms{tokenizer: t, captured: make(map[string]bool), forward: make(map[string]string), used: make(map[string]bool)}
+}
+
+// Observe records the words of a captured value.
+func (p *Pseudonyms) Observe(value string) {
+	for _, word := range words(value) {
+		p.captured[word] = true
+	}
+}
+
+// Replace returns value with each word replaced by its pseudonym.
+func (p *Pseudonyms) Replace(value string) string {
+	var out strings.Builder
+	start := -1
+	for i, r := range value {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			out.WriteString(p.Word(value[start:i]))
+			start = -1
+		}
+		out.WriteRune(r)
+	}
+	if start >= 0 {
+		out.WriteString(p.Word(value[start:]))
+	}
+	return out.String()
+}
+
+// Word returns the pseudonym of a single word.
+func (p *Pseudonyms) Word(word string) string {
+	if word == "" {
+		return ""
+	}
+	if !p.assigned {
+		p.assigned = true
+		observed := make([]string, 0, len(p.captured))
+		for captured := range p.captured {
+			observed = append(observed, captured)
+		}
+		sort.Strings(observed)
+		for _, captured := range observed {
+			p.Word(captured)
+		}
+	}
+	if fabricated, ok := p.forward[word]; ok {
+		return fabricated
+	}
+	for attempt := 0; ; attempt++ {
+		candidate := p.tokenizer.Shape(word, attempt)
+		if p.captured[candidate] || p.used[candidate] {
+			continue
+		}
+		p.forward[word] = candidate
+		p.used[candidate] = true
+		return candidate
+	}
+}
+
+// Len reports how many distinct words have been replaced.
+func (p *Pseudonyms) Len() int {
+	return len(p.forward)
+}
+
+func words(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool { return !isWordRune(r) })
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
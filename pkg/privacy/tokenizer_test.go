@@ -0,0 +1,37 @@
+package privacy
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestPseudonymsNeverReproduceCapturedWords(t *testing.T) {
+	tokenizer, err := NewTokenizer([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPseudonyms(tokenizer)
+	for digit := 0; digit < 10; digit++ {
+		p.Observe(strconv.Itoa(digit))
+	}
+	p.Observe("order-42 for Alice")
+
+	seen := make(map[string]bool)
+	for digit := 0; digit < 10; digit++ {
+		fabricated := p.Replace(strconv.Itoa(digit))
+		if _, err := strconv.Atoi(fabricated); err != nil || len(fabricated) < 2 || seen[fabricated] {
+			t.Fatalf("digit %d fabricated as %q", digit, fabricated)
+		}
+		seen[fabricated] = true
+	}
+	fabricated := p.Replace("order-42 for Alice")
+	if fabricated == "order-42 for Alice" || len(fabricated) != len("order-42 for Alice") || fabricated[5] != '-' || fabricated[13] < 'A' || fabricated[13] > 'Z' {
+		t.Fatalf("fabricated %q", fabricated)
+	}
+	if p.Replace("order-42 for Alice") != fabricated {
+		t.Fatal("pseudonyms are not stable")
+	}
+	if _, err := NewTokenizer([]byte("short")); err == nil {
+		t.Fatal("short token key was accepted")
+	}
+}
@@ -3,10 +3,13 @@ package replaydriver
 import (
 	"bytes"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"infernosim/pkg/dnssim"
 	"infernosim/pkg/matcher"
 	"infernosim/pkg/scenario"
 	"infernosim/pkg/simtemplate"
@@ -31,27 +34,142 @@ import (
 //	state:
 //	  file: ./state.json
 type ReplayYAMLConfig struct {
-	Target    string             `yaml:"target"`
-	TimeScale float64            `yaml:"time_scale"`
-	Runs      int                `yaml:"runs"`
-	SafeMode  bool               `yaml:"safe_mode"`
-	Chaos     ChaosConfig        `yaml:"chaos"`
-	State     StateConfig        `yaml:"state"`
-	Matching  matcher.Config     `yaml:"matching"`
-	Scenarios []scenario.Config  `yaml:"scenarios"`
-	Templates simtemplate.Config `yaml:"templates"`
-	Stub      StubConfig         `yaml:"stub"`
-	Workflows []workflow.Config  `yaml:"workflows"`
+	Target    string              `yaml:"target"`
+	TimeScale float64             `yaml:"time_scale"`
+	Runs      int                 `yaml:"runs"`
+	SafeMode  bool                `yaml:"safe_mode"`
+	Chaos     ChaosConfig         `yaml:"chaos"`
+	State     StateConfig         `yaml:"state"`
+	Matching  matcher.Config      `yaml:"matching"`
+	Scenarios []scenario.Config   `yaml:"scenarios"`
+	Templates simtemplate.Config  `yaml:"templates"`
+	Stub      StubConfig          `yaml:"stub"`
+	Workflows []workflow.Config   `yaml:"workflows"`
+	Load      LoadConfig          `yaml:"load"`
+	Latency   LatencyBudgetConfig `yaml:"latency"`
 }
 
 type StubConfig struct {
-	HTTPS HTTPSStubConfig `yaml:"https"`
+	HTTPS   HTTPSStubConfig   `yaml:"https"`
+	Latency StubLatencyConfig `yaml:"latency"`
+	TCP     StubTCPConfig     `yaml:"tcp"`
+	DNS     StubDNSConfig     `yaml:"dns"`
 }
 
+// StubDNSConfig serves a DNS stub on the UDP address Listen. It answers names
+// observed in the incident with Answer (default 127.0.0.1) and every other
+// name with NXDOMAIN. Faults make matching names fail or answer slowly.
+type StubDNSConfig struct {
+	Listen string         `yaml:"listen"`
+	Answer string         `yaml:"answer"`
+	Faults []StubDNSFault `yaml:"faults"`
+}
+
+// StubDNSFault applies to names matching Name, a host pattern. Delay is a
+// duration such as 2s.
+type StubDNSFault struct {
+	Name     string `yaml:"name"`
+	NXDomain bool   `yaml:"nxdomain"`
+	Delay    string `yaml:"delay"`
+}
+
+// DNSFaults parses the configured faults.
+func (c StubDNSConfig) DNSFaults() ([]dnssim.Fault, error) {
+	faults := make([]dnssim.Fault, 0, len(c.Faults))
+	for _, f := range c.Faults {
+		fault := dnssim.Fault{Name: f.Name, NXDomain: f.NXDomain}
+		if f.Delay != "" {
+			delay, err := time.ParseDuration(f.Delay)
+			if err != nil {
+				return nil, fmt.Errorf("stub.dns.faults delay %q: %w", f.Delay, err)
+			}
+			fault.Delay = delay
+		}
+		if err := fault.Validate(); err != nil {
+			return nil, fmt.Errorf("stub.dns.faults: %w", err)
+		}
+		faults = append(faults, fault)
+	}
+	return faults, nil
+}
+
+// StubTCPConfig replays captured raw TCP streams. Each Listen entry is
+// LISTEN=TARGET: the stub accepts connections on LISTEN and replays the
+// streams captured for TARGET. Ignore excludes byte ranges of every client
+// message, such as sequence numbers and timestamps, from matching.
+type StubTCPConfig struct {
+	Listen []string        `yaml:"listen"`
+	Ignore []StubTCPIgnore `yaml:"ignore"`
+}
+
+// StubTCPIgnore is a byte range of the client messages of streams to hosts
+// matching Host, or of every stream when Host is empty.
+type StubTCPIgnore struct {
+	Host   string `yaml:"host"`
+	Offset int    `yaml:"offset"`
+	Length int    `yaml:"length"`
+}
+
+// StubLatencyConfig replays captured dependency latency in the stub. Mode is
+// off, captured, or sampled. Scale multiplies the delays; 0 uses the replay
+// time scale, or 1 when serving.
+type StubLatencyConfig struct {
+	Mode  string  `yaml:"mode"`
+	Scale float64 `yaml:"scale"`
+}
+
+// HTTPSStubConfig enables native HTTPS stubbing. AllowHosts entries may be
+// exact names, globs such as *.payments.internal, or suffixes such as
+// .payments.internal. ClientCA, when set, is a PEM bundle of CAs whose client
+// certificates the stub requires. HostPolicy is a host policy file, shared
+// with capture, whose tunnel and block actions the stub applies before
+// AllowHosts.
 type HTTPSStubConfig struct {
 	Enabled    bool     `yaml:"enabled"`
 	CADir      string   `yaml:"ca_dir"`
 	AllowHosts []string `yaml:"allow_hosts"`
+	ClientCA   string   `yaml:"client_ca"`
+	HostPolicy string   `yaml:"host_policy"`
+}
+
+// LoadConfig selects open-loop load generation instead of timing-preserving
+// replay. An empty profile keeps the default replay behavior.
+type LoadConfig struct {
+	// Profile is constant, ramp, step, or poisson.
+	Profile  string  `yaml:"profile"`
+	RPS      float64 `yaml:"rps"`
+	StartRPS float64 `yaml:"start_rps"`
+	EndRPS   float64 `yaml:"end_rps"`
+	// Duration is the schedule length for constant, ramp, and poisson profiles.
+	Duration    string           `yaml:"duration"`
+	Steps       []LoadStepConfig `yaml:"steps"`
+	Seed        int64            `yaml:"seed"`
+	MaxInFlight int              `yaml:"max_in_flight"`
+}
+
+// LoadStepConfig is one stage of a step profile.
+type LoadStepConfig struct {
+	RPS      float64 `yaml:"rps"`
+	Duration string  `yaml:"duration"`
+}
+
+// LatencyBudgetConfig holds per-endpoint latency thresholds evaluated
+// against the captured baseline after each replay run.
+type LatencyBudgetConfig struct {
+	Thresholds []LatencyThresholdConfig `yaml:"thresholds"`
+}
+
+// LatencyThresholdConfig is the YAML form of LatencyThreshold.
+type LatencyThresholdConfig struct {
+	// Endpoint is "METHOD /path"; empty applies to every endpoint.
+	Endpoint string `yaml:"endpoint"`
+	// Percentile is p50, p90, p99, or max.
+	Percentile string `yaml:"percentile"`
+	// Max is an absolute ceiling such as "250ms".
+	Max string `yaml:"max"`
+	// MaxRegression is the allowed increase over baseline, such as "20%".
+	MaxRegression string `yaml:"max_regression"`
+	MinDelta      string `yaml:"min_delta"`
 }
 
 // ChaosConfig defines fault injection settings.
@@ -91,6 +209,25 @@ func LoadReplayConfig(path string) (ReplayYAMLConfig, error) {
 	if cfg.TimeScale < 0 {
 		return ReplayYAMLConfig{}, fmt.Errorf("parse replay config %q: time_scale must be >= 0", path)
 	}
+	switch cfg.Stub.Latency.Mode {
+	case "", "off", "captured", "sampled":
+	default:
+		return ReplayYAMLConfig{}, fmt.Errorf("parse replay config %q: stub.latency.mode %q must be off, captured, or sampled", path, cfg.Stub.Latency.Mode)
+	}
+	if cfg.Stub.Latency.Scale < 0 {
+		return ReplayYAMLConfig{}, fmt.Errorf("parse replay config %q: stub.latency.scale must be >= 0", path)
+	}
+	for _, ignore := range cfg.Stub.TCP.Ignore {
+		if ignore.Offset < 0 || ignore.Length <= 0 {
+			return ReplayYAMLConfig{}, fmt.Errorf("parse replay config %q: stub.tcp.ignore needs offset >= 0 and length > 0", path)
+		}
+	}
+	if cfg.Stub.DNS.Answer != "" && net.ParseIP(cfg.Stub.DNS.Answer) == nil {
+		return ReplayYAMLConfig{}, fmt.Errorf("parse replay config %q: stub.dns.answer %q is not an IP address", path, cfg.Stub.DNS.Answer)
+	}
+	if _, err := cfg.Stub.DNS.DNSFaults(); err != nil {
+		return ReplayYAMLConfig{}, fmt.Errorf("parse replay config %q: %w", path, err)
+	}
 	if cfg.Chaos.Latency.Request < 0 {
 		return ReplayYAMLConfig{}, fmt.Errorf("parse replay config %q: chaos.latency.request must be >= 0", path)
 	}
@@ -111,6 +248,21 @@ func LoadReplayConfig(path string) (ReplayYAMLConfig, error) {
 	if err := workflow.ValidateConfigs(cfg.Workflows); err != nil {
 		return ReplayYAMLConfig{}, fmt.Errorf("parse replay config %q: %w", path, err)
 	}
+	if _, err := cfg.Latency.LatencyThresholds(); err != nil {
+		return ReplayYAMLConfig{}, fmt.Errorf("parse replay config %q: %w", path, err)
+	}
+	if cfg.Load.Profile != "" {
+		profile, err := cfg.Load.LoadProfile()
+		if err != nil {
+			return ReplayYAMLConfig{}, fmt.Errorf("parse replay config %q: %w", path, err)
+		}
+		if err := profile.Validate(); err != nil {
+			return ReplayYAMLConfig{}, fmt.Errorf("parse replay config %q: %w", path, err)
+		}
+		if cfg.Load.MaxInFlight < 0 {
+			return ReplayYAMLConfig{}, fmt.Errorf("parse replay config %q: load.max_in_flight must be >= 0", path)
+		}
+	}
 	return cfg, nil
 }
 
@@ -128,3 +280,65 @@ func (c ChaosConfig) ChaosDelay() (time.Duration, error) {
 	}
 	return d, nil
 }
+
+// LoadProfile converts the YAML load section into a LoadProfile.
+func (c LoadConfig) LoadProfile() (LoadProfile, error) {
+	profile := LoadProfile{
+		Kind:     strings.ToLower(strings.TrimSpace(c.Profile)),
+		RPS:      c.RPS,
+		StartRPS: c.StartRPS,
+		EndRPS:   c.EndRPS,
+	}
+	if c.Duration != "" {
+		d, err := time.ParseDuration(c.Duration)
+		if err != nil {
+			return LoadProfile{}, fmt.Errorf("invalid load.duration %q: %w", c.Duration, err)
+		}
+		profile.Duration = d
+	}
+	for i, step := range c.Steps {
+		d, err := time.ParseDuration(step.Duration)
+		if err != nil {
+			return LoadProfile{}, fmt.Errorf("invalid load.steps[%d].duration %q: %w", i, step.Duration, err)
+		}
+		profile.Stages = append(profile.Stages, LoadStage{RPS: step.RPS, Duration: d})
+	}
+	return profile, nil
+}
+
+// LatencyThresholds converts and validates the YAML latency thresholds.
+func (c LatencyBudgetConfig) LatencyThresholds() ([]LatencyThreshold, error) {
+	thresholds := make([]LatencyThreshold, 0, len(c.Thresholds))
+	for i, raw := range c.Thresholds {
+		var threshold LatencyThreshold
+		var err error
+		if threshold.Percentile, err = ParsePercentile(raw.Percentile); err != nil {
+			return nil, fmt.Errorf("latency.thresholds[%d]: %w", i, err)
+		}
+		if endpoint := strings.Fields(raw.Endpoint); len(endpoint) == 2 {
+			threshold.Endpoint = EndpointKey(endpoint[0], endpoint[1])
+		} else if len(endpoint) != 0 {
+			return nil, fmt.Errorf("latency.thresholds[%d]: endpoint must be \"METHOD /path\"", i)
+		}
+		if raw.Max != "" {
+			if threshold.Max, err = time.ParseDuration(raw.Max); err != nil {
+				return nil, fmt.Errorf("latency.thresholds[%d]: invalid max %q: %w", i, raw.Max, err)
+			}
+		}
+		if raw.MaxRegression != "" {
+			if threshold.MaxRegression, err = ParseRegression(raw.MaxRegression); err != nil {
+				return nil, fmt.Errorf("latency.thresholds[%d]: %w", i, err)
+			}
+		}
+		if raw.MinDelta != "" {
+			if threshold.MinDelta, err = time.ParseDuration(raw.MinDelta); err != nil {
+				return nil, fmt.Errorf("latency.thresholds[%d]: invalid min_delta %q: %w", i, raw.MinDelta, err)
+			}
+		}
+		if err := threshold.Validate(); err != nil {
+			return nil, fmt.Errorf("latency.thresholds[%d]: %w", i, err)
+		}
+		thresholds = append(thresholds, threshold)
+	}
+	return thresholds, nil
+}
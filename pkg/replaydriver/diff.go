@@ -1,6 +1,7 @@
 package replaydriver
 
 import (
+	"encoding/base64"
 	"fmt"
 	"infernosim/pkg/event"
 	"net/http"
@@ -16,10 +17,24 @@ type EventDiff struct {
 	StatusChange string
 	HeaderDiff   []string
 	BodyDiff     string
-	LatencyDiff  string
+	// JSONChanges lists the field-level differences when both response
+	// bodies are JSON.
+	JSONChanges []JSONChange
+	LatencyDiff string
 }
 
+// maxPrintedJSONChanges bounds the field-level lines PrintDiffs writes per
+// event.
+const maxPrintedJSONChanges = 20
+
 func CompareEvents(captured, replayed event.Event, index int) *EventDiff {
+	return CompareEventsWithOptions(captured, replayed, index, DiffOptions{})
+}
+
+// CompareEventsWithOptions is CompareEvents with a field-level comparison of
+// JSON response bodies that honors opts. A JSON body whose only differences
+// are ignored or volatile fields is not reported.
+func CompareEventsWithOptions(captured, replayed event.Event, index int, opts DiffOptions) *EventDiff {
 	diff := &EventDiff{
 		Index:    index,
 		Captured: captured,
@@ -77,8 +92,16 @@ func CompareEvents(captured, replayed event.Event, index int) *EventDiff {
 		actualHash = replayed.BodySha256
 	}
 	if expectedHash != "" && actualHash != "" && expectedHash != actualHash {
-		diff.BodyDiff = fmt.Sprintf("Expected SHA-256 %s, Got %s", expectedHash, actualHash)
-		hasDiff = true
+		changes, isJSON := diffJSONBodies(captured, replayed, opts)
+		switch {
+		case !isJSON:
+			diff.BodyDiff = fmt.Sprintf("Expected SHA-256 %s, Got %s", expectedHash, actualHash)
+			hasDiff = true
+		case len(changes) > 0:
+			diff.BodyDiff = fmt.Sprintf("%d JSON field(s) differ", len(changes))
+			diff.JSONChanges = changes
+			hasDiff = true
+		}
 	}
 
 	if !hasDiff {
@@ -108,10 +131,35 @@ func PrintDiffs(diffs []*EventDiff) {
 		if d.BodyDiff != "" {
 			fmt.Printf("  [BODY]    %s\n", d.BodyDiff)
 		}
+		for i, change := range d.JSONChanges {
+			if i == maxPrintedJSONChanges {
+				fmt.Printf("  [JSON]    ... and %d more\n", len(d.JSONChanges)-i)
+				break
+			}
+			fmt.Printf("  [JSON]    %s\n", change)
+		}
 	}
 	fmt.Println("============================")
 }
 
+// diffJSONBodies compares the captured and replayed response bodies field by
+// field. isJSON is false when either body is missing or not JSON.
+func diffJSONBodies(captured, replayed event.Event, opts DiffOptions) (changes []JSONChange, isJSON bool) {
+	capturedBody, ok := capturedResponseBody(captured)
+	if !ok {
+		return nil, false
+	}
+	encoded := replayed.ResponseBodyB64
+	if encoded == "" {
+		encoded = replayed.BodyB64
+	}
+	replayedBody, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(replayedBody) == 0 {
+		return nil, false
+	}
+	return DiffJSON(capturedBody, replayedBody, opts)
+}
+
 func isVolatileResponseHeader(name string) bool {
 	switch http.CanonicalHeaderKey(name) {
 	case "Date", "Server", "Set-Cookie", "Content-Length":
@@ -1,6 +1,9 @@
 package replaydriver
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -55,6 +58,25 @@ func TestReplayFingerprintIncludesResponseBody(t *testing.T) {
 	}
 }
 
+func TestReplayReadsSlowBodiesWithinTheRequestTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(50 * time.Millisecond)
+		_, _ = w.Write([]byte("late body"))
+	}))
+	defer server.Close()
+	events := []event.Event{{Method: http.MethodGet, URL: "http://captured.test/slow", Timestamp: time.Now()}}
+
+	result, err := ReplayEvents(events, server.URL, ReplayConfig{TimeScale: 1, Density: 1, MaxIdleTime: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.CompletedEvents != 1 {
+		t.Fatalf("completed=%d", result.CompletedEvents)
+	}
+}
+
 func TestCompareEventsUsesResponseHeadersNotRequestHeaders(t *testing.T) {
 	captured := event.Event{
 		Status:           200,
@@ -72,6 +94,47 @@ func TestCompareEventsUsesResponseHeadersNotRequestHeaders(t *testing.T) {
 	}
 }
 
+func TestCompareEventsWithOptionsReportsJSONFieldChanges(t *testing.T) {
+	response := func(body string) event.Event {
+		return event.Event{
+			Status:             200,
+			ResponseCaptured:   true,
+			ResponseHeaders:    http.Header{"Content-Type": {"application/json"}},
+			ResponseBodyB64:    base64.StdEncoding.EncodeToString([]byte(body)),
+			ResponseBodySha256: fmt.Sprintf("%x", sha256.Sum256([]byte(body))),
+		}
+	}
+	captured := response(`{"id":42,"total":12.5,"note":"gift","tags":["a","b"],"lines":[1,2],"meta":{"request_id":"r-1","region":"eu"},"audit":{"by":"x"}}`)
+	replayed := response(`{"id":42,"total":13,"coupon":null,"tags":["b","a"],"lines":[1],"meta":{"request_id":"r-2","region":"eu"},"audit":{"by":"y"}}`)
+	opts := DiffOptions{
+		IgnoredJSONPaths: []string{"$.audit"},
+		Volatile:         func(path string, values ...string) bool { return path == "$.meta.request_id" },
+	}
+
+	diff := CompareEventsWithOptions(captured, replayed, 3, opts)
+	if diff == nil {
+		t.Fatal("JSON body change was not reported")
+	}
+	want := []JSONChange{
+		{Kind: JSONAdded, Path: "$.coupon", Replayed: "null"},
+		{Kind: JSONRemoved, Path: "$.lines[1]", Captured: "2"},
+		{Kind: JSONRemoved, Path: "$.note", Captured: `"gift"`},
+		{Kind: JSONReordered, Path: "$.tags", Captured: `["a","b"]`, Replayed: `["b","a"]`},
+		{Kind: JSONChanged, Path: "$.total", Captured: "12.5", Replayed: "13"},
+	}
+	if fmt.Sprint(diff.JSONChanges) != fmt.Sprint(want) || diff.BodyDiff != "5 JSON field(s) differ" {
+		t.Fatalf("changes = %v (%s), want %v", diff.JSONChanges, diff.BodyDiff, want)
+	}
+
+	quiet := response(`{"total":12.50,"id":42,"note":"gift","tags":["a","b"],"lines":[1,2],"meta":{"region":"eu","request_id":"r-3"},"audit":{}}`)
+	if diff := CompareEventsWithOptions(captured, quiet, 3, opts); diff != nil {
+		t.Fatalf("ignored, volatile, and reformatted fields produced a diff: %+v", diff.JSONChanges)
+	}
+	if diff := CompareEvents(captured, quiet, 3); diff == nil || len(diff.JSONChanges) != 2 {
+		t.Fatalf("CompareEvents without options = %+v", diff)
+	}
+}
+
 func TestLoadReplayConfigRejectsUnknownFields(t *testing.T) {
 	path := filepath.Join(t.TempDir(), "replay.yaml")
 	writeTestFile(t, path, []byte("target: http://localhost\nunknown: true\n"))
@@ -127,6 +190,32 @@ stub:
 	}
 }
 
+func TestLoadReplayConfigParsesDNSStubFaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay.yaml")
+	writeTestFile(t, path, []byte(`stub:
+  dns:
+    listen: 127.0.0.1:5353
+    faults:
+      - name: "*.payments.internal"
+        nxdomain: true
+      - name: cache.internal
+        delay: 2s
+`))
+	cfg, err := LoadReplayConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	faults, err := cfg.Stub.DNS.DNSFaults()
+	if err != nil || len(faults) != 2 || !faults[0].NXDomain || faults[1].Delay != 2*time.Second {
+		t.Fatalf("faults = %+v, %v", faults, err)
+	}
+
+	writeTestFile(t, path, []byte("stub:\n  dns:\n    faults:\n      - name: cache.internal\n"))
+	if _, err := LoadReplayConfig(path); err == nil {
+		t.Fatal("DNS fault without nxdomain or delay was accepted")
+	}
+}
+
 func TestReplayV2ExampleIsValid(t *testing.T) {
 	if _, err := LoadReplayConfig(filepath.Join("..", "..", "examples", "replay-v2.yaml")); err != nil {
 		t.Fatal(err)
@@ -0,0 +1,282 @@
+package replaydriver
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// histogramSubBucketBits controls histogram precision. Each power-of-two
+// magnitude is split into 2^(bits-1) linear sub-buckets, which bounds the
+// relative error of any reported value to under 1.6%.
+const histogramSubBucketBits = 7
+
+// LatencyHistogram is a fixed-precision, HDR-style latency histogram. Values
+// are recorded in nanoseconds into log-linear buckets so memory stays bounded
+// regardless of the number of samples or their range. It is safe for
+// concurrent use.
+type LatencyHistogram struct {
+	mu     sync.Mutex
+	counts []uint64
+	total  uint64
+	sum    float64
+	min    time.Duration
+	max    time.Duration
+}
+
+// NewLatencyHistogram returns an empty histogram.
+func NewLatencyHistogram() *LatencyHistogram {
+	return &LatencyHistogram{}
+}
+
+// Record adds one latency sample. Negative samples are recorded as zero.
+func (h *LatencyHistogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	index := histogramBucket(uint64(d))
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if index >= len(h.counts) {
+		grown := make([]uint64, index+1)
+		copy(grown, h.counts)
+		h.counts = grown
+	}
+	h.counts[index]++
+	if h.total == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.total++
+	h.sum += float64(d)
+}
+
+// Merge adds every sample of other into h.
+func (h *LatencyHistogram) Merge(other *LatencyHistogram) {
+	if other == nil || other == h {
+		return
+	}
+	other.mu.Lock()
+	counts := append([]uint64(nil), other.counts...)
+	total, sum, minimum, maximum := other.total, other.sum, other.min, other.max
+	other.mu.Unlock()
+	if total == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(counts) > len(h.counts) {
+		grown := make([]uint64, len(counts))
+		copy(grown, h.counts)
+		h.counts = grown
+	}
+	for i, count := range counts {
+		h.counts[i] += count
+	}
+	if h.total == 0 || minimum < h.min {
+		h.min = minimum
+	}
+	if maximum > h.max {
+		h.max = maximum
+	}
+	h.total += total
+	h.sum += sum
+}
+
+// Count returns the number of recorded samples.
+func (h *LatencyHistogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.total
+}
+
+// Min returns the smallest recorded sample.
+func (h *LatencyHistogram) Min() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.min
+}
+
+// Max returns the largest recorded sample.
+func (h *LatencyHistogram) Max() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.max
+}
+
+// Mean returns the arithmetic mean of the recorded samples.
+func (h *LatencyHistogram) Mean() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.total == 0 {
+		return 0
+	}
+	return time.Duration(h.sum / float64(h.total))
+}
+
+// Percentile returns the value at quantile q (0-100). The result is the
+// highest value equivalent to the selected bucket, clamped to the observed
+// range, so it never under-reports a tail latency.
+func (h *LatencyHistogram) Percentile(q float64) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.total == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return h.min
+	}
+	if q >= 100 {
+		return h.max
+	}
+	rank := uint64(math.Ceil(q / 100 * float64(h.total)))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for index, count := range h.counts {
+		seen += count
+		if seen < rank {
+			continue
+		}
+		value := time.Duration(histogramBucketUpper(index))
+		if value > h.max {
+			value = h.max
+		}
+		if value < h.min {
+			value = h.min
+		}
+		return value
+	}
+	return h.max
+}
+
+// Summary renders count, p50, p90, p99, and max on one line.
+func (h *LatencyHistogram) Summary() string {
+	return fmt.Sprintf(
+		"count=%d p50=%s p90=%s p99=%s max=%s",
+		h.Count(),
+		h.Percentile(50).Round(time.Microsecond),
+		h.Percentile(90).Round(time.Microsecond),
+		h.Percentile(99).Round(time.Microsecond),
+		h.Max().Round(time.Microsecond),
+	)
+}
+
+func histogramBucket(value uint64) int {
+	const linear = 1 << histogramSubBucketBits
+	if value < linear {
+		return int(value)
+	}
+	shift := bits.Len64(value) - histogramSubBucketBits
+	top := value >> uint(shift)
+	return linear + (shift-1)*(linear/2) + int(top-linear/2)
+}
+
+func histogramBucketUpper(index int) uint64 {
+	const linear = 1 << histogramSubBucketBits
+	if index < linear {
+		return uint64(index)
+	}
+	offset := index - linear
+	shift := offset/(linear/2) + 1
+	top := uint64(offset%(linear/2) + linear/2)
+	return ((top + 1) << uint(shift)) - 1
+}
+
+// EndpointLatency is the latency distribution observed for one endpoint.
+type EndpointLatency struct {
+	Endpoint  string
+	Histogram *LatencyHistogram
+}
+
+// EndpointHistograms groups latency histograms by method and path template.
+type EndpointHistograms struct {
+	mu        sync.Mutex
+	endpoints map[string]*LatencyHistogram
+}
+
+// NewEndpointHistograms returns an empty endpoint histogram set.
+func NewEndpointHistograms() *EndpointHistograms {
+	return &EndpointHistograms{endpoints: make(map[string]*LatencyHistogram)}
+}
+
+// Record adds a sample for the endpoint identified by method and URL.
+func (e *EndpointHistograms) Record(method, rawURL string, d time.Duration) {
+	e.histogram(EndpointKey(method, rawURL)).Record(d)
+}
+
+// Merge adds every endpoint histogram of other into e.
+func (e *EndpointHistograms) Merge(other *EndpointHistograms) {
+	if other == nil {
+		return
+	}
+	for _, endpoint := range other.Sorted() {
+		e.histogram(endpoint.Endpoint).Merge(endpoint.Histogram)
+	}
+}
+
+// Sorted returns endpoint histograms ordered by endpoint key.
+func (e *EndpointHistograms) Sorted() []EndpointLatency {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make([]EndpointLatency, 0, len(e.endpoints))
+	for endpoint, histogram := range e.endpoints {
+		out = append(out, EndpointLatency{Endpoint: endpoint, Histogram: histogram})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Endpoint < out[j].Endpoint })
+	return out
+}
+
+func (e *EndpointHistograms) histogram(key string) *LatencyHistogram {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	histogram := e.endpoints[key]
+	if histogram == nil {
+		histogram = NewLatencyHistogram()
+		e.endpoints[key] = histogram
+	}
+	return histogram
+}
+
+var (
+	numericSegment = regexp.MustCompile(`^[0-9]+$`)
+	uuidSegment    = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	hexSegment     = regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)
+)
+
+// EndpointKey returns "METHOD /path/template" for a captured or replayed URL.
+// Numeric, UUID, and long hexadecimal path segments collapse to {id} so
+// requests for different resources aggregate under one endpoint.
+func EndpointKey(method, rawURL string) string {
+	path := rawURL
+	if i := strings.Index(path, "://"); i >= 0 {
+		path = path[i+3:]
+		if slash := strings.Index(path, "/"); slash >= 0 {
+			path = path[slash:]
+		} else {
+			path = "/"
+		}
+	}
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	if path == "" {
+		path = "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if numericSegment.MatchString(segment) || uuidSegment.MatchString(segment) || hexSegment.MatchString(segment) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.ToUpper(method) + " " + strings.Join(segments, "/")
+}
@@ -0,0 +1,245 @@
+package replaydriver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JSON change kinds reported by DiffJSON.
+const (
+	JSONAdded     = "added"
+	JSONRemoved   = "removed"
+	JSONChanged   = "changed"
+	JSONReordered = "reordered"
+)
+
+// JSONChange is one field-level difference between a captured and a replayed
+// JSON document. Captured and Replayed hold JSON-encoded values; Captured is
+// empty for added fields and Replayed for removed ones.
+type JSONChange struct {
+	Kind     string `json:"kind"`
+	Path     string `json:"path"`
+	Captured string `json:"captured,omitempty"`
+	Replayed string `json:"replayed,omitempty"`
+}
+
+func (c JSONChange) String() string {
+	switch c.Kind {
+	case JSONAdded:
+		return fmt.Sprintf("added %s: %s", c.Path, abbreviate(c.Replayed))
+	case JSONRemoved:
+		return fmt.Sprintf("removed %s: %s", c.Path, abbreviate(c.Captured))
+	case JSONReordered:
+		return fmt.Sprintf("reordered %s", c.Path)
+	default:
+		return fmt.Sprintf("changed %s: %s -> %s", c.Path, abbreviate(c.Captured), abbreviate(c.Replayed))
+	}
+}
+
+// DiffOptions controls the response body comparison of
+// CompareEventsWithOptions.
+type DiffOptions struct {
+	// IgnoredJSONPaths lists JSONPaths, such as matcher.Config's
+	// IgnoredJSONPaths, whose values and descendants are not compared.
+	IgnoredJSONPaths []string
+	// Volatile reports whether a scalar field is expected to differ on every
+	// call, such as a request ID or timestamp; changes to such fields are not
+	// reported. heal.VolatileField applies the heal command's rules.
+	Volatile func(path string, values ...string) bool
+}
+
+// DiffJSON compares two JSON documents field by field. Object keys are
+// compared in sorted order, and an array whose elements are the same in a
+// different order is reported once as reordered. ok is false when either
+// document is not JSON.
+func DiffJSON(captured, replayed []byte, opts DiffOptions) (changes []JSONChange, ok bool) {
+	capturedValue, err := decodeJSONBody(captured)
+	if err != nil {
+		return nil, false
+	}
+	replayedValue, err := decodeJSONBody(replayed)
+	if err != nil {
+		return nil, false
+	}
+	d := jsonDiffer{opts: opts}
+	d.compare("$", capturedValue, replayedValue)
+	return d.changes, true
+}
+
+type jsonDiffer struct {
+	opts    DiffOptions
+	changes []JSONChange
+}
+
+func (d *jsonDiffer) ignored(path string) bool {
+	for _, ignored := range d.opts.IgnoredJSONPaths {
+		if ignored == "$" || path == ignored || strings.HasPrefix(path, ignored+".") || strings.HasPrefix(path, ignored+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *jsonDiffer) compare(path string, captured, replayed any) {
+	if d.ignored(path) {
+		return
+	}
+	switch c := captured.(type) {
+	case map[string]any:
+		if r, ok := replayed.(map[string]any); ok {
+			d.compareObjects(path, c, r)
+			return
+		}
+	case []any:
+		if r, ok := replayed.([]any); ok {
+			d.compareArrays(path, c, r)
+			return
+		}
+	}
+	if equalJSON(captured, replayed) {
+		return
+	}
+	capturedText, replayedText := encodeJSONValue(captured), encodeJSONValue(replayed)
+	if d.opts.Volatile != nil && isScalar(captured) && isScalar(replayed) && d.opts.Volatile(path, scalarText(captured), scalarText(replayed)) {
+		return
+	}
+	d.changes = append(d.changes, JSONChange{Kind: JSONChanged, Path: path, Captured: capturedText, Replayed: replayedText})
+}
+
+func (d *jsonDiffer) compareObjects(path string, captured, replayed map[string]any) {
+	keys := make([]string, 0, len(captured)+len(replayed))
+	for key := range captured {
+		keys = append(keys, key)
+	}
+	for key := range replayed {
+		if _, ok := captured[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		child := path + "." + key
+		capturedChild, inCaptured := captured[key]
+		replayedChild, inReplayed := replayed[key]
+		switch {
+		case !inReplayed:
+			if !d.ignored(child) {
+				d.changes = append(d.changes, JSONChange{Kind: JSONRemoved, Path: child, Captured: encodeJSONValue(capturedChild)})
+			}
+		case !inCaptured:
+			if !d.ignored(child) {
+				d.changes = append(d.changes, JSONChange{Kind: JSONAdded, Path: child, Replayed: encodeJSONValue(replayedChild)})
+			}
+		default:
+			d.compare(child, capturedChild, replayedChild)
+		}
+	}
+}
+
+func (d *jsonDiffer) compareArrays(path string, captured, replayed []any) {
+	if len(captured) == len(replayed) && len(captured) > 1 && !equalJSON(captured, replayed) && sameElements(captured, replayed) {
+		d.changes = append(d.changes, JSONChange{Kind: JSONReordered, Path: path, Captured: encodeJSONValue(captured), Replayed: encodeJSONValue(replayed)})
+		return
+	}
+	for i := 0; i < max(len(captured), len(replayed)); i++ {
+		child := path + "[" + strconv.Itoa(i) + "]"
+		switch {
+		case i >= len(replayed):
+			if !d.ignored(child) {
+				d.changes = append(d.changes, JSONChange{Kind: JSONRemoved, Path: child, Captured: encodeJSONValue(captured[i])})
+			}
+		case i >= len(captured):
+			if !d.ignored(child) {
+				d.changes = append(d.changes, JSONChange{Kind: JSONAdded, Path: child, Replayed: encodeJSONValue(replayed[i])})
+			}
+		default:
+			d.compare(child, captured[i], replayed[i])
+		}
+	}
+}
+
+func decodeJSONBody(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("trailing data after JSON value")
+	}
+	return value, nil
+}
+
+// encodeJSONValue returns the canonical encoding of value: object keys are
+// sorted and numbers keep their captured literal.
+func encodeJSONValue(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+func equalJSON(a, b any) bool {
+	if x, ok := a.(json.Number); ok {
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if x == y {
+			return true
+		}
+		fx, errX := x.Float64()
+		fy, errY := y.Float64()
+		return errX == nil && errY == nil && fx == fy
+	}
+	return encodeJSONValue(a) == encodeJSONValue(b)
+}
+
+func sameElements(a, b []any) bool {
+	counts := make(map[string]int, len(a))
+	for _, element := range a {
+		counts[encodeJSONValue(element)]++
+	}
+	for _, element := range b {
+		key := encodeJSONValue(element)
+		if counts[key] == 0 {
+			return false
+		}
+		counts[key]--
+	}
+	return true
+}
+
+func isScalar(value any) bool {
+	switch value.(type) {
+	case map[string]any, []any:
+		return false
+	default:
+		return true
+	}
+}
+
+func scalarText(value any) string {
+	switch typed := value.(type) {
+	case string:
+		return typed
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(typed)
+	}
+}
+
+func abbreviate(value string) string {
+	const limit = 80
+	if len(value) <= limit {
+		return value
+	}
+	return value[:limit-3] + "..."
+}
@@ -0,0 +1,258 @@
+package replaydriver
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"infernosim/pkg/event"
+)
+
+// LatencyStat summarizes the latency distribution of one method and path
+// template.
+type LatencyStat struct {
+	Endpoint string
+	Count    int
+	P50      time.Duration
+	P90      time.Duration
+	P99      time.Duration
+	Max      time.Duration
+}
+
+// Percentile returns the recorded value for 50, 90, 99, or 100 (max).
+func (s LatencyStat) Percentile(q float64) (time.Duration, bool) {
+	switch q {
+	case 50:
+		return s.P50, true
+	case 90:
+		return s.P90, true
+	case 99:
+		return s.P99, true
+	case 100:
+		return s.Max, true
+	default:
+		return 0, false
+	}
+}
+
+// Stats returns per-endpoint percentiles ordered by endpoint key.
+func (e *EndpointHistograms) Stats() []LatencyStat {
+	endpoints := e.Sorted()
+	stats := make([]LatencyStat, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		h := endpoint.Histogram
+		stats = append(stats, LatencyStat{
+			Endpoint: endpoint.Endpoint,
+			Count:    int(h.Count()),
+			P50:      h.Percentile(50),
+			P90:      h.Percentile(90),
+			P99:      h.Percentile(99),
+			Max:      h.Max(),
+		})
+	}
+	return stats
+}
+
+// LatencyStats computes per-endpoint percentiles from events that carry a
+// response. Captured inbound events use the request-to-response interval and
+// replayed events use the measured round trip, so both sides are comparable.
+func LatencyStats(events []event.Event) []LatencyStat {
+	histograms := NewEndpointHistograms()
+	for _, e := range events {
+		if !e.ResponseCaptured {
+			continue
+		}
+		histograms.Record(e.Method, e.URL, e.Duration)
+	}
+	return histograms.Stats()
+}
+
+// LatencyThreshold fails a replay when an endpoint percentile exceeds an
+// absolute ceiling or regresses too far from the captured baseline. Zero
+// Max and MaxRegression disable the corresponding check.
+type LatencyThreshold struct {
+	Endpoint      string  // "METHOD /path/template"; empty applies to every endpoint
+	Percentile    float64 // 50, 90, 99, or 100 for max
+	Max           time.Duration
+	MaxRegression float64 // allowed fractional increase over baseline, e.g. 0.2
+	// MinDelta ignores regressions smaller than this absolute increase so
+	// sub-millisecond baselines do not fail on scheduler noise.
+	MinDelta time.Duration
+}
+
+// Validate reports whether the threshold can be evaluated.
+func (t LatencyThreshold) Validate() error {
+	if _, ok := (LatencyStat{}).Percentile(t.Percentile); !ok {
+		return fmt.Errorf("latency threshold percentile must be p50, p90, p99, or max")
+	}
+	if t.Max < 0 || t.MaxRegression < 0 || t.MinDelta < 0 {
+		return fmt.Errorf("latency threshold limits must be >= 0")
+	}
+	if t.Max == 0 && t.MaxRegression == 0 {
+		return fmt.Errorf("latency threshold requires a maximum or a regression limit")
+	}
+	return nil
+}
+
+func (t LatencyThreshold) String() string {
+	var limits []string
+	if t.Max > 0 {
+		limits = append(limits, percentileLabel(t.Percentile)+"<="+t.Max.String())
+	}
+	if t.MaxRegression > 0 {
+		limits = append(limits, percentileLabel(t.Percentile)+"<=+"+strconv.FormatFloat(t.MaxRegression*100, 'f', -1, 64)+"%")
+	}
+	label := strings.Join(limits, " ")
+	if t.Endpoint != "" {
+		label = t.Endpoint + " " + label
+	}
+	return label
+}
+
+// LatencyViolation is one endpoint that broke a LatencyThreshold.
+type LatencyViolation struct {
+	Threshold LatencyThreshold
+	Endpoint  string
+	Observed  time.Duration
+	Baseline  time.Duration
+	Reason    string
+}
+
+// CheckLatency evaluates thresholds against candidate stats. Regression limits
+// are only evaluated for endpoints present in the baseline.
+func CheckLatency(baseline, candidate []LatencyStat, thresholds []LatencyThreshold) []LatencyViolation {
+	base := make(map[string]LatencyStat, len(baseline))
+	for _, stat := range baseline {
+		base[stat.Endpoint] = stat
+	}
+	var violations []LatencyViolation
+	for _, threshold := range thresholds {
+		label := percentileLabel(threshold.Percentile)
+		for _, stat := range candidate {
+			if threshold.Endpoint != "" && threshold.Endpoint != stat.Endpoint {
+				continue
+			}
+			observed, _ := stat.Percentile(threshold.Percentile)
+			if threshold.Max > 0 && observed > threshold.Max {
+				violations = append(violations, LatencyViolation{
+					Threshold: threshold,
+					Endpoint:  stat.Endpoint,
+					Observed:  observed,
+					Reason:    fmt.Sprintf("%s %s %s exceeds %s", stat.Endpoint, label, observed, threshold.Max),
+				})
+			}
+			reference, ok := base[stat.Endpoint]
+			if threshold.MaxRegression <= 0 || !ok {
+				continue
+			}
+			baselineValue, _ := reference.Percentile(threshold.Percentile)
+			limit := baselineValue + time.Duration(float64(baselineValue)*threshold.MaxRegression)
+			if observed > limit && observed-baselineValue > threshold.MinDelta {
+				violations = append(violations, LatencyViolation{
+					Threshold: threshold,
+					Endpoint:  stat.Endpoint,
+					Observed:  observed,
+					Baseline:  baselineValue,
+					Reason: fmt.Sprintf(
+						"%s %s %s regressed from baseline %s (limit +%.0f%%)",
+						stat.Endpoint, label, observed, baselineValue, threshold.MaxRegression*100,
+					),
+				})
+			}
+		}
+	}
+	return violations
+}
+
+var latencyBoundPattern = regexp.MustCompile(`^(p50|p90|p99|max)<=(.+)$`)
+
+// ParseLatencyThreshold parses CLI threshold specs such as "p99<=250ms",
+// "p90<=+20%", or "GET /orders/{id} p99<=+25% min-delta=5ms".
+func ParseLatencyThreshold(spec string) (LatencyThreshold, error) {
+	var threshold LatencyThreshold
+	var endpoint []string
+	bounds := 0
+	for _, field := range strings.Fields(spec) {
+		lower := strings.ToLower(field)
+		if match := latencyBoundPattern.FindStringSubmatch(lower); match != nil {
+			percentile, err := ParsePercentile(match[1])
+			if err != nil {
+				return LatencyThreshold{}, err
+			}
+			if bounds > 0 && percentile != threshold.Percentile {
+				return LatencyThreshold{}, fmt.Errorf("latency threshold %q mixes percentiles", spec)
+			}
+			threshold.Percentile = percentile
+			if strings.HasPrefix(match[2], "+") {
+				threshold.MaxRegression, err = ParseRegression(match[2])
+			} else {
+				threshold.Max, err = time.ParseDuration(match[2])
+			}
+			if err != nil {
+				return LatencyThreshold{}, fmt.Errorf("invalid latency threshold %q: %w", spec, err)
+			}
+			bounds++
+			continue
+		}
+		if value, ok := strings.CutPrefix(lower, "min-delta="); ok {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return LatencyThreshold{}, fmt.Errorf("invalid latency threshold %q: %w", spec, err)
+			}
+			threshold.MinDelta = d
+			continue
+		}
+		endpoint = append(endpoint, field)
+	}
+	if bounds == 0 {
+		return LatencyThreshold{}, fmt.Errorf("latency threshold %q has no pNN<=limit bound", spec)
+	}
+	if len(endpoint) > 0 {
+		if len(endpoint) != 2 {
+			return LatencyThreshold{}, fmt.Errorf("latency threshold %q endpoint must be \"METHOD /path\"", spec)
+		}
+		threshold.Endpoint = EndpointKey(endpoint[0], endpoint[1])
+	}
+	if err := threshold.Validate(); err != nil {
+		return LatencyThreshold{}, fmt.Errorf("invalid latency threshold %q: %w", spec, err)
+	}
+	return threshold, nil
+}
+
+// ParsePercentile parses "p50", "p90", "p99", or "max".
+func ParsePercentile(value string) (float64, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "p50":
+		return 50, nil
+	case "p90":
+		return 90, nil
+	case "p99":
+		return 99, nil
+	case "max", "p100":
+		return 100, nil
+	default:
+		return 0, fmt.Errorf("unsupported latency percentile %q (expected p50, p90, p99, or max)", value)
+	}
+}
+
+// ParseRegression parses a percentage such as "20%" or "+20%" into 0.2.
+func ParseRegression(value string) (float64, error) {
+	trimmed := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(value), "+"), "%")
+	if trimmed == strings.TrimPrefix(strings.TrimSpace(value), "+") {
+		return 0, fmt.Errorf("regression %q must be a percentage such as 20%%", value)
+	}
+	percent, err := strconv.ParseFloat(trimmed, 64)
+	if err != nil || percent < 0 {
+		return 0, fmt.Errorf("regression %q must be a non-negative percentage", value)
+	}
+	return percent / 100, nil
+}
+
+func percentileLabel(q float64) string {
+	if q == 100 {
+		return "max"
+	}
+	return "p" + strconv.FormatFloat(q, 'f', -1, 64)
+}
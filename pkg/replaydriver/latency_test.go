@@ -0,0 +1,90 @@
+package replaydriver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"infernosim/pkg/event"
+)
+
+func TestLatencyStatsAndThresholds(t *testing.T) {
+	var baselineEvents, candidateEvents []event.Event
+	for i := 1; i <= 10; i++ {
+		baselineEvents = append(baselineEvents, event.Event{
+			Method: "GET", URL: "http://svc/orders/" + strings.Repeat("1", i), Duration: time.Duration(i) * time.Millisecond, ResponseCaptured: true,
+		})
+		candidateEvents = append(candidateEvents, event.Event{
+			Method: "GET", URL: "http://svc/orders/" + strings.Repeat("2", i), Duration: time.Duration(i) * 3 * time.Millisecond, ResponseCaptured: true,
+		})
+	}
+	baselineEvents = append(baselineEvents, event.Event{Method: "GET", URL: "http://svc/health"})
+	baseline := LatencyStats(baselineEvents)
+	if len(baseline) != 1 || baseline[0].Endpoint != "GET /orders/{id}" || baseline[0].Count != 10 {
+		t.Fatalf("baseline stats = %+v", baseline)
+	}
+	if p50 := baseline[0].P50; p50 < 5*time.Millisecond || p50 > 5100*time.Microsecond || baseline[0].Max != 10*time.Millisecond {
+		t.Fatalf("baseline percentiles = %+v", baseline[0])
+	}
+	candidate := LatencyStats(candidateEvents)
+
+	regression, err := ParseLatencyThreshold("GET /orders/7 p90<=+50% min-delta=1ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ceiling, err := ParseLatencyThreshold("max<=1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	violations := CheckLatency(baseline, candidate, []LatencyThreshold{regression, ceiling})
+	if len(violations) != 1 || violations[0].Endpoint != "GET /orders/{id}" || violations[0].Baseline == 0 {
+		t.Fatalf("violations = %+v", violations)
+	}
+	noisy := regression
+	noisy.MinDelta = time.Second
+	if got := CheckLatency(baseline, candidate, []LatencyThreshold{noisy}); len(got) != 0 {
+		t.Fatalf("min delta did not suppress regression: %+v", got)
+	}
+
+	for _, spec := range []string{"p75<=1s", "p99", "GET p99<=1s", "p99<=1s p90<=2s", "p99<=+twenty%"} {
+		if _, err := ParseLatencyThreshold(spec); err == nil {
+			t.Fatalf("expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestReplayConfigLatencyThresholds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay.yaml")
+	if err := os.WriteFile(path, []byte(`
+latency:
+  thresholds:
+    - endpoint: GET /orders/42
+      percentile: p99
+      max: 250ms
+      max_regression: 20%
+      min_delta: 5ms
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadReplayConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thresholds, err := cfg.Latency.LatencyThresholds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := LatencyThreshold{Endpoint: "GET /orders/{id}", Percentile: 99, Max: 250 * time.Millisecond, MaxRegression: 0.2, MinDelta: 5 * time.Millisecond}
+	if len(thresholds) != 1 || thresholds[0] != want {
+		t.Fatalf("thresholds = %+v", thresholds)
+	}
+
+	if err := os.WriteFile(path, []byte("latency:\n  thresholds:\n    - percentile: p99\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadReplayConfig(path); err == nil {
+		t.Fatal("expected threshold without limits to be rejected")
+	}
+}
@@ -0,0 +1,370 @@
+package replaydriver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/cookiejar"
+	urlpkg "net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"infernosim/pkg/event"
+)
+
+// Load profile kinds accepted by LoadProfile.Kind.
+const (
+	LoadProfileConstant = "constant"
+	LoadProfileRamp     = "ramp"
+	LoadProfileStep     = "step"
+	LoadProfilePoisson  = "poisson"
+)
+
+// maxPlannedArrivals bounds the arrival schedule so a typo in rps or duration
+// cannot allocate an unbounded plan.
+const maxPlannedArrivals = 1_000_000
+
+// LoadStage is one segment of a step profile.
+type LoadStage struct {
+	RPS      float64
+	Duration time.Duration
+}
+
+// LoadProfile describes open-loop request arrivals. Arrivals are scheduled
+// independently of response times, so a slow target accumulates in-flight
+// requests instead of silently lowering the offered rate.
+type LoadProfile struct {
+	Kind     string
+	RPS      float64 // constant and poisson
+	StartRPS float64 // ramp
+	EndRPS   float64 // ramp
+	Stages   []LoadStage
+	Duration time.Duration // constant, ramp, and poisson
+}
+
+// Validate reports whether the profile can produce a bounded schedule.
+func (p LoadProfile) Validate() error {
+	switch p.Kind {
+	case LoadProfileConstant, LoadProfilePoisson:
+		if p.RPS <= 0 {
+			return fmt.Errorf("load profile %s requires rps > 0", p.Kind)
+		}
+		if p.Duration <= 0 {
+			return fmt.Errorf("load profile %s requires duration > 0", p.Kind)
+		}
+	case LoadProfileRamp:
+		if p.StartRPS < 0 || p.EndRPS < 0 || p.StartRPS+p.EndRPS <= 0 {
+			return fmt.Errorf("load profile ramp requires start_rps/end_rps >= 0 and at least one > 0")
+		}
+		if p.Duration <= 0 {
+			return fmt.Errorf("load profile ramp requires duration > 0")
+		}
+	case LoadProfileStep:
+		if len(p.Stages) == 0 {
+			return fmt.Errorf("load profile step requires at least one stage")
+		}
+		for i, stage := range p.Stages {
+			if stage.RPS < 0 || stage.Duration <= 0 {
+				return fmt.Errorf("load profile step stage %d requires rps >= 0 and duration > 0", i+1)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown load profile %q (expected constant, ramp, step, or poisson)", p.Kind)
+	}
+	if planned := p.expectedArrivals(); planned > maxPlannedArrivals {
+		return fmt.Errorf("load profile plans %.0f requests; limit is %d", planned, maxPlannedArrivals)
+	}
+	return nil
+}
+
+// TotalDuration returns the length of the arrival schedule.
+func (p LoadProfile) TotalDuration() time.Duration {
+	if p.Kind != LoadProfileStep {
+		return p.Duration
+	}
+	var total time.Duration
+	for _, stage := range p.Stages {
+		total += stage.Duration
+	}
+	return total
+}
+
+// MeanRPS returns the average offered rate across the schedule.
+func (p LoadProfile) MeanRPS() float64 {
+	total := p.TotalDuration()
+	if total <= 0 {
+		return 0
+	}
+	return p.expectedArrivals() / total.Seconds()
+}
+
+func (p LoadProfile) expectedArrivals() float64 {
+	switch p.Kind {
+	case LoadProfileRamp:
+		return (p.StartRPS + p.EndRPS) / 2 * p.Duration.Seconds()
+	case LoadProfileStep:
+		var total float64
+		for _, stage := range p.Stages {
+			total += stage.RPS * stage.Duration.Seconds()
+		}
+		return total
+	default:
+		return p.RPS * p.Duration.Seconds()
+	}
+}
+
+// rateAt returns the instantaneous offered rate and the offset at which the
+// rate next changes discontinuously.
+func (p LoadProfile) rateAt(offset time.Duration) (float64, time.Duration) {
+	switch p.Kind {
+	case LoadProfileRamp:
+		fraction := offset.Seconds() / p.Duration.Seconds()
+		return p.StartRPS + (p.EndRPS-p.StartRPS)*fraction, p.Duration
+	case LoadProfileStep:
+		var end time.Duration
+		for _, stage := range p.Stages {
+			end += stage.Duration
+			if offset < end {
+				return stage.RPS, end
+			}
+		}
+		return 0, end
+	default:
+		return p.RPS, p.Duration
+	}
+}
+
+// Arrivals returns the scheduled offsets of every request in the profile.
+// Constant, ramp, and step profiles are evenly paced; Poisson arrivals draw
+// exponential inter-arrival gaps from seed, so the plan is reproducible.
+func (p LoadProfile) Arrivals(seed int64) ([]time.Duration, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	rng := rand.New(rand.NewSource(seed))
+	total := p.TotalDuration()
+	arrivals := make([]time.Duration, 0, int(math.Min(p.expectedArrivals()+1, maxPlannedArrivals)))
+	// Ramps integrate the rate so the arrival count matches the area under the
+	// profile even when one endpoint is zero.
+	credit := 0.0
+	const rampStep = time.Millisecond
+	for offset := time.Duration(0); offset < total; {
+		rate, boundary := p.rateAt(offset)
+		if p.Kind == LoadProfileRamp {
+			credit += rate * rampStep.Seconds()
+			for ; credit >= 1; credit-- {
+				arrivals = append(arrivals, offset)
+			}
+			offset += rampStep
+			continue
+		}
+		if rate <= 0 {
+			offset = boundary
+			continue
+		}
+		gap := 1 / rate
+		if p.Kind == LoadProfilePoisson {
+			gap = rng.ExpFloat64() / rate
+		}
+		arrivals = append(arrivals, offset)
+		offset += time.Duration(gap * float64(time.Second))
+		if len(arrivals) > maxPlannedArrivals {
+			return nil, fmt.Errorf("load profile exceeds %d planned requests", maxPlannedArrivals)
+		}
+	}
+	return arrivals, nil
+}
+
+// ParseLoadStages parses "rps@duration" stages such as "10@30s,50@1m".
+func ParseLoadStages(value string) ([]LoadStage, error) {
+	var stages []LoadStage
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		rpsText, durationText, ok := strings.Cut(item, "@")
+		if !ok {
+			return nil, fmt.Errorf("invalid load stage %q (expected rps@duration)", item)
+		}
+		rps, err := strconv.ParseFloat(strings.TrimSpace(rpsText), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid load stage rps %q: %w", rpsText, err)
+		}
+		duration, err := time.ParseDuration(strings.TrimSpace(durationText))
+		if err != nil {
+			return nil, fmt.Errorf("invalid load stage duration %q: %w", durationText, err)
+		}
+		stages = append(stages, LoadStage{RPS: rps, Duration: duration})
+	}
+	return stages, nil
+}
+
+// OpenLoopConfig configures ReplayOpenLoop.
+type OpenLoopConfig struct {
+	Profile        LoadProfile
+	Seed           int64
+	MaxInFlight    int // requests beyond this limit are dropped, not delayed; 0 = 256
+	RequestTimeout time.Duration
+	SafeMode       bool
+	SafeModeAllow  []string
+	StateAdapters  []StateAdapter
+}
+
+// OpenLoopResult summarizes one open-loop load run.
+type OpenLoopResult struct {
+	Planned         int
+	Sent            int
+	Completed       int
+	Errors          int
+	Dropped         int
+	SafeModeSkipped int // captured writes excluded from the mix by safe mode
+	Duration        time.Duration
+	AchievedRPS     float64
+	Latency         *LatencyHistogram
+	Endpoints       *EndpointHistograms
+}
+
+// ReplayOpenLoop drives targetBase with the arrival schedule of cfg.Profile.
+// Each arrival samples a captured inbound request uniformly, which preserves
+// the incident's request mix, and the shared RequestRewriter keeps runtime
+// state substitution active across concurrent requests. Latency is measured
+// from the scheduled arrival time so queueing delay is not hidden.
+func ReplayOpenLoop(events []event.Event, targetBase string, cfg OpenLoopConfig) (OpenLoopResult, error) {
+	arrivals, err := cfg.Profile.Arrivals(cfg.Seed)
+	if err != nil {
+		return OpenLoopResult{}, err
+	}
+	result := OpenLoopResult{
+		Planned:   len(arrivals),
+		Latency:   NewLatencyHistogram(),
+		Endpoints: NewEndpointHistograms(),
+	}
+
+	mix := make([]event.Event, 0, len(events))
+	for i, e := range events {
+		if cfg.SafeMode && isSideEffect(e.Method) && !safeModeAllowed(capturedPath(e.URL), cfg.SafeModeAllow) {
+			result.SafeModeSkipped++
+			continue
+		}
+		if !cfg.SafeMode && e.BodyRedacted && e.BodySize > 0 && e.BodyB64 == "" {
+			return OpenLoopResult{}, fmt.Errorf(
+				"request %d body was omitted during secure capture; recapture with --capture-sensitive-data before replaying writes",
+				i+1,
+			)
+		}
+		mix = append(mix, e)
+	}
+	if len(mix) == 0 {
+		return OpenLoopResult{}, fmt.Errorf("no replayable inbound requests for load profile")
+	}
+
+	maxInFlight := cfg.MaxInFlight
+	if maxInFlight <= 0 {
+		maxInFlight = 256
+	}
+	timeout := cfg.RequestTimeout
+	if timeout <= 0 {
+		timeout = 15 * time.Second
+	}
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Timeout: timeout,
+		Jar:     jar,
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConnsPerHost: maxInFlight,
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	state := NewRuntimeState()
+	if len(cfg.StateAdapters) > 0 {
+		if err := ApplyAdapters(state, cfg.StateAdapters); err != nil {
+			log.Printf("state adapters warning: %v", err)
+		}
+	}
+	rewriter := NewRequestRewriterWithEvents(state, events)
+
+	// The request mix is drawn from a stream independent of Poisson arrivals
+	// so changing the profile shape does not reshuffle which requests are sent.
+	rng := rand.New(rand.NewSource(cfg.Seed ^ 0x5deece66d))
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		inFlight = make(chan struct{}, maxInFlight)
+	)
+	start := time.Now()
+	for i, offset := range arrivals {
+		scheduled := start.Add(offset)
+		if wait := time.Until(scheduled); wait > 0 {
+			time.Sleep(wait)
+		}
+		e := mix[rng.Intn(len(mix))]
+		select {
+		case inFlight <- struct{}{}:
+		default:
+			result.Dropped++
+			continue
+		}
+		prepared, err := prepareReplayRequest(e, targetBase, rewriter)
+		if err != nil {
+			<-inFlight
+			wg.Wait()
+			return OpenLoopResult{}, err
+		}
+		result.Sent++
+		if i == 0 || (i+1)%1000 == 0 {
+			log.Printf("Load %d/%d | profile=%s offset=%s", i+1, len(arrivals), cfg.Profile.Kind, offset)
+		}
+		wg.Add(1)
+		go func(e event.Event, prepared preparedRequest, scheduled time.Time) {
+			defer wg.Done()
+			defer func() { <-inFlight }()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			resp, err := client.Do(prepared.req.WithContext(ctx))
+			if err != nil {
+				mu.Lock()
+				result.Errors++
+				mu.Unlock()
+				return
+			}
+			respBody, readErr := io.ReadAll(io.LimitReader(resp.Body, 16*1024*1024))
+			resp.Body.Close()
+			latency := time.Since(scheduled)
+			result.Latency.Record(latency)
+			result.Endpoints.Record(e.Method, e.URL, latency)
+			if readErr == nil {
+				rewriter.UpdateState(e, resp, respBody)
+			}
+			mu.Lock()
+			if readErr != nil || resp.StatusCode >= 500 {
+				result.Errors++
+			}
+			result.Completed++
+			mu.Unlock()
+		}(e, prepared, scheduled)
+	}
+	wg.Wait()
+	result.Duration = time.Since(start)
+	if result.Duration > 0 {
+		result.AchievedRPS = float64(result.Completed) / result.Duration.Seconds()
+	}
+	return result, nil
+}
+
+func capturedPath(rawURL string) string {
+	parsed, err := urlpkg.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return parsed.Path
+}
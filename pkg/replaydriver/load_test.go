@@ -0,0 +1,149 @@
+package replaydriver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadProfileArrivals(t *testing.T) {
+	constant, err := LoadProfile{Kind: LoadProfileConstant, RPS: 50, Duration: 2 * time.Second}.Arrivals(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(constant) != 100 || constant[1] != 20*time.Millisecond {
+		t.Fatalf("constant arrivals = %d, second at %s", len(constant), constant[1])
+	}
+
+	step, err := LoadProfile{Kind: LoadProfileStep, Stages: []LoadStage{
+		{RPS: 10, Duration: time.Second},
+		{RPS: 0, Duration: time.Second},
+		{RPS: 20, Duration: time.Second},
+	}}.Arrivals(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(step) != 30 || step[10] != 2*time.Second {
+		t.Fatalf("step arrivals = %d, eleventh at %s", len(step), step[10])
+	}
+
+	ramp, err := LoadProfile{Kind: LoadProfileRamp, StartRPS: 0, EndRPS: 100, Duration: 2 * time.Second}.Arrivals(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ramp) < 98 || len(ramp) > 100 {
+		t.Fatalf("ramp arrivals = %d, want about 100", len(ramp))
+	}
+	if first, last := ramp[1]-ramp[0], ramp[len(ramp)-1]-ramp[len(ramp)-2]; last >= first {
+		t.Fatalf("ramp gaps did not shrink: first %s last %s", first, last)
+	}
+
+	poisson := LoadProfile{Kind: LoadProfilePoisson, RPS: 200, Duration: 5 * time.Second}
+	a, err := poisson.Arrivals(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := poisson.Arrivals(42)
+	if len(a) != len(b) || a[len(a)-1] != b[len(b)-1] {
+		t.Fatal("poisson arrivals are not reproducible for the same seed")
+	}
+	if len(a) < 850 || len(a) > 1150 {
+		t.Fatalf("poisson arrivals = %d, want about 1000", len(a))
+	}
+
+	if _, err := (LoadProfile{Kind: "burst", RPS: 1, Duration: time.Second}).Arrivals(1); err == nil {
+		t.Fatal("expected unknown profile to be rejected")
+	}
+	if _, err := (LoadProfile{Kind: LoadProfileConstant, RPS: 1e6, Duration: time.Hour}).Arrivals(1); err == nil {
+		t.Fatal("expected oversized profile to be rejected")
+	}
+}
+
+func TestReplayOpenLoopSamplesMixAndRewritesState(t *testing.T) {
+	var mu sync.Mutex
+	paths := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths[r.URL.Path]++
+		mu.Unlock()
+		if r.URL.Path == "/login" {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"token":"live-token"}`))
+			return
+		}
+		if r.URL.Path == "/slow" {
+			time.Sleep(20 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	events, err := LoadInboundEvents(writeInboundLog(t, []string{
+		`{"id":"1","type":"InboundRequest","timestamp":"2026-02-02T08:30:43.000Z","method":"GET","url":"http://svc/login","trace_id":"t1"}`,
+		`{"id":"2","type":"InboundResponse","timestamp":"2026-02-02T08:30:43.010Z","status":200,"trace_id":"t1","headers":{"Content-Type":["application/json"]},"body_b64":"eyJ0b2tlbiI6Im9sZC10b2tlbiJ9"}`,
+		`{"id":"3","type":"InboundRequest","timestamp":"2026-02-02T08:30:43.100Z","method":"GET","url":"http://svc/orders/123","trace_id":"t2"}`,
+		`{"id":"4","type":"InboundRequest","timestamp":"2026-02-02T08:30:43.200Z","method":"GET","url":"http://svc/slow","trace_id":"t3"}`,
+		`{"id":"5","type":"InboundRequest","timestamp":"2026-02-02T08:30:43.300Z","method":"DELETE","url":"http://svc/orders/123","trace_id":"t4"}`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ReplayOpenLoop(events, server.URL, OpenLoopConfig{
+		Profile:  LoadProfile{Kind: LoadProfileConstant, RPS: 200, Duration: 300 * time.Millisecond},
+		Seed:     7,
+		SafeMode: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Planned != 60 || result.Sent != 60 || result.Completed != 60 || result.Errors != 0 {
+		t.Fatalf("unexpected load result: %+v", result)
+	}
+	if result.SafeModeSkipped != 1 || paths["/orders/123"] == 0 || paths["/slow"] == 0 || paths["/login"] == 0 {
+		t.Fatalf("request mix was not sampled from safe captured requests: %v skipped=%d", paths, result.SafeModeSkipped)
+	}
+	if result.Latency.Count() != 60 {
+		t.Fatalf("latency samples = %d", result.Latency.Count())
+	}
+	endpoints := map[string]*LatencyHistogram{}
+	for _, endpoint := range result.Endpoints.Sorted() {
+		endpoints[endpoint.Endpoint] = endpoint.Histogram
+	}
+	if endpoints["GET /orders/{id}"] == nil || endpoints["GET /slow"] == nil {
+		t.Fatalf("missing endpoint histograms: %v", endpoints)
+	}
+	if endpoints["GET /slow"].Percentile(50) < 20*time.Millisecond {
+		t.Fatalf("slow endpoint p50 = %s", endpoints["GET /slow"].Percentile(50))
+	}
+}
+
+func TestLatencyHistogramPercentiles(t *testing.T) {
+	h := NewLatencyHistogram()
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+	for _, tc := range []struct {
+		q    float64
+		want time.Duration
+	}{{50, 500 * time.Millisecond}, {90, 900 * time.Millisecond}, {99, 990 * time.Millisecond}} {
+		got := h.Percentile(tc.q)
+		if got < tc.want || float64(got-tc.want) > float64(tc.want)*0.016 {
+			t.Fatalf("p%.0f = %s, want %s within 1.6%%", tc.q, got, tc.want)
+		}
+	}
+	if h.Max() != time.Second || h.Min() != time.Millisecond || h.Percentile(100) != time.Second {
+		t.Fatalf("min/max = %s/%s", h.Min(), h.Max())
+	}
+	merged := NewLatencyHistogram()
+	merged.Merge(h)
+	merged.Merge(h)
+	if merged.Count() != 2000 || merged.Percentile(50) != h.Percentile(50) {
+		t.Fatalf("merge changed distribution: count=%d p50=%s", merged.Count(), merged.Percentile(50))
+	}
+	if key := EndpointKey("get", "http://svc/orders/123/items/3fa85f64-5717-4562-b3fc-2c963f66afa6?x=1"); key != "GET /orders/{id}/items/{id}" {
+		t.Fatalf("endpoint key = %q", key)
+	}
+}
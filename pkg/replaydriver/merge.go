@@ -0,0 +1,203 @@
+package replaydriver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"infernosim/pkg/event"
+	"infernosim/pkg/message"
+)
+
+// MergeResult reports what MergeIncidents wrote.
+type MergeResult struct {
+	SubsetCounts
+	// ConfigFrom is the incident whose replay.yaml was carried over.
+	ConfigFrom string
+	// ConfigConflicts lists incidents whose different replay.yaml was
+	// dropped in favour of ConfigFrom's.
+	ConfigConflicts []string
+}
+
+// MergeIncidents writes an incident to dir holding the logs of every incident
+// in dirs, such as captures from two pods of one service. Each log is
+// interleaved by timestamp, ties keeping the order of dirs, and resequenced
+// from 1. incident.json counts the merged events, spans the earliest capture
+// time, and lists every distinct host. The first replay.yaml is carried over.
+func MergeIncidents(dir string, dirs []string) (MergeResult, error) {
+	if len(dirs) < 2 {
+		return MergeResult{}, fmt.Errorf("merge needs at least two incidents")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "inbound.log")); err == nil {
+		return MergeResult{}, fmt.Errorf("%s already holds an incident", dir)
+	}
+	var result MergeResult
+	var inbound, outbound []event.Event
+	var messages []message.Record
+	var hasOutbound, hasMessages bool
+	var metadata []IncidentMetadata
+	var config []byte
+	messageIDs := make(map[string]string)
+	for _, src := range dirs {
+		b, err := OpenBundle(src)
+		if err != nil {
+			return MergeResult{}, err
+		}
+		events, err := readEvents(b.InboundLog)
+		if err != nil {
+			return MergeResult{}, fmt.Errorf("load inbound log of %s: %w", src, err)
+		}
+		inbound = append(inbound, events...)
+		if b.HasOutbound() {
+			hasOutbound = true
+			events, err := readEvents(b.OutboundLog)
+			if err != nil {
+				return MergeResult{}, fmt.Errorf("load outbound log of %s: %w", src, err)
+			}
+			outbound = append(outbound, events...)
+		}
+		messagesPath := filepath.Join(src, "messages.log")
+		if _, err := os.Stat(messagesPath); err == nil {
+			hasMessages = true
+			records, err := message.Load(messagesPath)
+			if err != nil {
+				return MergeResult{}, fmt.Errorf("load message log of %s: %w", src, err)
+			}
+			for _, record := range records {
+				if other, ok := messageIDs[record.ID]; ok {
+					return MergeResult{}, fmt.Errorf("message %q appears in both %s and %s", record.ID, other, src)
+				}
+				messageIDs[record.ID] = src
+			}
+			messages = append(messages, records...)
+		}
+		if _, err := os.Stat(b.MetadataPath); err == nil {
+			m, err := b.ReadMetadata()
+			if err != nil {
+				return MergeResult{}, err
+			}
+			metadata = append(metadata, m)
+		}
+		if b.HasConfig() {
+			data, err := os.ReadFile(b.ConfigPath)
+			if err != nil {
+				return MergeResult{}, err
+			}
+			if config == nil {
+				config = data
+				result.ConfigFrom = src
+			} else if !bytes.Equal(config, data) {
+				result.ConfigConflicts = append(result.ConfigConflicts, src)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return MergeResult{}, err
+	}
+	if err := writeEvents(filepath.Join(dir, "inbound.log"), inbound); err != nil {
+		return MergeResult{}, err
+	}
+	for _, e := range inbound {
+		if e.Type == "InboundRequest" {
+			result.Inbound++
+		}
+	}
+	if hasOutbound {
+		if err := writeEvents(filepath.Join(dir, "outbound.log"), outbound); err != nil {
+			return MergeResult{}, err
+		}
+		result.Outbound = len(outbound)
+	}
+	if hasMessages {
+		sort.SliceStable(messages, func(i, j int) bool { return messages[i].Timestamp.Before(messages[j].Timestamp) })
+		logger, err := message.NewLogger(filepath.Join(dir, "messages.log"))
+		if err != nil {
+			return MergeResult{}, err
+		}
+		for _, record := range messages {
+			if err := logger.Write(record); err != nil {
+				_ = logger.Close()
+				return MergeResult{}, err
+			}
+		}
+		if err := logger.Close(); err != nil {
+			return MergeResult{}, err
+		}
+		result.Messages = len(messages)
+	}
+	if len(metadata) > 0 {
+		merged := mergeMetadata(metadata)
+		merged.InboundCount = result.Inbound
+		merged.OutboundCount = result.Outbound
+		if err := WriteMetadata(dir, merged); err != nil {
+			return MergeResult{}, err
+		}
+	}
+	if config != nil {
+		if err := os.WriteFile(filepath.Join(dir, "replay.yaml"), config, 0o600); err != nil {
+			return MergeResult{}, err
+		}
+	}
+	return result, nil
+}
+
+func readEvents(path string) ([]event.Event, error) {
+	var events []event.Event
+	err := readJSONLines(path, func(raw json.RawMessage) error {
+		var e event.Event
+		if err := json.Unmarshal(raw, &e); err != nil {
+			return err
+		}
+		events = append(events, e)
+		return nil
+	})
+	return events, err
+}
+
+// writeEvents writes events ordered by timestamp through an event logger,
+// which numbers them from 1.
+func writeEvents(path string, events []event.Event) error {
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp.Before(events[j].Timestamp) })
+	logger, err := event.NewLogger(path)
+	if err != nil {
+		return err
+	}
+	for i := range events {
+		if err := logger.Write(&events[i]); err != nil {
+			_ = logger.Close()
+			return err
+		}
+	}
+	return logger.Close()
+}
+
+// mergeMetadata keeps the earliest capture time and joins the distinct
+// values of the descriptive fields.
+func mergeMetadata(all []IncidentMetadata) IncidentMetadata {
+	merged := IncidentMetadata{CapturedAt: all[0].CapturedAt}
+	join := func(field func(IncidentMetadata) string) string {
+		var values []string
+		for _, m := range all {
+			if value := field(m); value != "" && !slices.Contains(values, value) {
+				values = append(values, value)
+			}
+		}
+		return strings.Join(values, ",")
+	}
+	for _, m := range all[1:] {
+		if !m.CapturedAt.IsZero() && (merged.CapturedAt.IsZero() || m.CapturedAt.Before(merged.CapturedAt)) {
+			merged.CapturedAt = m.CapturedAt
+		}
+	}
+	merged.Env = join(func(m IncidentMetadata) string { return m.Env })
+	merged.Host = join(func(m IncidentMetadata) string { return m.Host })
+	merged.Listen = join(func(m IncidentMetadata) string { return m.Listen })
+	merged.Forward = join(func(m IncidentMetadata) string { return m.Forward })
+	return merged
+}
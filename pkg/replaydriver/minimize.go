@@ -0,0 +1,164 @@
+package replaydriver
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"infernosim/pkg/event"
+)
+
+// DependencyRefs returns, for each event, the earlier events whose captured
+// responses produced a value the event consumes, such as a login response
+// issuing the bearer token later requests send.
+func DependencyRefs(events []event.Event) [][]DependencyRef {
+	refs := make([][]DependencyRef, len(events))
+	producers := make(map[string]int)
+	for i, e := range events {
+		for _, c := range IdentifyConsumers(e) {
+			if from, ok := producers[c.Value]; ok {
+				refs[i] = append(refs[i], DependencyRef{FromIndex: from, Target: c.Target, ValueName: c.Name})
+			}
+		}
+		body, _ := capturedResponseBody(e)
+		for _, p := range ExtractResponseValues(&http.Response{Header: capturedResponseHeaders(e)}, body) {
+			if _, seen := producers[p.Value]; !seen && p.Value != "" {
+				producers[p.Value] = i
+			}
+		}
+	}
+	return refs
+}
+
+// MinimizeResult is the outcome of Minimize.
+type MinimizeResult struct {
+	// Keep holds the indices of the kept events, in order.
+	Keep []int
+	// Replays counts the subsets tested.
+	Replays int
+	// Exhausted reports that the replay budget ran out before no single
+	// chunk could be removed, so Keep may not be minimal.
+	Exhausted bool
+}
+
+// Minimize shrinks events to a small subset for which reproduces still
+// returns true, using delta debugging: it tries ever smaller chunks of the
+// kept events, alone and removed, and keeps any candidate that reproduces.
+// Every candidate also keeps the events its members depend on through
+// DependencyRefs, so chained credentials are never cut. maxReplays bounds
+// the number of subsets tested; 0 means no bound.
+func Minimize(events []event.Event, maxReplays int, reproduces func(keep []int) (bool, error)) (MinimizeResult, error) {
+	refs := DependencyRefs(events)
+	closure := func(indices []int) []int {
+		kept := make(map[int]bool, len(indices))
+		stack := append([]int(nil), indices...)
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if kept[i] {
+				continue
+			}
+			kept[i] = true
+			for _, ref := range refs[i] {
+				stack = append(stack, ref.FromIndex)
+			}
+		}
+		out := make([]int, 0, len(kept))
+		for i := range kept {
+			out = append(out, i)
+		}
+		sort.Ints(out)
+		return out
+	}
+
+	result := MinimizeResult{}
+	tested := make(map[string]bool)
+	try := func(candidate []int) (bool, error) {
+		key := fmt.Sprint(candidate)
+		if tested[key] {
+			return false, nil
+		}
+		tested[key] = true
+		result.Replays++
+		return reproduces(candidate)
+	}
+
+	current := make([]int, len(events))
+	for i := range current {
+		current[i] = i
+	}
+	granularity := 2
+	for len(current) > 1 {
+		chunks := splitChunks(current, granularity)
+		reduced := false
+		var candidates [][]int
+		for _, chunk := range chunks {
+			candidates = append(candidates, closure(chunk))
+		}
+		if granularity > 2 {
+			for c := range chunks {
+				var complement []int
+				for other, chunk := range chunks {
+					if other != c {
+						complement = append(complement, chunk...)
+					}
+				}
+				candidates = append(candidates, closure(complement))
+			}
+		}
+		for _, candidate := range candidates {
+			if len(candidate) >= len(current) {
+				continue
+			}
+			if maxReplays > 0 && result.Replays >= maxReplays {
+				result.Keep = current
+				result.Exhausted = true
+				return result, nil
+			}
+			ok, err := try(candidate)
+			if err != nil {
+				return result, err
+			}
+			if ok {
+				current = candidate
+				granularity = max(granularity-1, 2)
+				reduced = true
+				break
+			}
+		}
+		if reduced {
+			continue
+		}
+		if granularity >= len(current) {
+			break
+		}
+		granularity = min(granularity*2, len(current))
+	}
+	result.Keep = current
+	return result, nil
+}
+
+// splitChunks splits indices into n chunks of nearly equal size.
+func splitChunks(indices []int, n int) [][]int {
+	chunks := make([][]int, 0, n)
+	start := 0
+	for i := 0; i < n; i++ {
+		end := start + (len(indices)-start)/(n-i)
+		if end > start {
+			chunks = append(chunks, indices[start:end])
+		}
+		start = end
+	}
+	return chunks
+}
+
+// FormatKept renders kept indices as 1-based request numbers for display.
+func FormatKept(keep []int) string {
+	parts := make([]string, len(keep))
+	for i, index := range keep {
+		parts[i] = strconv.Itoa(index + 1)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,130 @@
+package replaydriver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"infernosim/pkg/event"
+)
+
+func TestMinimizeKeepsDependencyChains(t *testing.T) {
+	events := make([]event.Event, 20)
+	for i := range events {
+		events[i] = event.Event{Type: "InboundRequest", Method: "GET", URL: fmt.Sprintf("/items/%d", i)}
+	}
+	events[3] = event.Event{
+		Type:             "InboundRequest",
+		Method:           "POST",
+		URL:              "/login",
+		ResponseCaptured: true,
+		ResponseHeaders:  map[string][]string{"Content-Type": {"application/json"}},
+		ResponseBodyB64:  base64.StdEncoding.EncodeToString([]byte(`{"token":"tok-1"}`)),
+	}
+	events[12].Headers = map[string][]string{"Authorization": {"Bearer tok-1"}}
+
+	refs := DependencyRefs(events)
+	if len(refs[12]) != 1 || refs[12][0].FromIndex != 3 || refs[12][0].ValueName != "Authorization" {
+		t.Fatalf("refs[12] = %+v", refs[12])
+	}
+
+	result, err := Minimize(events, 0, func(keep []int) (bool, error) {
+		if slices.Contains(keep, 12) && !slices.Contains(keep, 3) {
+			t.Fatalf("candidate %v cut the login request 12 depends on", keep)
+		}
+		return slices.Contains(keep, 12), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(result.Keep, []int{3, 12}) || result.Exhausted {
+		t.Fatalf("result = %+v", result)
+	}
+
+	bounded, err := Minimize(events, 2, func(keep []int) (bool, error) { return slices.Contains(keep, 12), nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bounded.Exhausted || bounded.Replays != 2 || !slices.Contains(bounded.Keep, 12) {
+		t.Fatalf("bounded result = %+v", bounded)
+	}
+}
+
+func TestIncidentLogsWriteSubsetKeepsNeededOutbound(t *testing.T) {
+	src := t.TempDir()
+	base := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	at := func(ms int) time.Time { return base.Add(time.Duration(ms) * time.Millisecond) }
+	write := func(name string, events []event.Event) {
+		var b strings.Builder
+		for _, e := range events {
+			line, _ := json.Marshal(e)
+			b.Write(line)
+			b.WriteByte('\n')
+		}
+		writeTestFile(t, filepath.Join(src, name), []byte(b.String()))
+	}
+	write("inbound.log", []event.Event{
+		{ID: "r1", Type: "InboundRequest", TraceID: "t1", Timestamp: at(0), Method: "GET", URL: "/a"},
+		{ID: "s1", Type: "InboundResponse", TraceID: "t1", Timestamp: at(50), Status: 200},
+		{ID: "r2", Type: "InboundRequest", TraceID: "t2", Timestamp: at(100), Method: "GET", URL: "/b"},
+		{ID: "s2", Type: "InboundResponse", TraceID: "t2", Timestamp: at(150), Status: 500},
+	})
+	write("outbound.log", []event.Event{
+		{ID: "o1", Type: "OutboundCall", Timestamp: at(10), URL: "http://dep/a"},
+		{ID: "o2", Type: "OutboundCall", Timestamp: at(110), URL: "http://dep/b"},
+		{ID: "o3", Type: "OutboundCall", Timestamp: at(300), URL: "http://dep/poll"},
+		{ID: "o4", Type: "OutboundCall", Timestamp: at(120), URL: "http://dep/traced", Headers: map[string][]string{"X-Inferno-Traceid": {"t1"}}},
+	})
+	writeTestFile(t, filepath.Join(src, "replay.yaml"), []byte("runs: 2\n"))
+
+	bundle, err := OpenBundle(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs, err := LoadIncidentLogs(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := t.TempDir()
+	counts, err := logs.WriteSubset(dst, []int{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts != (SubsetCounts{Inbound: 1, Outbound: 3}) {
+		t.Fatalf("counts = %+v", counts)
+	}
+	ids := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var e event.Event
+			if err := json.Unmarshal([]byte(line), &e); err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, e.ID)
+		}
+		return strings.Join(out, ",")
+	}
+	if got := ids("inbound.log"); got != "r1,s1" {
+		t.Fatalf("inbound = %s", got)
+	}
+	if got := ids("outbound.log"); got != "o1,o3,o4" {
+		t.Fatalf("outbound = %s", got)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "replay.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	subset, err := LoadInboundEvents(filepath.Join(dst, "inbound.log"))
+	if err != nil || len(subset) != 1 || subset[0].Status != 200 {
+		t.Fatalf("subset = %+v, %v", subset, err)
+	}
+}
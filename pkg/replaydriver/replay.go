@@ -52,35 +52,56 @@ type ReplayConfig struct {
 }
 
 func LoadInboundEvents(inboundLog string) ([]event.Event, error) {
+	evs, _, _, err := loadInboundLog(inboundLog)
+	return evs, err
+}
+
+// loadInboundLog loads the paired inbound requests of inboundLog along with
+// the raw log lines and, for each request, the indices of its request and
+// response lines.
+func loadInboundLog(inboundLog string) ([]event.Event, []json.RawMessage, [][]int, error) {
 	f, err := os.Open(inboundLog)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 	defer f.Close()
 
 	dec := json.NewDecoder(f)
 	var evs []event.Event
+	var lines []json.RawMessage
+	var requestLines []int
 	responses := make(map[string]event.Event)
+	responseLines := make(map[string]int)
 
 	for {
-		var e event.Event
-		if err := dec.Decode(&e); err != nil {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
 			if err == io.EOF {
 				break
 			}
-			return nil, err
+			return nil, nil, nil, err
 		}
+		var e event.Event
+		if err := json.Unmarshal(raw, &e); err != nil {
+			return nil, nil, nil, err
+		}
+		lines = append(lines, raw)
 		if e.Type == "InboundRequest" {
 			evs = append(evs, e)
+			requestLines = append(requestLines, len(lines)-1)
 		} else if e.Type == "InboundResponse" && e.TraceID != "" {
 			responses[e.TraceID] = e
+			responseLines[e.TraceID] = len(lines) - 1
 		}
 	}
+	owned := make([][]int, len(evs))
 	for i := range evs {
+		owned[i] = []int{requestLines[i]}
 		resp, ok := responses[evs[i].TraceID]
 		if !ok {
 			continue
 		}
+		owned[i] = append(owned[i], responseLines[evs[i].TraceID])
 		evs[i].Status = resp.Status
 		evs[i].Duration = resp.Timestamp.Sub(evs[i].Timestamp)
 		evs[i].ResponseCaptured = true
@@ -92,13 +113,24 @@ func LoadInboundEvents(inboundLog string) ([]event.Event, error) {
 		evs[i].ResponseBodyRedacted = resp.BodyRedacted
 	}
 	// Sort by (Timestamp, Sequence) for deterministic ordering under concurrent capture.
-	sort.SliceStable(evs, func(i, j int) bool {
-		if evs[i].Timestamp.Equal(evs[j].Timestamp) {
-			return evs[i].Sequence < evs[j].Sequence
+	order := make([]int, len(evs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := evs[order[i]], evs[order[j]]
+		if a.Timestamp.Equal(b.Timestamp) {
+			return a.Sequence < b.Sequence
 		}
-		return evs[i].Timestamp.Before(evs[j].Timestamp)
+		return a.Timestamp.Before(b.Timestamp)
 	})
-	return evs, nil
+	sorted := make([]event.Event, len(evs))
+	sortedOwned := make([][]int, len(evs))
+	for i, from := range order {
+		sorted[i] = evs[from]
+		sortedOwned[i] = owned[from]
+	}
+	return sorted, lines, sortedOwned, nil
 }
 
 // isSideEffect returns true for HTTP methods that modify server state.
@@ -201,7 +233,6 @@ func ReplayEvents(
 	safeModeSkipped := 0
 
 	for i, e := range events {
-		startTime := time.Now()
 		if cfg.MaxIdleTime > 0 && time.Since(lastProgress) > cfg.MaxIdleTime {
 			return ReplayResult{
 				CompletedEvents:    i,
@@ -260,50 +291,18 @@ func ReplayEvents(
 			)
 		}
 
-		parsed, err := urlpkg.Parse(e.URL)
+		prepared, err := prepareReplayRequest(e, targetBase, rewriter)
 		if err != nil {
 			return ReplayResult{}, err
 		}
-
-		var body io.Reader
-		bodyBytes, hasBody := rewriter.PrepareBody(e)
-		if hasBody {
-			body = bytes.NewReader(bodyBytes)
-		} else if e.BodyRedacted && e.BodySize > 0 && !cfg.SafeMode {
+		parsed, req, bodyBytes, hasBody := prepared.captured, prepared.req, prepared.body, prepared.hasBody
+		if !hasBody && e.BodyRedacted && e.BodySize > 0 && !cfg.SafeMode {
 			return ReplayResult{}, fmt.Errorf(
 				"request %d body was omitted during secure capture; recapture with --capture-sensitive-data before replaying writes",
 				i+1,
 			)
 		}
 
-		target, err := urlpkg.Parse(targetBase)
-		if err != nil || target.Scheme == "" || target.Host == "" {
-			return ReplayResult{}, fmt.Errorf("invalid target base %q", targetBase)
-		}
-		target.Path = parsed.Path
-		target.RawPath = parsed.RawPath
-		target.RawQuery = parsed.RawQuery
-		req, err := http.NewRequest(e.Method, target.String(), body)
-		if err != nil {
-			return ReplayResult{}, err
-		}
-
-		// Replay captured headers
-		for k, vals := range e.Headers {
-			if shouldSkipReplayHeader(k) {
-				continue
-			}
-			for _, v := range vals {
-				if v == "[REDACTED]" {
-					continue
-				}
-				req.Header.Add(k, v)
-			}
-		}
-
-		// Apply state-aware substitutions
-		rewriter.Rewrite(e, req)
-
 		// Safe mode: skip side-effect requests unless explicitly allowed
 		if cfg.SafeMode && isSideEffect(req.Method) && !safeModeAllowed(req.URL.Path, cfg.SafeModeAllow) {
 			log.Printf("[safe-mode] skipped %s %s", req.Method, req.URL.Path)
@@ -325,11 +324,12 @@ func ReplayEvents(
 			req = req.WithContext(ctx)
 		}
 
+		requestStart := time.Now()
 		resp, err := client.Do(req)
-		if cancel != nil {
-			cancel()
-		}
 		if err != nil {
+			if cancel != nil {
+				cancel()
+			}
 			errCount++
 			sig := fmt.Sprintf("%s %s ERR:%T", e.Method, parsed.RequestURI(), err)
 			signatures = append(signatures, sig)
@@ -351,8 +351,13 @@ func ReplayEvents(
 			}
 			continue
 		}
+		// The request context bounds the body read as well, so it is only
+		// cancelled once the body has been read.
 		respBody, readErr := io.ReadAll(io.LimitReader(resp.Body, 16*1024*1024+1))
 		resp.Body.Close()
+		if cancel != nil {
+			cancel()
+		}
 		if readErr != nil {
 			return ReplayResult{}, fmt.Errorf("read replay response: %w", readErr)
 		}
@@ -367,7 +372,7 @@ func ReplayEvents(
 			Status:             resp.StatusCode,
 			Headers:            req.Header.Clone(),
 			BodySize:           int64(len(bodyBytes)),
-			Duration:           time.Since(startTime),
+			Duration:           time.Since(requestStart),
 			Timestamp:          e.Timestamp, // preserve captured timestamp for apples-to-apples diff
 			ResponseCaptured:   true,
 			ResponseHeaders:    resp.Header.Clone(),
@@ -428,6 +433,58 @@ func ReplayEvents(
 	}, nil
 }
 
+type preparedRequest struct {
+	captured *urlpkg.URL
+	req      *http.Request
+	body     []byte
+	hasBody  bool
+}
+
+// prepareReplayRequest builds the live request for a captured inbound event:
+// the captured path and query are resolved against targetBase, replayable
+// headers are copied, and runtime state substitutions are applied.
+func prepareReplayRequest(e event.Event, targetBase string, rewriter *RequestRewriter) (preparedRequest, error) {
+	parsed, err := urlpkg.Parse(e.URL)
+	if err != nil {
+		return preparedRequest{}, err
+	}
+
+	var body io.Reader
+	bodyBytes, hasBody := rewriter.PrepareBody(e)
+	if hasBody {
+		body = bytes.NewReader(bodyBytes)
+	}
+
+	target, err := urlpkg.Parse(targetBase)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		return preparedRequest{}, fmt.Errorf("invalid target base %q", targetBase)
+	}
+	target.Path = parsed.Path
+	target.RawPath = parsed.RawPath
+	target.RawQuery = parsed.RawQuery
+	req, err := http.NewRequest(e.Method, target.String(), body)
+	if err != nil {
+		return preparedRequest{}, err
+	}
+
+	// Replay captured headers
+	for k, vals := range e.Headers {
+		if shouldSkipReplayHeader(k) {
+			continue
+		}
+		for _, v := range vals {
+			if v == "[REDACTED]" {
+				continue
+			}
+			req.Header.Add(k, v)
+		}
+	}
+
+	// Apply state-aware substitutions
+	rewriter.Rewrite(e, req)
+	return preparedRequest{captured: parsed, req: req, body: bodyBytes, hasBody: hasBody}, nil
+}
+
 func shouldSkipReplayHeader(name string) bool {
 	switch http.CanonicalHeaderKey(name) {
 	case "Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
@@ -0,0 +1,364 @@
+package replaydriver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"infernosim/pkg/event"
+	"infernosim/pkg/message"
+)
+
+// IncidentLogs holds an incident's logs so that subsets of its inbound
+// requests can be written out as incidents of their own.
+type IncidentLogs struct {
+	Bundle IncidentBundle
+	// Inbound holds the paired inbound requests in replay order.
+	Inbound []event.Event
+
+	inboundLines []json.RawMessage
+	requestLines [][]int
+	outbound     []logLine
+	messages     []logLine
+	hasMessages  bool
+	metadata     *IncidentMetadata
+}
+
+// logLine is an outbound event or message record with the inbound requests
+// it is attributed to. A nil owners means it happened outside every request.
+type logLine struct {
+	raw    json.RawMessage
+	at     time.Time
+	owners []int
+}
+
+// SubsetCounts reports what a written incident holds.
+type SubsetCounts struct {
+	Inbound  int
+	Outbound int
+	Messages int
+}
+
+// LoadIncidentLogs reads the inbound and, when present, outbound and message
+// logs and metadata of b.
+func LoadIncidentLogs(b IncidentBundle) (*IncidentLogs, error) {
+	inbound, lines, owned, err := loadInboundLog(b.InboundLog)
+	if err != nil {
+		return nil, fmt.Errorf("load inbound log: %w", err)
+	}
+	logs := &IncidentLogs{Bundle: b, Inbound: inbound, inboundLines: lines, requestLines: owned}
+	owners := newOwnership(inbound)
+
+	if b.HasOutbound() {
+		err := readJSONLines(b.OutboundLog, func(raw json.RawMessage) error {
+			var e event.Event
+			if err := json.Unmarshal(raw, &e); err != nil {
+				return err
+			}
+			correlation := http.Header(e.Headers).Get("X-Inferno-TraceID")
+			logs.outbound = append(logs.outbound, logLine{raw: raw, at: e.Timestamp, owners: owners.of(e.Timestamp, correlation)})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("outbound log parse error: %w", err)
+		}
+	}
+	messagesPath := filepath.Join(b.Dir, "messages.log")
+	if _, err := os.Stat(messagesPath); err == nil {
+		logs.hasMessages = true
+		err := readJSONLines(messagesPath, func(raw json.RawMessage) error {
+			var record message.Record
+			if err := json.Unmarshal(raw, &record); err != nil {
+				return err
+			}
+			logs.messages = append(logs.messages, logLine{raw: raw, at: record.Timestamp, owners: owners.of(record.Timestamp, record.CorrelationID)})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("message log parse error: %w", err)
+		}
+	}
+	if _, err := os.Stat(b.MetadataPath); err == nil {
+		metadata, err := b.ReadMetadata()
+		if err != nil {
+			return nil, err
+		}
+		logs.metadata = &metadata
+	}
+	return logs, nil
+}
+
+// ownership attributes outbound events and messages to the inbound requests
+// that may have caused them. One carrying a request's trace ID as its
+// correlation belongs to that request; otherwise it belongs to every request
+// in flight when it happened, ending at the next request when the response
+// was not captured.
+type ownership struct {
+	inbound []event.Event
+	ends    []time.Time
+	byTrace map[string]int
+}
+
+func newOwnership(inbound []event.Event) ownership {
+	o := ownership{inbound: inbound, ends: make([]time.Time, len(inbound)), byTrace: make(map[string]int)}
+	for i, e := range inbound {
+		if e.TraceID != "" {
+			o.byTrace[e.TraceID] = i
+		}
+		switch {
+		case e.ResponseCaptured && e.Duration > 0:
+			o.ends[i] = e.Timestamp.Add(e.Duration)
+		case i+1 < len(inbound):
+			o.ends[i] = inbound[i+1].Timestamp
+		}
+	}
+	return o
+}
+
+func (o ownership) of(at time.Time, correlation string) []int {
+	if owner, ok := o.byTrace[correlation]; ok && correlation != "" {
+		return []int{owner}
+	}
+	var owners []int
+	for i, in := range o.inbound {
+		if at.Before(in.Timestamp) || (!o.ends[i].IsZero() && at.After(o.ends[i])) {
+			continue
+		}
+		owners = append(owners, i)
+	}
+	return owners
+}
+
+// NeededOutbound reports which outbound events a replay of the kept inbound
+// requests can still reach: those attributed to a kept request, and those
+// outside every request, such as background polling.
+func (l *IncidentLogs) NeededOutbound(keep []int) []bool {
+	kept := keptSet(keep)
+	needed := make([]bool, len(l.outbound))
+	for o, line := range l.outbound {
+		needed[o] = line.needed(kept, func(time.Time) bool { return true })
+	}
+	return needed
+}
+
+func keptSet(keep []int) map[int]bool {
+	kept := make(map[int]bool, len(keep))
+	for _, i := range keep {
+		kept[i] = true
+	}
+	return kept
+}
+
+func (line logLine) needed(kept map[int]bool, unowned func(time.Time) bool) bool {
+	if line.owners == nil {
+		return unowned(line.at)
+	}
+	for _, i := range line.owners {
+		if kept[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteSubset writes an incident to dir holding the kept inbound requests,
+// their responses, and the outbound events and messages they need. Log
+// lines are copied unchanged, incident.json is rewritten with the new
+// counts, and replay.yaml is copied when present.
+func (l *IncidentLogs) WriteSubset(dir string, keep []int) (SubsetCounts, error) {
+	return l.write(dir, keep, func(time.Time) bool { return true })
+}
+
+// SliceFilter selects inbound requests. Every set criterion must match.
+type SliceFilter struct {
+	// From and To bound request timestamps, inclusive; zero is unbounded.
+	From, To time.Time
+	// Path matches the request path.
+	Path *regexp.Regexp
+	// TraceIDs lists the trace IDs to keep.
+	TraceIDs []string
+	// Statuses lists status codes such as 503 or classes such as 5xx.
+	Statuses []string
+}
+
+var statusPattern = regexp.MustCompile(`^[1-5]([0-9]{2}|xx)$`)
+
+// Validate reports malformed status patterns and inverted time ranges.
+func (f SliceFilter) Validate() error {
+	for _, status := range f.Statuses {
+		if !statusPattern.MatchString(strings.ToLower(status)) {
+			return fmt.Errorf("status %q must be a code such as 503 or a class such as 5xx", status)
+		}
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && f.To.Before(f.From) {
+		return fmt.Errorf("slice ends at %s before it starts at %s", f.To.Format(time.RFC3339), f.From.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (f SliceFilter) inRange(at time.Time) bool {
+	return (f.From.IsZero() || !at.Before(f.From)) && (f.To.IsZero() || !at.After(f.To))
+}
+
+// Match reports whether f selects the inbound request e.
+func (f SliceFilter) Match(e event.Event) bool {
+	if !f.inRange(e.Timestamp) {
+		return false
+	}
+	if f.Path != nil {
+		path := e.URL
+		if u, err := url.Parse(e.URL); err == nil {
+			path = u.Path
+		}
+		if !f.Path.MatchString(path) {
+			return false
+		}
+	}
+	if len(f.TraceIDs) > 0 && !slices.Contains(f.TraceIDs, e.TraceID) {
+		return false
+	}
+	if len(f.Statuses) > 0 {
+		code := strconv.Itoa(e.Status)
+		matched := false
+		for _, status := range f.Statuses {
+			status = strings.ToLower(status)
+			if status == code || (strings.HasSuffix(status, "xx") && len(code) == 3 && code[0] == status[0]) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// Slice writes an incident to dir holding the inbound requests f selects,
+// with the outbound events and messages attributed to them. Events outside
+// every request are kept when they fall within the slice's time range.
+func (l *IncidentLogs) Slice(dir string, f SliceFilter) (SubsetCounts, error) {
+	if err := f.Validate(); err != nil {
+		return SubsetCounts{}, err
+	}
+	var keep []int
+	for i, e := range l.Inbound {
+		if f.Match(e) {
+			keep = append(keep, i)
+		}
+	}
+	return l.write(dir, keep, f.inRange)
+}
+
+func (l *IncidentLogs) write(dir string, keep []int, unowned func(time.Time) bool) (SubsetCounts, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return SubsetCounts{}, err
+	}
+	skip := make([]bool, len(l.inboundLines))
+	for _, lines := range l.requestLines {
+		for _, line := range lines {
+			skip[line] = true
+		}
+	}
+	for _, i := range keep {
+		for _, line := range l.requestLines[i] {
+			skip[line] = false
+		}
+	}
+	var inbound []json.RawMessage
+	for line, raw := range l.inboundLines {
+		if !skip[line] {
+			inbound = append(inbound, raw)
+		}
+	}
+	if err := writeJSONLines(filepath.Join(dir, "inbound.log"), inbound); err != nil {
+		return SubsetCounts{}, err
+	}
+	counts := SubsetCounts{Inbound: len(keep)}
+
+	kept := keptSet(keep)
+	selected := func(lines []logLine) []json.RawMessage {
+		var out []json.RawMessage
+		for _, line := range lines {
+			if line.needed(kept, unowned) {
+				out = append(out, line.raw)
+			}
+		}
+		return out
+	}
+	if l.Bundle.HasOutbound() {
+		outbound := selected(l.outbound)
+		if err := writeJSONLines(filepath.Join(dir, "outbound.log"), outbound); err != nil {
+			return SubsetCounts{}, err
+		}
+		counts.Outbound = len(outbound)
+	}
+	if l.hasMessages {
+		messages := selected(l.messages)
+		if err := writeJSONLines(filepath.Join(dir, "messages.log"), messages); err != nil {
+			return SubsetCounts{}, err
+		}
+		counts.Messages = len(messages)
+	}
+	if l.metadata != nil {
+		metadata := *l.metadata
+		metadata.InboundCount = counts.Inbound
+		metadata.OutboundCount = counts.Outbound
+		if err := WriteMetadata(dir, metadata); err != nil {
+			return SubsetCounts{}, err
+		}
+	}
+	if l.Bundle.HasConfig() {
+		if err := copyFile(l.Bundle.ConfigPath, filepath.Join(dir, "replay.yaml")); err != nil {
+			return SubsetCounts{}, err
+		}
+	}
+	return counts, nil
+}
+
+func readJSONLines(path string, each func(json.RawMessage) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := each(raw); err != nil {
+			return err
+		}
+	}
+}
+
+func writeJSONLines(path string, lines []json.RawMessage) error {
+	var b strings.Builder
+	for _, line := range lines {
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o600)
+}
+
+func copyFile(from, to string) error {
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(to, data, 0o600)
+}
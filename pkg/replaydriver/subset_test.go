@@ -0,0 +1,220 @@
+package replaydriver
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"infernosim/pkg/event"
+	"infernosim/pkg/message"
+)
+
+func writeTestIncident(t *testing.T, dir string, inbound, outbound []event.Event, messages []message.Record, metadata *IncidentMetadata) {
+	t.Helper()
+	write := func(name string, events []event.Event) {
+		var b strings.Builder
+		for i, e := range events {
+			e.Sequence = int64(i + 1)
+			line, _ := json.Marshal(e)
+			b.Write(line)
+			b.WriteByte('\n')
+		}
+		writeTestFile(t, filepath.Join(dir, name), []byte(b.String()))
+	}
+	write("inbound.log", inbound)
+	if outbound != nil {
+		write("outbound.log", outbound)
+	}
+	if messages != nil {
+		logger, err := message.NewLogger(filepath.Join(dir, "messages.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, record := range messages {
+			if err := logger.Write(record); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := logger.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if metadata != nil {
+		if err := WriteMetadata(dir, *metadata); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testMessage(id, correlation string, at time.Time) message.Record {
+	record := message.New("orders", nil, []byte(`{}`), nil)
+	record.ID = id
+	record.Timestamp = at
+	record.CorrelationID = correlation
+	return record
+}
+
+func readTestEvents(t *testing.T, path string) []event.Event {
+	t.Helper()
+	events, err := readEvents(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestIncidentLogsSliceFiltersByPathStatusAndTime(t *testing.T) {
+	src := t.TempDir()
+	base := time.Date(2026, 3, 4, 14, 0, 0, 0, time.UTC)
+	at := func(minute int) time.Time { return base.Add(time.Duration(minute) * time.Minute) }
+	writeTestIncident(t, src,
+		[]event.Event{
+			{ID: "r1", Type: "InboundRequest", TraceID: "t1", Timestamp: at(1), Method: "POST", URL: "/checkout"},
+			{ID: "s1", Type: "InboundResponse", TraceID: "t1", Timestamp: at(1).Add(time.Second), Status: 200},
+			{ID: "r2", Type: "InboundRequest", TraceID: "t2", Timestamp: at(3), Method: "POST", URL: "/checkout?retry=1"},
+			{ID: "s2", Type: "InboundResponse", TraceID: "t2", Timestamp: at(3).Add(time.Second), Status: 503},
+			{ID: "r3", Type: "InboundRequest", TraceID: "t3", Timestamp: at(4), Method: "GET", URL: "/health"},
+			{ID: "s3", Type: "InboundResponse", TraceID: "t3", Timestamp: at(4).Add(time.Second), Status: 503},
+		},
+		[]event.Event{
+			{ID: "o1", Type: "OutboundCall", Timestamp: at(1).Add(500 * time.Millisecond), URL: "http://payments/charge"},
+			{ID: "o2", Type: "OutboundCall", Timestamp: at(3).Add(500 * time.Millisecond), URL: "http://payments/charge"},
+			{ID: "o3", Type: "OutboundCall", Timestamp: at(2), URL: "http://config/poll"},
+			{ID: "o4", Type: "OutboundCall", Timestamp: at(9), URL: "http://config/poll"},
+		},
+		[]message.Record{
+			testMessage("m1", "t1", at(1).Add(time.Millisecond)),
+			testMessage("m2", "t2", at(3).Add(time.Millisecond)),
+		},
+		&IncidentMetadata{CapturedAt: base, Host: "pod-a", InboundCount: 3, OutboundCount: 4},
+	)
+	writeTestFile(t, filepath.Join(src, "replay.yaml"), []byte("runs: 2\n"))
+
+	bundle, err := OpenBundle(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs, err := LoadIncidentLogs(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := t.TempDir()
+	counts, err := logs.Slice(dst, SliceFilter{
+		From:     at(2),
+		To:       at(5),
+		Path:     regexp.MustCompile(`^/checkout$`),
+		Statuses: []string{"5xx"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts != (SubsetCounts{Inbound: 1, Outbound: 2, Messages: 1}) {
+		t.Fatalf("counts = %+v", counts)
+	}
+	ids := func(events []event.Event) string {
+		var out []string
+		for _, e := range events {
+			out = append(out, e.ID)
+		}
+		return strings.Join(out, ",")
+	}
+	if got := ids(readTestEvents(t, filepath.Join(dst, "inbound.log"))); got != "r2,s2" {
+		t.Fatalf("inbound = %s", got)
+	}
+	if got := ids(readTestEvents(t, filepath.Join(dst, "outbound.log"))); got != "o2,o3" {
+		t.Fatalf("outbound = %s", got)
+	}
+	records, err := message.Load(filepath.Join(dst, "messages.log"))
+	if err != nil || len(records) != 1 || records[0].ID != "m2" {
+		t.Fatalf("messages = %+v, %v", records, err)
+	}
+	sliced, err := OpenBundle(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := sliced.ReadMetadata()
+	if err != nil || metadata.Host != "pod-a" || metadata.InboundCount != 1 || metadata.OutboundCount != 2 {
+		t.Fatalf("metadata = %+v, %v", metadata, err)
+	}
+	if !sliced.HasConfig() {
+		t.Fatal("replay.yaml was not carried over")
+	}
+
+	if err := (SliceFilter{Statuses: []string{"50x"}}).Validate(); err == nil {
+		t.Fatal("malformed status pattern was accepted")
+	}
+}
+
+func TestMergeIncidentsInterleavesAndResequences(t *testing.T) {
+	base := time.Date(2026, 3, 4, 14, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time { return base.Add(time.Duration(ms) * time.Millisecond) }
+	podA, podB := t.TempDir(), t.TempDir()
+	writeTestIncident(t, podA,
+		[]event.Event{
+			{ID: "a1", Type: "InboundRequest", Timestamp: at(0), Method: "GET", URL: "/a"},
+			{ID: "a1r", Type: "InboundResponse", Timestamp: at(20), Status: 200},
+		},
+		[]event.Event{{ID: "ao", Type: "OutboundCall", Timestamp: at(10), URL: "http://dep/a"}},
+		[]message.Record{testMessage("am", "", at(15))},
+		&IncidentMetadata{CapturedAt: at(5), Env: "prod", Host: "pod-a", InboundCount: 1, OutboundCount: 1},
+	)
+	writeTestFile(t, filepath.Join(podA, "replay.yaml"), []byte("runs: 2\n"))
+	writeTestIncident(t, podB,
+		[]event.Event{
+			{ID: "b1", Type: "InboundRequest", Timestamp: at(5), Method: "GET", URL: "/b"},
+			{ID: "b1r", Type: "InboundResponse", Timestamp: at(30), Status: 200},
+		},
+		nil,
+		[]message.Record{testMessage("bm", "", at(8))},
+		&IncidentMetadata{CapturedAt: at(0), Env: "prod", Host: "pod-b", InboundCount: 1},
+	)
+	writeTestFile(t, filepath.Join(podB, "replay.yaml"), []byte("runs: 3\n"))
+
+	dst := filepath.Join(t.TempDir(), "merged")
+	result, err := MergeIncidents(dst, []string{podA, podB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.SubsetCounts != (SubsetCounts{Inbound: 2, Outbound: 1, Messages: 2}) {
+		t.Fatalf("counts = %+v", result.SubsetCounts)
+	}
+	if result.ConfigFrom != podA || len(result.ConfigConflicts) != 1 || result.ConfigConflicts[0] != podB {
+		t.Fatalf("config = %q, conflicts = %v", result.ConfigFrom, result.ConfigConflicts)
+	}
+	var order []string
+	for i, e := range readTestEvents(t, filepath.Join(dst, "inbound.log")) {
+		if e.Sequence != int64(i+1) {
+			t.Fatalf("event %s has sequence %d, want %d", e.ID, e.Sequence, i+1)
+		}
+		order = append(order, e.ID)
+	}
+	if got := strings.Join(order, ","); got != "a1,b1,a1r,b1r" {
+		t.Fatalf("inbound = %s", got)
+	}
+	records, err := message.Load(filepath.Join(dst, "messages.log"))
+	if err != nil || len(records) != 2 || records[0].ID != "bm" || records[0].Sequence != 1 {
+		t.Fatalf("messages = %+v, %v", records, err)
+	}
+	merged, err := OpenBundle(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := merged.ReadMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !metadata.CapturedAt.Equal(at(0)) || metadata.Env != "prod" || metadata.Host != "pod-a,pod-b" || metadata.InboundCount != 2 || metadata.OutboundCount != 1 {
+		t.Fatalf("metadata = %+v", metadata)
+	}
+	if data, err := os.ReadFile(merged.ConfigPath); err != nil || string(data) != "runs: 2\n" {
+		t.Fatalf("replay.yaml = %q, %v", data, err)
+	}
+
+	if _, err := MergeIncidents(dst, []string{podA, podB}); err == nil {
+		t.Fatal("merge into an existing incident was accepted")
+	}
+}
@@ -28,12 +28,42 @@ type Finding struct {
 	Location string `json:"location,omitempty"`
 }
 
+// LatencyRow is one endpoint's latency percentiles for a replay scope such as
+// "baseline" or "run 2". Values are milliseconds.
+type LatencyRow struct {
+	Scope    string  `json:"scope"`
+	Endpoint string  `json:"endpoint"`
+	Count    int     `json:"count"`
+	P50MS    float64 `json:"p50_ms"`
+	P90MS    float64 `json:"p90_ms"`
+	P99MS    float64 `json:"p99_ms"`
+	MaxMS    float64 `json:"max_ms"`
+}
+
+// BodyDiff is the field-level JSON difference of one replayed response, such
+// as "#3 GET /orders/42".
+type BodyDiff struct {
+	Request string        `json:"request"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// FieldChange is one added, removed, changed, or reordered JSONPath with its
+// JSON-encoded captured and replayed values.
+type FieldChange struct {
+	Kind     string `json:"kind"`
+	Path     string `json:"path"`
+	Captured string `json:"captured,omitempty"`
+	Replayed string `json:"replayed,omitempty"`
+}
+
 type Result struct {
-	Tool      string    `json:"tool"`
-	Outcome   string    `json:"outcome"`
-	Summary   string    `json:"summary"`
-	Generated time.Time `json:"generated"`
-	Findings  []Finding `json:"findings"`
+	Tool      string       `json:"tool"`
+	Outcome   string       `json:"outcome"`
+	Summary   string       `json:"summary"`
+	Generated time.Time    `json:"generated"`
+	Findings  []Finding    `json:"findings"`
+	Latency   []LatencyRow `json:"latency,omitempty"`
+	BodyDiffs []BodyDiff   `json:"body_diffs,omitempty"`
 }
 
 func WriteFormats(directory string, formats []string, result Result) ([]string, error) {
@@ -129,12 +159,22 @@ func WritePrivateFile(path string, data []byte) error {
 }
 
 type junitSuite struct {
-	XMLName  xml.Name    `xml:"testsuite"`
-	Name     string      `xml:"name,attr"`
-	Tests    int         `xml:"tests,attr"`
-	Failures int         `xml:"failures,attr"`
-	Time     string      `xml:"time,attr"`
-	Cases    []junitCase `xml:"testcase"`
+	XMLName    xml.Name         `xml:"testsuite"`
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Time       string           `xml:"time,attr"`
+	Properties *junitProperties `xml:"properties,omitempty"`
+	Cases      []junitCase      `xml:"testcase"`
+}
+
+type junitProperties struct {
+	Property []junitProperty `xml:"property"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
 }
 
 type junitCase struct {
@@ -151,6 +191,19 @@ type junitFailure struct {
 
 func marshalJUnit(result Result) ([]byte, error) {
 	suite := junitSuite{Name: result.Tool, Tests: len(result.Findings) + 1}
+	if len(result.Latency) > 0 {
+		suite.Properties = &junitProperties{}
+		for _, row := range result.Latency {
+			prefix := "latency." + row.Scope + "." + row.Endpoint + "."
+			suite.Properties.Property = append(suite.Properties.Property,
+				junitProperty{Name: prefix + "count", Value: fmt.Sprintf("%d", row.Count)},
+				junitProperty{Name: prefix + "p50_ms", Value: formatMS(row.P50MS)},
+				junitProperty{Name: prefix + "p90_ms", Value: formatMS(row.P90MS)},
+				junitProperty{Name: prefix + "p99_ms", Value: formatMS(row.P99MS)},
+				junitProperty{Name: prefix + "max_ms", Value: formatMS(row.MaxMS)},
+			)
+		}
+	}
 	suite.Cases = append(suite.Cases, junitCase{
 		Name:      "replay-outcome",
 		Classname: "infernosim.replay",
@@ -190,8 +243,9 @@ type sarifDocument struct {
 }
 
 type sarifRun struct {
-	Tool    sarifTool     `json:"tool"`
-	Results []sarifResult `json:"results"`
+	Tool       sarifTool      `json:"tool"`
+	Results    []sarifResult  `json:"results"`
+	Properties map[string]any `json:"properties,omitempty"`
 }
 
 type sarifTool struct {
@@ -295,6 +349,9 @@ func marshalSARIF(result Result) ([]byte, error) {
 			Results: results,
 		}},
 	}
+	if len(result.Latency) > 0 {
+		document.Runs[0].Properties = map[string]any{"latency": result.Latency}
+	}
 	return json.MarshalIndent(document, "", "  ")
 }
 
@@ -329,8 +386,21 @@ var htmlReportTemplate = template.Must(template.New("report").Parse(`<!doctype h
 {{if .Findings}}<table><thead><tr><th>Rule</th><th>Level</th><th>Finding</th><th>Location</th></tr></thead>
 <tbody>{{range .Findings}}<tr><td><code>{{.RuleID}}</code></td><td>{{.Level}}</td><td><strong>{{.Title}}</strong><br>{{.Message}}</td><td>{{.Location}}</td></tr>{{end}}</tbody></table>
 {{else}}<p>No findings.</p>{{end}}
+{{if .Latency}}<h2>Latency percentiles</h2>
+<table><thead><tr><th>Scope</th><th>Endpoint</th><th>Count</th><th>p50 (ms)</th><th>p90 (ms)</th><th>p99 (ms)</th><th>max (ms)</th></tr></thead>
+<tbody>{{range .Latency}}<tr><td>{{.Scope}}</td><td><code>{{.Endpoint}}</code></td><td>{{.Count}}</td><td>{{printf "%.3f" .P50MS}}</td><td>{{printf "%.3f" .P90MS}}</td><td>{{printf "%.3f" .P99MS}}</td><td>{{printf "%.3f" .MaxMS}}</td></tr>{{end}}</tbody></table>
+{{end}}
+{{if .BodyDiffs}}<h2>Response body differences</h2>
+{{range .BodyDiffs}}<h3><code>{{.Request}}</code></h3>
+<table><thead><tr><th>Change</th><th>Path</th><th>Captured</th><th>Replayed</th></tr></thead>
+<tbody>{{range .Changes}}<tr><td>{{.Kind}}</td><td><code>{{.Path}}</code></td><td><code>{{.Captured}}</code></td><td><code>{{.Replayed}}</code></td></tr>{{end}}</tbody></table>
+{{end}}{{end}}
 </body></html>`))
 
+func formatMS(value float64) string {
+	return fmt.Sprintf("%.3f", value)
+}
+
 func marshalHTML(result Result) ([]byte, error) {
 	var output strings.Builder
 	if err := htmlReportTemplate.Execute(&output, result); err != nil {
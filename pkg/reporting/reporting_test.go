@@ -61,6 +61,43 @@ func TestWriteJUnitSARIFAndHTML(t *testing.T) {
 	}
 }
 
+func TestReportsIncludeLatencyPercentiles(t *testing.T) {
+	dir := t.TempDir()
+	_, err := WriteFormats(dir, []string{"junit", "sarif", "html"}, Result{
+		Outcome: "FAIL_LATENCY_REGRESSION",
+		Summary: "p99 regressed",
+		Latency: []LatencyRow{
+			{Scope: "baseline", Endpoint: "GET /orders/{id}", Count: 4, P50MS: 10, P90MS: 12, P99MS: 13, MaxMS: 13},
+			{Scope: "run 1", Endpoint: "GET /orders/{id}", Count: 4, P50MS: 30, P90MS: 45, P99MS: 48.5, MaxMS: 48.5},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	junitData, _ := os.ReadFile(filepath.Join(dir, "infernosim-report.junit.xml"))
+	if !strings.Contains(string(junitData), `name="latency.run 1.GET /orders/{id}.p99_ms" value="48.500"`) {
+		t.Fatalf("JUnit properties missing latency:\n%s", junitData)
+	}
+	sarifData, _ := os.ReadFile(filepath.Join(dir, "infernosim-report.sarif"))
+	var sarif struct {
+		Runs []struct {
+			Properties struct {
+				Latency []LatencyRow `json:"latency"`
+			} `json:"properties"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(sarifData, &sarif); err != nil {
+		t.Fatal(err)
+	}
+	if len(sarif.Runs[0].Properties.Latency) != 2 || sarif.Runs[0].Properties.Latency[1].P99MS != 48.5 {
+		t.Fatalf("SARIF latency properties = %+v", sarif.Runs[0].Properties.Latency)
+	}
+	htmlData, _ := os.ReadFile(filepath.Join(dir, "infernosim-report.html"))
+	if !strings.Contains(string(htmlData), "Latency percentiles") || !strings.Contains(string(htmlData), "48.500") {
+		t.Fatal("HTML report missing latency table")
+	}
+}
+
 func TestSARIFSemanticVersionNormalization(t *testing.T) {
 	if got := sarifSemanticVersion("v3.4.0"); got != "3.4.0" {
 		t.Fatalf("version=%q", got)
@@ -69,3 +106,25 @@ func TestSARIFSemanticVersionNormalization(t *testing.T) {
 		t.Fatalf("invalid semantic version was emitted: %q", got)
 	}
 }
+
+func TestHTMLReportIncludesBodyDiffs(t *testing.T) {
+	data, err := marshalHTML(Result{
+		Outcome: "FAIL_DIVERGENCE",
+		BodyDiffs: []BodyDiff{{
+			Request: "#2 GET /orders/42",
+			Changes: []FieldChange{
+				{Kind: "changed", Path: "$.total", Captured: "12.5", Replayed: "13"},
+				{Kind: "added", Path: "$.note", Replayed: `"<late>"`},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(data)
+	for _, want := range []string{"Response body differences", "#2 GET /orders/42", "$.total", "&#34;&lt;late&gt;&#34;"} {
+		if !strings.Contains(html, want) {
+			t.Fatalf("HTML report missing %q:\n%s", want, html)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package scenario
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
@@ -16,15 +17,78 @@ import (
 type Config struct {
 	Name         string `yaml:"name" json:"name"`
 	InitialState string `yaml:"initial_state" json:"initial_state"`
-	Steps        []Step `yaml:"steps" json:"steps"`
+	// PartitionBy keeps a separate state and counters for every distinct
+	// key extracted from the request. Requests without the key do not match
+	// the scenario.
+	PartitionBy *Partition `yaml:"partition_by" json:"partition_by,omitempty"`
+	Steps       []Step     `yaml:"steps" json:"steps"`
+}
+
+// Partition selects the request value that keys scenario state. Exactly one
+// field is set. PathSegment counts from 1, so segment 2 of /orders/42 is 42.
+type Partition struct {
+	JSONPath    string `yaml:"jsonpath" json:"jsonpath,omitempty"`
+	Header      string `yaml:"header" json:"header,omitempty"`
+	PathSegment int    `yaml:"path_segment" json:"path_segment,omitempty"`
 }
 
 type Step struct {
-	Name      string       `yaml:"name" json:"name,omitempty"`
-	State     string       `yaml:"state" json:"state"`
-	Match     matcher.Rule `yaml:"match" json:"match"`
-	NextState string       `yaml:"next_state" json:"next_state,omitempty"`
-	Response  Response     `yaml:"response" json:"response"`
+	Name  string       `yaml:"name" json:"name,omitempty"`
+	State string       `yaml:"state" json:"state"`
+	Match matcher.Rule `yaml:"match" json:"match"`
+	// When lists counter guards that must all hold, in addition to State and
+	// Match, for the step to apply. Guards see the counters before the step
+	// updates them.
+	When []Guard `yaml:"when" json:"when,omitempty"`
+	// Set assigns counters and Increment then adds to them when the step
+	// applies. Unset counters read as zero.
+	Set       map[string]int `yaml:"set" json:"set,omitempty"`
+	Increment map[string]int `yaml:"increment" json:"increment,omitempty"`
+	// Extract stores named values from the request or the rendered response
+	// in the partition's variables when the step applies.
+	Extract   map[string]Extract `yaml:"extract" json:"extract,omitempty"`
+	NextState string             `yaml:"next_state" json:"next_state,omitempty"`
+	Response  Response           `yaml:"response" json:"response"`
+	// Alternatives replace Response for a weighted share of calls.
+	Alternatives []Alternative `yaml:"alternatives" json:"alternatives,omitempty"`
+}
+
+// Alternative is a weighted replacement for a step's response. Weight is a
+// percentage of calls; Response answers the remainder.
+type Alternative struct {
+	Weight   int      `yaml:"weight" json:"weight"`
+	Response Response `yaml:"response" json:"response"`
+}
+
+// Extract selects one value for a scenario variable. From is request, the
+// default, or response. Exactly one selector is set; responses support
+// jsonpath, which reads JSON bodies, and header.
+type Extract struct {
+	From        string `yaml:"from" json:"from,omitempty"`
+	JSONPath    string `yaml:"jsonpath" json:"jsonpath,omitempty"`
+	Header      string `yaml:"header" json:"header,omitempty"`
+	Query       string `yaml:"query" json:"query,omitempty"`
+	PathSegment int    `yaml:"path_segment" json:"path_segment,omitempty"`
+}
+
+// Guard compares a partition counter with a constant. Op is one of ==, !=,
+// <, <=, > and >=.
+type Guard struct {
+	Counter string `yaml:"counter" json:"counter"`
+	Op      string `yaml:"op" json:"op"`
+	Value   int    `yaml:"value" json:"value"`
+}
+
+// MaxPartitions bounds the partitions a scenario stores between resets. A
+// request for a new key beyond it is answered from the initial state, and
+// what its step changes is not kept.
+const MaxPartitions = 10000
+
+// PartitionState is the state and counters of one scenario partition.
+type PartitionState struct {
+	State    string            `json:"state"`
+	Counters map[string]int    `json:"counters,omitempty"`
+	Vars     map[string]string `json:"vars,omitempty"`
 }
 
 type Response struct {
@@ -44,16 +108,37 @@ type Response struct {
 type Result struct {
 	Scenario string
 	Step     string
-	Response Response
+	// FromState and ToState record the scenario state before and after the
+	// step. They are equal when the step has no next_state.
+	FromState string
+	ToState   string
+	// Partition is the key the step was matched under; it is empty for
+	// scenarios without partition_by. Counters and Vars are the partition's
+	// values after the step updated them from the request.
+	Partition string
+	Counters  map[string]int
+	Vars      map[string]string
+	Response  Response
+	// Alternatives are the step's weighted responses; the caller picks one.
+	Alternatives []Alternative
+
+	// extract holds the step's response extractions for CaptureResponse.
+	extract map[string]Extract
 }
 
 // Engine maintains explicit scenario state. A single lock makes transitions
 // atomic when a replay uses concurrent workers.
 type Engine struct {
-	configs  []Config
-	states   map[string]string
-	compiled [][]*matcher.RuleMatcher
-	mu       sync.Mutex
+	configs []Config
+	// partitions holds state by scenario name and partition key. Scenarios
+	// without partition_by use the empty key.
+	partitions map[string]map[string]*PartitionState
+	compiled   [][]*matcher.RuleMatcher
+	mu         sync.Mutex
+
+	// matching and registry compile scenarios added after construction.
+	matching matcher.Config
+	registry *grpcsim.Registry
 }
 
 func New(configs []Config) (*Engine, error) {
@@ -69,7 +154,9 @@ func NewWithMatching(configs []Config, matching matcher.Config) (*Engine, error)
 }
 
 func NewWithRegistry(configs []Config, matching matcher.Config, registry *grpcsim.Registry) (*Engine, error) {
-	e := &Engine{configs: configs, states: make(map[string]string)}
+	// Put replaces scenarios in place, so the engine owns its slice rather
+	// than writing through to the caller's configuration.
+	e := &Engine{configs: append([]Config(nil), configs...), partitions: make(map[string]map[string]*PartitionState), matching: matching, registry: registry}
 	names := make(map[string]struct{})
 	for i, cfg := range configs {
 		if strings.TrimSpace(cfg.Name) == "" {
@@ -82,6 +169,9 @@ func NewWithRegistry(configs []Config, matching matcher.Config, registry *grpcsi
 		if cfg.InitialState == "" {
 			return nil, fmt.Errorf("scenario %q initial_state is required", cfg.Name)
 		}
+		if err := validatePartition(cfg.PartitionBy); err != nil {
+			return nil, fmt.Errorf("scenario %q partition_by: %w", cfg.Name, err)
+		}
 		states := map[string]struct{}{cfg.InitialState: {}}
 		compiledSteps := make([]*matcher.RuleMatcher, 0, len(cfg.Steps))
 		for j, step := range cfg.Steps {
@@ -89,37 +179,29 @@ func NewWithRegistry(configs []Config, matching matcher.Config, registry *grpcsi
 				return nil, fmt.Errorf("scenario %q steps[%d].state is required", cfg.Name, j)
 			}
 			states[step.State] = struct{}{}
-			if step.Response.Status < 100 || step.Response.Status > 599 {
-				return nil, fmt.Errorf("scenario %q steps[%d].response.status must be 100..599", cfg.Name, j)
-			}
-			responseForms := 0
-			for _, present := range []bool{
-				step.Response.Body != "",
-				step.Response.BodyB64 != "",
-				step.Response.BodyTemplate != "",
-				step.Response.ProtobufJSON != "",
-				len(step.Response.ProtobufStream) > 0,
-			} {
-				if present {
-					responseForms++
+			if err := validateCounters(step); err != nil {
+				return nil, fmt.Errorf("scenario %q steps[%d].%w", cfg.Name, j, err)
+			}
+			for name, extract := range step.Extract {
+				if err := validateExtract(name, extract); err != nil {
+					return nil, fmt.Errorf("scenario %q steps[%d].extract.%s: %w", cfg.Name, j, name, err)
 				}
 			}
-			if responseForms > 1 {
-				return nil, fmt.Errorf("scenario %q steps[%d] configures more than one response body form", cfg.Name, j)
+			if err := validateResponse("response", step.Response); err != nil {
+				return nil, fmt.Errorf("scenario %q steps[%d].%w", cfg.Name, j, err)
 			}
-			if step.Response.BodyB64 != "" {
-				if _, err := base64.StdEncoding.DecodeString(step.Response.BodyB64); err != nil {
-					return nil, fmt.Errorf("scenario %q steps[%d].response.body_base64: %w", cfg.Name, j, err)
+			totalWeight := 0
+			for k, alternative := range step.Alternatives {
+				if alternative.Weight < 1 || alternative.Weight > 100 {
+					return nil, fmt.Errorf("scenario %q steps[%d].alternatives[%d].weight must be 1..100", cfg.Name, j, k)
 				}
-			}
-			if step.Response.StreamMessageDelay != "" {
-				delay, err := time.ParseDuration(step.Response.StreamMessageDelay)
-				if err != nil || delay < 0 {
-					return nil, fmt.Errorf("scenario %q steps[%d].response.stream_message_delay must be a non-negative duration", cfg.Name, j)
+				totalWeight += alternative.Weight
+				if err := validateResponse(fmt.Sprintf("alternatives[%d].response", k), alternative.Response); err != nil {
+					return nil, fmt.Errorf("scenario %q steps[%d].%w", cfg.Name, j, err)
 				}
 			}
-			if err := validateResponseTemplates(step.Response); err != nil {
-				return nil, fmt.Errorf("scenario %q steps[%d].response: %w", cfg.Name, j, err)
+			if totalWeight > 100 {
+				return nil, fmt.Errorf("scenario %q steps[%d].alternatives weights sum to %d, more than 100", cfg.Name, j, totalWeight)
 			}
 			compiled, err := matcher.CompileRuleWithRegistry(step.Match, matching, registry)
 			if err != nil {
@@ -134,12 +216,260 @@ func NewWithRegistry(configs []Config, matching matcher.Config, registry *grpcsi
 				}
 			}
 		}
-		e.states[cfg.Name] = cfg.InitialState
+		e.partitions[cfg.Name] = make(map[string]*PartitionState)
 		e.compiled = append(e.compiled, compiledSteps)
 	}
 	return e, nil
 }
 
+func validatePartition(partition *Partition) error {
+	if partition == nil {
+		return nil
+	}
+	if countSelectors(partition.JSONPath != "", partition.Header != "", partition.PathSegment != 0) != 1 {
+		return fmt.Errorf("exactly one of jsonpath, header or path_segment is required")
+	}
+	return validateSelectors(partition.JSONPath, partition.PathSegment)
+}
+
+func validateExtract(name string, extract Extract) error {
+	if name == "" {
+		return fmt.Errorf("variable name is required")
+	}
+	switch extract.From {
+	case "", "request":
+		if countSelectors(extract.JSONPath != "", extract.Header != "", extract.Query != "", extract.PathSegment != 0) != 1 {
+			return fmt.Errorf("exactly one of jsonpath, header, query or path_segment is required")
+		}
+	case "response":
+		if countSelectors(extract.JSONPath != "", extract.Header != "") != 1 || extract.Query != "" || extract.PathSegment != 0 {
+			return fmt.Errorf("exactly one of jsonpath or header is required for a response")
+		}
+	default:
+		return fmt.Errorf("from %q must be request or response", extract.From)
+	}
+	return validateSelectors(extract.JSONPath, extract.PathSegment)
+}
+
+func countSelectors(present ...bool) int {
+	count := 0
+	for _, set := range present {
+		if set {
+			count++
+		}
+	}
+	return count
+}
+
+func validateSelectors(jsonPath string, pathSegment int) error {
+	if pathSegment < 0 {
+		return fmt.Errorf("path_segment must be >= 1")
+	}
+	if jsonPath != "" {
+		if err := matcher.ValidateJSONPath(jsonPath); err != nil {
+			return fmt.Errorf("jsonpath: %w", err)
+		}
+	}
+	return nil
+}
+
+func validateCounters(step Step) error {
+	for index, guard := range step.When {
+		if guard.Counter == "" {
+			return fmt.Errorf("when[%d].counter is required", index)
+		}
+		if _, ok := guardOps[guard.Op]; !ok {
+			return fmt.Errorf("when[%d].op %q must be one of ==, !=, <, <=, >, >=", index, guard.Op)
+		}
+	}
+	for name := range step.Set {
+		if name == "" {
+			return fmt.Errorf("set has an empty counter name")
+		}
+	}
+	for name := range step.Increment {
+		if name == "" {
+			return fmt.Errorf("increment has an empty counter name")
+		}
+	}
+	return nil
+}
+
+var guardOps = map[string]func(counter, value int) bool{
+	"==": func(counter, value int) bool { return counter == value },
+	"!=": func(counter, value int) bool { return counter != value },
+	"<":  func(counter, value int) bool { return counter < value },
+	"<=": func(counter, value int) bool { return counter <= value },
+	">":  func(counter, value int) bool { return counter > value },
+	">=": func(counter, value int) bool { return counter >= value },
+}
+
+// key extracts the partition key from the request. It reports false when
+// the request does not carry the key.
+func (p *Partition) key(req *http.Request, body []byte) (string, bool) {
+	return Extract{JSONPath: p.JSONPath, Header: p.Header, PathSegment: p.PathSegment}.request(req, body)
+}
+
+// request reads the selected value from a request. It reports false when
+// the value is absent or empty.
+func (x Extract) request(req *http.Request, body []byte) (string, bool) {
+	switch {
+	case x.Header != "":
+		value := req.Header.Get(x.Header)
+		return value, value != ""
+	case x.Query != "":
+		value := req.URL.Query().Get(x.Query)
+		return value, value != ""
+	case x.PathSegment > 0:
+		segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+		if x.PathSegment > len(segments) || segments[x.PathSegment-1] == "" {
+			return "", false
+		}
+		return segments[x.PathSegment-1], true
+	default:
+		return jsonValue(body, x.JSONPath)
+	}
+}
+
+// response reads the selected value from a rendered response.
+func (x Extract) response(headers http.Header, body []byte) (string, bool) {
+	if x.Header != "" {
+		value := headers.Get(x.Header)
+		return value, value != ""
+	}
+	return jsonValue(body, x.JSONPath)
+}
+
+// jsonValue returns the value at path in a JSON document. Strings are
+// returned as is and other values in their JSON encoding.
+func jsonValue(body []byte, path string) (string, bool) {
+	var document any
+	if err := json.Unmarshal(body, &document); err != nil {
+		return "", false
+	}
+	value, ok := matcher.JSONPathValue(document, path)
+	if !ok || value == nil {
+		return "", false
+	}
+	if text, isString := value.(string); isString {
+		return text, text != ""
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+	return string(encoded), true
+}
+
+func guardsHold(guards []Guard, counters map[string]int) bool {
+	for _, guard := range guards {
+		if !guardOps[guard.Op](counters[guard.Counter], guard.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// lookup returns the named partition without storing it; a partition that
+// has not been stored yet is in the initial state. Callers hold e.mu.
+func (e *Engine) lookup(cfg Config, key string) (*PartitionState, bool) {
+	if current, ok := e.partitions[cfg.Name][key]; ok {
+		return current, true
+	}
+	return &PartitionState{State: cfg.InitialState}, false
+}
+
+// store keeps a partition returned by lookup, unless the scenario already
+// holds MaxPartitions. Callers hold e.mu.
+func (e *Engine) store(cfg Config, key string, current *PartitionState) {
+	partitions := e.partitions[cfg.Name]
+	if partitions == nil {
+		partitions = make(map[string]*PartitionState)
+		e.partitions[cfg.Name] = partitions
+	}
+	if len(partitions) < MaxPartitions {
+		partitions[key] = current
+	}
+}
+
+// partition returns the named partition, creating it in the initial state.
+// Callers hold e.mu.
+func (e *Engine) partition(cfg Config, key string) *PartitionState {
+	partitions := e.partitions[cfg.Name]
+	if partitions == nil {
+		partitions = make(map[string]*PartitionState)
+		e.partitions[cfg.Name] = partitions
+	}
+	current, ok := partitions[key]
+	if !ok {
+		current = &PartitionState{State: cfg.InitialState}
+		partitions[key] = current
+	}
+	return current
+}
+
+func (p *PartitionState) clone() PartitionState {
+	out := PartitionState{State: p.State}
+	if len(p.Counters) > 0 {
+		out.Counters = make(map[string]int, len(p.Counters))
+		for name, value := range p.Counters {
+			out.Counters[name] = value
+		}
+	}
+	if len(p.Vars) > 0 {
+		out.Vars = make(map[string]string, len(p.Vars))
+		for name, value := range p.Vars {
+			out.Vars[name] = value
+		}
+	}
+	return out
+}
+
+func (p *PartitionState) setVar(name, value string) {
+	if p.Vars == nil {
+		p.Vars = make(map[string]string)
+	}
+	p.Vars[name] = value
+}
+
+// validateResponse checks one response; field names in errors are prefixed
+// with path.
+func validateResponse(path string, response Response) error {
+	if response.Status < 100 || response.Status > 599 {
+		return fmt.Errorf("%s.status must be 100..599", path)
+	}
+	responseForms := 0
+	for _, present := range []bool{
+		response.Body != "",
+		response.BodyB64 != "",
+		response.BodyTemplate != "",
+		response.ProtobufJSON != "",
+		len(response.ProtobufStream) > 0,
+	} {
+		if present {
+			responseForms++
+		}
+	}
+	if responseForms > 1 {
+		return fmt.Errorf("%s configures more than one body form", path)
+	}
+	if response.BodyB64 != "" {
+		if _, err := base64.StdEncoding.DecodeString(response.BodyB64); err != nil {
+			return fmt.Errorf("%s.body_base64: %w", path, err)
+		}
+	}
+	if response.StreamMessageDelay != "" {
+		delay, err := time.ParseDuration(response.StreamMessageDelay)
+		if err != nil || delay < 0 {
+			return fmt.Errorf("%s.stream_message_delay must be a non-negative duration", path)
+		}
+	}
+	if err := validateResponseTemplates(response); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
 func validateResponseTemplates(response Response) error {
 	if err := simtemplate.Validate(response.BodyTemplate); err != nil {
 		return fmt.Errorf("body_template: %w", err)
@@ -176,7 +506,7 @@ func (e *Engine) Reset() {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	for _, cfg := range e.configs {
-		e.states[cfg.Name] = cfg.InitialState
+		e.partitions[cfg.Name] = make(map[string]*PartitionState)
 	}
 }
 
@@ -187,28 +517,236 @@ func (e *Engine) Match(req *http.Request, body []byte) (Result, bool) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	for configIndex, cfg := range e.configs {
-		current := e.states[cfg.Name]
+		key := ""
+		if cfg.PartitionBy != nil {
+			var ok bool
+			if key, ok = cfg.PartitionBy.key(req, body); !ok {
+				continue
+			}
+		}
+		// Only a step that changes the partition stores it, so keys that
+		// never advance a scenario, such as health checks or random IDs,
+		// do not accumulate.
+		current, stored := e.lookup(cfg, key)
 		for stepIndex, step := range cfg.Steps {
-			if step.State != current {
+			if step.State != current.State || !guardsHold(step.When, current.Counters) {
 				continue
 			}
 			ok, _ := e.compiled[configIndex][stepIndex].Match(req, body)
 			if !ok {
 				continue
 			}
+			from := current.State
+			changed := len(step.Set) > 0 || len(step.Increment) > 0
+			if changed {
+				if current.Counters == nil {
+					current.Counters = make(map[string]int)
+				}
+				for name, value := range step.Set {
+					current.Counters[name] = value
+				}
+				for name, delta := range step.Increment {
+					current.Counters[name] += delta
+				}
+			}
+			var responseExtract map[string]Extract
+			for name, extract := range step.Extract {
+				if extract.From == "response" {
+					if responseExtract == nil {
+						responseExtract = make(map[string]Extract)
+					}
+					responseExtract[name] = extract
+					continue
+				}
+				if value, ok := extract.request(req, body); ok {
+					current.setVar(name, value)
+					changed = true
+				}
+			}
 			if step.NextState != "" {
-				e.states[cfg.Name] = step.NextState
+				current.State = step.NextState
+				changed = changed || step.NextState != from
 			}
+			// Response extractions store their values in the partition
+			// later, in CaptureResponse.
+			if !stored && (changed || len(responseExtract) > 0) {
+				e.store(cfg, key, current)
+			}
+			values := current.clone()
 			return Result{
-				Scenario: cfg.Name,
-				Step:     step.Name,
-				Response: step.Response,
+				Scenario:     cfg.Name,
+				Step:         step.Name,
+				FromState:    from,
+				ToState:      current.State,
+				Partition:    key,
+				Counters:     values.Counters,
+				Vars:         values.Vars,
+				Response:     step.Response,
+				Alternatives: step.Alternatives,
+				extract:      responseExtract,
 			}, true
 		}
 	}
 	return Result{}, false
 }
 
+// CaptureResponse stores the variables that the matched step extracts from
+// its rendered response. A reset or replaced scenario since the match drops
+// the values.
+func (e *Engine) CaptureResponse(result Result, headers http.Header, body []byte) {
+	if e == nil || len(result.extract) == 0 {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	current, ok := e.partitions[result.Scenario][result.Partition]
+	if !ok {
+		return
+	}
+	for name, extract := range result.extract {
+		if value, found := extract.response(headers, body); found {
+			current.setVar(name, value)
+		}
+	}
+}
+
+// Configs returns a copy of the scenarios in match order.
+func (e *Engine) Configs() []Config {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Config(nil), e.configs...)
+}
+
+// Put validates cfg with the same rules as construction, then replaces the
+// scenario with the same name in place or appends it. The scenario starts
+// in its initial state. It reports whether an existing scenario was replaced.
+func (e *Engine) Put(cfg Config) (bool, error) {
+	compiled, err := NewWithRegistry([]Config{cfg}, e.matching, e.registry)
+	if err != nil {
+		return false, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.partitions[cfg.Name] = make(map[string]*PartitionState)
+	for index, existing := range e.configs {
+		if existing.Name == cfg.Name {
+			e.configs[index] = cfg
+			e.compiled[index] = compiled.compiled[0]
+			return true, nil
+		}
+	}
+	e.configs = append(e.configs, cfg)
+	e.compiled = append(e.compiled, compiled.compiled[0])
+	return false, nil
+}
+
+// Remove deletes the named scenario and reports whether it existed.
+func (e *Engine) Remove(name string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for index, existing := range e.configs {
+		if existing.Name != name {
+			continue
+		}
+		e.configs = append(e.configs[:index:index], e.configs[index+1:]...)
+		e.compiled = append(e.compiled[:index:index], e.compiled[index+1:]...)
+		delete(e.partitions, name)
+		return true
+	}
+	return false
+}
+
+// States returns the current state of every scenario by name. A scenario
+// with partition_by reports its initial state, which is the state new
+// partitions start in; Partitions reports the partitions themselves.
+func (e *Engine) States() map[string]string {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make(map[string]string, len(e.configs))
+	for _, cfg := range e.configs {
+		out[cfg.Name] = cfg.InitialState
+		if current, ok := e.partitions[cfg.Name][""]; ok && cfg.PartitionBy == nil {
+			out[cfg.Name] = current.State
+		}
+	}
+	return out
+}
+
+// Partitions returns the state, counters, and variables of every partition
+// a step changed or whose state was set since the last reset, by scenario
+// name and partition key. Scenarios without partition_by use the empty key.
+func (e *Engine) Partitions() map[string]map[string]PartitionState {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make(map[string]map[string]PartitionState, len(e.partitions))
+	for name, partitions := range e.partitions {
+		if len(partitions) == 0 {
+			continue
+		}
+		copied := make(map[string]PartitionState, len(partitions))
+		for key, current := range partitions {
+			copied[key] = current.clone()
+		}
+		out[name] = copied
+	}
+	return out
+}
+
+// SetState forces the named scenario into state, which must be its initial
+// state or a state or next_state of one of its steps. It returns the previous
+// state and reports whether the scenario exists. Scenarios with partition_by
+// need SetPartitionState.
+func (e *Engine) SetState(name, state string) (string, bool, error) {
+	return e.SetPartitionState(name, "", state)
+}
+
+// SetPartitionState is SetState for one partition of a scenario with
+// partition_by. The partition is created if no request has used it yet and
+// its counters are kept.
+func (e *Engine) SetPartitionState(name, key, state string) (string, bool, error) {
+	if e == nil {
+		return "", false, nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, cfg := range e.configs {
+		if cfg.Name != name {
+			continue
+		}
+		if cfg.PartitionBy != nil && key == "" {
+			return cfg.InitialState, true, fmt.Errorf("scenario %q is partitioned; a partition key is required", name)
+		}
+		if cfg.PartitionBy == nil && key != "" {
+			return "", true, fmt.Errorf("scenario %q has no partition_by", name)
+		}
+		known := state == cfg.InitialState
+		for _, step := range cfg.Steps {
+			known = known || state == step.State || state == step.NextState
+		}
+		if !known {
+			previous := cfg.InitialState
+			if current, ok := e.partitions[name][key]; ok {
+				previous = current.State
+			}
+			return previous, true, fmt.Errorf("scenario %q has no state %q", name, state)
+		}
+		current := e.partition(cfg, key)
+		previous := current.State
+		current.State = state
+		return previous, true, nil
+	}
+	return "", false, nil
+}
+
 func (r Response) Bytes() ([]byte, error) {
 	if r.BodyB64 != "" {
 		return base64.StdEncoding.DecodeString(r.BodyB64)
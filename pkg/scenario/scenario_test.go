@@ -1,6 +1,7 @@
 package scenario
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -66,3 +67,234 @@ func TestEngineRejectsUnknownNextState(t *testing.T) {
 		t.Fatal("expected validation error")
 	}
 }
+
+func TestEnginePutReplacesValidatesAndRemoves(t *testing.T) {
+	engine, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := Config{Name: "flag", InitialState: "on", Steps: []Step{{
+		State:    "on",
+		Match:    matcher.Rule{PathRegex: `^/flag$`},
+		Response: Response{Status: 200, Body: "on"},
+	}}}
+	if replaced, err := engine.Put(ok); err != nil || replaced {
+		t.Fatalf("put new scenario: replaced=%t err=%v", replaced, err)
+	}
+	ok.Steps[0].Response.Body = "off"
+	if replaced, err := engine.Put(ok); err != nil || !replaced {
+		t.Fatalf("put existing scenario: replaced=%t err=%v", replaced, err)
+	}
+	request := httptest.NewRequest(http.MethodGet, "http://dependency.test/flag", nil)
+	if result, matched := engine.Match(request, nil); !matched || result.Response.Body != "off" {
+		t.Fatalf("replaced scenario not served: %#v %t", result, matched)
+	}
+	if _, err := engine.Put(Config{Name: "bad", InitialState: "x", Steps: []Step{{State: "x", Match: matcher.Rule{PathRegex: "("}, Response: Response{Status: 200}}}}); err == nil {
+		t.Fatal("expected invalid match regex to be rejected")
+	}
+	if !engine.Remove("flag") || engine.Remove("flag") {
+		t.Fatal("remove did not report existence correctly")
+	}
+	if _, matched := engine.Match(request, nil); matched || len(engine.Configs()) != 0 {
+		t.Fatal("removed scenario still matches")
+	}
+}
+
+func TestEnginePutLeavesTheCallersConfigsUnchanged(t *testing.T) {
+	loaded := []Config{{Name: "flag", InitialState: "on", Steps: []Step{{
+		State: "on", Match: matcher.Rule{PathRegex: `^/flag$`}, Response: Response{Status: 200},
+	}}}}
+	engine, err := New(loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Put(Config{Name: "flag", InitialState: "off", Steps: []Step{{
+		State: "off", Match: matcher.Rule{PathRegex: `^/flag$`}, Response: Response{Status: 503},
+	}}}); err != nil {
+		t.Fatal(err)
+	}
+	if loaded[0].InitialState != "on" || loaded[0].Steps[0].Response.Status != 200 {
+		t.Fatalf("put changed the loaded configuration: %#v", loaded[0])
+	}
+}
+
+func TestEngineSetStateValidatesAndReportsStates(t *testing.T) {
+	engine, err := New([]Config{{
+		Name: "checkout", InitialState: "cart",
+		Steps: []Step{
+			{State: "cart", NextState: "paid", Match: matcher.Rule{PathRegex: `^/pay$`}, Response: Response{Status: 200}},
+			{State: "paid", Match: matcher.Rule{PathRegex: `^/status$`}, Response: Response{Status: 200}},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous, found, err := engine.SetState("checkout", "paid"); err != nil || !found || previous != "cart" {
+		t.Fatalf("set state: previous=%q found=%t err=%v", previous, found, err)
+	}
+	if got := engine.States()["checkout"]; got != "paid" {
+		t.Fatalf("state = %q", got)
+	}
+	if _, found, err := engine.SetState("checkout", "refunded"); !found || err == nil {
+		t.Fatalf("unknown state: found=%t err=%v", found, err)
+	}
+	if _, found, _ := engine.SetState("missing", "cart"); found {
+		t.Fatal("unknown scenario reported as found")
+	}
+	engine.Reset()
+	if got := engine.States()["checkout"]; got != "cart" {
+		t.Fatalf("state after reset = %q", got)
+	}
+}
+
+func TestEnginePartitionsStateAndGuardsCounters(t *testing.T) {
+	engine, err := New([]Config{{
+		Name: "webhook", InitialState: "pending",
+		PartitionBy: &Partition{JSONPath: "$.order_id"},
+		Steps: []Step{
+			{
+				Name: "retry", State: "pending",
+				When:      []Guard{{Counter: "calls", Op: "<", Value: 2}},
+				Increment: map[string]int{"calls": 1},
+				Match:     matcher.Rule{PathRegex: `^/webhook$`},
+				Response:  Response{Status: 503},
+			},
+			{
+				Name: "settle", State: "pending", NextState: "settled",
+				Increment: map[string]int{"calls": 1},
+				Match:     matcher.Rule{PathRegex: `^/webhook$`},
+				Response:  Response{Status: 200},
+			},
+			{State: "settled", Match: matcher.Rule{PathRegex: `^/webhook$`}, Response: Response{Status: 409}},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := func(order string) Result {
+		t.Helper()
+		request := httptest.NewRequest(http.MethodPost, "http://dependency.test/webhook", nil)
+		result, ok := engine.Match(request, []byte(`{"order_id":"`+order+`"}`))
+		if !ok {
+			t.Fatalf("order %s did not match", order)
+		}
+		return result
+	}
+	for _, want := range []int{503, 503, 200, 409} {
+		if result := call("a"); result.Response.Status != want || result.Partition != "a" {
+			t.Fatalf("order a: status=%d partition=%q want %d", result.Response.Status, result.Partition, want)
+		}
+	}
+	if result := call("b"); result.Response.Status != 503 || result.Counters["calls"] != 1 {
+		t.Fatalf("order b shares state with order a: %#v", result)
+	}
+	if _, ok := engine.Match(httptest.NewRequest(http.MethodPost, "http://dependency.test/webhook", nil), []byte(`{}`)); ok {
+		t.Fatal("request without the partition key matched")
+	}
+	partitions := engine.Partitions()["webhook"]
+	if partitions["a"].State != "settled" || partitions["a"].Counters["calls"] != 3 || partitions["b"].State != "pending" {
+		t.Fatalf("partitions = %#v", partitions)
+	}
+	if _, _, err := engine.SetState("webhook", "settled"); err == nil {
+		t.Fatal("expected a partition key to be required")
+	}
+	if previous, _, err := engine.SetPartitionState("webhook", "b", "settled"); err != nil || previous != "pending" {
+		t.Fatalf("set partition state: previous=%q err=%v", previous, err)
+	}
+	engine.Reset()
+	if len(engine.Partitions()) != 0 {
+		t.Fatal("reset kept partitions")
+	}
+}
+
+func TestEngineStoresOnlyPartitionsAStepChanges(t *testing.T) {
+	engine, err := New([]Config{{
+		Name: "login", InitialState: "open",
+		PartitionBy: &Partition{Header: "X-Client"},
+		Steps: []Step{
+			{State: "open", Match: matcher.Rule{PathRegex: `^/health$`}, Response: Response{Status: 200}},
+			{State: "open", NextState: "locked", Match: matcher.Rule{PathRegex: `^/login$`}, Response: Response{Status: 401}},
+			{State: "locked", Match: matcher.Rule{PathRegex: `^/login$`}, Response: Response{Status: 423}},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := func(path, client string) bool {
+		request := httptest.NewRequest(http.MethodGet, "http://dependency.test"+path, nil)
+		request.Header.Set("X-Client", client)
+		_, ok := engine.Match(request, nil)
+		return ok
+	}
+	for i := 0; i < 3; i++ {
+		if !call("/health", fmt.Sprintf("probe-%d", i)) || call("/other", fmt.Sprintf("miss-%d", i)) {
+			t.Fatal("unexpected match results")
+		}
+	}
+	if partitions := engine.Partitions(); len(partitions) != 0 {
+		t.Fatalf("unchanged partitions were stored: %#v", partitions)
+	}
+	for i := 0; i < MaxPartitions+1; i++ {
+		call("/login", fmt.Sprintf("client-%d", i))
+	}
+	partitions := engine.Partitions()["login"]
+	if len(partitions) != MaxPartitions || partitions["client-0"].State != "locked" {
+		t.Fatalf("stored %d partitions, want %d", len(partitions), MaxPartitions)
+	}
+}
+
+func TestEngineRejectsInvalidPartitionsAndGuards(t *testing.T) {
+	for name, cfg := range map[string]Config{
+		"two selectors": {Name: "bad", InitialState: "one", PartitionBy: &Partition{Header: "X-Id", PathSegment: 2},
+			Steps: []Step{{State: "one", Response: Response{Status: 200}}}},
+		"bad jsonpath": {Name: "bad", InitialState: "one", PartitionBy: &Partition{JSONPath: "id"},
+			Steps: []Step{{State: "one", Response: Response{Status: 200}}}},
+		"bad op": {Name: "bad", InitialState: "one",
+			Steps: []Step{{State: "one", When: []Guard{{Counter: "calls", Op: "=>"}}, Response: Response{Status: 200}}}},
+	} {
+		if _, err := New([]Config{cfg}); err == nil {
+			t.Fatalf("%s: expected validation error", name)
+		}
+	}
+}
+
+func TestEngineExtractsVariablesFromRequestsAndResponses(t *testing.T) {
+	engine, err := New([]Config{{
+		Name: "orders", InitialState: "empty",
+		Steps: []Step{
+			{
+				State: "empty", NextState: "created",
+				Extract: map[string]Extract{
+					"customer": {Header: "X-Customer"},
+					"order_id": {From: "response", JSONPath: "$.id"},
+				},
+				Match:    matcher.Rule{PathRegex: `^/orders$`},
+				Response: Response{Status: 201},
+			},
+			{State: "created", Match: matcher.Rule{PathRegex: `^/orders/`}, Response: Response{Status: 200}},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := httptest.NewRequest(http.MethodPost, "http://dependency.test/orders", nil)
+	create.Header.Set("X-Customer", "cust-7")
+	result, ok := engine.Match(create, nil)
+	if !ok || result.Vars["customer"] != "cust-7" {
+		t.Fatalf("create: %#v %t", result, ok)
+	}
+	engine.CaptureResponse(result, nil, []byte(`{"id":"ord-1"}`))
+	result, ok = engine.Match(httptest.NewRequest(http.MethodGet, "http://dependency.test/orders/ord-1", nil), nil)
+	if !ok || result.Vars["order_id"] != "ord-1" || result.Vars["customer"] != "cust-7" {
+		t.Fatalf("get: %#v %t", result, ok)
+	}
+	engine.Reset()
+	if len(engine.Partitions()) != 0 {
+		t.Fatal("reset kept variables")
+	}
+	if _, err := New([]Config{{Name: "bad", InitialState: "one", Steps: []Step{{
+		State: "one", Extract: map[string]Extract{"id": {From: "response", Query: "id"}}, Response: Response{Status: 200},
+	}}}}); err == nil {
+		t.Fatal("expected response query extraction to be rejected")
+	}
+}
@@ -3,21 +3,33 @@ package simserver
 import (
 	"context"
 	"crypto/sha256"
+	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
 
 	"infernosim/pkg/capture"
+	"infernosim/pkg/event"
+	"infernosim/pkg/matcher"
+	"infernosim/pkg/metrics"
+	"infernosim/pkg/privacy"
 	"infernosim/pkg/replaydriver"
+	"infernosim/pkg/scenario"
 	"infernosim/pkg/stubproxy"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 const controlPrefix = "/__infernosim"
@@ -25,29 +37,84 @@ const controlPrefix = "/__infernosim"
 type Options struct {
 	IncidentDir string
 	ConfigPath  string
+	// Incidents serves several bundles behind one listener instead of
+	// IncidentDir. Each incident has its own scenario state, journal, proof,
+	// and reset, and its control API is namespaced under
+	// /__infernosim/incidents/{name}.
+	Incidents   []Incident
 	Listen      string
 	AdminListen string
+	// ObservedLog records observed dependency calls. With several incidents,
+	// each writes to the path with its name inserted before the extension.
 	ObservedLog string
 	HTTPS       bool
 	CADir       string
 	AllowHosts  []string
+	// ClientCA, when set, is a PEM bundle of CAs; HTTPS stubbing then
+	// requires a client certificate issued by one of them.
+	ClientCA string
+	// HostPolicy, when set, is a host policy file applied before AllowHosts.
+	HostPolicy string
+	// AllowPrivateDestinations lets record-on-miss and hosts tunnelled by a
+	// glob, suffix, or * host policy reach loopback and private addresses.
+	AllowPrivateDestinations bool
+	// HTTP3Listen, when set, also serves dependency calls over HTTP/3 on
+	// this UDP address. It requires HTTPS stubbing.
+	HTTP3Listen string
+	// RecordOnMiss forwards unmatched dependency calls to a live upstream and
+	// appends them to the incident's outbound log.
+	RecordOnMiss *stubproxy.RecordOnMiss
+	// Journal configures the request journal behind the verification API.
+	Journal stubproxy.JournalOptions
+	// Privacy and CaptureSensitiveData filter recorded calls, the journal,
+	// and near-miss diagnostics.
+	Privacy              *privacy.Policy
+	CaptureSensitiveData bool
+	// Latency overrides each incident's stub.latency configuration field by
+	// field: a set mode or a non-zero scale replaces the configured one.
+	Latency stubproxy.LatencyOptions
+}
+
+// Incident is one bundle served by a multi-incident simulator. A request is
+// routed to the incident listing its host in Hosts, otherwise to the longest
+// PathPrefix that contains its path. The request is not rewritten, because
+// captured URLs include the prefix. At most one incident may omit both and
+// receive everything else. HTTPS CONNECT tunnels are routed by host only.
+type Incident struct {
+	Name       string
+	Dir        string
+	ConfigPath string
+	Hosts      []string
+	PathPrefix string
 }
 
 type Server struct {
 	options       Options
-	stub          *stubproxy.StubProxy
+	incidents     []*incident
+	byName        map[string]*incident
 	stubServer    *http.Server
 	adminServer   *http.Server
 	stubListener  net.Listener
 	adminListener net.Listener
-	proof         Proof
+	http3Server   *http3.Server
+	http3Conn     net.PacketConn
 	errors        chan error
 	closeOnce     sync.Once
 	closeErr      error
 }
 
+// incident is a loaded bundle with its own stub and proof.
+type incident struct {
+	Incident
+	stub  *stubproxy.StubProxy
+	proof Proof
+}
+
 type Proof struct {
-	Version      int                `json:"version"`
+	Version int `json:"version"`
+	// Incident names the incident when several are served. It is not part
+	// of the semantic hash.
+	Incident     string             `json:"incident,omitempty"`
 	IncidentHash string             `json:"incident_hash"`
 	ConfigHash   string             `json:"config_hash,omitempty"`
 	SemanticHash string             `json:"semantic_hash"`
@@ -55,9 +122,22 @@ type Proof struct {
 	Snapshot     stubproxy.Snapshot `json:"snapshot"`
 }
 
+// validIncidentName keeps names usable as a single URL path segment.
+var validIncidentName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// loadedIncident is an incident whose bundle and configuration were read but
+// whose stub is not yet built, so HTTPS settings can be merged first.
+type loadedIncident struct {
+	Incident
+	bundle     replaydriver.IncidentBundle
+	configPath string
+	config     replaydriver.ReplayYAMLConfig
+}
+
 func New(opts Options) (*Server, error) {
-	if strings.TrimSpace(opts.IncidentDir) == "" {
-		return nil, fmt.Errorf("incident directory is required")
+	incidents, err := resolveIncidents(opts)
+	if err != nil {
+		return nil, err
 	}
 	if opts.Listen == "" {
 		opts.Listen = "127.0.0.1:19000"
@@ -65,29 +145,42 @@ func New(opts Options) (*Server, error) {
 	if opts.AdminListen == "" {
 		opts.AdminListen = "127.0.0.1:19001"
 	}
-	bundle, err := replaydriver.OpenBundle(opts.IncidentDir)
-	if err != nil {
-		return nil, err
-	}
-	configPath := opts.ConfigPath
-	if configPath == "" && bundle.HasConfig() {
-		configPath = bundle.ConfigPath
-	}
-	var config replaydriver.ReplayYAMLConfig
-	if configPath != "" {
-		config, err = replaydriver.LoadReplayConfig(configPath)
+	loaded := make([]loadedIncident, 0, len(incidents))
+	for _, spec := range incidents {
+		bundle, err := replaydriver.OpenBundle(spec.Dir)
 		if err != nil {
 			return nil, err
 		}
+		item := loadedIncident{Incident: spec, bundle: bundle, configPath: spec.ConfigPath}
+		if item.configPath == "" && bundle.HasConfig() {
+			item.configPath = bundle.ConfigPath
+		}
+		if item.configPath != "" {
+			item.config, err = replaydriver.LoadReplayConfig(item.configPath)
+			if err != nil {
+				return nil, err
+			}
+		}
+		loaded = append(loaded, item)
 	}
-	if config.Stub.HTTPS.Enabled {
-		opts.HTTPS = true
-	}
-	if opts.CADir == "" {
-		opts.CADir = config.Stub.HTTPS.CADir
-	}
-	if len(opts.AllowHosts) == 0 {
-		opts.AllowHosts = append([]string(nil), config.Stub.HTTPS.AllowHosts...)
+	mergeAllowHosts := len(opts.AllowHosts) == 0
+	for _, item := range loaded {
+		https := item.config.Stub.HTTPS
+		if https.Enabled {
+			opts.HTTPS = true
+		}
+		if opts.CADir == "" {
+			opts.CADir = https.CADir
+		}
+		if opts.ClientCA == "" {
+			opts.ClientCA = https.ClientCA
+		}
+		if opts.HostPolicy == "" {
+			opts.HostPolicy = https.HostPolicy
+		}
+		if mergeAllowHosts {
+			opts.AllowHosts = append(opts.AllowHosts, https.AllowHosts...)
+		}
 	}
 	var ca *capture.CAStore
 	if opts.HTTPS {
@@ -102,43 +195,242 @@ func New(opts Options) (*Server, error) {
 		if len(opts.AllowHosts) > 0 {
 			ca.AllowedHosts = append([]string(nil), opts.AllowHosts...)
 		}
+		if opts.HostPolicy != "" {
+			if ca.Hosts, err = capture.LoadHostPolicies(opts.HostPolicy); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if opts.HTTP3Listen != "" && ca == nil {
+		return nil, fmt.Errorf("HTTP/3 listener requires HTTPS stubbing")
+	}
+	var clientCAs *x509.CertPool
+	if opts.ClientCA != "" {
+		if ca == nil {
+			return nil, fmt.Errorf("client certificate verification requires HTTPS stubbing")
+		}
+		clientCAs, err = capture.LoadCertPool(opts.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("load stub client CA: %w", err)
+		}
+	}
+	s := &Server{
+		options: opts,
+		byName:  make(map[string]*incident, len(loaded)),
+		errors:  make(chan error, 3),
+	}
+	for _, item := range loaded {
+		built, err := buildIncident(item, opts, ca, clientCAs, len(opts.Incidents) > 0)
+		if err != nil {
+			s.closeStubs()
+			return nil, err
+		}
+		s.incidents = append(s.incidents, built)
+		s.byName[built.Name] = built
+	}
+	var dependencies, routed http.Handler
+	if len(opts.Incidents) == 0 {
+		routed = s.incidents[0].stub
+		dependencies = s.incidents[0].stub.Handler()
+	} else {
+		routed = http.HandlerFunc(s.routeDependency)
+		dependencies = h2c.NewHandler(routed, &http2.Server{})
+	}
+	s.stubServer = &http.Server{Handler: dependencies, ReadHeaderTimeout: 10 * time.Second}
+	if opts.HTTP3Listen != "" {
+		s.http3Server = stubproxy.NewHTTP3Server(routed, ca, clientCAs)
+	}
+	s.adminServer = &http.Server{Handler: s.controlHandler(), ReadHeaderTimeout: 5 * time.Second}
+	return s, nil
+}
+
+// resolveIncidents returns the incidents to serve, treating the single
+// IncidentDir form as an unrouted incident named "default".
+func resolveIncidents(opts Options) ([]Incident, error) {
+	if len(opts.Incidents) == 0 {
+		if strings.TrimSpace(opts.IncidentDir) == "" {
+			return nil, fmt.Errorf("incident directory is required")
+		}
+		return []Incident{{Name: "default", Dir: opts.IncidentDir, ConfigPath: opts.ConfigPath}}, nil
+	}
+	if opts.IncidentDir != "" || opts.ConfigPath != "" {
+		return nil, fmt.Errorf("incident directory and config cannot be combined with several incidents")
+	}
+	names := make(map[string]struct{})
+	hosts := make(map[string]string)
+	prefixes := make(map[string]string)
+	catchAll := ""
+	out := make([]Incident, 0, len(opts.Incidents))
+	for i, spec := range opts.Incidents {
+		if !validIncidentName.MatchString(spec.Name) {
+			return nil, fmt.Errorf("incidents[%d].name %q must be letters, digits, '.', '_' or '-'", i, spec.Name)
+		}
+		if _, exists := names[spec.Name]; exists {
+			return nil, fmt.Errorf("incident name %q is duplicated", spec.Name)
+		}
+		names[spec.Name] = struct{}{}
+		if strings.TrimSpace(spec.Dir) == "" {
+			return nil, fmt.Errorf("incident %q directory is required", spec.Name)
+		}
+		normalized := spec
+		normalized.Hosts = nil
+		for _, host := range spec.Hosts {
+			host = strings.ToLower(strings.TrimSpace(host))
+			if host == "" {
+				continue
+			}
+			if owner, exists := hosts[host]; exists {
+				return nil, fmt.Errorf("host %q is routed to both %q and %q", host, owner, spec.Name)
+			}
+			hosts[host] = spec.Name
+			normalized.Hosts = append(normalized.Hosts, host)
+		}
+		if spec.PathPrefix != "" {
+			prefix := strings.TrimRight(spec.PathPrefix, "/")
+			if !strings.HasPrefix(spec.PathPrefix, "/") || prefix == "" {
+				return nil, fmt.Errorf("incident %q path prefix %q must start with / and not be the root", spec.Name, spec.PathPrefix)
+			}
+			if owner, exists := prefixes[prefix]; exists {
+				return nil, fmt.Errorf("path prefix %q is routed to both %q and %q", prefix, owner, spec.Name)
+			}
+			prefixes[prefix] = spec.Name
+			normalized.PathPrefix = prefix
+		}
+		if len(normalized.Hosts) == 0 && normalized.PathPrefix == "" {
+			if catchAll != "" {
+				return nil, fmt.Errorf("incidents %q and %q both have no host or path prefix route", catchAll, spec.Name)
+			}
+			catchAll = spec.Name
+		}
+		out = append(out, normalized)
+	}
+	return out, nil
+}
+
+func buildIncident(item loadedIncident, opts Options, ca *capture.CAStore, clientCAs *x509.CertPool, named bool) (*incident, error) {
+	observedLog := opts.ObservedLog
+	if named && observedLog != "" {
+		extension := filepath.Ext(observedLog)
+		observedLog = strings.TrimSuffix(observedLog, extension) + "." + item.Name + extension
+	}
+	latency := stubproxy.LatencyOptions{Mode: item.config.Stub.Latency.Mode, Scale: item.config.Stub.Latency.Scale}
+	if opts.Latency.Mode != "" {
+		latency.Mode = opts.Latency.Mode
 	}
-	stub, err := stubproxy.NewWithOptions(bundle.OutboundLog, opts.ObservedLog, nil, stubproxy.Options{
-		Matching:  config.Matching,
-		Scenarios: config.Scenarios,
-		Templates: config.Templates,
-		TLSCA:     ca,
+	if opts.Latency.Scale != 0 {
+		latency.Scale = opts.Latency.Scale
+	}
+	var streamMasks []stubproxy.StreamMask
+	for _, ignore := range item.config.Stub.TCP.Ignore {
+		streamMasks = append(streamMasks, stubproxy.StreamMask{Host: ignore.Host, Offset: ignore.Offset, Length: ignore.Length})
+	}
+	stub, err := stubproxy.NewWithOptions(item.bundle.OutboundLog, observedLog, nil, stubproxy.Options{
+		Matching:                 item.config.Matching,
+		Scenarios:                item.config.Scenarios,
+		Templates:                item.config.Templates,
+		TLSCA:                    ca,
+		ClientCAs:                clientCAs,
+		AllowPrivateDestinations: opts.AllowPrivateDestinations,
+		RecordOnMiss:             opts.RecordOnMiss,
+		Journal:                  opts.Journal,
+		Privacy:                  opts.Privacy,
+		CaptureSensitiveData:     opts.CaptureSensitiveData,
+		Latency:                  latency,
+		StreamMasks:              streamMasks,
 	})
 	if err != nil {
+		if named {
+			return nil, fmt.Errorf("incident %q: %w", item.Name, err)
+		}
 		return nil, err
 	}
+	bundle := item.bundle
 	incidentHash, err := hashFiles(bundle.MetadataPath, bundle.InboundLog, bundle.OutboundLog, filepath.Join(bundle.Dir, "messages.log"))
 	if err != nil {
 		_ = stub.Close()
 		return nil, err
 	}
 	configHash := ""
-	if configPath != "" {
-		configHash, err = hashFiles(configPath)
+	if item.configPath != "" {
+		configHash, err = hashFiles(item.configPath)
 		if err != nil {
 			_ = stub.Close()
 			return nil, err
 		}
 	}
-	s := &Server{
-		options: opts,
-		stub:    stub,
-		errors:  make(chan error, 2),
-		proof: Proof{
-			Version:      1,
-			IncidentHash: incidentHash,
-			ConfigHash:   configHash,
-			StartedAt:    time.Now().UTC(),
-		},
+	proof := Proof{
+		Version:      1,
+		IncidentHash: incidentHash,
+		ConfigHash:   configHash,
+		StartedAt:    time.Now().UTC(),
 	}
-	s.stubServer = &http.Server{Handler: stub.Handler(), ReadHeaderTimeout: 10 * time.Second}
-	s.adminServer = &http.Server{Handler: s.controlHandler(), ReadHeaderTimeout: 5 * time.Second}
-	return s, nil
+	if named {
+		proof.Incident = item.Name
+	}
+	return &incident{Incident: item.Incident, stub: stub, proof: proof}, nil
+}
+
+// routeDependency hands a dependency request to the incident that serves
+// its host or path prefix.
+func (s *Server) routeDependency(w http.ResponseWriter, r *http.Request) {
+	target := s.incidentForRequest(r)
+	if target == nil {
+		w.Header().Set("Content-Type", "application/json")
+		writeError(w, http.StatusBadGateway, fmt.Errorf("no incident serves host %q path %q", requestHost(r), r.URL.Path))
+		return
+	}
+	target.stub.ServeHTTP(w, r)
+}
+
+// socksConnect hands a SOCKS5 connection to the incident that serves its
+// host, as for CONNECT.
+func (s *Server) socksConnect(dest string) func(net.Conn) {
+	if len(s.options.Incidents) == 0 {
+		return s.incidents[0].stub.SOCKSConnect(dest)
+	}
+	target := s.incidentForRequest(&http.Request{Method: http.MethodConnect, Host: dest, URL: &url.URL{}})
+	if target == nil {
+		return nil
+	}
+	return target.stub.SOCKSConnect(dest)
+}
+
+// incidentForRequest prefers a host route, then the longest path prefix,
+// then the incident without routes.
+func (s *Server) incidentForRequest(r *http.Request) *incident {
+	host := requestHost(r)
+	var catchAll, byPrefix *incident
+	for _, candidate := range s.incidents {
+		for _, routed := range candidate.Hosts {
+			if routed == host {
+				return candidate
+			}
+		}
+		prefix := candidate.PathPrefix
+		switch {
+		case prefix == "" && len(candidate.Hosts) == 0:
+			catchAll = candidate
+		case prefix != "" && r.Method != http.MethodConnect &&
+			(r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/")) &&
+			(byPrefix == nil || len(prefix) > len(byPrefix.PathPrefix)):
+			byPrefix = candidate
+		}
+	}
+	if byPrefix != nil {
+		return byPrefix
+	}
+	return catchAll
+}
+
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if r.URL != nil && r.URL.Host != "" {
+		host = r.URL.Host
+	}
+	if parsed, _, err := net.SplitHostPort(host); err == nil {
+		host = parsed
+	}
+	return strings.ToLower(strings.Trim(host, "[]"))
 }
 
 func (s *Server) Start() error {
@@ -151,9 +443,23 @@ func (s *Server) Start() error {
 		_ = stubListener.Close()
 		return fmt.Errorf("listen on admin address %s: %w", s.options.AdminListen, err)
 	}
-	s.stubListener = stubListener
+	if s.http3Server != nil {
+		http3Conn, err := net.ListenPacket("udp", s.options.HTTP3Listen)
+		if err != nil {
+			_ = stubListener.Close()
+			_ = adminListener.Close()
+			return fmt.Errorf("listen on HTTP/3 address %s: %w", s.options.HTTP3Listen, err)
+		}
+		s.http3Conn = http3Conn
+		go func() {
+			if err := s.http3Server.Serve(http3Conn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				s.errors <- err
+			}
+		}()
+	}
+	s.stubListener = capture.ListenSOCKS5(stubListener, s.socksConnect)
 	s.adminListener = adminListener
-	go serve(s.stubServer, stubListener, s.errors)
+	go serve(s.stubServer, s.stubListener, s.errors)
 	go serve(s.adminServer, adminListener, s.errors)
 	return nil
 }
@@ -176,6 +482,15 @@ func (s *Server) StubAddress() string {
 	return s.stubListener.Addr().String()
 }
 
+// HTTP3Address returns the UDP address serving HTTP/3, or "" when HTTP/3 is
+// not enabled.
+func (s *Server) HTTP3Address() string {
+	if s.http3Conn == nil {
+		return ""
+	}
+	return s.http3Conn.LocalAddr().String()
+}
+
 func (s *Server) AdminAddress() string {
 	if s.adminListener == nil {
 		return ""
@@ -192,21 +507,63 @@ func (s *Server) Close(ctx context.Context) error {
 		if s.stubServer != nil {
 			errs = append(errs, s.stubServer.Shutdown(ctx))
 		}
-		if s.stub != nil {
-			errs = append(errs, s.stub.Close())
+		if s.http3Conn != nil {
+			errs = append(errs, s.http3Server.Shutdown(ctx))
+			_ = s.http3Conn.Close()
 		}
+		errs = append(errs, s.closeStubs())
 		s.closeErr = errors.Join(errs...)
 	})
 	return s.closeErr
 }
 
+func (s *Server) closeStubs() error {
+	var errs []error
+	for _, served := range s.incidents {
+		errs = append(errs, served.stub.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// controlHandler registers every incident route twice: unprefixed, which
+// addresses the only incident when one is served, and under
+// /__infernosim/incidents/{incident}.
 func (s *Server) controlHandler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /healthz", s.handleHealth)
 	mux.HandleFunc("GET "+controlPrefix+"/healthz", s.handleHealth)
-	mux.HandleFunc("GET "+controlPrefix+"/status", s.handleStatus)
-	mux.HandleFunc("POST "+controlPrefix+"/reset", s.handleReset)
-	mux.HandleFunc("GET "+controlPrefix+"/proof", s.handleProof)
+	mux.HandleFunc("GET "+controlPrefix+"/incidents", s.handleListIncidents)
+	for _, base := range []string{controlPrefix, controlPrefix + "/incidents/{incident}"} {
+		mux.HandleFunc("GET "+base+"/status", s.handleStatus)
+		mux.HandleFunc("POST "+base+"/reset", s.handleReset)
+		mux.HandleFunc("GET "+base+"/proof", s.handleProof)
+		mux.HandleFunc("GET "+base+"/exchanges", s.handleListExchanges)
+		mux.HandleFunc("POST "+base+"/exchanges", s.handlePutExchange)
+		mux.HandleFunc("PUT "+base+"/exchanges/{id}", s.handlePutExchange)
+		mux.HandleFunc("DELETE "+base+"/exchanges/{id}", s.handleDeleteExchange)
+		mux.HandleFunc("GET "+base+"/scenarios", s.handleListScenarios)
+		mux.HandleFunc("PUT "+base+"/scenarios/{name}", s.handlePutScenario)
+		mux.HandleFunc("DELETE "+base+"/scenarios/{name}", s.handleDeleteScenario)
+		mux.HandleFunc("GET "+base+"/scenarios/{name}/state", s.handleScenarioState)
+		mux.HandleFunc("PUT "+base+"/scenarios/{name}/state", s.handleSetScenarioState)
+		mux.HandleFunc("GET "+base+"/requests", s.handleRequests)
+		mux.HandleFunc("GET "+base+"/requests/count", s.handleRequestCount)
+	}
+	mux.Handle("GET "+controlPrefix+"/incidents/{incident}/metrics", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if target, ok := s.target(w, r); ok {
+			target.stub.Metrics().Handler().ServeHTTP(w, r)
+		}
+	}))
+	metricsHandler := s.incidents[0].stub.Metrics().Handler()
+	if len(s.options.Incidents) > 0 {
+		registries := make(map[string]*metrics.Registry, len(s.incidents))
+		for _, served := range s.incidents {
+			registries[served.Name] = served.stub.Metrics()
+		}
+		metricsHandler = metrics.MergedHandler("incident", registries)
+	}
+	mux.Handle("GET /metrics", metricsHandler)
+	mux.Handle("GET "+controlPrefix+"/metrics", metricsHandler)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Cache-Control", "no-store")
 		w.Header().Set("Content-Type", "application/json")
@@ -214,22 +571,82 @@ func (s *Server) controlHandler() http.Handler {
 	})
 }
 
+// target resolves the incident a control request addresses. Unprefixed
+// routes are ambiguous when several incidents are served.
+func (s *Server) target(w http.ResponseWriter, r *http.Request) (*incident, bool) {
+	if name := r.PathValue("incident"); name != "" {
+		served, ok := s.byName[name]
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("incident %q is not served", name))
+		}
+		return served, ok
+	}
+	if len(s.incidents) == 1 {
+		return s.incidents[0], true
+	}
+	writeError(w, http.StatusNotFound, fmt.Errorf("%d incidents are served; use %s/incidents/{incident}%s", len(s.incidents), controlPrefix, strings.TrimPrefix(r.URL.Path, controlPrefix)))
+	return nil, false
+}
+
 func (s *Server) handleHealth(w http.ResponseWriter, _ *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]any{"status": "ready"})
 }
 
-func (s *Server) handleStatus(w http.ResponseWriter, _ *http.Request) {
-	writeJSON(w, http.StatusOK, s.stub.Snapshot())
+// IncidentSummary describes one served incident and its routes.
+type IncidentSummary struct {
+	Name         string   `json:"name"`
+	Hosts        []string `json:"hosts,omitempty"`
+	PathPrefix   string   `json:"path_prefix,omitempty"`
+	IncidentHash string   `json:"incident_hash"`
+	ConfigHash   string   `json:"config_hash,omitempty"`
+}
+
+func (s *Server) handleListIncidents(w http.ResponseWriter, _ *http.Request) {
+	summaries := make([]IncidentSummary, 0, len(s.incidents))
+	for _, served := range s.incidents {
+		summaries = append(summaries, IncidentSummary{
+			Name:         served.Name,
+			Hosts:        served.Hosts,
+			PathPrefix:   served.PathPrefix,
+			IncidentHash: served.proof.IncidentHash,
+			ConfigHash:   served.proof.ConfigHash,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"incidents": summaries})
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	target, ok := s.target(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, target.stub.Snapshot())
 }
 
-func (s *Server) handleReset(w http.ResponseWriter, _ *http.Request) {
-	s.stub.Reset()
+// handleReset resets the addressed incident. The unprefixed route resets
+// every incident, so one call prepares a whole test environment.
+func (s *Server) handleReset(w http.ResponseWriter, r *http.Request) {
+	targets := s.incidents
+	if r.PathValue("incident") != "" {
+		target, ok := s.target(w, r)
+		if !ok {
+			return
+		}
+		targets = []*incident{target}
+	}
+	for _, target := range targets {
+		target.stub.Reset()
+	}
 	writeJSON(w, http.StatusOK, map[string]any{"status": "reset"})
 }
 
-func (s *Server) handleProof(w http.ResponseWriter, _ *http.Request) {
-	proof := s.proof
-	proof.Snapshot = s.stub.Snapshot()
+func (s *Server) handleProof(w http.ResponseWriter, r *http.Request) {
+	target, ok := s.target(w, r)
+	if !ok {
+		return
+	}
+	proof := target.proof
+	proof.Snapshot = target.stub.Snapshot()
 	semantic, _ := json.Marshal(struct {
 		Version      int
 		IncidentHash string
@@ -245,6 +662,289 @@ func hashBytes(value []byte) string {
 	return hex.EncodeToString(sum[:])
 }
 
+func (s *Server) handleListExchanges(w http.ResponseWriter, r *http.Request) {
+	target, ok := s.target(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"exchanges": target.stub.Exchanges()})
+}
+
+// handlePutExchange adds an exchange (POST) or replaces the exchange named
+// by the path (PUT). A POST whose body carries an existing id replaces it.
+func (s *Server) handlePutExchange(w http.ResponseWriter, r *http.Request) {
+	target, ok := s.target(w, r)
+	if !ok {
+		return
+	}
+	var exchange event.Event
+	if err := decodeControlBody(w, r, &exchange); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if id := r.PathValue("id"); id != "" {
+		if exchange.ID != "" && exchange.ID != id {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("exchange id %q does not match path id %q", exchange.ID, id))
+			return
+		}
+		exchange.ID = id
+	}
+	stored, replaced, err := target.stub.PutExchange(exchange)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	status := http.StatusCreated
+	if replaced {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, stubproxy.ExchangeSummary{ID: stored.ID, Method: stored.Method, URL: stored.URL, Status: stored.Status})
+}
+
+func (s *Server) handleDeleteExchange(w http.ResponseWriter, r *http.Request) {
+	target, ok := s.target(w, r)
+	if !ok {
+		return
+	}
+	if !target.stub.RemoveExchange(r.PathValue("id")) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("exchange %q not found", r.PathValue("id")))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleListScenarios(w http.ResponseWriter, r *http.Request) {
+	target, ok := s.target(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"scenarios":  target.stub.Scenarios(),
+		"states":     target.stub.ScenarioStates(),
+		"partitions": target.stub.ScenarioPartitions(),
+	})
+}
+
+func (s *Server) handlePutScenario(w http.ResponseWriter, r *http.Request) {
+	target, ok := s.target(w, r)
+	if !ok {
+		return
+	}
+	var config scenario.Config
+	if err := decodeControlBody(w, r, &config); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	name := r.PathValue("name")
+	if config.Name != "" && config.Name != name {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("scenario name %q does not match path name %q", config.Name, name))
+		return
+	}
+	config.Name = name
+	replaced, err := target.stub.PutScenario(config)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	status := http.StatusCreated
+	if replaced {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, config)
+}
+
+func (s *Server) handleDeleteScenario(w http.ResponseWriter, r *http.Request) {
+	target, ok := s.target(w, r)
+	if !ok {
+		return
+	}
+	if !target.stub.RemoveScenario(r.PathValue("name")) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("scenario %q not found", r.PathValue("name")))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ScenarioState is the body of the scenario state endpoints. Partition
+// addresses one partition of a scenario with partition_by; GET takes it from
+// the partition query parameter. Previous is set only in the response to a
+// change.
+type ScenarioState struct {
+	Scenario  string         `json:"scenario"`
+	Partition string         `json:"partition,omitempty"`
+	State     string         `json:"state"`
+	Counters  map[string]int `json:"counters,omitempty"`
+	Previous  string         `json:"previous,omitempty"`
+}
+
+func (s *Server) handleScenarioState(w http.ResponseWriter, r *http.Request) {
+	target, ok := s.target(w, r)
+	if !ok {
+		return
+	}
+	name := r.PathValue("name")
+	state, exists := target.stub.ScenarioStates()[name]
+	if !exists {
+		writeError(w, http.StatusNotFound, fmt.Errorf("scenario %q not found", name))
+		return
+	}
+	partition := r.URL.Query().Get("partition")
+	if partition == "" {
+		writeJSON(w, http.StatusOK, ScenarioState{Scenario: name, State: state})
+		return
+	}
+	current, exists := target.stub.ScenarioPartitions()[name][partition]
+	if !exists {
+		writeError(w, http.StatusNotFound, fmt.Errorf("scenario %q has no partition %q", name, partition))
+		return
+	}
+	writeJSON(w, http.StatusOK, ScenarioState{Scenario: name, Partition: partition, State: current.State, Counters: current.Counters})
+}
+
+// handleSetScenarioState forces a scenario into a state so a test can start
+// mid-workflow without replaying the transitions that lead there.
+func (s *Server) handleSetScenarioState(w http.ResponseWriter, r *http.Request) {
+	target, ok := s.target(w, r)
+	if !ok {
+		return
+	}
+	var request ScenarioState
+	if err := decodeControlBody(w, r, &request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	name := r.PathValue("name")
+	if request.Scenario != "" && request.Scenario != name {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("scenario name %q does not match path name %q", request.Scenario, name))
+		return
+	}
+	if request.Counters != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("counters cannot be set through the state endpoint"))
+		return
+	}
+	previous, found, err := target.stub.SetScenarioPartitionState(name, request.Partition, request.State)
+	if !found {
+		writeError(w, http.StatusNotFound, fmt.Errorf("scenario %q not found", name))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ScenarioState{Scenario: name, Partition: request.Partition, State: request.State, Previous: previous})
+}
+
+func (s *Server) handleRequests(w http.ResponseWriter, r *http.Request) {
+	entries, ok := s.journalQuery(w, r)
+	if !ok {
+		return
+	}
+	if entries == nil {
+		entries = []stubproxy.JournalEntry{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"count": len(entries), "requests": entries})
+}
+
+func (s *Server) handleRequestCount(w http.ResponseWriter, r *http.Request) {
+	entries, ok := s.journalQuery(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"count": len(entries)})
+}
+
+func (s *Server) journalQuery(w http.ResponseWriter, r *http.Request) ([]stubproxy.JournalEntry, bool) {
+	target, ok := s.target(w, r)
+	if !ok {
+		return nil, false
+	}
+	filter, err := parseJournalFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	entries, err := target.stub.Journal(filter)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	return entries, true
+}
+
+// parseJournalFilter reads verification filters from query parameters:
+// method (repeatable or comma-separated), host, path, and client_identity
+// regular expressions, result, and repeatable header=Name:regex,
+// query=name=regex, and jsonpath=$.path=regex selectors. A JSONPath key may
+// itself contain "=", so jsonpath selectors split on the last one; the regex
+// can spell "=" as \x3d.
+func parseJournalFilter(values url.Values) (stubproxy.JournalFilter, error) {
+	filter := stubproxy.JournalFilter{
+		Result: values.Get("result"),
+		Rule: matcher.Rule{
+			HostRegex:           values.Get("host"),
+			PathRegex:           values.Get("path"),
+			ClientIdentityRegex: values.Get("client_identity"),
+		},
+	}
+	for _, value := range values["method"] {
+		filter.Rule.Methods = append(filter.Rule.Methods, splitList(value)...)
+	}
+	selectors := []struct {
+		param     string
+		separator string
+		last      bool
+		target    *map[string]string
+	}{
+		{"header", ":", false, &filter.Rule.HeaderRegex},
+		{"query", "=", false, &filter.Rule.QueryRegex},
+		{"jsonpath", "=", true, &filter.Rule.JSONPathRegex},
+	}
+	for _, selector := range selectors {
+		for _, value := range values[selector.param] {
+			name, pattern, ok := strings.Cut(value, selector.separator)
+			if selector.last {
+				if i := strings.LastIndex(value, selector.separator); i >= 0 {
+					name, pattern = value[:i], value[i+len(selector.separator):]
+				}
+			}
+			if !ok || strings.TrimSpace(name) == "" {
+				return stubproxy.JournalFilter{}, fmt.Errorf("%s filter %q must be name%sregex", selector.param, value, selector.separator)
+			}
+			if *selector.target == nil {
+				*selector.target = make(map[string]string)
+			}
+			(*selector.target)[strings.TrimSpace(name)] = pattern
+		}
+	}
+	return filter, nil
+}
+
+func splitList(value string) []string {
+	var out []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// maxControlBody bounds programmed exchanges, which may carry response bodies.
+const maxControlBody = 16 << 20
+
+func decodeControlBody(w http.ResponseWriter, r *http.Request, value any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxControlBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(value); err != nil {
+		return fmt.Errorf("decode request body: %w", err)
+	}
+	return nil
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]any{"error": err.Error()})
+}
+
 func writeJSON(w http.ResponseWriter, status int, value any) {
 	w.WriteHeader(status)
 	_ = json.NewEncoder(w).Encode(value)
@@ -4,11 +4,18 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
+
+	"infernosim/pkg/privacy"
+	"infernosim/pkg/stubproxy"
 )
 
 func TestServerHealthResetStatusAndProof(t *testing.T) {
@@ -85,6 +92,504 @@ func TestServerHealthResetStatusAndProof(t *testing.T) {
 	if secondProof.SemanticHash != proof.SemanticHash {
 		t.Fatalf("proof is not deterministic: first=%s second=%s", proof.SemanticHash, secondProof.SemanticHash)
 	}
+
+	response, err = http.Get(admin + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exposition, _ := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+	if !strings.HasPrefix(response.Header.Get("Content-Type"), "text/plain") {
+		t.Fatalf("metrics content type=%q", response.Header.Get("Content-Type"))
+	}
+	if want := `infernosim_stub_requests_total{dependency="dependency.test",result="matched"} 2`; !strings.Contains(string(exposition), want) {
+		t.Fatalf("metrics missing %q:\n%s", want, exposition)
+	}
+
+	request, _ = http.NewRequest(http.MethodGet, "http://dependency.test/values", nil)
+	request.Host = "dependency.test"
+	response, err = http.DefaultClient.Do(rewriteToProxy(request, server.StubAddress()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	missBody, _ := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusBadGateway || !strings.Contains(string(missBody), `"field":"path"`) {
+		t.Fatalf("miss response: %d %s", response.StatusCode, missBody)
+	}
+	response, _ = http.Get(admin + "/__infernosim/status")
+	var afterMiss stubproxy.Snapshot
+	_ = json.NewDecoder(response.Body).Decode(&afterMiss)
+	_ = response.Body.Close()
+	if len(afterMiss.NearMisses) != 1 || afterMiss.NearMisses[0].Candidates[0].Reason != "path mismatch" {
+		t.Fatalf("status near misses = %+v", afterMiss.NearMisses)
+	}
+}
+
+func TestServerProgramsExchangesAndScenariosAtRuntime(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"inbound.log", "outbound.log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server, err := New(Options{IncidentDir: dir, Listen: "127.0.0.1:0", AdminListen: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = server.Close(ctx)
+	})
+	admin := "http://" + server.AdminAddress() + "/__infernosim"
+	call := func(method, path, body string) (int, string) {
+		t.Helper()
+		request, _ := http.NewRequest(method, admin+path, strings.NewReader(body))
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		data, _ := io.ReadAll(response.Body)
+		return response.StatusCode, string(data)
+	}
+	dependency := func(method, path string) (int, string) {
+		t.Helper()
+		request, _ := http.NewRequest(method, "http://dependency.test"+path, nil)
+		request.Host = "dependency.test"
+		response, err := http.DefaultClient.Do(rewriteToProxy(request, server.StubAddress()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		data, _ := io.ReadAll(response.Body)
+		return response.StatusCode, string(data)
+	}
+
+	exchange := `{"method":"GET","url":"http://dependency.test/value","status":200,"responseBodyB64":"` +
+		base64.StdEncoding.EncodeToString([]byte("first")) + `"}`
+	if status, body := call(http.MethodPut, "/exchanges/value-1", exchange); status != http.StatusCreated {
+		t.Fatalf("put exchange: %d %s", status, body)
+	}
+	if status, body := dependency(http.MethodGet, "/value"); status != http.StatusOK || body != "first" {
+		t.Fatalf("programmed exchange: %d %q", status, body)
+	}
+	replacement := strings.Replace(exchange, base64.StdEncoding.EncodeToString([]byte("first")), base64.StdEncoding.EncodeToString([]byte("second")), 1)
+	if status, body := call(http.MethodPut, "/exchanges/value-1", replacement); status != http.StatusOK {
+		t.Fatalf("replace exchange: %d %s", status, body)
+	}
+	if status, body := call(http.MethodPost, "/reset", ""); status != http.StatusOK {
+		t.Fatalf("reset: %d %s", status, body)
+	}
+	if status, body := dependency(http.MethodGet, "/value"); status != http.StatusOK || body != "second" {
+		t.Fatalf("replaced exchange: %d %q", status, body)
+	}
+	if status, body := call(http.MethodPost, "/exchanges", `{"method":"GET","url":"/relative","status":200}`); status != http.StatusUnprocessableEntity {
+		t.Fatalf("invalid exchange accepted: %d %s", status, body)
+	}
+	if status, _ := call(http.MethodDelete, "/exchanges/value-1", ""); status != http.StatusNoContent {
+		t.Fatalf("delete exchange status=%d", status)
+	}
+	if status, body := call(http.MethodGet, "/exchanges", ""); status != http.StatusOK || !strings.Contains(body, `"exchanges":[]`) {
+		t.Fatalf("list exchanges: %d %s", status, body)
+	}
+
+	scenarioBody := `{"initial_state":"up","steps":[{"state":"up","match":{"path_regex":"^/health$"},"response":{"status":503,"body":"down"}}]}`
+	if status, body := call(http.MethodPut, "/scenarios/outage", scenarioBody); status != http.StatusCreated {
+		t.Fatalf("put scenario: %d %s", status, body)
+	}
+	if status, body := dependency(http.MethodGet, "/health"); status != http.StatusServiceUnavailable || body != "down" {
+		t.Fatalf("programmed scenario: %d %q", status, body)
+	}
+	invalid := `{"initial_state":"up","steps":[{"state":"up","next_state":"missing","response":{"status":200}}]}`
+	if status, body := call(http.MethodPut, "/scenarios/broken", invalid); status != http.StatusUnprocessableEntity || !strings.Contains(body, "next_state") {
+		t.Fatalf("invalid scenario accepted: %d %s", status, body)
+	}
+	if status, _ := call(http.MethodDelete, "/scenarios/outage", ""); status != http.StatusNoContent {
+		t.Fatalf("delete scenario status=%d", status)
+	}
+	if status, _ := call(http.MethodDelete, "/scenarios/outage", ""); status != http.StatusNotFound {
+		t.Fatalf("second delete status=%d", status)
+	}
+	if proof := fetchProof(t, "http://"+server.AdminAddress()); proof.Snapshot.Revision != 5 {
+		t.Fatalf("proof revision = %d, want 5", proof.Snapshot.Revision)
+	}
+}
+
+func TestServerVerificationEndpointsFilterJournal(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "inbound.log"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	line, _ := json.Marshal(map[string]any{"id": "pay", "type": "OutboundCall", "method": "POST", "url": "http://payments.test/pay", "status": 201})
+	if err := os.WriteFile(filepath.Join(dir, "outbound.log"), append(line, '\n'), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// JSONPath filters need the request bodies, which the journal keeps only
+	// with capture_bodies.
+	server, err := New(Options{IncidentDir: dir, Listen: "127.0.0.1:0", AdminListen: "127.0.0.1:0", Privacy: &privacy.Policy{CaptureBodies: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = server.Close(ctx)
+	})
+	for _, amount := range []string{"42", "42", "7"} {
+		request, _ := http.NewRequest(http.MethodPost, "http://payments.test/pay", strings.NewReader(`{"amount":`+amount+`}`))
+		request.Host = "payments.test"
+		request.Header.Set("X-Tenant", "acme")
+		response, err := http.DefaultClient.Do(rewriteToProxy(request, server.StubAddress()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = response.Body.Close()
+	}
+	query := url.Values{
+		"method":   {"POST"},
+		"path":     {"^/pay$"},
+		"header":   {"X-Tenant:^acme$"},
+		"jsonpath": {"$.amount=^42$"},
+	}
+	response, err := http.Get("http://" + server.AdminAddress() + "/__infernosim/requests/count?" + query.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count struct{ Count int }
+	_ = json.NewDecoder(response.Body).Decode(&count)
+	_ = response.Body.Close()
+	if count.Count != 2 {
+		t.Fatalf("count = %d, want 2", count.Count)
+	}
+	response, err = http.Get("http://" + server.AdminAddress() + "/__infernosim/requests?result=unmatched")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed struct {
+		Count    int
+		Requests []map[string]any
+	}
+	_ = json.NewDecoder(response.Body).Decode(&listed)
+	_ = response.Body.Close()
+	// The single captured exchange answers only the first call.
+	if listed.Count != 2 || listed.Requests[0]["miss_reason"] != "no_matching_captured_call" {
+		t.Fatalf("unmatched requests = %+v", listed)
+	}
+	response, err = http.Get("http://" + server.AdminAddress() + "/__infernosim/requests?header=missing-separator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("malformed filter status = %d", response.StatusCode)
+	}
+}
+
+func TestServerMergesLatencyFlagsWithConfigPerField(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "inbound.log"), []byte(""), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	line, _ := json.Marshal(map[string]any{
+		"id": "dep-1", "type": "OutboundCall", "timestamp": "2026-01-01T00:00:00Z",
+		"method": "GET", "url": "http://dependency.test/slow", "status": 200,
+		"duration": time.Second, "responseCaptured": true,
+	})
+	if err := os.WriteFile(filepath.Join(dir, "outbound.log"), append(line, '\n'), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name   string
+		config string
+		flags  stubproxy.LatencyOptions
+	}{
+		{"mode flag keeps the configured scale", "stub:\n  latency:\n    scale: 0.01\n", stubproxy.LatencyOptions{Mode: stubproxy.LatencyCaptured}},
+		{"scale flag keeps the configured mode", "stub:\n  latency:\n    mode: captured\n", stubproxy.LatencyOptions{Scale: 0.01}},
+	}
+	for _, tc := range cases {
+		configPath := filepath.Join(dir, "replay.yaml")
+		if err := os.WriteFile(configPath, []byte(tc.config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		server, err := New(Options{IncidentDir: dir, ConfigPath: configPath, Listen: "127.0.0.1:0", AdminListen: "127.0.0.1:0", Latency: tc.flags})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if err := server.Start(); err != nil {
+			t.Fatal(err)
+		}
+		request, _ := http.NewRequest(http.MethodGet, "http://dependency.test/slow", nil)
+		request.Host = "dependency.test"
+		start := time.Now()
+		response, err := http.DefaultClient.Do(rewriteToProxy(request, server.StubAddress()))
+		elapsed := time.Since(start)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_ = server.Close(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = response.Body.Close()
+		if response.StatusCode != http.StatusOK || elapsed < 10*time.Millisecond || elapsed > 500*time.Millisecond {
+			t.Fatalf("%s: status=%d elapsed=%s, want about 10ms", tc.name, response.StatusCode, elapsed)
+		}
+	}
+}
+
+func TestParseJournalFilterSplitsJSONPathOnLastEquals(t *testing.T) {
+	filter, err := parseJournalFilter(url.Values{
+		"jsonpath": {`$.labels.env=prod=^eu\x3d1$`, "$.amount=^42$"},
+		"query":    {"sig=^a=b$"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{`$.labels.env=prod`: `^eu\x3d1$`, "$.amount": "^42$"}
+	if !reflect.DeepEqual(filter.Rule.JSONPathRegex, want) || filter.Rule.QueryRegex["sig"] != "^a=b$" {
+		t.Fatalf("filter = %+v", filter.Rule)
+	}
+	if _, err := parseJournalFilter(url.Values{"jsonpath": {"=^42$"}}); err == nil {
+		t.Fatal("jsonpath filter without a path was accepted")
+	}
+}
+
+func TestServerForcesScenarioStateAndProvesTransitions(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"inbound.log", "outbound.log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server, err := New(Options{IncidentDir: dir, Listen: "127.0.0.1:0", AdminListen: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = server.Close(ctx)
+	})
+	admin := "http://" + server.AdminAddress() + "/__infernosim"
+	call := func(method, path, body string) (int, string) {
+		t.Helper()
+		request, _ := http.NewRequest(method, admin+path, strings.NewReader(body))
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		data, _ := io.ReadAll(response.Body)
+		return response.StatusCode, strings.TrimSpace(string(data))
+	}
+	dependency := func(method, path string) int {
+		t.Helper()
+		request, _ := http.NewRequest(method, "http://payments.test"+path, nil)
+		request.Host = "payments.test"
+		response, err := http.DefaultClient.Do(rewriteToProxy(request, server.StubAddress()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = response.Body.Close()
+		return response.StatusCode
+	}
+	checkout := `{"initial_state":"cart","steps":[
+		{"name":"checkout","state":"cart","next_state":"payment_pending","match":{"path_regex":"^/checkout$"},"response":{"status":200}},
+		{"name":"poll","state":"payment_pending","match":{"path_regex":"^/status$"},"response":{"status":202}},
+		{"name":"pay","state":"payment_pending","next_state":"paid","match":{"methods":["POST"],"path_regex":"^/pay$"},"response":{"status":201}},
+		{"name":"done","state":"paid","match":{"path_regex":"^/status$"},"response":{"status":200}}]}`
+	if status, body := call(http.MethodPut, "/scenarios/checkout", checkout); status != http.StatusCreated {
+		t.Fatalf("put scenario: %d %s", status, body)
+	}
+	if status, body := call(http.MethodGet, "/scenarios/checkout/state", ""); status != http.StatusOK || body != `{"scenario":"checkout","state":"cart"}` {
+		t.Fatalf("initial state: %d %s", status, body)
+	}
+	if status, body := call(http.MethodPut, "/scenarios/checkout/state", `{"state":"payment_pending"}`); status != http.StatusOK || !strings.Contains(body, `"previous":"cart"`) {
+		t.Fatalf("force state: %d %s", status, body)
+	}
+	if status := dependency(http.MethodGet, "/status"); status != http.StatusAccepted {
+		t.Fatalf("forced state not served: %d", status)
+	}
+	if status := dependency(http.MethodPost, "/pay"); status != http.StatusCreated {
+		t.Fatalf("pay: %d", status)
+	}
+	if status, body := call(http.MethodGet, "/scenarios", ""); status != http.StatusOK || !strings.Contains(body, `"states":{"checkout":"paid"}`) {
+		t.Fatalf("scenario list states: %d %s", status, body)
+	}
+	if status, body := call(http.MethodPut, "/scenarios/checkout/state", `{"state":"refunded"}`); status != http.StatusUnprocessableEntity {
+		t.Fatalf("unknown state accepted: %d %s", status, body)
+	}
+	if status, _ := call(http.MethodPut, "/scenarios/missing/state", `{"state":"cart"}`); status != http.StatusNotFound {
+		t.Fatalf("unknown scenario status = %d", status)
+	}
+	want := []stubproxy.Transition{
+		{Scenario: "checkout", From: "cart", To: "payment_pending", Forced: true},
+		{Scenario: "checkout", Step: "pay", From: "payment_pending", To: "paid"},
+	}
+	proof := fetchProof(t, "http://"+server.AdminAddress())
+	if len(proof.Snapshot.Transitions) != len(want) {
+		t.Fatalf("proof transitions = %+v", proof.Snapshot.Transitions)
+	}
+	for i := range want {
+		if proof.Snapshot.Transitions[i] != want[i] {
+			t.Fatalf("transition[%d] = %+v, want %+v", i, proof.Snapshot.Transitions[i], want[i])
+		}
+	}
+	if status, body := call(http.MethodPost, "/reset", ""); status != http.StatusOK {
+		t.Fatalf("reset: %d %s", status, body)
+	}
+	if _, body := call(http.MethodGet, "/scenarios/checkout/state", ""); !strings.Contains(body, `"state":"cart"`) {
+		t.Fatalf("state after reset: %s", body)
+	}
+	if proof := fetchProof(t, "http://"+server.AdminAddress()); len(proof.Snapshot.Transitions) != 0 {
+		t.Fatalf("reset kept transitions: %+v", proof.Snapshot.Transitions)
+	}
+}
+
+func TestServerRoutesSeveralIncidentsWithNamespacedControl(t *testing.T) {
+	writeIncident := func(rawURL, body string) string {
+		dir := t.TempDir()
+		line, _ := json.Marshal(map[string]any{
+			"id": "dep-1", "type": "OutboundCall", "method": "GET", "url": rawURL, "status": 200,
+			"responseCaptured": true, "responseBodyB64": base64.StdEncoding.EncodeToString([]byte(body)),
+		})
+		if err := os.WriteFile(filepath.Join(dir, "inbound.log"), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "outbound.log"), append(line, '\n'), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	server, err := New(Options{
+		Incidents: []Incident{
+			{Name: "payments", Dir: writeIncident("http://payments.test/charge", "paid"), Hosts: []string{"Payments.test"}},
+			{Name: "inventory", Dir: writeIncident("http://gateway.test/inventory/items", "items"), PathPrefix: "/inventory/"},
+			{Name: "fallback", Dir: writeIncident("http://other.test/ping", "pong")},
+		},
+		Listen: "127.0.0.1:0", AdminListen: "127.0.0.1:0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = server.Close(ctx)
+	})
+	dependency := func(host, path string) (int, string) {
+		t.Helper()
+		request, _ := http.NewRequest(http.MethodGet, "http://"+host+path, nil)
+		request.Host = host
+		response, err := http.DefaultClient.Do(rewriteToProxy(request, server.StubAddress()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		return response.StatusCode, string(body)
+	}
+	for _, tc := range []struct{ host, path, want string }{
+		{"PAYMENTS.test", "/charge", "paid"},
+		{"gateway.test", "/inventory/items", "items"},
+		{"other.test", "/ping", "pong"},
+	} {
+		if status, body := dependency(tc.host, tc.path); status != http.StatusOK || body != tc.want {
+			t.Fatalf("%s%s: %d %q", tc.host, tc.path, status, body)
+		}
+	}
+
+	admin := "http://" + server.AdminAddress() + "/__infernosim"
+	getJSON := func(path string, value any) int {
+		t.Helper()
+		response, err := http.Get(admin + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		_ = json.NewDecoder(response.Body).Decode(value)
+		return response.StatusCode
+	}
+	var listed struct{ Incidents []IncidentSummary }
+	if getJSON("/incidents", &listed); len(listed.Incidents) != 3 || listed.Incidents[1].PathPrefix != "/inventory" || listed.Incidents[0].Hosts[0] != "payments.test" {
+		t.Fatalf("incidents = %+v", listed)
+	}
+	var proof Proof
+	if status := getJSON("/incidents/payments/proof", &proof); status != http.StatusOK || proof.Incident != "payments" || proof.Snapshot.Observed != 1 {
+		t.Fatalf("payments proof: %d %+v", status, proof)
+	}
+	var ambiguous map[string]string
+	if status := getJSON("/status", &ambiguous); status != http.StatusNotFound || !strings.Contains(ambiguous["error"], "/__infernosim/incidents/{incident}/status") {
+		t.Fatalf("unprefixed status with several incidents: %d %v", status, ambiguous)
+	}
+	if status := getJSON("/incidents/missing/status", &ambiguous); status != http.StatusNotFound {
+		t.Fatalf("unknown incident status = %d", status)
+	}
+
+	response, err := http.Post(admin+"/incidents/payments/reset", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+	var snapshot stubproxy.Snapshot
+	if getJSON("/incidents/payments/status", &snapshot); snapshot.Observed != 0 {
+		t.Fatalf("payments was not reset: %+v", snapshot)
+	}
+	if getJSON("/incidents/inventory/status", &snapshot); snapshot.Observed != 1 {
+		t.Fatalf("resetting payments reset inventory: %+v", snapshot)
+	}
+	response, err = http.Post(admin+"/reset", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+	if getJSON("/incidents/inventory/status", &snapshot); snapshot.Observed != 0 {
+		t.Fatalf("unprefixed reset did not reset every incident: %+v", snapshot)
+	}
+
+	response, err = http.Get(admin + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exposition, _ := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+	for _, want := range []string{
+		`infernosim_stub_requests_total{dependency="PAYMENTS.test",result="matched",incident="payments"} 1`,
+		`infernosim_stub_requests_total{dependency="gateway.test",result="matched",incident="inventory"} 1`,
+	} {
+		if !strings.Contains(string(exposition), want) {
+			t.Fatalf("merged metrics missing %q:\n%s", want, exposition)
+		}
+	}
+	if strings.Count(string(exposition), "# TYPE infernosim_stub_requests_total counter") != 1 {
+		t.Fatalf("merged metrics repeat family headers:\n%s", exposition)
+	}
+}
+
+func TestNewRejectsConflictingIncidentRoutes(t *testing.T) {
+	for name, incidents := range map[string][]Incident{
+		"duplicate host":      {{Name: "a", Dir: "a", Hosts: []string{"x.test"}}, {Name: "b", Dir: "b", Hosts: []string{"X.test"}}},
+		"two defaults":        {{Name: "a", Dir: "a"}, {Name: "b", Dir: "b"}},
+		"relative prefix":     {{Name: "a", Dir: "a", PathPrefix: "api"}},
+		"name with separator": {{Name: "a/b", Dir: "a"}},
+	} {
+		if _, err := New(Options{Incidents: incidents}); err == nil {
+			t.Fatalf("%s: expected validation error", name)
+		}
+	}
 }
 
 func fetchProof(t *testing.T, admin string) Proof {
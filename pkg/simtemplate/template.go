@@ -36,8 +36,19 @@ type Request struct {
 	Body     string
 }
 
+// Scenario describes the scenario step being rendered. It is empty for
+// responses that do not come from a scenario.
+type Scenario struct {
+	Name      string
+	State     string
+	Partition string
+	Counters  map[string]int
+	Vars      map[string]string
+}
+
 type Data struct {
-	Request Request
+	Request  Request
+	Scenario Scenario
 }
 
 type Engine struct {
@@ -77,6 +88,8 @@ func validationFunctions() template.FuncMap {
 		"proto":    func(string) any { return "" },
 		"header":   func(string) string { return "" },
 		"query":    func(string) string { return "" },
+		"counter":  func(string) int { return 0 },
+		"var":      func(string) string { return "" },
 		"uuid":     func(string) string { return "" },
 		"token":    func(string) string { return "" },
 		"now":      func() string { return "" },
@@ -110,6 +123,12 @@ func (e *Engine) Render(name, value string, data Data) (string, error) {
 		"query": func(name string) string {
 			return data.Request.Query.Get(name)
 		},
+		"counter": func(name string) int {
+			return data.Scenario.Counters[name]
+		},
+		"var": func(name string) string {
+			return data.Scenario.Vars[name]
+		},
 		"uuid": func(label string) string {
 			sum := sha256.Sum256([]byte(fingerprint + "\x00uuid\x00" + label))
 			raw := append([]byte(nil), sum[:16]...)
@@ -189,6 +208,21 @@ func defaultValue(fallback, value any) any {
 	return value
 }
 
+// Fingerprint identifies the request in data under the engine's seed.
+func (e *Engine) Fingerprint(data Data) string {
+	return requestFingerprint(e.seed, data)
+}
+
+// Draw returns a value in [0, n) derived from the seed, the request, label
+// and occurrence, so every run with the same seed draws the same values.
+func (e *Engine) Draw(label string, data Data, occurrence, n int) int {
+	if n <= 0 {
+		return 0
+	}
+	sum := sha256.Sum256([]byte(requestFingerprint(e.seed, data) + "\x00draw\x00" + label + "\x00" + strconv.Itoa(occurrence)))
+	return int(binary.BigEndian.Uint64(sum[:8]) % uint64(n))
+}
+
 func requestFingerprint(seed string, data Data) string {
 	hash := sha256.New()
 	for _, value := range []string{
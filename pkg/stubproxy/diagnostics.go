@@ -0,0 +1,146 @@
+package stubproxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"infernosim/pkg/capture"
+	"infernosim/pkg/event"
+	"infernosim/pkg/matcher"
+)
+
+const (
+	// nearMissCandidates is how many captured calls are reported per miss.
+	nearMissCandidates = 3
+	// maxNearMisses bounds the misses kept for the status endpoint.
+	maxNearMisses = 20
+)
+
+// NearMiss is an unmatched request and the captured calls closest to it,
+// ranked by matcher.Distance. Values in the field diffs are filtered by the
+// stub's privacy settings.
+type NearMiss struct {
+	// Index is the outbound event index, as in the divergence message.
+	Index      int64                 `json:"index"`
+	Method     string                `json:"method"`
+	URL        string                `json:"url"`
+	Candidates []matcher.Explanation `json:"candidates,omitempty"`
+}
+
+// recordNearMiss ranks the captured calls against r and keeps the result for
+// the status endpoint.
+func (s *StubProxy) recordNearMiss(index int64, r *http.Request, body []byte) NearMiss {
+	s.matchMu.Lock()
+	events := append([]event.Event(nil), s.events...)
+	s.matchMu.Unlock()
+
+	candidates := s.semanticMatcher.NearMisses(events, r, body, nearMissCandidates)
+	for i := range candidates {
+		candidate := &candidates[i]
+		if candidate.Matched {
+			// Every predicate holds, so the call was already replayed as
+			// many times as the run allows.
+			candidate.Matched = false
+			candidate.Reason = "captured call already used"
+		}
+		if parsed, err := url.Parse(candidate.URL); err == nil {
+			candidate.URL = capture.SanitizeURL(parsed, s.privacy)
+		}
+		for j := range candidate.Diffs {
+			candidate.Diffs[j] = s.sanitizeDiff(candidate.Diffs[j])
+		}
+	}
+	target := *r.URL
+	if target.Host == "" {
+		target.Host = r.Host
+	}
+	miss := NearMiss{
+		Index:      index,
+		Method:     r.Method,
+		URL:        capture.SanitizeURL(&target, s.privacy),
+		Candidates: candidates,
+	}
+	s.mu.Lock()
+	s.nearMisses = append(s.nearMisses, miss)
+	if len(s.nearMisses) > maxNearMisses {
+		s.nearMisses = append([]NearMiss(nil), s.nearMisses[len(s.nearMisses)-maxNearMisses:]...)
+	}
+	s.mu.Unlock()
+	return miss
+}
+
+// NearMisses returns the most recent unmatched requests with their closest
+// captured candidates, oldest first.
+func (s *StubProxy) NearMisses() []NearMiss {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]NearMiss(nil), s.nearMisses...)
+}
+
+// sanitizeDiff filters the values of one diff the way capture filters the
+// same field. Regex patterns come from configuration and are kept.
+func (s *StubProxy) sanitizeDiff(diff matcher.FieldDiff) matcher.FieldDiff {
+	filter := func(value string, missing bool) string { return value }
+	switch diff.Field {
+	case matcher.FieldQuery:
+		filter = func(value string, missing bool) string {
+			if missing {
+				return value
+			}
+			filtered := capture.SanitizeURL(&url.URL{RawQuery: url.Values{diff.Name: {value}}.Encode()}, s.privacy)
+			parsed, err := url.Parse(filtered)
+			if err != nil {
+				return ""
+			}
+			return parsed.Query().Get(diff.Name)
+		}
+	case matcher.FieldHeader:
+		filter = func(value string, missing bool) string {
+			if missing {
+				return value
+			}
+			return capture.SanitizeHeaders(http.Header{diff.Name: {value}}, s.captureSensitive, s.privacy).Get(diff.Name)
+		}
+	case matcher.FieldJSONPath, matcher.FieldProtobuf:
+		filter = func(value string, missing bool) string {
+			if missing {
+				return value
+			}
+			if action, ok := s.privacy.JSONFieldRule(diff.Name); ok {
+				return s.privacy.ApplyValue(value, action)
+			}
+			if s.captureSensitive || s.privacy != nil && s.privacy.CaptureBodies {
+				return value
+			}
+			return fingerprint(value)
+		}
+	}
+	if !diff.Regex {
+		diff.Expected = filter(diff.Expected, diff.ExpectedMissing)
+	}
+	diff.Actual = filter(diff.Actual, diff.ActualMissing)
+	return diff
+}
+
+// fingerprint lets callers see whether two body values are equal without
+// revealing either of them.
+func fingerprint(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// writeMiss fails an unmatched request with the ranked candidates so the
+// caller can see why nothing matched without querying the control API.
+func writeMiss(w http.ResponseWriter, reason string, candidates []matcher.Explanation) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusBadGateway)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error      string                `json:"error"`
+		Reason     string                `json:"reason"`
+		NearMisses []matcher.Explanation `json:"near_misses,omitempty"`
+	}{Error: "unexpected outbound call", Reason: reason, NearMisses: candidates})
+}
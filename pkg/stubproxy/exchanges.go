@@ -0,0 +1,202 @@
+package stubproxy
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"infernosim/pkg/event"
+	"infernosim/pkg/scenario"
+)
+
+// ExchangeSummary identifies a captured exchange without exposing its
+// headers or payloads.
+type ExchangeSummary struct {
+	ID     string `json:"id"`
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+}
+
+// ValidateExchange reports whether evt can be served as a captured
+// dependency response. An empty Type is treated as OutboundCall.
+func ValidateExchange(evt event.Event) error {
+	if evt.Type != "" && evt.Type != "OutboundCall" {
+		return fmt.Errorf("exchange type %q is not OutboundCall", evt.Type)
+	}
+	if strings.TrimSpace(evt.Method) == "" {
+		return fmt.Errorf("exchange method is required")
+	}
+	parsed, err := url.Parse(evt.URL)
+	if err != nil {
+		return fmt.Errorf("exchange url: %w", err)
+	}
+	if !parsed.IsAbs() || parsed.Host == "" {
+		return fmt.Errorf("exchange url %q must be absolute", evt.URL)
+	}
+	if evt.Status != 0 && (evt.Status < 100 || evt.Status > 599) {
+		return fmt.Errorf("exchange status must be 100..599, or 0 to replay a captured error")
+	}
+	if _, err := base64.StdEncoding.DecodeString(evt.ResponseBodyB64); err != nil {
+		return fmt.Errorf("exchange responseBodyB64: %w", err)
+	}
+	if err := validateAlternatives(evt.ResponseAlternatives); err != nil {
+		return fmt.Errorf("exchange %w", err)
+	}
+	return nil
+}
+
+// validateAlternatives checks the weights, statuses, and bodies of weighted
+// response alternatives, whether programmed at runtime or read from an
+// outbound log.
+func validateAlternatives(alternatives []event.ResponseAlternative) error {
+	totalWeight := 0
+	for index, alternative := range alternatives {
+		if alternative.Weight < 1 || alternative.Weight > 100 {
+			return fmt.Errorf("responseAlternatives[%d].weight must be 1..100", index)
+		}
+		totalWeight += alternative.Weight
+		if alternative.Status < 100 || alternative.Status > 599 {
+			return fmt.Errorf("responseAlternatives[%d].status must be 100..599", index)
+		}
+		if _, err := base64.StdEncoding.DecodeString(alternative.ResponseBodyB64); err != nil {
+			return fmt.Errorf("responseAlternatives[%d].responseBodyB64: %w", index, err)
+		}
+	}
+	if totalWeight > 100 {
+		return fmt.Errorf("responseAlternatives weights sum to %d, more than 100", totalWeight)
+	}
+	return nil
+}
+
+// Exchanges lists the captured exchanges in match order.
+func (s *StubProxy) Exchanges() []ExchangeSummary {
+	s.matchMu.Lock()
+	defer s.matchMu.Unlock()
+	out := make([]ExchangeSummary, 0, len(s.events))
+	for _, evt := range s.events {
+		out = append(out, ExchangeSummary{ID: evt.ID, Method: evt.Method, URL: evt.URL, Status: evt.Status})
+	}
+	return out
+}
+
+// PutExchange validates evt and replaces the exchange with the same ID in
+// place, or appends it when the ID is new. An empty ID is generated. It
+// returns the stored exchange and whether an existing one was replaced.
+// Changes live in memory only; the incident's outbound.log is not modified.
+func (s *StubProxy) PutExchange(evt event.Event) (event.Event, bool, error) {
+	if err := ValidateExchange(evt); err != nil {
+		return event.Event{}, false, err
+	}
+	evt.Type = "OutboundCall"
+	if evt.ID == "" {
+		evt.ID = event.GenerateID()
+	}
+	if evt.Status != 0 {
+		evt.ResponseCaptured = true
+	}
+	s.matchMu.Lock()
+	defer s.matchMu.Unlock()
+	defer atomic.AddInt64(&s.revision, 1)
+	for index, existing := range s.events {
+		if existing.ID == evt.ID {
+			s.events[index] = evt
+			delete(s.eventUseCounts, index)
+			return evt, true, nil
+		}
+	}
+	s.events = append(s.events, evt)
+	return evt, false, nil
+}
+
+// RemoveExchange deletes the exchange with the given ID and reports whether
+// it existed.
+func (s *StubProxy) RemoveExchange(id string) bool {
+	if id == "" {
+		return false
+	}
+	s.matchMu.Lock()
+	defer s.matchMu.Unlock()
+	for index, existing := range s.events {
+		if existing.ID != id {
+			continue
+		}
+		s.events = append(s.events[:index:index], s.events[index+1:]...)
+		// Use counts are keyed by position, so shift the ones after index.
+		counts := make(map[int]int, len(s.eventUseCounts))
+		for position, count := range s.eventUseCounts {
+			switch {
+			case position < index:
+				counts[position] = count
+			case position > index:
+				counts[position-1] = count
+			}
+		}
+		s.eventUseCounts = counts
+		atomic.AddInt64(&s.revision, 1)
+		return true
+	}
+	return false
+}
+
+// Scenarios returns the configured scenarios in match order.
+func (s *StubProxy) Scenarios() []scenario.Config {
+	return s.scenarios.Configs()
+}
+
+// PutScenario validates cfg exactly as replay configuration loading does and
+// adds it, or replaces the scenario with the same name and resets its state.
+func (s *StubProxy) PutScenario(cfg scenario.Config) (bool, error) {
+	replaced, err := s.scenarios.Put(cfg)
+	if err != nil {
+		return false, err
+	}
+	atomic.AddInt64(&s.revision, 1)
+	return replaced, nil
+}
+
+// RemoveScenario deletes the named scenario and reports whether it existed.
+func (s *StubProxy) RemoveScenario(name string) bool {
+	if !s.scenarios.Remove(name) {
+		return false
+	}
+	atomic.AddInt64(&s.revision, 1)
+	return true
+}
+
+// ScenarioStates returns the current state of every scenario by name.
+func (s *StubProxy) ScenarioStates() map[string]string {
+	return s.scenarios.States()
+}
+
+// ScenarioPartitions returns the state and counters of every scenario
+// partition by scenario name and partition key.
+func (s *StubProxy) ScenarioPartitions() map[string]map[string]scenario.PartitionState {
+	return s.scenarios.Partitions()
+}
+
+// SetScenarioState forces the named scenario into state and records the
+// change as a forced transition. It returns the previous state and reports
+// whether the scenario exists. Reset restores the initial state.
+func (s *StubProxy) SetScenarioState(name, state string) (string, bool, error) {
+	return s.SetScenarioPartitionState(name, "", state)
+}
+
+// SetScenarioPartitionState is SetScenarioState for one partition of a
+// scenario with partition_by.
+func (s *StubProxy) SetScenarioPartitionState(name, partition, state string) (string, bool, error) {
+	previous, found, err := s.scenarios.SetPartitionState(name, partition, state)
+	if !found || err != nil {
+		return previous, found, err
+	}
+	s.recordTransition(Transition{Scenario: name, Partition: partition, From: previous, To: state, Forced: true})
+	return previous, true, nil
+}
+
+// Revision counts runtime changes to exchanges and scenarios. Reset does not
+// clear it because the changes themselves survive a reset.
+func (s *StubProxy) Revision() int {
+	return int(atomic.LoadInt64(&s.revision))
+}
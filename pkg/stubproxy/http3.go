@@ -0,0 +1,27 @@
+package stubproxy
+
+import (
+	"crypto/x509"
+	"net/http"
+
+	"infernosim/pkg/capture"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// NewHTTP3Server returns an HTTP/3 server for handler that presents a leaf
+// from ca for the SNI host of each connection and, when clientCAs is set,
+// requires a client certificate issued by one of them. HTTP/3 carries no proxy
+// request, so requests are rewritten to https URLs for their authority before
+// they reach handler, as for CONNECT-terminated HTTPS. Serve it on a UDP
+// listener the client resolves dependency hosts to.
+func NewHTTP3Server(handler http.Handler, ca *capture.CAStore, clientCAs *x509.CertPool) *http3.Server {
+	return &http3.Server{
+		TLSConfig: capture.RequireClientCertificates(ca.SNITLSConfig(), clientCAs),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.URL.Scheme = "https"
+			r.URL.Host = r.Host
+			handler.ServeHTTP(w, r)
+		}),
+	}
+}
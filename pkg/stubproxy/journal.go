@@ -0,0 +1,189 @@
+package stubproxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"infernosim/pkg/capture"
+	"infernosim/pkg/event"
+	"infernosim/pkg/matcher"
+	"infernosim/pkg/privacy"
+)
+
+// DefaultJournalSize is the number of requests kept when JournalOptions
+// does not set a capacity.
+const DefaultJournalSize = 1000
+
+// JournalOptions bounds and filters the request journal.
+type JournalOptions struct {
+	// Size is the number of most recent requests kept. Zero uses
+	// DefaultJournalSize and a negative value disables the journal.
+	Size int
+}
+
+// JournalEntry is one request served by the stub and how it was answered.
+// Only privacy-filtered data is kept, and the body only when sensitive data
+// capture or the policy's capture_bodies is set; otherwise BodySha256 alone
+// identifies it.
+type JournalEntry struct {
+	Sequence   int64       `json:"sequence"`
+	Time       time.Time   `json:"time"`
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Headers    http.Header `json:"headers,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BodySha256 string      `json:"body_sha256,omitempty"`
+	// ClientIdentity names the verified mTLS client certificate.
+	ClientIdentity string `json:"client_identity,omitempty"`
+	// Result is matched, scenario, recorded, unmatched, or invalid.
+	Result string `json:"result"`
+	// EventIndex and EventID identify the captured exchange that answered a
+	// matched request.
+	EventIndex *int   `json:"event_index,omitempty"`
+	EventID    string `json:"event_id,omitempty"`
+	Scenario   string `json:"scenario,omitempty"`
+	Step       string `json:"step,omitempty"`
+	// Alternative is the 1-based weighted alternative that answered, or 0
+	// for the primary response.
+	Alternative int    `json:"alternative,omitempty"`
+	MissReason  string `json:"miss_reason,omitempty"`
+}
+
+// JournalFilter selects journal entries. Rule uses the semantic matcher's
+// method, host, path, query, header, JSONPath, and client identity selectors
+// and is applied to the filtered request as stored. Result, when set, must
+// equal the entry's result.
+type JournalFilter struct {
+	Rule   matcher.Rule
+	Result string
+}
+
+type journal struct {
+	mu       sync.Mutex
+	entries  []JournalEntry
+	next     int64
+	capacity int
+	privacy  *privacy.Policy
+	// captureSensitive keeps credential headers unredacted and request
+	// bodies.
+	captureSensitive bool
+}
+
+func newJournal(opts JournalOptions, policy *privacy.Policy, captureSensitive bool) *journal {
+	capacity := opts.Size
+	if capacity == 0 {
+		capacity = DefaultJournalSize
+	}
+	if capacity < 0 {
+		return nil
+	}
+	return &journal{capacity: capacity, privacy: policy, captureSensitive: captureSensitive}
+}
+
+// entry builds a privacy-filtered entry for r; the caller fills in the
+// outcome before calling add.
+func (j *journal) entry(r *http.Request, body []byte) JournalEntry {
+	entry := JournalEntry{
+		Time:    time.Now().UTC(),
+		Method:  r.Method,
+		Headers: capture.SanitizeHeaders(r.Header, j.captureSensitive, j.privacy),
+		// The identity names a certificate rather than carrying a secret.
+		ClientIdentity: event.ClientIdentity(r.TLS),
+	}
+	target := *r.URL
+	if target.Host == "" {
+		target.Host = r.Host
+	}
+	if target.Scheme == "" {
+		target.Scheme = "http"
+		if r.TLS != nil {
+			target.Scheme = "https"
+		}
+	}
+	entry.URL = capture.SanitizeURL(&target, j.privacy)
+	if len(body) > 0 {
+		filtered, err := j.privacy.ApplyBody(body)
+		if err == nil {
+			if j.captureSensitive || j.privacy != nil && j.privacy.CaptureBodies {
+				entry.Body = string(filtered)
+			}
+			hash := sha256.Sum256(filtered)
+			entry.BodySha256 = hex.EncodeToString(hash[:])
+		}
+	}
+	return entry
+}
+
+func (j *journal) add(entry JournalEntry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.next++
+	entry.Sequence = j.next
+	if len(j.entries) == j.capacity {
+		copy(j.entries, j.entries[1:])
+		j.entries[len(j.entries)-1] = entry
+		return
+	}
+	j.entries = append(j.entries, entry)
+}
+
+func (j *journal) reset() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = nil
+}
+
+func (j *journal) snapshot() []JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]JournalEntry(nil), j.entries...)
+}
+
+// Journal returns the retained requests that match filter, oldest first.
+// Reset clears the journal.
+func (s *StubProxy) Journal(filter JournalFilter) ([]JournalEntry, error) {
+	if s.journal == nil {
+		return nil, fmt.Errorf("request journal is disabled")
+	}
+	// Stored entries have no TLS state, so the client identity is matched
+	// against the recorded name instead of by the rule.
+	var identity *regexp.Regexp
+	if pattern := filter.Rule.ClientIdentityRegex; pattern != "" {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("journal filter: client_identity_regex: %w", err)
+		}
+		identity = compiled
+		filter.Rule.ClientIdentityRegex = ""
+	}
+	rule, err := matcher.CompileRule(filter.Rule, matcher.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("journal filter: %w", err)
+	}
+	var out []JournalEntry
+	for _, entry := range s.journal.snapshot() {
+		if filter.Result != "" && entry.Result != filter.Result {
+			continue
+		}
+		if identity != nil && !identity.MatchString(entry.ClientIdentity) {
+			continue
+		}
+		request, err := http.NewRequest(entry.Method, entry.URL, nil)
+		if err != nil {
+			continue
+		}
+		request.Header = entry.Headers.Clone()
+		if request.Header == nil {
+			request.Header = http.Header{}
+		}
+		if matched, _ := rule.Match(request, []byte(entry.Body)); matched {
+			out = append(out, entry)
+		}
+	}
+	return out, nil
+}
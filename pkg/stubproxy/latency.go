@@ -0,0 +1,103 @@
+package stubproxy
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"infernosim/pkg/event"
+)
+
+const (
+	// LatencyCaptured waits for each matched exchange's captured duration.
+	LatencyCaptured = "captured"
+	// LatencySampled waits for a duration drawn from the exchanges captured
+	// for the same method, host, and path.
+	LatencySampled = "sampled"
+)
+
+// LatencyOptions replays captured dependency latency before a captured
+// response is written. Scenario responses are not delayed.
+type LatencyOptions struct {
+	// Mode is empty or "off" to answer immediately, LatencyCaptured, or
+	// LatencySampled.
+	Mode string
+	// Scale multiplies every delay, like the replay time scale; 0 means 1.
+	Scale float64
+}
+
+// Validate reports whether the mode and scale are usable.
+func (o LatencyOptions) Validate() error {
+	switch o.Mode {
+	case "", "off", LatencyCaptured, LatencySampled:
+	default:
+		return fmt.Errorf("latency mode %q must be off, %s, or %s", o.Mode, LatencyCaptured, LatencySampled)
+	}
+	if math.IsNaN(o.Scale) || math.IsInf(o.Scale, 0) || o.Scale < 0 {
+		return fmt.Errorf("latency scale must be a finite number >= 0")
+	}
+	return nil
+}
+
+func (o LatencyOptions) enabled() bool {
+	return o.Mode == LatencyCaptured || o.Mode == LatencySampled
+}
+
+// capturedLatency returns how long to wait before answering r with
+// expected. Sampled draws are deterministic like weighted responses.
+func (s *StubProxy) capturedLatency(r *http.Request, body []byte, expected event.Event) time.Duration {
+	if !s.latency.enabled() {
+		return 0
+	}
+	duration := expected.Duration
+	if s.latency.Mode == LatencySampled {
+		endpoint := latencyEndpoint(expected.Method, expected.URL)
+		var durations []time.Duration
+		s.matchMu.Lock()
+		for _, candidate := range s.events {
+			if candidate.Duration > 0 && latencyEndpoint(candidate.Method, candidate.URL) == endpoint {
+				durations = append(durations, candidate.Duration)
+			}
+		}
+		s.matchMu.Unlock()
+		if len(durations) > 0 {
+			duration = durations[s.draw("latency\x00"+endpoint, s.templateData(r, body), len(durations))]
+		}
+	}
+	if duration <= 0 {
+		return 0
+	}
+	scale := s.latency.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	return time.Duration(float64(duration) * scale)
+}
+
+// latencyEndpoint groups captured calls by method, host, and path.
+func latencyEndpoint(method, rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return strings.ToUpper(method) + " " + rawURL
+	}
+	return strings.ToUpper(method) + " " + strings.ToLower(parsed.Host) + parsed.EscapedPath()
+}
+
+// waitLatency sleeps for delay and reports false if the client went away
+// first.
+func waitLatency(r *http.Request, delay time.Duration) bool {
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
@@ -0,0 +1,72 @@
+package stubproxy
+
+import (
+	"time"
+
+	"infernosim/pkg/metrics"
+)
+
+// Match results used as the "result" label of stub request metrics.
+const (
+	resultMatched   = "matched"
+	resultScenario  = "scenario"
+	resultUnmatched = "unmatched"
+	resultInvalid   = "invalid"
+	// resultRecorded marks unmatched requests forwarded by record-on-miss.
+	resultRecorded = "recorded"
+)
+
+// stubMetrics are the Prometheus series exported by a StubProxy. Counters are
+// cumulative for the life of the proxy and are intentionally not cleared by
+// Reset, which only rewinds replay state.
+type stubMetrics struct {
+	registry    *metrics.Registry
+	requests    *metrics.CounterVec
+	divergences *metrics.CounterVec
+	transitions *metrics.CounterVec
+	faults      *metrics.CounterVec
+	latency     *metrics.HistogramVec
+}
+
+func newStubMetrics() *stubMetrics {
+	registry := metrics.NewRegistry()
+	return &stubMetrics{
+		registry: registry,
+		requests: registry.Counter(
+			"infernosim_stub_requests_total",
+			"Dependency requests served by the stub, by dependency host and match result.",
+			"dependency", "result",
+		),
+		divergences: registry.Counter(
+			"infernosim_stub_divergences_total",
+			"Divergences from the captured outbound sequence, by reason.",
+			"reason",
+		),
+		transitions: registry.Counter(
+			"infernosim_scenario_transitions_total",
+			"Scenario steps served, by scenario and state transition.",
+			"scenario", "from", "to",
+		),
+		faults: registry.Counter(
+			"infernosim_injected_faults_total",
+			"Faults injected into dependency responses, by dependency host and fault kind.",
+			"dependency", "fault",
+		),
+		latency: registry.Histogram(
+			"infernosim_stub_response_duration_seconds",
+			"Time to serve a dependency response, including injected latency.",
+			nil,
+			"dependency", "result",
+		),
+	}
+}
+
+func (m *stubMetrics) observe(dependency, result string, elapsed time.Duration) {
+	m.requests.Inc(dependency, result)
+	m.latency.Observe(elapsed.Seconds(), dependency, result)
+}
+
+// Metrics returns the proxy's metric registry for exposition on /metrics.
+func (s *StubProxy) Metrics() *metrics.Registry {
+	return s.metrics.registry
+}
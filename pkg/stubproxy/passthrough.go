@@ -0,0 +1,79 @@
+package stubproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+
+	"infernosim/pkg/capture"
+	"infernosim/pkg/event"
+	"infernosim/pkg/privacy"
+)
+
+// RecordOnMiss configures hybrid mode: requests that match no captured call
+// or scenario are forwarded to a live upstream instead of failing, and the
+// exchange is appended to the incident's outbound log so the next run can
+// replay it.
+type RecordOnMiss struct {
+	// Upstream receives unmatched requests, keeping their path and query.
+	// Nil forwards to the host the application originally requested.
+	Upstream *url.URL
+	// AllowPrivateDestinations permits forwarding to loopback and private
+	// addresses. An explicit Upstream is always allowed because the operator
+	// chose it, which is how a local stand-in is used.
+	AllowPrivateDestinations bool
+	AllowInsecureUpstream    bool
+}
+
+type passthrough struct {
+	upstream *url.URL
+	ctx      *capture.ProxyContext
+}
+
+// newPassthrough stores recorded exchanges under policy and captureSensitive
+// exactly as capture does. Without either, bodies are fingerprinted but not
+// stored.
+func newPassthrough(outboundLog string, opts *RecordOnMiss, policy *privacy.Policy, captureSensitive bool) (*passthrough, error) {
+	if opts == nil {
+		return nil, nil
+	}
+	if outboundLog == "" {
+		return nil, fmt.Errorf("record-on-miss requires an outbound log")
+	}
+	if opts.Upstream != nil && (opts.Upstream.Scheme != "http" && opts.Upstream.Scheme != "https" || opts.Upstream.Host == "") {
+		return nil, fmt.Errorf("record-on-miss upstream %q must be an absolute http or https URL", opts.Upstream)
+	}
+	logger, err := event.NewLogger(outboundLog)
+	if err != nil {
+		return nil, fmt.Errorf("open outbound log for record-on-miss: %w", err)
+	}
+	return &passthrough{
+		upstream: opts.Upstream,
+		ctx: &capture.ProxyContext{
+			Logger:                   logger,
+			AllowInsecureUpstream:    opts.AllowInsecureUpstream,
+			AllowPrivateDestinations: opts.AllowPrivateDestinations || opts.Upstream != nil,
+			CaptureSensitiveData:     captureSensitive,
+			Privacy:                  policy,
+		},
+	}, nil
+}
+
+// servePassthrough forwards an unmatched request and records the exchange.
+// It reports false when record-on-miss is disabled so the caller can fail the
+// request as a divergence.
+func (s *StubProxy) servePassthrough(w http.ResponseWriter, r *http.Request) bool {
+	if s.passthrough == nil {
+		return false
+	}
+	capture.ForwardAndRecord(w, r, s.passthrough.upstream, s.passthrough.ctx)
+	atomic.AddInt64(&s.recorded, 1)
+	return true
+}
+
+// RecordedCount returns how many unmatched requests were forwarded and
+// appended to the outbound log since the last Reset.
+func (s *StubProxy) RecordedCount() int {
+	return int(atomic.LoadInt64(&s.recorded))
+}
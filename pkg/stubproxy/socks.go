@@ -0,0 +1,77 @@
+package stubproxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"infernosim/pkg/capture"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// SOCKSConnect serves SOCKS5 clients of the stub, for use with
+// capture.ListenSOCKS5. Plain HTTP is answered from the capture like proxy
+// requests, and TLS is terminated as for HTTP CONNECT when HTTPS stubbing
+// allows the host. Host policies that tunnel or block a host apply as they do
+// for CONNECT. Any other protocol replays a captured TCP stream.
+func (s *StubProxy) SOCKSConnect(dest string) func(net.Conn) {
+	host, _, err := net.SplitHostPort(dest)
+	if err != nil {
+		return nil
+	}
+	host = strings.Trim(host, "[]")
+	if s.tlsCA != nil {
+		if policy, ok := s.tlsCA.Hosts.Lookup(host); ok && policy.Action != capture.HostIntercept {
+			if policy.Action == capture.HostBlock {
+				return nil
+			}
+			return func(conn net.Conn) {
+				capture.TunnelConn(conn, dest, s.tunnelContext(policy))
+			}
+		}
+	}
+	return func(conn net.Conn) {
+		conn, protocol := capture.SniffConn(conn)
+		switch {
+		case protocol == capture.ProtocolHTTP:
+			s.serveHTTPConn(conn, dest)
+		case protocol == capture.ProtocolTLS && s.tlsCA != nil && s.tlsCA.IsAllowed(host):
+			s.serveTLS(conn, host, dest)
+		case protocol == capture.ProtocolTLS:
+			s.recordObserved("UNKNOWN", dest, "", 0)
+			s.refuseConn(conn, dest, protocol, "https_stub_not_allowed")
+		default:
+			s.serveOpaque(conn, dest, protocol)
+		}
+	}
+}
+
+// serveHTTPConn answers plain HTTP/1.x and h2c requests a client sends over
+// a connection it opened to dest. Requests are routed by their Host header and
+// fall back to dest.
+func (s *StubProxy) serveHTTPConn(conn net.Conn, dest string) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !r.URL.IsAbs() {
+			r.URL.Scheme = "http"
+			r.URL.Host = r.Host
+			if r.URL.Host == "" {
+				r.URL.Host = dest
+			}
+		}
+		s.ServeHTTP(w, r)
+	})
+	server := &http.Server{
+		Handler:           h2c.NewHandler(handler, &http2.Server{}),
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	if err := server.Serve(&tlsSingleConnListener{conn: conn}); err != nil && err != http.ErrServerClosed && err != io.EOF {
+		fmt.Fprintf(os.Stderr, "SOCKS5 stub connection error: %v\n", err)
+	}
+}
@@ -1,16 +1,18 @@
 package stubproxy
 
 import (
-	"bufio"
 	"bytes"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"infernosim/pkg/capture"
 	"infernosim/pkg/event"
 	"infernosim/pkg/inject"
 	"infernosim/pkg/matcher"
+	"infernosim/pkg/privacy"
 	"infernosim/pkg/scenario"
 	"infernosim/pkg/simtemplate"
 
@@ -21,6 +23,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -59,6 +62,22 @@ type StubProxy struct {
 	scenarios       *scenario.Engine
 	templates       *simtemplate.Engine
 	tlsCA           *capture.CAStore
+	clientCAs       *x509.CertPool
+	allowPrivate    bool
+	metrics         *stubMetrics
+	passthrough     *passthrough
+	recorded        int64
+	journal         *journal
+	revision        int64
+	nearMisses      []NearMiss
+	transitions     []Transition
+	// draws counts weighted choices per label and request fingerprint.
+	draws   map[string]int
+	latency LatencyOptions
+	streams *tcpStreams
+
+	privacy          *privacy.Policy
+	captureSensitive bool
 }
 
 type Options struct {
@@ -66,6 +85,30 @@ type Options struct {
 	Scenarios []scenario.Config
 	Templates simtemplate.Config
 	TLSCA     *capture.CAStore
+	// ClientCAs, when set with TLSCA, makes HTTPS stubbing require a client
+	// certificate issued by one of these CAs.
+	ClientCAs *x509.CertPool
+	// AllowPrivateDestinations lets hosts a tunnel policy matches by glob,
+	// suffix, or * reach loopback and private addresses. A policy naming
+	// the host exactly always may.
+	AllowPrivateDestinations bool
+	// RecordOnMiss, when set, forwards unmatched requests to a live upstream
+	// and appends them to the outbound log instead of failing them.
+	RecordOnMiss *RecordOnMiss
+	// Journal bounds the request journal used by verification queries.
+	Journal JournalOptions
+	// Privacy filters everything the stub stores or reports about live
+	// requests: the journal, near-miss diagnostics, and record-on-miss
+	// exchanges. Credential headers are redacted and unmatched body values
+	// are hashed unless CaptureSensitiveData is set.
+	Privacy              *privacy.Policy
+	CaptureSensitiveData bool
+	// Latency replays captured dependency latency. It also delays the
+	// server replies of replayed TCP streams.
+	Latency LatencyOptions
+	// StreamMasks exclude byte ranges of client messages from TCP stream
+	// matching.
+	StreamMasks []StreamMask
 }
 
 // Snapshot is a point-in-time, race-safe view of a running simulator. It is
@@ -76,9 +119,23 @@ type Snapshot struct {
 	Observed    int      `json:"observed"`
 	Divergences []string `json:"divergences,omitempty"`
 	Unexpected  bool     `json:"unexpected"`
+	Recorded    int      `json:"recorded,omitempty"`
+	// NearMisses lists the most recent unmatched requests with the closest
+	// captured calls, privacy-filtered like the journal.
+	NearMisses []NearMiss `json:"near_misses,omitempty"`
+	// Transitions lists scenario state changes since the last reset, in
+	// order, so a proof shows the path the run took.
+	Transitions []Transition `json:"transitions,omitempty"`
+	// Revision counts exchanges and scenarios changed at runtime.
+	Revision int `json:"revision,omitempty"`
 }
 
 func LoadOutboundEvents(path string) ([]event.Event, error) {
+	return loadEvents(path, "OutboundCall")
+}
+
+// loadEvents reads the events of eventType from an outbound log.
+func loadEvents(path, eventType string) ([]event.Event, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -96,7 +153,7 @@ func LoadOutboundEvents(path string) ([]event.Event, error) {
 			}
 			return nil, fmt.Errorf("outbound log parse error: %w", err)
 		}
-		if e.Type == "OutboundCall" {
+		if e.Type == eventType {
 			out = append(out, e)
 		}
 	}
@@ -116,6 +173,11 @@ func NewWithOptions(outboundLog string, observedLog string, rules []inject.Rule,
 		}
 		evs = nil
 	}
+	for index, evt := range evs {
+		if err := validateAlternatives(evt.ResponseAlternatives); err != nil {
+			return nil, fmt.Errorf("outbound event %d: %w", index, err)
+		}
+	}
 	var observedLogger *event.Logger
 	if observedLog != "" {
 		observedLogger, err = event.NewLogger(observedLog)
@@ -140,19 +202,46 @@ func NewWithOptions(outboundLog string, observedLog string, rules []inject.Rule,
 	if err != nil {
 		return nil, err
 	}
+	if err := opts.Latency.Validate(); err != nil {
+		return nil, err
+	}
+	streams, err := newTCPStreams(outboundLog, opts.StreamMasks)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if streams == nil {
+		streams = &tcpStreams{}
+	}
+	passthrough, err := newPassthrough(outboundLog, opts.RecordOnMiss, opts.Privacy, opts.CaptureSensitiveData)
+	if err != nil {
+		return nil, err
+	}
+	if passthrough != nil && opts.TLSCA != nil {
+		// Recorded misses follow the per-host privacy and body limits.
+		passthrough.ctx.Hosts = opts.TLSCA.Hosts
+	}
 	return &StubProxy{
-		events:          evs,
-		rules:           rules,
-		attempts:        map[string]int{},
-		observedLogger:  observedLogger,
-		eventsByKey:     eventsByKey,
-		matchCounts:     make(map[string]int),
-		matchMultiplier: 1,
-		semanticMatcher: semanticMatcher,
-		eventUseCounts:  make(map[int]int),
-		scenarios:       scenarioEngine,
-		templates:       templateEngine,
-		tlsCA:           opts.TLSCA,
+		events:           evs,
+		rules:            rules,
+		attempts:         map[string]int{},
+		observedLogger:   observedLogger,
+		eventsByKey:      eventsByKey,
+		matchCounts:      make(map[string]int),
+		matchMultiplier:  1,
+		semanticMatcher:  semanticMatcher,
+		eventUseCounts:   make(map[int]int),
+		scenarios:        scenarioEngine,
+		templates:        templateEngine,
+		tlsCA:            opts.TLSCA,
+		clientCAs:        opts.ClientCAs,
+		allowPrivate:     opts.AllowPrivateDestinations,
+		metrics:          newStubMetrics(),
+		passthrough:      passthrough,
+		journal:          newJournal(opts.Journal, opts.Privacy, opts.CaptureSensitiveData),
+		privacy:          opts.Privacy,
+		captureSensitive: opts.CaptureSensitiveData,
+		latency:          opts.Latency,
+		streams:          streams,
 	}, nil
 }
 
@@ -162,11 +251,15 @@ func (s *StubProxy) Reset() {
 	atomic.StoreInt64(&s.i, 0)
 	atomic.StoreInt64(&s.seen, 0)
 	atomic.StoreInt64(&s.maxSeen, 0)
+	atomic.StoreInt64(&s.recorded, 0)
 	s.attemptsMu.Lock()
 	s.attempts = map[string]int{}
 	s.attemptsMu.Unlock()
 	s.mu.Lock()
 	s.divergenceReasons = nil
+	s.nearMisses = nil
+	s.transitions = nil
+	s.draws = nil
 	s.unexpectedOutbound = false
 	s.mu.Unlock()
 	s.matchMu.Lock()
@@ -174,6 +267,10 @@ func (s *StubProxy) Reset() {
 	s.eventUseCounts = make(map[int]int)
 	s.matchMu.Unlock()
 	s.scenarios.Reset()
+	s.streams.reset()
+	if s.journal != nil {
+		s.journal.reset()
+	}
 }
 
 // ConfigureReplayCardinality controls how many outbound events this run may observe.
@@ -218,10 +315,20 @@ func (s *StubProxy) divergence(expected event.Event, got *http.Request, idx int6
 		expected.Method, expected.URL,
 		got.Method, got.URL.String(), got.Host,
 	)
+	s.recordDivergence(why, msg, false)
+}
+
+// recordDivergence logs and stores a divergence message and counts it under
+// reason. unexpected marks the run as having an unexpected outbound call.
+func (s *StubProxy) recordDivergence(reason, msg string, unexpected bool) {
 	fmt.Fprintln(os.Stderr, msg)
 	s.mu.Lock()
 	s.divergenceReasons = append(s.divergenceReasons, msg)
+	if unexpected {
+		s.unexpectedOutbound = true
+	}
 	s.mu.Unlock()
+	s.metrics.divergences.Inc(reason)
 }
 
 func (s *StubProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -234,12 +341,35 @@ func (s *StubProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	start := time.Now()
+	dep := depKey(r)
+	result := resultInvalid
+	var body []byte
+	// outcome collects the journal details of how the request was answered.
+	var outcome JournalEntry
+	defer func() {
+		s.metrics.observe(dep, result, time.Since(start))
+		if s.journal != nil {
+			entry := s.journal.entry(r, body)
+			entry.Time = start.UTC()
+			entry.Result = result
+			entry.EventIndex, entry.EventID = outcome.EventIndex, outcome.EventID
+			entry.Scenario, entry.Step = outcome.Scenario, outcome.Step
+			entry.Alternative = outcome.Alternative
+			entry.MissReason = outcome.MissReason
+			s.journal.add(entry)
+		}
+	}()
+
 	body, err := io.ReadAll(io.LimitReader(r.Body, 16*1024*1024+1))
 	if err != nil {
+		outcome.MissReason = "unreadable_body"
 		http.Error(w, "could not read request body", http.StatusBadRequest)
 		return
 	}
 	if len(body) > 16*1024*1024 {
+		outcome.MissReason = "body_too_large"
+		body = nil
 		http.Error(w, "request body exceeds 16 MiB safety limit", http.StatusRequestEntityTooLarge)
 		return
 	}
@@ -255,59 +385,53 @@ func (s *StubProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	maxSeen := atomic.LoadInt64(&s.maxSeen)
 	if maxSeen > 0 && seen > maxSeen {
+		outcome.MissReason = "unexpected_outbound_call"
+		if s.servePassthrough(w, r) {
+			result = resultRecorded
+			return
+		}
+		result = resultUnmatched
 		msg := fmt.Sprintf("DIVERGENCE at outbound event index=%d why=unexpected_outbound_call", seen-1)
-		fmt.Fprintln(os.Stderr, msg)
-		s.mu.Lock()
-		s.divergenceReasons = append(s.divergenceReasons, msg)
-		s.unexpectedOutbound = true
-		s.mu.Unlock()
+		s.recordDivergence("unexpected_outbound_call", msg, true)
 		http.Error(w, "unexpected outbound call", http.StatusBadGateway)
 		return
 	}
-	if result, matched := s.scenarios.Match(r, body); matched {
-		data := s.templateData(r, body)
-		headers, renderErr := s.templates.RenderHeader("headers", http.Header(result.Response.Headers), data)
-		if renderErr != nil {
-			http.Error(w, "scenario response header template failed: "+renderErr.Error(), http.StatusBadGateway)
-			return
-		}
-		trailers, renderErr := s.templates.RenderHeader("trailers", http.Header(result.Response.Trailers), data)
-		if renderErr != nil {
-			http.Error(w, "scenario response trailer template failed: "+renderErr.Error(), http.StatusBadGateway)
-			return
-		}
-		headers.Set("X-Inferno-Scenario", result.Scenario)
-		grpcStatus := result.Response.GRPCStatus
-		if isGRPCRequest(r) && grpcStatus == "" {
-			grpcStatus = "0"
-		}
-		if grpcStatus != "" {
-			grpcStatus = normalizeGRPCStatus(grpcStatus)
-		}
-		chunks, bodyErr := s.renderScenarioBody(result.Response, r, body, data)
-		if bodyErr != nil {
-			http.Error(w, "scenario response body is invalid: "+bodyErr.Error(), http.StatusBadGateway)
-			return
+	if scenarioResult, matched := s.scenarios.Match(r, body); matched {
+		result = resultScenario
+		outcome.Scenario, outcome.Step = scenarioResult.Scenario, scenarioResult.Step
+		s.metrics.transitions.Inc(scenarioResult.Scenario, scenarioResult.FromState, scenarioResult.ToState)
+		if scenarioResult.FromState != scenarioResult.ToState {
+			s.recordTransition(Transition{
+				Scenario:  scenarioResult.Scenario,
+				Partition: scenarioResult.Partition,
+				Step:      scenarioResult.Step,
+				From:      scenarioResult.FromState,
+				To:        scenarioResult.ToState,
+			})
 		}
-		if result.Response.ProtobufJSON != "" || len(result.Response.ProtobufStream) > 0 {
-			if headers.Get("Content-Type") == "" {
-				headers.Set("Content-Type", "application/grpc")
-			}
-		}
-		var delay time.Duration
-		if result.Response.StreamMessageDelay != "" {
-			delay, _ = time.ParseDuration(result.Response.StreamMessageDelay)
-		}
-		writeStubResponseChunks(w, result.Response.Status, headers, trailers, grpcStatus, chunks, delay)
+		outcome.Alternative = s.chooseScenarioResponse(r, body, &scenarioResult)
+		s.serveScenario(w, r, body, scenarioResult)
 		return
 	}
-	if len(s.events) == 0 {
+	if s.ExpectedCount() == 0 {
+		outcome.MissReason = "no_captured_events"
+		if s.servePassthrough(w, r) {
+			result = resultRecorded
+			return
+		}
+		result = resultUnmatched
 		http.Error(w, "no captured outbound events or matching scenario", http.StatusBadGateway)
 		return
 	}
 
-	expected, _, matched := s.matchExpected(r, body)
+	expected, index, matched := s.matchExpected(r, body)
 	if !matched {
+		outcome.MissReason = "no_matching_captured_call"
+		if s.servePassthrough(w, r) {
+			result = resultRecorded
+			return
+		}
+		result = resultUnmatched
 		msg := fmt.Sprintf(
 			"DIVERGENCE at outbound event index=%d why=no_matching_captured_call got={method=%s url=%s host=%s}",
 			seen-1,
@@ -315,16 +439,66 @@ func (s *StubProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			r.URL.String(),
 			r.Host,
 		)
-		fmt.Fprintln(os.Stderr, msg)
-		s.mu.Lock()
-		s.divergenceReasons = append(s.divergenceReasons, msg)
-		s.unexpectedOutbound = true
-		s.mu.Unlock()
-		http.Error(w, "unexpected outbound call", http.StatusBadGateway)
+		s.recordDivergence("no_matching_captured_call", msg, true)
+		miss := s.recordNearMiss(seen-1, r, body)
+		writeMiss(w, "no_matching_captured_call", miss.Candidates)
 		return
 	}
+	result = resultMatched
+	eventIndex := int(index)
+	outcome.EventIndex, outcome.EventID = &eventIndex, expected.ID
+	outcome.Alternative = s.chooseCapturedResponse(r, body, &expected)
+	s.serveCaptured(w, r, dep, expected, s.capturedLatency(r, body, expected))
+}
 
-	dep := depKey(r)
+func (s *StubProxy) serveScenario(w http.ResponseWriter, r *http.Request, body []byte, result scenario.Result) {
+	data := s.templateData(r, body)
+	data.Scenario = simtemplate.Scenario{
+		Name:      result.Scenario,
+		State:     result.ToState,
+		Partition: result.Partition,
+		Counters:  result.Counters,
+		Vars:      result.Vars,
+	}
+	headers, renderErr := s.templates.RenderHeader("headers", http.Header(result.Response.Headers), data)
+	if renderErr != nil {
+		http.Error(w, "scenario response header template failed: "+renderErr.Error(), http.StatusBadGateway)
+		return
+	}
+	trailers, renderErr := s.templates.RenderHeader("trailers", http.Header(result.Response.Trailers), data)
+	if renderErr != nil {
+		http.Error(w, "scenario response trailer template failed: "+renderErr.Error(), http.StatusBadGateway)
+		return
+	}
+	headers.Set("X-Inferno-Scenario", result.Scenario)
+	grpcStatus := result.Response.GRPCStatus
+	if isGRPCRequest(r) && grpcStatus == "" {
+		grpcStatus = "0"
+	}
+	if grpcStatus != "" {
+		grpcStatus = normalizeGRPCStatus(grpcStatus)
+	}
+	chunks, bodyErr := s.renderScenarioBody(result.Response, r, body, data)
+	if bodyErr != nil {
+		http.Error(w, "scenario response body is invalid: "+bodyErr.Error(), http.StatusBadGateway)
+		return
+	}
+	if result.Response.ProtobufJSON != "" || len(result.Response.ProtobufStream) > 0 {
+		if headers.Get("Content-Type") == "" {
+			headers.Set("Content-Type", "application/grpc")
+		}
+		s.scenarios.CaptureResponse(result, headers, nil)
+	} else {
+		s.scenarios.CaptureResponse(result, headers, chunks[0])
+	}
+	var delay time.Duration
+	if result.Response.StreamMessageDelay != "" {
+		delay, _ = time.ParseDuration(result.Response.StreamMessageDelay)
+	}
+	writeStubResponseChunks(w, result.Response.Status, headers, trailers, grpcStatus, chunks, delay)
+}
+
+func (s *StubProxy) serveCaptured(w http.ResponseWriter, r *http.Request, dep string, expected event.Event, latency time.Duration) {
 	s.attemptsMu.Lock()
 	s.attempts[dep]++
 	attemptCount := s.attempts[dep]
@@ -334,6 +508,7 @@ func (s *StubProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// --- TIMEOUT INJECTION ---
 	if rule != nil && rule.Timeout > 0 {
+		s.metrics.faults.Inc(dep, "timeout")
 		time.Sleep(rule.Timeout)
 		http.Error(w, "injected timeout", http.StatusGatewayTimeout)
 		return
@@ -341,17 +516,24 @@ func (s *StubProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// --- LATENCY INJECTION ---
 	if rule != nil && rule.AddLatency > 0 {
+		s.metrics.faults.Inc(dep, "latency")
 		time.Sleep(rule.AddLatency)
 	}
 
 	// --- RETRY COUNT MODIFICATION ---
 	if rule != nil && rule.RetryLimit >= 0 {
 		if attemptCount <= rule.RetryLimit {
+			s.metrics.faults.Inc(dep, "retry_failure")
 			http.Error(w, "injected retry-failure", http.StatusBadGateway)
 			return
 		}
 	}
 
+	// --- CAPTURED LATENCY ---
+	if !waitLatency(r, latency) {
+		return
+	}
+
 	// --- DEFAULT: replay captured outcome ---
 	status := expected.Status
 	if status == 0 {
@@ -557,6 +739,14 @@ func (s *StubProxy) serveTLSConnect(w http.ResponseWriter, r *http.Request) {
 		host = parsedHost
 	}
 	host = strings.Trim(host, "[]")
+	if policy, ok := s.tlsCA.Hosts.Lookup(host); ok && policy.Action != capture.HostIntercept {
+		if policy.Action == capture.HostTunnel {
+			capture.TunnelConnect(w, r, s.tunnelContext(policy))
+			return
+		}
+		http.Error(w, "HTTPS stub host is blocked by policy", http.StatusForbidden)
+		return
+	}
 	if !s.tlsCA.IsAllowed(host) {
 		http.Error(w, "HTTPS stub host is not allowlisted", http.StatusForbidden)
 		return
@@ -574,25 +764,47 @@ func (s *StubProxy) serveTLSConnect(w http.ResponseWriter, r *http.Request) {
 		_ = clientConn.Close()
 		return
 	}
+	s.serveTLS(clientConn, host, r.Host)
+}
+
+// tunnelContext returns the context relaying a connection a tunnel policy
+// matched. Only a policy naming the host exactly, or AllowPrivateDestinations,
+// lets the relay reach loopback and private addresses, so a glob does not turn
+// the stub into an open relay.
+func (s *StubProxy) tunnelContext(policy capture.HostPolicy) *capture.ProxyContext {
+	return &capture.ProxyContext{AllowPrivateDestinations: s.allowPrivate || policy.Exact()}
+}
+
+// serveTLS terminates TLS on a client connection opened to destination with a
+// leaf for host and answers the decrypted requests from the capture.
+func (s *StubProxy) serveTLS(clientConn net.Conn, host, destination string) {
 	cert, err := s.tlsCA.GenerateLeafCert(host)
 	if err != nil {
 		_ = clientConn.Close()
 		return
 	}
-	tlsConn := tls.Server(clientConn, &tls.Config{
+	tlsConn := tls.Server(clientConn, capture.RequireClientCertificates(&tls.Config{
 		Certificates: []tls.Certificate{*cert},
 		MinVersion:   tls.VersionTLS12,
 		NextProtos:   []string{"h2", "http/1.1"},
-	})
+	}, s.clientCAs))
 	if err := tlsConn.Handshake(); err != nil {
 		_ = tlsConn.Close()
 		return
 	}
-	destination := r.Host
+	s.serveDecrypted(tlsConn, destination)
+}
+
+// serveDecrypted answers the requests on a terminated TLS connection to
+// destination, over HTTP/2 when ALPN selected it. An empty destination keeps
+// each request's Host.
+func (s *StubProxy) serveDecrypted(tlsConn *tls.Conn, destination string) {
 	handler := http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
 		request.URL.Scheme = "https"
-		request.URL.Host = destination
-		request.Host = destination
+		if destination != "" {
+			request.Host = destination
+		}
+		request.URL.Host = request.Host
 		s.ServeHTTP(response, request)
 	})
 	server := &http.Server{
@@ -723,86 +935,72 @@ func (s *StubProxy) ServeTransparent(listener net.Listener) error {
 }
 
 func (s *StubProxy) handleTransparent(conn net.Conn) {
-	defer conn.Close()
-
 	tcpConn, ok := conn.(*net.TCPConn)
 	if !ok {
+		_ = conn.Close()
 		return
 	}
 
 	ip, port, err := originalDst(tcpConn)
 	if err != nil {
+		_ = conn.Close()
 		return
 	}
+	s.serveTransparentConn(conn, ip, port)
+}
 
-	reader := bufio.NewReader(conn)
-	req, err := http.ReadRequest(reader)
-	if err != nil {
+// serveTransparentConn dispatches a redirected connection to ip:port by the
+// first bytes the client sends: TLS is terminated with a leaf for the SNI
+// host, HTTP/1.x and h2c are answered by the stub handler, and anything else,
+// including protocols where the server speaks first, replays a captured TCP
+// stream or is refused with a divergence.
+func (s *StubProxy) serveTransparentConn(conn net.Conn, ip string, port int) {
+	dest := net.JoinHostPort(ip, strconv.Itoa(port))
+	conn, protocol := capture.SniffConn(conn)
+	switch {
+	case protocol == capture.ProtocolHTTP:
+		s.serveHTTPConn(conn, dest)
+	case protocol == capture.ProtocolTLS && s.tlsCA != nil:
+		s.serveTransparentTLS(conn)
+	case protocol == capture.ProtocolTLS:
 		s.recordObserved("UNKNOWN", "", ip, port)
-		return
-	}
-	_ = req.Body.Close()
-
-	host := req.Host
-	s.recordObserved(req.Method, host, ip, port)
-
-	idx := atomic.LoadInt64(&s.i)
-	atomic.AddInt64(&s.seen, 1)
-
-	if int(idx) >= len(s.events) {
-		msg := fmt.Sprintf("DIVERGENCE at outbound event index=%d why=unexpected_outbound_call", idx)
-		fmt.Fprintln(os.Stderr, msg)
-		s.mu.Lock()
-		s.divergenceReasons = append(s.divergenceReasons, msg)
-		s.unexpectedOutbound = true
-		s.mu.Unlock()
-		writeSimpleResponse(conn, http.StatusBadGateway)
-		return
-	}
-
-	expected := s.events[idx]
-	atomic.AddInt64(&s.i, 1)
-
-	dep := host
-	if dep == "" {
-		dep = fmt.Sprintf("%s:%d", ip, port)
+		s.refuseConn(conn, dest, protocol, "https_stub_disabled")
+	default:
+		s.serveOpaque(conn, dest, protocol)
 	}
+}
 
-	rule := inject.Match(depKeyFromHost(dep), s.rules)
-
-	if rule != nil && rule.Timeout > 0 {
-		time.Sleep(rule.Timeout)
-		writeSimpleResponse(conn, http.StatusGatewayTimeout)
-		return
-	}
-	if rule != nil && rule.AddLatency > 0 {
-		time.Sleep(rule.AddLatency)
-	}
-	if rule != nil && rule.RetryLimit >= 0 {
-		s.attemptsMu.Lock()
-		s.attempts[dep]++
-		attemptCount := s.attempts[dep]
-		s.attemptsMu.Unlock()
-		if attemptCount <= rule.RetryLimit {
-			writeSimpleResponse(conn, http.StatusBadGateway)
-			return
+// serveTransparentTLS terminates TLS with a leaf for the SNI host and routes
+// the decrypted requests by their Host. The handshake fails for clients
+// without SNI and for hosts HTTPS stubbing does not intercept.
+func (s *StubProxy) serveTransparentTLS(conn net.Conn) {
+	config := s.tlsCA.SNITLSConfig()
+	config.MinVersion = tls.VersionTLS12
+	config.NextProtos = []string{"h2", "http/1.1"}
+	tlsConn := tls.Server(conn, capture.RequireClientCertificates(config, s.clientCAs))
+	_ = tlsConn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := tlsConn.Handshake(); err != nil {
+		_ = tlsConn.Close()
+		dest := tlsConn.ConnectionState().ServerName
+		if dest == "" {
+			dest = conn.LocalAddr().String()
 		}
-	}
-
-	status := expected.Status
-	if status == 0 {
-		writeSimpleResponse(conn, http.StatusBadGateway)
+		s.recordObserved("UNKNOWN", dest, "", 0)
+		msg := fmt.Sprintf("DIVERGENCE at outbound event index=%d why=tls_handshake_failed got={dest=%s error=%v}", atomic.LoadInt64(&s.i), dest, err)
+		s.recordDivergence("tls_handshake_failed", msg, true)
 		return
 	}
-	writeSimpleResponse(conn, status)
+	_ = tlsConn.SetDeadline(time.Time{})
+	s.serveDecrypted(tlsConn, "")
 }
 
-func writeSimpleResponse(w io.Writer, status int) {
-	reason := http.StatusText(status)
-	if reason == "" {
-		reason = "Status"
-	}
-	fmt.Fprintf(w, "HTTP/1.1 %d %s\r\nContent-Length: 0\r\n\r\n", status, reason)
+// refuseConn closes a connection the stub cannot answer and records it as an
+// unexpected outbound call, so the client fails fast instead of hanging.
+func (s *StubProxy) refuseConn(conn net.Conn, dest, protocol, why string) {
+	_ = conn.Close()
+	s.metrics.observe(depKeyFromHost(dest), resultInvalid, 0)
+	msg := fmt.Sprintf("DIVERGENCE at outbound event index=%d why=%s got={dest=%s protocol=%s}", atomic.LoadInt64(&s.i), why, dest, protocol)
+	s.recordDivergence(why, msg, true)
 }
 
 func depKeyFromHost(host string) string {
@@ -853,6 +1051,8 @@ func (s *StubProxy) ForwardSuccess() int {
 }
 
 func (s *StubProxy) ExpectedCount() int {
+	s.matchMu.Lock()
+	defer s.matchMu.Unlock()
 	return len(s.events)
 }
 
@@ -864,30 +1064,73 @@ func (s *StubProxy) DivergenceReasons() []string {
 	return out
 }
 
+// maxTransitions bounds the transitions kept between resets; the oldest
+// are dropped first.
+const maxTransitions = 1000
+
+// Transition is one scenario state change, made by a served step or forced
+// through SetScenarioState.
+type Transition struct {
+	Scenario  string `json:"scenario"`
+	Partition string `json:"partition,omitempty"`
+	Step      string `json:"step,omitempty"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Forced    bool   `json:"forced,omitempty"`
+}
+
+func (s *StubProxy) recordTransition(transition Transition) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transitions = append(s.transitions, transition)
+	if len(s.transitions) > maxTransitions {
+		s.transitions = append([]Transition(nil), s.transitions[len(s.transitions)-maxTransitions:]...)
+	}
+}
+
+// Transitions returns the scenario state changes since the last Reset.
+func (s *StubProxy) Transitions() []Transition {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Transition(nil), s.transitions...)
+}
+
 func (s *StubProxy) UnexpectedOutbound() bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	return s.unexpectedOutbound
 }
 
-// Snapshot returns simulator counters and diagnostics without exposing any
-// captured payloads. The returned slices are detached from internal state.
+// Snapshot returns simulator counters and diagnostics. Near-miss values are
+// privacy-filtered rather than raw captured payloads. The returned slices are
+// detached from internal state.
 func (s *StubProxy) Snapshot() Snapshot {
 	return Snapshot{
 		Expected:    s.ExpectedCount(),
 		Observed:    s.ObservedCount(),
 		Divergences: s.DivergenceReasons(),
 		Unexpected:  s.UnexpectedOutbound(),
+		Recorded:    s.RecordedCount(),
+		NearMisses:  s.NearMisses(),
+		Transitions: s.Transitions(),
+		Revision:    s.Revision(),
 	}
 }
 
-// Close flushes the optional observed-event log. It is safe to call when no
-// observed log was configured.
+// Close flushes the optional observed-event and record-on-miss logs. It is
+// safe to call when neither was configured.
 func (s *StubProxy) Close() error {
-	if s == nil || s.observedLogger == nil {
+	if s == nil {
 		return nil
 	}
-	return s.observedLogger.Close()
+	var errs []error
+	if s.observedLogger != nil {
+		errs = append(errs, s.observedLogger.Close())
+	}
+	if s.passthrough != nil {
+		errs = append(errs, s.passthrough.ctx.Logger.Close())
+	}
+	return errors.Join(errs...)
 }
 
 var hopByHopHeaders = map[string]struct{}{
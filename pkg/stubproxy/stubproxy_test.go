@@ -3,19 +3,25 @@ package stubproxy
 import (
 	"bufio"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math/big"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
@@ -26,9 +32,13 @@ import (
 	"infernosim/pkg/event"
 	"infernosim/pkg/grpcsim"
 	"infernosim/pkg/matcher"
+	"infernosim/pkg/privacy"
 	"infernosim/pkg/scenario"
 	"infernosim/pkg/simtemplate"
 
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
@@ -119,6 +129,239 @@ func TestStubFanoutMatchesUnorderedCalls(t *testing.T) {
 	}
 }
 
+func TestStubMetricsCountResultsDivergencesAndTransitions(t *testing.T) {
+	path := writeOutboundFixture(t, event.Event{Type: "OutboundCall", Method: http.MethodGet, URL: "http://dependency.test/a", Status: 200})
+	stub, err := NewWithOptions(path, "", nil, Options{
+		Scenarios: []scenario.Config{{
+			Name: "session", InitialState: "new",
+			Steps: []scenario.Step{
+				{
+					Name: "start", State: "new", NextState: "ready",
+					Match:    matcher.Rule{Methods: []string{"POST"}, PathRegex: `^/start$`},
+					Response: scenario.Response{Status: 202},
+				},
+				{
+					Name: "read", State: "ready",
+					Match:    matcher.Rule{Methods: []string{"GET"}, PathRegex: `^/value$`},
+					Response: scenario.Response{Status: 200},
+				},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "http://dependency.test/a", nil),
+		httptest.NewRequest(http.MethodGet, "http://dependency.test/missing", nil),
+		httptest.NewRequest(http.MethodPost, "http://dependency.test/start", nil),
+	} {
+		stub.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	stub.Reset()
+
+	var exposition strings.Builder
+	if err := stub.Metrics().WriteText(&exposition); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`infernosim_stub_requests_total{dependency="dependency.test",result="matched"} 1`,
+		`infernosim_stub_requests_total{dependency="dependency.test",result="unmatched"} 1`,
+		`infernosim_stub_requests_total{dependency="dependency.test",result="scenario"} 1`,
+		`infernosim_stub_divergences_total{reason="no_matching_captured_call"} 1`,
+		`infernosim_scenario_transitions_total{scenario="session",from="new",to="ready"} 1`,
+		`infernosim_stub_response_duration_seconds_count{dependency="dependency.test",result="matched"} 1`,
+	} {
+		if !strings.Contains(exposition.String(), want) {
+			t.Fatalf("metrics missing %q:\n%s", want, exposition.String())
+		}
+	}
+}
+
+func TestStubRecordOnMissForwardsAndAppendsExchange(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"path":"` + r.URL.Path + `"}`))
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL + "/standin")
+
+	path := writeOutboundFixture(t, event.Event{Type: "OutboundCall", Method: http.MethodGet, URL: "http://dependency.test/a", Status: 200})
+	stub, err := NewWithOptions(path, "", nil, Options{
+		RecordOnMiss:         &RecordOnMiss{Upstream: upstreamURL},
+		CaptureSensitiveData: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	stub.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://dependency.test/missing?x=1", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"path":"/standin/missing"}` {
+		t.Fatalf("passthrough status=%d body=%q", rec.Code, rec.Body.String())
+	}
+	if got := stub.Snapshot(); got.Recorded != 1 || len(got.Divergences) != 0 || got.Unexpected {
+		t.Fatalf("snapshot after passthrough = %+v", got)
+	}
+	if err := stub.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := LoadOutboundEvents(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[1].URL != "http://dependency.test/missing?x=1" || !events[1].ResponseCaptured {
+		t.Fatalf("recorded events = %+v", events)
+	}
+
+	replay, err := New(path, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	replay.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://dependency.test/missing?x=1", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"path":"/standin/missing"}` {
+		t.Fatalf("recorded call did not replay: status=%d body=%q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestStubJournalRecordsOutcomesPrivacyFilteredAndBounded(t *testing.T) {
+	path := writeOutboundFixture(t, event.Event{ID: "pay-1", Type: "OutboundCall", Method: http.MethodPost, URL: "http://payments.test/pay", Status: 201})
+	stub, err := NewWithOptions(path, "", nil, Options{
+		Scenarios: []scenario.Config{{
+			Name: "flags", InitialState: "on",
+			Steps: []scenario.Step{{
+				Name: "read", State: "on",
+				Match:    matcher.Rule{PathRegex: `^/flags$`},
+				Response: scenario.Response{Status: 200},
+			}},
+		}},
+		Journal: JournalOptions{Size: 3},
+		Privacy: &privacy.Policy{CaptureBodies: true, JSONFields: []privacy.JSONRule{{Path: "$.card", Action: privacy.ActionRedact}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(method, target, body string) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		stub.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	send(http.MethodGet, "http://payments.test/ignored", "")
+	send(http.MethodPost, "http://payments.test/pay", `{"amount":42,"card":"4111"}`)
+	send(http.MethodGet, "http://flags.test/flags", "")
+	send(http.MethodPost, "http://payments.test/pay", `{"amount":7,"card":"4111"}`)
+
+	all, err := stub.Journal(JournalFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].Sequence != 2 {
+		t.Fatalf("journal is not bounded to the newest 3 entries: %+v", all)
+	}
+	paid := all[0]
+	if paid.Result != resultMatched || paid.EventIndex == nil || *paid.EventIndex != 0 || paid.EventID != "pay-1" {
+		t.Fatalf("matched entry = %+v", paid)
+	}
+	if paid.Headers.Get("Authorization") != "[REDACTED]" || strings.Contains(paid.Body, "4111") {
+		t.Fatalf("journal stored unfiltered data: headers=%v body=%s", paid.Headers, paid.Body)
+	}
+	if all[1].Scenario != "flags" || all[1].Step != "read" {
+		t.Fatalf("scenario entry = %+v", all[1])
+	}
+	if all[2].Result != resultUnmatched || all[2].MissReason != "no_matching_captured_call" {
+		t.Fatalf("miss entry = %+v", all[2])
+	}
+
+	amount, err := stub.Journal(JournalFilter{Rule: matcher.Rule{
+		Methods: []string{http.MethodPost}, PathRegex: `^/pay$`, JSONPathRegex: map[string]string{"$.amount": `^42$`},
+	}})
+	if err != nil || len(amount) != 1 || amount[0].Sequence != 2 {
+		t.Fatalf("JSONPath filter = %+v err=%v", amount, err)
+	}
+	stub.Reset()
+	if cleared, _ := stub.Journal(JournalFilter{}); len(cleared) != 0 {
+		t.Fatalf("reset kept %d journal entries", len(cleared))
+	}
+
+	// Without capture_bodies or sensitive capture only the body hash is kept.
+	for _, policy := range []*privacy.Policy{nil, {JSONFields: []privacy.JSONRule{{Path: "$.card", Action: privacy.ActionRedact}}}} {
+		hashed, err := NewWithOptions(path, "", nil, Options{Privacy: policy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashed.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "http://payments.test/pay", strings.NewReader(`{"amount":42,"card":"4111"}`)))
+		entries, _ := hashed.Journal(JournalFilter{})
+		if len(entries) != 1 || entries[0].Body != "" || entries[0].BodySha256 == "" {
+			t.Fatalf("journal kept a body without capture_bodies: %+v", entries)
+		}
+	}
+}
+
+func TestStubMissReturnsRankedPrivacyFilteredNearMisses(t *testing.T) {
+	capturedBody := base64.StdEncoding.EncodeToString([]byte(`{"amount":42,"card":"4111","note":"gift"}`))
+	path := writeOutboundFixture(t,
+		event.Event{ID: "list", Type: "OutboundCall", Method: http.MethodGet, URL: "http://payments.test/orders?page=1", Status: 200},
+		event.Event{ID: "pay", Type: "OutboundCall", Method: http.MethodPost, URL: "http://payments.test/pay?currency=EUR", Status: 201, BodyB64: capturedBody},
+	)
+	stub, err := NewWithOptions(path, "", nil, Options{
+		Matching: matcher.Config{Rules: []matcher.Rule{{
+			Methods:     []string{http.MethodPost},
+			PathRegex:   `^/pay$`,
+			HeaderRegex: map[string]string{"Authorization": `^Bearer live-`},
+			CompareJSON: true,
+		}}},
+		Privacy: &privacy.Policy{JSONFields: []privacy.JSONRule{{Path: "$.card", Action: privacy.ActionRedact}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "http://payments.test/pay?currency=USD", strings.NewReader(`{"amount":42,"card":"5500","note":"birthday"}`))
+	req.Header.Set("Authorization", "Bearer test-token")
+	rec := httptest.NewRecorder()
+	stub.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadGateway || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("miss status=%d content-type=%q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var response struct {
+		Reason     string                `json:"reason"`
+		NearMisses []matcher.Explanation `json:"near_misses"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Reason != "no_matching_captured_call" || len(response.NearMisses) != 2 || response.NearMisses[0].Index != 1 {
+		t.Fatalf("near misses are not ranked closest first: %+v", response)
+	}
+	diffs := map[string]matcher.FieldDiff{}
+	for _, diff := range response.NearMisses[0].Diffs {
+		diffs[diff.Field+" "+diff.Name] = diff
+	}
+	if got := diffs["query currency"]; got.Expected != "EUR" || got.Actual != "USD" {
+		t.Fatalf("query diff = %+v", got)
+	}
+	if got := diffs["header Authorization"]; !got.Regex || got.Expected != `^Bearer live-` || got.Actual != "[REDACTED]" {
+		t.Fatalf("credential header diff was not redacted: %+v", got)
+	}
+	if got := diffs["jsonpath $.card"]; got.Expected != "[REDACTED]" || got.Actual != "[REDACTED]" {
+		t.Fatalf("policy-covered JSON diff = %+v", got)
+	}
+	if got := diffs["jsonpath $.note"]; !strings.HasPrefix(got.Actual, "sha256:") || got.Actual == got.Expected {
+		t.Fatalf("body value was not fingerprinted: %+v", got)
+	}
+	if _, ok := diffs["jsonpath $.amount"]; ok || strings.Contains(rec.Body.String(), "birthday") || strings.Contains(rec.Body.String(), "test-token") {
+		t.Fatalf("near misses leak values or report equal fields: %s", rec.Body.String())
+	}
+	snapshot := stub.Snapshot()
+	if len(snapshot.NearMisses) != 1 || snapshot.NearMisses[0].URL != "http://payments.test/pay?currency=USD" || len(snapshot.NearMisses[0].Candidates) != 2 {
+		t.Fatalf("snapshot near misses = %+v", snapshot.NearMisses)
+	}
+	stub.Reset()
+	if got := stub.NearMisses(); len(got) != 0 {
+		t.Fatalf("reset kept near misses: %+v", got)
+	}
+}
+
 func TestStubAllowsMissingOutboundLog(t *testing.T) {
 	stub, err := New(filepath.Join(t.TempDir(), "missing.log"), "", nil)
 	if err != nil {
@@ -176,6 +419,531 @@ func TestNativeHTTPSResponseStubbing(t *testing.T) {
 	}
 }
 
+func TestTunnelPolicyReachesPrivateHostsOnlyWhenNamedExactly(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	path := writeOutboundFixture(t)
+	cases := []struct {
+		pattern      string
+		allowPrivate bool
+		want         int
+	}{
+		{pattern: "127.0.0.1", want: http.StatusOK},
+		{pattern: "*", want: http.StatusServiceUnavailable},
+		{pattern: "127.0.0.*", want: http.StatusServiceUnavailable},
+		{pattern: "*", allowPrivate: true, want: http.StatusOK},
+	}
+	for _, tc := range cases {
+		ca, err := capture.NewCAStoreAt(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ca.Hosts, err = capture.NewHostPolicies([]capture.HostPolicy{{Host: tc.pattern, Action: capture.HostTunnel}}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		stub, err := NewWithOptions(path, "", nil, Options{TLSCA: ca, AllowPrivateDestinations: tc.allowPrivate})
+		if err != nil {
+			t.Fatal(err)
+		}
+		proxy := httptest.NewServer(stub)
+		conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		dest := upstream.Addr().String()
+		if _, err := fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", dest, dest); err != nil {
+			t.Fatal(err)
+		}
+		response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.Close()
+		proxy.Close()
+		if response.StatusCode != tc.want {
+			t.Fatalf("tunnel policy %q (allow private %v): status=%d, want %d", tc.pattern, tc.allowPrivate, response.StatusCode, tc.want)
+		}
+	}
+}
+
+func TestHTTPSStubAppliesHostPatternsAndPolicies(t *testing.T) {
+	ca, err := capture.NewCAStoreAt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.AllowedHosts = []string{"*.dependency.test"}
+	ca.Hosts, err = capture.NewHostPolicies([]capture.HostPolicy{{Host: "blocked.dependency.test", Action: capture.HostBlock}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeOutboundFixture(t, event.Event{
+		Type:             "OutboundCall",
+		Method:           http.MethodGet,
+		URL:              "https://api.dependency.test/api/value",
+		Status:           http.StatusOK,
+		ResponseCaptured: true,
+		ResponseBodyB64:  base64.StdEncoding.EncodeToString([]byte("wildcard")),
+	})
+	stub, err := NewWithOptions(path, "", nil, Options{TLSCA: ca})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.ConfigureReplayCardinality(false, 1)
+	proxy := httptest.NewServer(stub)
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.CertificatePEM())
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12},
+	}}
+
+	response, err := client.Get("https://api.dependency.test/api/value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK || string(body) != "wildcard" {
+		t.Fatalf("status=%d body=%q", response.StatusCode, body)
+	}
+	if _, err := client.Get("https://blocked.dependency.test/api/value"); err == nil || !strings.Contains(err.Error(), "Forbidden") {
+		t.Fatalf("expected the blocked host to be refused, got %v", err)
+	}
+	if _, err := client.Get("https://dependency.test/api/value"); err == nil {
+		t.Fatal("expected the bare domain to fall outside *.dependency.test")
+	}
+}
+
+func TestStubServesSOCKS5Clients(t *testing.T) {
+	ca, err := capture.NewCAStoreAt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.AllowedHosts = []string{"*.dependency.test"}
+	ca.Hosts, err = capture.NewHostPolicies([]capture.HostPolicy{{Host: "blocked.dependency.test", Action: capture.HostBlock}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeOutboundFixture(t,
+		event.Event{
+			Type:             "OutboundCall",
+			Method:           http.MethodGet,
+			URL:              "http://plain.dependency.test/api/value",
+			Status:           http.StatusOK,
+			ResponseCaptured: true,
+			ResponseBodyB64:  base64.StdEncoding.EncodeToString([]byte("plain")),
+		},
+		event.Event{
+			Type:             "OutboundCall",
+			Method:           http.MethodGet,
+			URL:              "https://api.dependency.test/api/value",
+			Status:           http.StatusOK,
+			ResponseCaptured: true,
+			ResponseBodyB64:  base64.StdEncoding.EncodeToString([]byte("secure")),
+		},
+	)
+	stub, err := NewWithOptions(path, "", nil, Options{TLSCA: ca})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.ConfigureReplayCardinality(false, 2)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: stub.Handler()}
+	go func() { _ = server.Serve(capture.ListenSOCKS5(listener, stub.SOCKSConnect)) }()
+	defer server.Close()
+
+	proxyURL, _ := url.Parse("socks5://" + listener.Addr().String())
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.CertificatePEM())
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12},
+	}}
+	for _, want := range []struct{ url, body string }{
+		{"http://plain.dependency.test/api/value", "plain"},
+		{"https://api.dependency.test/api/value", "secure"},
+	} {
+		response, err := client.Get(want.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(response.Body)
+		_ = response.Body.Close()
+		if response.StatusCode != http.StatusOK || string(body) != want.body {
+			t.Fatalf("%s: status=%d body=%q", want.url, response.StatusCode, body)
+		}
+	}
+	if _, err := client.Get("https://blocked.dependency.test/api/value"); err == nil {
+		t.Fatal("expected the blocked host to be refused")
+	}
+	if stub.UnexpectedOutbound() {
+		t.Fatalf("divergences: %v", stub.DivergenceReasons())
+	}
+}
+
+func TestTransparentConnectionsAreDispatchedByProtocol(t *testing.T) {
+	ca, err := capture.NewCAStoreAt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.AllowedHosts = []string{"*.dependency.test"}
+	captured := func(url, body string) event.Event {
+		return event.Event{
+			Type:             "OutboundCall",
+			Method:           http.MethodGet,
+			URL:              url,
+			Status:           http.StatusOK,
+			ResponseCaptured: true,
+			ResponseBodyB64:  base64.StdEncoding.EncodeToString([]byte(body)),
+		}
+	}
+	path := writeOutboundFixture(t,
+		captured("http://plain.dependency.test/api/value", "http1"),
+		captured("http://h2c.dependency.test/api/value", "h2c"),
+		captured("https://api.dependency.test/api/value", "tls"),
+	)
+	stub, err := NewWithOptions(path, "", nil, Options{TLSCA: ca})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.ConfigureReplayCardinality(false, 3)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			// Stands in for SO_ORIGINAL_DST, which needs an iptables redirect.
+			go stub.serveTransparentConn(conn, "203.0.113.10", port)
+		}
+	}()
+	dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "tcp", listener.Addr().String())
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.CertificatePEM())
+
+	for _, tc := range []struct {
+		name   string
+		client *http.Client
+		url    string
+	}{
+		{"http1", &http.Client{Transport: &http.Transport{DialContext: dial}}, "http://plain.dependency.test/api/value"},
+		{"h2c", &http.Client{Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(ctx, network, addr)
+			},
+		}}, "http://h2c.dependency.test/api/value"},
+		{"tls", &http.Client{Transport: &http.Transport{
+			DialContext:       dial,
+			ForceAttemptHTTP2: true,
+			TLSClientConfig:   &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12},
+		}}, "https://api.dependency.test/api/value"},
+	} {
+		response, err := tc.client.Get(tc.url)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		body, _ := io.ReadAll(response.Body)
+		_ = response.Body.Close()
+		if response.StatusCode != http.StatusOK || string(body) != tc.name {
+			t.Fatalf("%s: status=%d body=%q", tc.name, response.StatusCode, body)
+		}
+	}
+	if stub.UnexpectedOutbound() {
+		t.Fatalf("divergences: %v", stub.DivergenceReasons())
+	}
+
+	// A client waiting for a server greeting must not hang.
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("read = %v, want the stub to close the connection", err)
+	}
+	reasons := stub.DivergenceReasons()
+	if len(reasons) != 1 || !strings.Contains(reasons[0], "why=unsupported_protocol") || !strings.Contains(reasons[0], "203.0.113.10") {
+		t.Fatalf("divergences = %v", reasons)
+	}
+}
+
+func TestStubReplaysTCPStreamsWithIgnoreMasks(t *testing.T) {
+	stream := func(request string) event.Event {
+		chunk := func(direction string, offset time.Duration, data string) event.StreamChunk {
+			return event.StreamChunk{Direction: direction, Offset: offset, Size: len(data), DataB64: base64.StdEncoding.EncodeToString([]byte(data))}
+		}
+		return event.Event{
+			Type: "TCPStream",
+			URL:  "tcp://ledger.internal:7000",
+			Chunks: []event.StreamChunk{
+				chunk(event.StreamServer, 0, "HELLO\n"),
+				chunk(event.StreamClient, time.Millisecond, request[:4]),
+				chunk(event.StreamClient, 2*time.Millisecond, request[4:]),
+				chunk(event.StreamServer, 3*time.Millisecond, "OK\n"),
+			},
+		}
+	}
+	path := writeOutboundFixture(t, stream("SEQ0001 BALANCE\n"), stream("SEQ0002 BALANCE\n"))
+	stub, err := NewWithOptions(path, "", nil, Options{StreamMasks: []StreamMask{{Host: "*.internal", Offset: 3, Length: 4}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stub.ExpectedCount() != 0 {
+		t.Fatalf("expected count = %d, want streams kept out of HTTP matching", stub.ExpectedCount())
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() { _ = stub.ServeTCP(listener, "ledger.internal:7000") }()
+
+	converse := func(request string) string {
+		t.Helper()
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		reader := bufio.NewReader(conn)
+		if greeting, _ := reader.ReadString('\n'); greeting != "HELLO\n" {
+			t.Fatalf("greeting = %q", greeting)
+		}
+		_, _ = io.WriteString(conn, request)
+		reply, _ := reader.ReadString('\n')
+		return reply
+	}
+	if reply := converse("SEQ9876 BALANCE\n"); reply != "OK\n" {
+		t.Fatalf("masked sequence number: reply = %q, divergences %v", reply, stub.DivergenceReasons())
+	}
+	if reply := converse("SEQ9876 TRANSFER\n"); reply != "" {
+		t.Fatalf("changed command: reply = %q, want the connection closed", reply)
+	}
+	reasons := stub.DivergenceReasons()
+	if len(reasons) != 1 || !strings.Contains(reasons[0], "why=tcp_stream_mismatch") || !strings.Contains(reasons[0], "offset=8") {
+		t.Fatalf("divergences = %v", reasons)
+	}
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("read = %v, want a closed connection once the streams are used", err)
+	}
+	_ = conn.Close()
+	if reasons := stub.DivergenceReasons(); !strings.Contains(reasons[len(reasons)-1], "why=unexpected_tcp_stream") {
+		t.Fatalf("divergences = %v", reasons)
+	}
+	stub.Reset()
+	if reply := converse("SEQ0001 BALANCE\n"); reply != "OK\n" {
+		t.Fatalf("after reset: reply = %q", reply)
+	}
+}
+
+func TestHTTP3ResponseStubbing(t *testing.T) {
+	ca, err := capture.NewCAStoreAt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.AllowedHosts = []string{"dependency.test"}
+	path := writeOutboundFixture(t, event.Event{
+		Type:             "OutboundCall",
+		Method:           http.MethodGet,
+		URL:              "https://dependency.test/api/value",
+		Protocol:         http3.NextProtoH3,
+		Status:           http.StatusOK,
+		ResponseCaptured: true,
+		ResponseHeaders:  http.Header{"Content-Type": {"application/json"}},
+		ResponseBodyB64:  base64.StdEncoding.EncodeToString([]byte(`{"quic":true}`)),
+	})
+	stub, err := NewWithOptions(path, "", nil, Options{TLSCA: ca})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.ConfigureReplayCardinality(false, 2)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewHTTP3Server(stub, ca, nil)
+	go func() { _ = server.Serve(conn) }()
+	defer server.Close()
+
+	rootPEM, err := os.ReadFile(ca.CertificatePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(rootPEM) {
+		t.Fatal("could not add InfernoSIM CA")
+	}
+	dial := func(ctx context.Context, _ string, tlsConfig *tls.Config, config *quic.Config) (*quic.Conn, error) {
+		return quic.DialAddrEarly(ctx, conn.LocalAddr().String(), tlsConfig, config)
+	}
+	transport := &http3.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}, Dial: dial}
+	defer transport.Close()
+	client := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+	response, err := client.Get("https://dependency.test/api/value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK || string(body) != `{"quic":true}` || response.ProtoMajor != 3 {
+		t.Fatalf("proto=%s status=%d body=%q", response.Proto, response.StatusCode, body)
+	}
+
+	// Hosts outside the allowlist get no certificate.
+	other := &http3.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}, Dial: dial}
+	defer other.Close()
+	if _, err := (&http.Client{Transport: other, Timeout: 10 * time.Second}).Get("https://other.test/api/value"); err == nil {
+		t.Fatal("expected handshake failure for a host outside the allowlist")
+	}
+}
+
+// issueClientCert returns a client certificate for commonName and uri signed
+// by issuer, or a self-signed CA when issuer is nil.
+func issueClientCert(t *testing.T, issuer *tls.Certificate, commonName, uri string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if uri != "" {
+		parsed, _ := url.Parse(uri)
+		template.URIs = []*url.URL{parsed}
+	}
+	parent, signer := template, any(key)
+	if issuer == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		parent, signer = issuer.Leaf, issuer.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestHTTPSStubRequiresClientCertificateAndMatchesIdentity(t *testing.T) {
+	ca, err := capture.NewCAStoreAt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.AllowedHosts = []string{"dependency.test"}
+	clientCA := issueClientCert(t, nil, "app clients", "")
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCA.Leaf)
+	path := writeOutboundFixture(t, event.Event{
+		Type:             "OutboundCall",
+		Method:           http.MethodGet,
+		URL:              "https://dependency.test/api/value",
+		ClientIdentity:   "spiffe://example.test/orders",
+		Status:           http.StatusOK,
+		ResponseCaptured: true,
+		ResponseBodyB64:  base64.StdEncoding.EncodeToString([]byte("orders-only")),
+	})
+	stub, err := NewWithOptions(path, "", nil, Options{
+		TLSCA:     ca,
+		ClientCAs: clientCAs,
+		Matching: matcher.Config{Rules: []matcher.Rule{{
+			PathRegex:           `^/api/value$`,
+			ClientIdentityRegex: `^spiffe://example\.test/orders$`,
+		}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.ConfigureReplayCardinality(true, 10)
+	proxy := httptest.NewServer(stub)
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	rootPEM, err := os.ReadFile(ca.CertificatePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(rootPEM)
+	get := func(certs ...tls.Certificate) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+		}}
+		return client.Get("https://dependency.test/api/value")
+	}
+
+	response, err := get(issueClientCert(t, &clientCA, "orders", "spiffe://example.test/orders"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(response.Body)
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK || string(body) != "orders-only" {
+		t.Fatalf("status=%d body=%q", response.StatusCode, body)
+	}
+	response, err = get(issueClientCert(t, &clientCA, "billing", "spiffe://example.test/billing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusBadGateway {
+		t.Fatalf("another identity status = %d, want 502", response.StatusCode)
+	}
+	if _, err := get(); err == nil {
+		t.Fatal("expected the stub to refuse a client without a certificate")
+	}
+
+	entries, err := stub.Journal(JournalFilter{Rule: matcher.Rule{ClientIdentityRegex: `billing$`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].ClientIdentity != "spiffe://example.test/billing" || entries[0].Result != resultUnmatched {
+		t.Fatalf("journal = %+v", entries)
+	}
+}
+
 func TestStubScenarioTakesPriorityAndTransitions(t *testing.T) {
 	stub, err := NewWithOptions(filepath.Join(t.TempDir(), "missing.log"), "", nil, Options{
 		Scenarios: []scenario.Config{{
@@ -245,6 +1013,191 @@ func TestScenarioRendersDeterministicDynamicResponse(t *testing.T) {
 	}
 }
 
+func TestPartitionedScenarioRendersCountersAndRecordsPartition(t *testing.T) {
+	stub, err := NewWithOptions(filepath.Join(t.TempDir(), "missing.log"), "", nil, Options{
+		Scenarios: []scenario.Config{{
+			Name: "refunds", InitialState: "open",
+			PartitionBy: &scenario.Partition{PathSegment: 2},
+			Steps: []scenario.Step{
+				{
+					State: "open", When: []scenario.Guard{{Counter: "attempts", Op: "<", Value: 1}},
+					Increment: map[string]int{"attempts": 1},
+					Match:     matcher.Rule{PathRegex: `^/refunds/[^/]+$`},
+					Response:  scenario.Response{Status: http.StatusAccepted, BodyTemplate: `{{ .Scenario.Partition }}:{{ counter "attempts" }}`},
+				},
+				{
+					State: "open", NextState: "done", Increment: map[string]int{"attempts": 1},
+					Match:    matcher.Rule{PathRegex: `^/refunds/[^/]+$`},
+					Response: scenario.Response{Status: http.StatusOK, BodyTemplate: `{{ .Scenario.State }}:{{ counter "attempts" }}`},
+				},
+				{State: "done", Match: matcher.Rule{PathRegex: `^/refunds/[^/]+$`}, Response: scenario.Response{Status: http.StatusConflict}},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"r-1:1", "done:2"} {
+		recorder := httptest.NewRecorder()
+		stub.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "http://dependency.test/refunds/r-1", nil))
+		if recorder.Body.String() != want {
+			t.Fatalf("body = %q, want %q", recorder.Body.String(), want)
+		}
+	}
+	transitions := stub.Transitions()
+	if len(transitions) != 1 || transitions[0].Partition != "r-1" || transitions[0].To != "done" {
+		t.Fatalf("transitions = %#v", transitions)
+	}
+	if got := stub.ScenarioPartitions()["refunds"]["r-1"].Counters["attempts"]; got != 2 {
+		t.Fatalf("attempts = %d", got)
+	}
+}
+
+func TestScenarioReusesVariableFromEarlierResponse(t *testing.T) {
+	stub, err := NewWithOptions(filepath.Join(t.TempDir(), "missing.log"), "", nil, Options{
+		Scenarios: []scenario.Config{{
+			Name: "orders", InitialState: "empty",
+			Steps: []scenario.Step{
+				{
+					State: "empty", NextState: "created",
+					Extract:  map[string]scenario.Extract{"order_id": {From: "response", JSONPath: "$.id"}},
+					Match:    matcher.Rule{Methods: []string{http.MethodPost}, PathRegex: `^/orders$`},
+					Response: scenario.Response{Status: http.StatusCreated, BodyTemplate: `{"id":"{{ uuid "order" }}"}`},
+				},
+				{
+					State:    "created",
+					Match:    matcher.Rule{Methods: []string{http.MethodGet}, PathRegex: `^/orders/`},
+					Response: scenario.Response{Status: http.StatusOK, BodyTemplate: `{"id":"{{ var "order_id" }}","status":"open"}`},
+				},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := httptest.NewRecorder()
+	stub.ServeHTTP(create, httptest.NewRequest(http.MethodPost, "http://dependency.test/orders", nil))
+	var created struct{ ID string }
+	if err := json.Unmarshal(create.Body.Bytes(), &created); err != nil || created.ID == "" {
+		t.Fatalf("create body %q: %v", create.Body.String(), err)
+	}
+	get := httptest.NewRecorder()
+	stub.ServeHTTP(get, httptest.NewRequest(http.MethodGet, "http://dependency.test/orders/"+created.ID, nil))
+	if want := `{"id":"` + created.ID + `","status":"open"}`; get.Body.String() != want {
+		t.Fatalf("get body = %q, want %q", get.Body.String(), want)
+	}
+}
+
+func TestWeightedResponsesAreDeterministicPerSeed(t *testing.T) {
+	newStub := func() *StubProxy {
+		t.Helper()
+		stub, err := NewWithOptions(filepath.Join(t.TempDir(), "missing.log"), "", nil, Options{
+			Templates: simtemplate.Config{Seed: "flaky"},
+			Scenarios: []scenario.Config{{
+				Name: "pricing", InitialState: "ready",
+				Steps: []scenario.Step{{
+					State:        "ready",
+					Match:        matcher.Rule{PathRegex: `^/price$`},
+					Response:     scenario.Response{Status: http.StatusOK},
+					Alternatives: []scenario.Alternative{{Weight: 30, Response: scenario.Response{Status: http.StatusServiceUnavailable, Body: "retry"}}},
+				}},
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := stub.PutExchange(event.Event{
+			ID: "quote", Method: http.MethodGet, URL: "http://dependency.test/quote", Status: http.StatusOK,
+			ResponseAlternatives: []event.ResponseAlternative{{Weight: 50, Status: http.StatusTooManyRequests}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		stub.ConfigureReplayCardinality(true, 1000)
+		return stub
+	}
+	statuses := func(stub *StubProxy, path string) []int {
+		var out []int
+		for range 40 {
+			recorder := httptest.NewRecorder()
+			stub.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://dependency.test"+path, nil))
+			out = append(out, recorder.Code)
+		}
+		return out
+	}
+	for _, path := range []string{"/price", "/quote"} {
+		first := newStub()
+		got := statuses(first, path)
+		if !slices.Contains(got, http.StatusOK) || slices.Equal(slices.Compact(slices.Clone(got)), []int{http.StatusOK}) {
+			t.Fatalf("%s: statuses do not mix primary and alternative: %v", path, got)
+		}
+		if again := statuses(newStub(), path); !slices.Equal(got, again) {
+			t.Fatalf("%s: same seed drew %v then %v", path, got, again)
+		}
+		first.Reset()
+		if afterReset := statuses(first, path); !slices.Equal(got, afterReset) {
+			t.Fatalf("%s: reset did not restart the draws: %v then %v", path, got, afterReset)
+		}
+	}
+	if err := ValidateExchange(event.Event{
+		Method: http.MethodGet, URL: "http://dependency.test/quote", Status: http.StatusOK,
+		ResponseAlternatives: []event.ResponseAlternative{{Weight: 60, Status: 503}, {Weight: 60, Status: 500}},
+	}); err == nil {
+		t.Fatal("expected weights above 100 to be rejected")
+	}
+	for _, alternative := range []event.ResponseAlternative{
+		{Weight: 0, Status: http.StatusOK},
+		{Weight: 50, Status: 700},
+		{Weight: 50, Status: http.StatusOK, ResponseBodyB64: "not base64!"},
+	} {
+		path := writeOutboundFixture(t,
+			event.Event{Type: "OutboundCall", Method: http.MethodGet, URL: "http://dependency.test/ok", Status: http.StatusOK},
+			event.Event{Type: "OutboundCall", Method: http.MethodGet, URL: "http://dependency.test/quote", Status: http.StatusOK,
+				ResponseAlternatives: []event.ResponseAlternative{alternative}},
+		)
+		if _, err := New(path, "", nil); err == nil || !strings.Contains(err.Error(), "outbound event 1: responseAlternatives[0]") {
+			t.Fatalf("alternative %+v: expected the outbound log to be rejected, got %v", alternative, err)
+		}
+	}
+}
+
+func TestStubReplaysCapturedAndSampledLatency(t *testing.T) {
+	newStub := func(latency LatencyOptions) *StubProxy {
+		t.Helper()
+		stub, err := NewWithOptions(filepath.Join(t.TempDir(), "missing.log"), "", nil, Options{Latency: latency})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, duration := range []time.Duration{40 * time.Millisecond, 80 * time.Millisecond} {
+			if _, _, err := stub.PutExchange(event.Event{Method: http.MethodGet, URL: "http://dependency.test/slow", Status: http.StatusOK, Duration: duration}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return stub
+	}
+	elapsed := func(stub *StubProxy) time.Duration {
+		start := time.Now()
+		recorder := httptest.NewRecorder()
+		stub.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://dependency.test/slow", nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("status = %d", recorder.Code)
+		}
+		return time.Since(start)
+	}
+	if got := elapsed(newStub(LatencyOptions{})); got >= 40*time.Millisecond {
+		t.Fatalf("latency replayed while off: %s", got)
+	}
+	if got := elapsed(newStub(LatencyOptions{Mode: LatencyCaptured, Scale: 0.5})); got < 20*time.Millisecond || got >= 40*time.Millisecond {
+		t.Fatalf("captured latency at scale 0.5 = %s, want about 20ms", got)
+	}
+	sampled := newStub(LatencyOptions{Mode: LatencySampled})
+	if got := elapsed(sampled); got < 40*time.Millisecond {
+		t.Fatalf("sampled latency = %s, want a captured duration", got)
+	}
+	if _, err := NewWithOptions(filepath.Join(t.TempDir(), "missing.log"), "", nil, Options{Latency: LatencyOptions{Mode: "random"}}); err == nil {
+		t.Fatal("expected an unknown latency mode to be rejected")
+	}
+}
+
 func TestScenarioSynthesizesDescriptorAwareGRPCResponse(t *testing.T) {
 	grpcConfig := grpcsim.Config{
 		ProtoFiles:  []string{filepath.Join("..", "..", "examples", "grpcapp", "echo", "echo.proto")},
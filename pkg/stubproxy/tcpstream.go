@@ -0,0 +1,266 @@
+package stubproxy
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"infernosim/pkg/capture"
+	"infernosim/pkg/event"
+)
+
+// streamReadTimeout bounds how long a replayed stream waits for the client's
+// next message.
+const streamReadTimeout = 30 * time.Second
+
+// StreamMask excludes a byte range of every client message in matching TCP
+// streams from comparison, for fields such as sequence numbers and
+// timestamps. A client message is the run of bytes the client sent before
+// the server's next reply.
+type StreamMask struct {
+	// Host is a host pattern for the stream destination, as in host
+	// policies. Empty matches every stream.
+	Host   string `yaml:"host" json:"host,omitempty"`
+	Offset int    `yaml:"offset" json:"offset"`
+	Length int    `yaml:"length" json:"length"`
+}
+
+// Validate reports masks that cannot select any bytes.
+func (m StreamMask) Validate() error {
+	if m.Host != "" {
+		if err := capture.ValidateHostPattern(m.Host); err != nil {
+			return err
+		}
+	}
+	if m.Offset < 0 || m.Length <= 0 {
+		return fmt.Errorf("stream mask needs offset >= 0 and length > 0")
+	}
+	return nil
+}
+
+// tcpStreams holds the captured TCP streams a stub replays, each at most
+// once per run.
+type tcpStreams struct {
+	mu      sync.Mutex
+	streams []event.Event
+	used    []bool
+	masks   []StreamMask
+}
+
+func newTCPStreams(outboundLog string, masks []StreamMask) (*tcpStreams, error) {
+	for _, mask := range masks {
+		if err := mask.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	streams, err := loadEvents(outboundLog, "TCPStream")
+	if err != nil {
+		return nil, err
+	}
+	return &tcpStreams{streams: streams, used: make([]bool, len(streams)), masks: masks}, nil
+}
+
+func (t *tcpStreams) reset() {
+	t.mu.Lock()
+	t.used = make([]bool, len(t.streams))
+	t.mu.Unlock()
+}
+
+func (t *tcpStreams) empty() bool {
+	return len(t.streams) == 0
+}
+
+// claim returns the first unused stream captured for dest, else the first
+// unused one captured for the same port, since a transparent replay sees
+// the resolved address where capture may have seen a name.
+func (t *tcpStreams) claim(dest string) (int, event.Event, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, port, _ := net.SplitHostPort(dest)
+	fallback := -1
+	for i, stream := range t.streams {
+		if t.used[i] {
+			continue
+		}
+		captured := strings.TrimPrefix(stream.URL, "tcp://")
+		if captured == dest {
+			t.used[i] = true
+			return i, stream, true
+		}
+		if _, capturedPort, err := net.SplitHostPort(captured); err == nil && capturedPort == port && fallback < 0 {
+			fallback = i
+		}
+	}
+	if fallback < 0 {
+		return 0, event.Event{}, false
+	}
+	t.used[fallback] = true
+	return fallback, t.streams[fallback], true
+}
+
+// masksFor returns the masks that apply to streams to dest.
+func (t *tcpStreams) masksFor(dest string) []StreamMask {
+	host, _, err := net.SplitHostPort(dest)
+	if err != nil {
+		host = dest
+	}
+	var out []StreamMask
+	for _, mask := range t.masks {
+		if mask.Host == "" || capture.MatchHost(mask.Host, host) {
+			out = append(out, mask)
+		}
+	}
+	return out
+}
+
+// streamTurn is a run of consecutive chunks from one side of a stream.
+type streamTurn struct {
+	direction string
+	data      []byte
+	// gap is the captured time between the previous turn's last chunk and
+	// this turn's first.
+	gap time.Duration
+}
+
+func streamTurns(stream event.Event) ([]streamTurn, error) {
+	if stream.BodyTruncated {
+		return nil, fmt.Errorf("stream was truncated during capture")
+	}
+	var turns []streamTurn
+	var last time.Duration
+	for _, chunk := range stream.Chunks {
+		if chunk.Size > 0 && chunk.DataB64 == "" {
+			return nil, fmt.Errorf("stream payload was not captured")
+		}
+		data, err := base64.StdEncoding.DecodeString(chunk.DataB64)
+		if err != nil {
+			return nil, fmt.Errorf("decode stream chunk: %w", err)
+		}
+		if n := len(turns); n > 0 && turns[n-1].direction == chunk.Direction {
+			turns[n-1].data = append(turns[n-1].data, data...)
+		} else {
+			turns = append(turns, streamTurn{direction: chunk.Direction, data: data, gap: chunk.Offset - last})
+		}
+		last = chunk.Offset
+	}
+	return turns, nil
+}
+
+// firstDifference returns the offset of the first byte of got that differs
+// from want outside masks, or -1 when they match.
+func firstDifference(want, got []byte, masks []StreamMask) int {
+	for i := range want {
+		if want[i] == got[i] {
+			continue
+		}
+		masked := false
+		for _, mask := range masks {
+			if i >= mask.Offset && i < mask.Offset+mask.Length {
+				masked = true
+				break
+			}
+		}
+		if !masked {
+			return i
+		}
+	}
+	return -1
+}
+
+// ServeTCP replays streams captured for dest, a host:port, to every
+// connection accepted on listener.
+func (s *StubProxy) ServeTCP(listener net.Listener, dest string) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveStream(conn, dest)
+	}
+}
+
+// serveOpaque replays a captured TCP stream to a connection whose protocol
+// the stub cannot parse, or refuses it when the incident has none.
+func (s *StubProxy) serveOpaque(conn net.Conn, dest, protocol string) {
+	if !s.streams.empty() {
+		s.serveStream(conn, dest)
+		return
+	}
+	s.recordObservedStream("UNKNOWN", dest)
+	s.refuseConn(conn, dest, protocol, "unsupported_protocol")
+}
+
+// serveStream plays the server side of the next stream captured for dest,
+// writing each server reply once the client has sent the bytes it sent
+// during capture. Any difference outside the masks ends the connection with
+// a divergence.
+func (s *StubProxy) serveStream(conn net.Conn, dest string) {
+	defer conn.Close()
+	start := time.Now()
+	dependency := depKeyFromHost(dest)
+	result := resultUnmatched
+	defer func() { s.metrics.observe(dependency, result, time.Since(start)) }()
+	s.recordObservedStream("TCP", dest)
+
+	index, stream, ok := s.streams.claim(dest)
+	if !ok {
+		s.recordDivergence("unexpected_tcp_stream", fmt.Sprintf("DIVERGENCE at tcp stream why=unexpected_tcp_stream got={dest=%s}", dest), true)
+		return
+	}
+	diverge := func(why, detail string) {
+		msg := fmt.Sprintf("DIVERGENCE at tcp stream index=%d why=%s expected={url=%s} got={dest=%s %s}", index, why, stream.URL, dest, detail)
+		s.recordDivergence(why, msg, true)
+	}
+	turns, err := streamTurns(stream)
+	if err != nil {
+		result = resultInvalid
+		diverge("tcp_stream_not_replayable", err.Error())
+		return
+	}
+	masks := s.streams.masksFor(dest)
+	message := 0
+	for _, turn := range turns {
+		if turn.direction == event.StreamServer {
+			if s.latency.enabled() && turn.gap > 0 {
+				scale := s.latency.Scale
+				if scale == 0 {
+					scale = 1
+				}
+				time.Sleep(time.Duration(float64(turn.gap) * scale))
+			}
+			if _, err := conn.Write(turn.data); err != nil {
+				return
+			}
+			continue
+		}
+		got := make([]byte, len(turn.data))
+		_ = conn.SetReadDeadline(time.Now().Add(streamReadTimeout))
+		n, err := io.ReadFull(conn, got)
+		if err != nil {
+			diverge("tcp_stream_mismatch", fmt.Sprintf("message=%d received=%d of %d bytes", message, n, len(turn.data)))
+			return
+		}
+		if at := firstDifference(turn.data, got, masks); at >= 0 {
+			diverge("tcp_stream_mismatch", fmt.Sprintf("message=%d offset=%d expected_byte=%#02x got_byte=%#02x", message, at, turn.data[at], got[at]))
+			return
+		}
+		message++
+	}
+	result = resultMatched
+}
+
+// recordObservedStream logs a raw TCP connection to dest in the observed log.
+func (s *StubProxy) recordObservedStream(method, dest string) {
+	host, portText, err := net.SplitHostPort(dest)
+	if err != nil {
+		s.recordObserved(method, dest, "", 0)
+		return
+	}
+	port, _ := strconv.Atoi(portText)
+	s.recordObserved(method, "", host, port)
+}
@@ -0,0 +1,81 @@
+package stubproxy
+
+import (
+	"net/http"
+
+	"infernosim/pkg/event"
+	"infernosim/pkg/scenario"
+	"infernosim/pkg/simtemplate"
+)
+
+// choose picks a weighted alternative, returning its 1-based index or 0 for
+// the primary response. Weights are percentages. The draw depends on the
+// template seed, the request and how many identical requests came before it
+// since the last reset, so a retry can draw differently while every run with
+// the same seed and traffic draws the same sequence.
+func (s *StubProxy) choose(label string, data simtemplate.Data, weights []int) int {
+	if len(weights) == 0 {
+		return 0
+	}
+	roll := s.draw(label, data, 100)
+	for index, weight := range weights {
+		if roll < weight {
+			return index + 1
+		}
+		roll -= weight
+	}
+	return 0
+}
+
+// draw returns a value in [0, n) that depends on the template seed, the
+// request, and how many identical requests drew under label before it since
+// the last reset.
+func (s *StubProxy) draw(label string, data simtemplate.Data, n int) int {
+	key := label + "\x00" + s.templates.Fingerprint(data)
+	s.mu.Lock()
+	if s.draws == nil {
+		s.draws = make(map[string]int)
+	}
+	occurrence := s.draws[key]
+	s.draws[key]++
+	s.mu.Unlock()
+	return s.templates.Draw(label, data, occurrence, n)
+}
+
+// chooseScenarioResponse replaces result.Response with the drawn alternative.
+func (s *StubProxy) chooseScenarioResponse(r *http.Request, body []byte, result *scenario.Result) int {
+	if len(result.Alternatives) == 0 {
+		return 0
+	}
+	weights := make([]int, 0, len(result.Alternatives))
+	for _, alternative := range result.Alternatives {
+		weights = append(weights, alternative.Weight)
+	}
+	choice := s.choose("scenario\x00"+result.Scenario+"\x00"+result.Step+"\x00"+result.FromState, s.templateData(r, body), weights)
+	if choice > 0 {
+		result.Response = result.Alternatives[choice-1].Response
+	}
+	return choice
+}
+
+// chooseCapturedResponse replaces the response fields of expected with the
+// drawn alternative.
+func (s *StubProxy) chooseCapturedResponse(r *http.Request, body []byte, expected *event.Event) int {
+	if len(expected.ResponseAlternatives) == 0 {
+		return 0
+	}
+	weights := make([]int, 0, len(expected.ResponseAlternatives))
+	for _, alternative := range expected.ResponseAlternatives {
+		weights = append(weights, alternative.Weight)
+	}
+	choice := s.choose("exchange\x00"+expected.ID, s.templateData(r, body), weights)
+	if choice > 0 {
+		alternative := expected.ResponseAlternatives[choice-1]
+		expected.Status = alternative.Status
+		expected.ResponseHeaders = alternative.ResponseHeaders
+		expected.ResponseTrailers = alternative.ResponseTrailers
+		expected.ResponseBodyB64 = alternative.ResponseBodyB64
+		expected.GrpcStatus = alternative.GrpcStatus
+	}
+	return choice
+}
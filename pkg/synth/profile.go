@@ -0,0 +1,196 @@
+package synth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"infernosim/pkg/event"
+	"infernosim/pkg/replaydriver"
+)
+
+// EndpointProfile is the learned schema of one endpoint's JSON bodies.
+type EndpointProfile struct {
+	// Endpoint is the method, host, and path with identifier segments
+	// replaced by {id}, such as "GET payments.internal/charges/{id}".
+	Endpoint string         `json:"endpoint"`
+	Calls    int            `json:"calls"`
+	Fields   []FieldProfile `json:"fields"`
+}
+
+// FieldProfile describes the values seen at one JSON path. Paths start
+// with "request" or "response", array elements are written [], and object
+// keys that look like identifiers are written {key}.
+type FieldProfile struct {
+	Path string `json:"path"`
+	// Kinds lists the JSON types seen: string, number, bool, or null.
+	Kinds []string `json:"kinds"`
+	// Count is how many values were seen and Distinct how many differed.
+	Count    int `json:"count"`
+	Distinct int `json:"distinct"`
+	// MinLength and MaxLength bound the length of string values.
+	MinLength int `json:"min_length,omitempty"`
+	MaxLength int `json:"max_length,omitempty"`
+}
+
+// LearnProfile learns per-endpoint schemas and value distributions from the
+// paired inbound requests and the outbound calls of the incident in dir.
+func LearnProfile(dir string) ([]EndpointProfile, error) {
+	b, err := replaydriver.OpenBundle(dir)
+	if err != nil {
+		return nil, err
+	}
+	calls, err := replaydriver.LoadInboundEvents(b.InboundLog)
+	if err != nil {
+		return nil, fmt.Errorf("load inbound log: %w", err)
+	}
+	if b.HasOutbound() {
+		outbound, err := readEvents(b.OutboundLog)
+		if err != nil {
+			return nil, fmt.Errorf("load outbound log: %w", err)
+		}
+		calls = append(calls, outbound...)
+	}
+
+	type fieldStats struct {
+		kinds                map[string]bool
+		count                int
+		values               map[string]bool
+		minLength, maxLength int
+	}
+	type endpointStats struct {
+		calls  int
+		fields map[string]*fieldStats
+	}
+	endpoints := make(map[string]*endpointStats)
+	for _, call := range calls {
+		if call.Method == "" {
+			continue
+		}
+		key := endpointKey(call)
+		stats := endpoints[key]
+		if stats == nil {
+			stats = &endpointStats{fields: make(map[string]*fieldStats)}
+			endpoints[key] = stats
+		}
+		stats.calls++
+		record := func(location, encoded string) {
+			data, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil || len(data) == 0 {
+				return
+			}
+			dec := json.NewDecoder(bytes.NewReader(data))
+			dec.UseNumber()
+			var value any
+			if dec.Decode(&value) != nil {
+				return
+			}
+			walkLeaves(value, location+" $", func(path, kind, leaf string) {
+				field := stats.fields[path]
+				if field == nil {
+					field = &fieldStats{kinds: make(map[string]bool), values: make(map[string]bool), minLength: -1}
+					stats.fields[path] = field
+				}
+				field.kinds[kind] = true
+				field.count++
+				field.values[leaf] = true
+				if kind == "string" {
+					if field.minLength < 0 || len(leaf) < field.minLength {
+						field.minLength = len(leaf)
+					}
+					field.maxLength = max(field.maxLength, len(leaf))
+				}
+			})
+		}
+		record("request", call.BodyB64)
+		record("response", call.ResponseBodyB64)
+	}
+
+	profiles := make([]EndpointProfile, 0, len(endpoints))
+	for key, stats := range endpoints {
+		profile := EndpointProfile{Endpoint: key, Calls: stats.calls}
+		for path, field := range stats.fields {
+			kinds := make([]string, 0, len(field.kinds))
+			for kind := range field.kinds {
+				kinds = append(kinds, kind)
+			}
+			sort.Strings(kinds)
+			profile.Fields = append(profile.Fields, FieldProfile{
+				Path:      path,
+				Kinds:     kinds,
+				Count:     field.count,
+				Distinct:  len(field.values),
+				MinLength: max(field.minLength, 0),
+				MaxLength: field.maxLength,
+			})
+		}
+		sort.Slice(profile.Fields, func(i, j int) bool { return profile.Fields[i].Path < profile.Fields[j].Path })
+		profiles = append(profiles, profile)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Endpoint < profiles[j].Endpoint })
+	return profiles, nil
+}
+
+func endpointKey(e event.Event) string {
+	host, path := "", e.URL
+	if u, err := url.Parse(e.URL); err == nil {
+		host, path = u.Host, u.Path
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if identifier(segment) {
+			segments[i] = "{id}"
+		}
+	}
+	return e.Method + " " + host + strings.Join(segments, "/")
+}
+
+func walkLeaves(value any, path string, leaf func(path, kind, value string)) {
+	switch typed := value.(type) {
+	case map[string]any:
+		for key, child := range typed {
+			if identifier(key) {
+				key = "{key}"
+			}
+			walkLeaves(child, path+"."+key, leaf)
+		}
+	case []any:
+		for _, child := range typed {
+			walkLeaves(child, path+"[]", leaf)
+		}
+	case string:
+		leaf(path, "string", typed)
+	case json.Number:
+		leaf(path, "number", normalizeNumber(typed))
+	case bool:
+		leaf(path, "bool", fmt.Sprint(typed))
+	case nil:
+		leaf(path, "null", "null")
+	}
+}
+
+// compareProfiles reports the first endpoint or field whose structure or
+// cardinality differs. String lengths may differ, as a fabricated word
+// grows when every word of its shape was captured.
+func compareProfiles(captured, synthetic []EndpointProfile) error {
+	if len(captured) != len(synthetic) {
+		return fmt.Errorf("%d endpoints, want %d", len(synthetic), len(captured))
+	}
+	for i, want := range captured {
+		got := synthetic[i]
+		if got.Endpoint != want.Endpoint || got.Calls != want.Calls || len(got.Fields) != len(want.Fields) {
+			return fmt.Errorf("endpoint %s: got %s with %d calls and %d fields, want %d calls and %d fields", want.Endpoint, got.Endpoint, got.Calls, len(got.Fields), want.Calls, len(want.Fields))
+		}
+		for j, field := range want.Fields {
+			other := got.Fields[j]
+			if other.Path != field.Path || strings.Join(other.Kinds, ",") != strings.Join(field.Kinds, ",") || other.Count != field.Count || other.Distinct != field.Distinct {
+				return fmt.Errorf("endpoint %s field %s: got %+v, want %+v", want.Endpoint, field.Path, other, field)
+			}
+		}
+	}
+	return nil
+}
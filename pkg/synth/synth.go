@@ -0,0 +1,660 @@
+// Package synth fabricates shareable incidents from captured ones. A
+// synthetic incident keeps the captured structure, endpoint shapes,
+// cardinalities, and timing, while every captured value is replaced by a
+// keyed pseudonym of the same shape, so it can leave a privacy boundary that
+// real incidents, even redacted ones, cannot.
+package synth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"infernosim/pkg/event"
+	"infernosim/pkg/message"
+	"infernosim/pkg/privacy"
+	"infernosim/pkg/replaydriver"
+)
+
+// Options configures Synthesize.
+type Options struct {
+	// Tokenizer derives the fabricated values. The same key fabricates the
+	// same incident.
+	Tokenizer *privacy.Tokenizer
+}
+
+// Result reports what Synthesize wrote.
+type Result struct {
+	Inbound  int
+	Outbound int
+	Messages int
+	// Endpoints is the schema learned from the synthetic incident, which was
+	// checked to match the captured one under the fabricated names.
+	Endpoints []EndpointProfile
+	// Words counts the distinct captured words replaced.
+	Words int
+	// Shift is how far every timestamp moved.
+	Shift time.Duration
+}
+
+// structuralHeaders describe message framing rather than content and are
+// kept as captured.
+var structuralHeaders = map[string]bool{
+	"Accept":            true,
+	"Accept-Encoding":   true,
+	"Accept-Language":   true,
+	"Cache-Control":     true,
+	"Connection":        true,
+	"Content-Encoding":  true,
+	"Content-Type":      true,
+	"Transfer-Encoding": true,
+	"Vary":              true,
+}
+
+// Synthesize writes a synthetic incident to dst fabricated from the incident
+// in src. Words of hosts, URL paths and queries, header values, JSON keys and
+// values, bodies, errors, message topics and keys, and IDs are replaced
+// through privacy.Pseudonyms; RFC3339
+// timestamps, in bodies and on events alike, move by one keyed shift; and
+// resolved addresses map to the 198.18.0.0/15 benchmarking range. Binary
+// bodies and raw TCP payloads are dropped. replay.yaml is not carried over,
+// as its matchers and responses may quote captured values.
+func Synthesize(src, dst string, opts Options) (Result, error) {
+	if opts.Tokenizer == nil {
+		return Result{}, fmt.Errorf("synthesis requires a tokenizer")
+	}
+	if _, err := os.Stat(filepath.Join(dst, "inbound.log")); err == nil {
+		return Result{}, fmt.Errorf("%s already holds an incident", dst)
+	}
+	b, err := replaydriver.OpenBundle(src)
+	if err != nil {
+		return Result{}, err
+	}
+	inbound, err := readEvents(b.InboundLog)
+	if err != nil {
+		return Result{}, fmt.Errorf("load inbound log: %w", err)
+	}
+	var outbound []event.Event
+	if b.HasOutbound() {
+		if outbound, err = readEvents(b.OutboundLog); err != nil {
+			return Result{}, fmt.Errorf("load outbound log: %w", err)
+		}
+	}
+	messagesPath := filepath.Join(src, "messages.log")
+	var records []message.Record
+	hasMessages := false
+	if _, err := os.Stat(messagesPath); err == nil {
+		hasMessages = true
+		if records, err = message.Load(messagesPath); err != nil {
+			return Result{}, fmt.Errorf("load message log: %w", err)
+		}
+	}
+	var metadata *replaydriver.IncidentMetadata
+	if _, err := os.Stat(b.MetadataPath); err == nil {
+		m, err := b.ReadMetadata()
+		if err != nil {
+			return Result{}, err
+		}
+		metadata = &m
+	}
+
+	s := &synthesizer{
+		words:     privacy.NewPseudonyms(opts.Tokenizer),
+		observing: true,
+		strings:   make(map[string]bool),
+		times:     make(map[string]time.Time),
+		addresses: make(map[string]string),
+	}
+	// The first pass only observes, so that no fabricated word can collide
+	// with a captured word seen later.
+	s.rewrite(inbound, outbound, records, metadata)
+	s.observing = false
+	s.shift = s.chooseShift(opts.Tokenizer)
+	inbound, outbound, records, metadata = s.rewrite(inbound, outbound, records, metadata)
+
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return Result{}, err
+	}
+	if err := writeEvents(filepath.Join(dst, "inbound.log"), inbound); err != nil {
+		return Result{}, err
+	}
+	if b.HasOutbound() {
+		if err := writeEvents(filepath.Join(dst, "outbound.log"), outbound); err != nil {
+			return Result{}, err
+		}
+	}
+	if hasMessages {
+		logger, err := message.NewLogger(filepath.Join(dst, "messages.log"))
+		if err != nil {
+			return Result{}, err
+		}
+		for _, record := range records {
+			if err := logger.Write(record); err != nil {
+				_ = logger.Close()
+				return Result{}, err
+			}
+		}
+		if err := logger.Close(); err != nil {
+			return Result{}, err
+		}
+	}
+	if metadata != nil {
+		if err := replaydriver.WriteMetadata(dst, *metadata); err != nil {
+			return Result{}, err
+		}
+	}
+
+	captured, err := LearnProfile(src)
+	if err != nil {
+		return Result{}, err
+	}
+	synthetic, err := LearnProfile(dst)
+	if err != nil {
+		return Result{}, err
+	}
+	if err := compareProfiles(s.profiles(captured), synthetic); err != nil {
+		return Result{}, fmt.Errorf("synthetic incident diverges from the captured schema: %w", err)
+	}
+	result := Result{Outbound: len(outbound), Messages: len(records), Endpoints: synthetic, Words: s.words.Len(), Shift: s.shift}
+	for _, e := range inbound {
+		if e.Type == "InboundRequest" {
+			result.Inbound++
+		}
+	}
+	return result, nil
+}
+
+// synthesizer rewrites captured values. While observing it records them
+// and changes nothing; afterwards it fabricates.
+type synthesizer struct {
+	words     *privacy.Pseudonyms
+	observing bool
+	// strings holds every captured string, so that shifted timestamps can
+	// be checked against them.
+	strings   map[string]bool
+	times     map[string]time.Time
+	shift     time.Duration
+	addresses map[string]string
+}
+
+func (s *synthesizer) rewrite(inbound, outbound []event.Event, records []message.Record, metadata *replaydriver.IncidentMetadata) ([]event.Event, []event.Event, []message.Record, *replaydriver.IncidentMetadata) {
+	inbound = s.events(inbound)
+	outbound = s.events(outbound)
+	out := make([]message.Record, len(records))
+	for i, record := range records {
+		out[i] = s.message(record)
+	}
+	if metadata != nil {
+		m := *metadata
+		m.CapturedAt = s.time(m.CapturedAt)
+		m.Env = s.text(m.Env)
+		m.Host = s.text(m.Host)
+		m.Listen = s.hostPort(m.Listen)
+		m.Forward = s.url(m.Forward)
+		metadata = &m
+	}
+	return inbound, outbound, out, metadata
+}
+
+func (s *synthesizer) events(events []event.Event) []event.Event {
+	out := make([]event.Event, len(events))
+	for i, e := range events {
+		out[i] = s.event(e)
+	}
+	return out
+}
+
+func (s *synthesizer) event(e event.Event) event.Event {
+	e.ID = s.text(e.ID)
+	e.TraceID = s.text(e.TraceID)
+	e.Timestamp = s.time(e.Timestamp)
+	e.Service = s.hostPort(e.Service)
+	e.URL = s.url(e.URL)
+	e.Error = s.text(e.Error)
+	e.ClientIdentity = s.text(e.ClientIdentity)
+	e.GrpcServiceMethod = s.text(e.GrpcServiceMethod)
+	e.InjectionApplied = s.text(e.InjectionApplied)
+	e.Headers = s.headers(e.Headers)
+	e.ResponseHeaders = s.headers(e.ResponseHeaders)
+	e.ResponseTrailers = s.headers(e.ResponseTrailers)
+	if !e.BodyRedacted {
+		e.BodyB64, e.BodySha256, e.BodyRedacted = s.body(e.BodyB64, e.BodySha256)
+		if e.BodySize > 0 {
+			e.BodySize = int64(decodedLen(e.BodyB64))
+		}
+	}
+	if !e.ResponseBodyRedacted {
+		e.ResponseBodyB64, e.ResponseBodySha256, e.ResponseBodyRedacted = s.body(e.ResponseBodyB64, e.ResponseBodySha256)
+	}
+	if len(e.ResponseAlternatives) > 0 {
+		alternatives := make([]event.ResponseAlternative, len(e.ResponseAlternatives))
+		for i, alt := range e.ResponseAlternatives {
+			alt.ResponseHeaders = s.headers(alt.ResponseHeaders)
+			alt.ResponseTrailers = s.headers(alt.ResponseTrailers)
+			alt.ResponseBodyB64, _, _ = s.body(alt.ResponseBodyB64, "")
+			alternatives[i] = alt
+		}
+		e.ResponseAlternatives = alternatives
+	}
+	if len(e.Addresses) > 0 {
+		addresses := make([]string, len(e.Addresses))
+		for i, address := range e.Addresses {
+			addresses[i] = s.address(address)
+		}
+		e.Addresses = addresses
+	}
+	if len(e.Chunks) > 0 && !s.observing {
+		chunks := make([]event.StreamChunk, len(e.Chunks))
+		for i, chunk := range e.Chunks {
+			chunk.DataB64, chunk.Sha256 = "", ""
+			chunks[i] = chunk
+		}
+		e.Chunks = chunks
+	}
+	return e
+}
+
+func (s *synthesizer) message(record message.Record) message.Record {
+	record.ID = s.text(record.ID)
+	record.Timestamp = s.time(record.Timestamp)
+	record.Broker = s.hostPort(record.Broker)
+	record.Topic = s.text(record.Topic)
+	record.CorrelationID = s.text(record.CorrelationID)
+	record.Schema = s.text(record.Schema)
+	var dropped bool
+	record.KeyB64, dropped = s.bytes(record.KeyB64)
+	record.Redacted = record.Redacted || dropped
+	if len(record.Headers) > 0 {
+		headers := make([]message.Header, len(record.Headers))
+		for i, header := range record.Headers {
+			header.ValueB64, dropped = s.bytes(header.ValueB64)
+			record.Redacted = record.Redacted || dropped
+			headers[i] = header
+		}
+		record.Headers = headers
+	}
+	payload, _ := base64.StdEncoding.DecodeString(record.PayloadB64)
+	fabricated, dropped := s.payload(payload)
+	record.Redacted = record.Redacted || dropped
+	record.PayloadB64 = base64.StdEncoding.EncodeToString(fabricated)
+	record.PayloadSHA256 = message.SHA256(fabricated)
+	return record
+}
+
+// profiles renames the endpoints and field paths of captured profiles as
+// their hosts, path segments, and JSON keys were renamed in the synthetic
+// incident, so the two can be compared.
+func (s *synthesizer) profiles(captured []EndpointProfile) []EndpointProfile {
+	out := make([]EndpointProfile, len(captured))
+	for i, profile := range captured {
+		method, target, _ := strings.Cut(profile.Endpoint, " ")
+		host, path := target, ""
+		if slash := strings.IndexByte(target, '/'); slash >= 0 {
+			host, path = target[:slash], target[slash:]
+		}
+		profile.Endpoint = method + " " + s.hostPort(host) + s.path(path)
+		fields := make([]FieldProfile, len(profile.Fields))
+		for j, field := range profile.Fields {
+			location, jsonPath, _ := strings.Cut(field.Path, " ")
+			parts := strings.Split(jsonPath, ".")
+			for k, part := range parts {
+				name := strings.TrimRight(part, "[]")
+				if name != "$" && name != "{key}" {
+					parts[k] = s.text(name) + part[len(name):]
+				}
+			}
+			field.Path = location + " " + strings.Join(parts, ".")
+			fields[j] = field
+		}
+		sort.Slice(fields, func(a, b int) bool { return fields[a].Path < fields[b].Path })
+		profile.Fields = fields
+		out[i] = profile
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].Endpoint < out[b].Endpoint })
+	return out
+}
+
+// text replaces the words of a captured string.
+func (s *synthesizer) text(value string) string {
+	if value == "" {
+		return ""
+	}
+	if s.observing {
+		s.words.Observe(value)
+		s.strings[value] = true
+		return value
+	}
+	return s.words.Replace(value)
+}
+
+func (s *synthesizer) time(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return t.Add(s.shift)
+}
+
+// chooseShift derives a shift of more than a year from the key, moving it
+// on while a shifted timestamp would spell a captured string.
+func (s *synthesizer) chooseShift(t *privacy.Tokenizer) time.Duration {
+	seed, _ := strconv.ParseUint(strings.TrimPrefix(t.Token("synthesis-shift"), "tok_")[:8], 16, 64)
+	shift := time.Duration(400+seed%365)*24*time.Hour + time.Duration(1+seed/365%86399)*time.Second
+	for {
+		collides := false
+		for layout, captured := range s.times {
+			if s.strings[captured.Add(shift).Format(layoutOf(layout))] {
+				collides = true
+				break
+			}
+		}
+		if !collides {
+			return shift
+		}
+		shift += 24*time.Hour + time.Second
+	}
+}
+
+// timestamp shifts a string that parses as an RFC3339 timestamp or a date.
+func (s *synthesizer) timestamp(value string) (string, bool) {
+	for _, layout := range []string{time.RFC3339Nano, time.DateOnly} {
+		t, err := time.Parse(layout, value)
+		if err != nil {
+			continue
+		}
+		if s.observing {
+			s.times[layout+"|"+value] = t
+			s.strings[value] = true
+			return value, true
+		}
+		return t.Add(s.shift).Format(layout), true
+	}
+	return "", false
+}
+
+func layoutOf(key string) string {
+	layout, _, _ := strings.Cut(key, "|")
+	return layout
+}
+
+// url keeps the scheme and the path and query structure, and replaces the
+// host, every path segment, query names and values, and the fragment.
+func (s *synthesizer) url(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return s.text(raw)
+	}
+	u.User = nil
+	u.Host = s.hostPort(u.Host)
+	u.Path = s.path(u.Path)
+	u.RawPath = ""
+	if u.RawQuery != "" {
+		query := make(url.Values)
+		for name, values := range u.Query() {
+			fabricated := make([]string, len(values))
+			for i, value := range values {
+				fabricated[i] = s.text(value)
+			}
+			query[s.text(name)] = fabricated
+		}
+		u.RawQuery = query.Encode()
+	}
+	u.Fragment = s.text(u.Fragment)
+	u.RawFragment = ""
+	return u.String()
+}
+
+// path replaces the words of every segment of a URL path, keeping the
+// {id} placeholders of learned endpoints.
+func (s *synthesizer) path(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if segment != "{id}" {
+			segments[i] = s.text(segment)
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// hostPort replaces a host name, or maps an IP address like a resolved
+// one, and keeps the port so that listeners stay valid.
+func (s *synthesizer) hostPort(value string) string {
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		host, port = value, ""
+	}
+	switch {
+	case host == "":
+	case net.ParseIP(host) != nil:
+		host = s.address(host)
+	default:
+		host = s.text(host)
+	}
+	if port == "" {
+		return host
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// identifier reports whether a path segment or JSON key looks like data
+// rather than the name of a route or field: it holds a digit or is
+// token-length. Learned profiles write such segments and keys as {id} and
+// {key}.
+func identifier(segment string) bool {
+	return len(segment) >= 20 || strings.IndexFunc(segment, unicode.IsDigit) >= 0
+}
+
+func (s *synthesizer) headers(headers map[string][]string) map[string][]string {
+	if headers == nil {
+		return nil
+	}
+	out := make(map[string][]string, len(headers))
+	for name, values := range headers {
+		canonical := http.CanonicalHeaderKey(name)
+		if canonical == "Content-Length" && !s.observing {
+			continue
+		}
+		if structuralHeaders[canonical] {
+			out[name] = values
+			continue
+		}
+		fabricated := make([]string, len(values))
+		for i, value := range values {
+			// The scheme of a credential is kept so bearer tokens are still
+			// recognised as dependencies.
+			if canonical == "Authorization" || canonical == "Proxy-Authorization" {
+				if scheme, credential, ok := strings.Cut(value, " "); ok {
+					fabricated[i] = scheme + " " + s.text(credential)
+					continue
+				}
+			}
+			fabricated[i] = s.text(value)
+		}
+		out[name] = fabricated
+	}
+	return out
+}
+
+// body fabricates a base64 body, returning its hash when the captured one
+// had a hash, and whether it was dropped.
+func (s *synthesizer) body(encoded, hash string) (string, string, bool) {
+	if encoded == "" {
+		return "", hash, false
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", true
+	}
+	fabricated, dropped := s.payload(data)
+	if dropped {
+		return "", "", true
+	}
+	if hash != "" {
+		sum := sha256.Sum256(fabricated)
+		hash = hex.EncodeToString(sum[:])
+	}
+	return base64.StdEncoding.EncodeToString(fabricated), hash, false
+}
+
+// bytes fabricates a base64 text value such as a message key, dropping
+// binary ones.
+func (s *synthesizer) bytes(encoded string) (string, bool) {
+	if encoded == "" {
+		return "", false
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || !utf8.Valid(data) {
+		return "", true
+	}
+	return base64.StdEncoding.EncodeToString([]byte(s.text(string(data)))), false
+}
+
+// payload fabricates a JSON document value by value, replaces the words of
+// other text, and drops binary data.
+func (s *synthesizer) payload(data []byte) ([]byte, bool) {
+	if len(data) == 0 {
+		return data, false
+	}
+	if json.Valid(data) {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var value any
+		if err := dec.Decode(&value); err == nil {
+			var out bytes.Buffer
+			enc := json.NewEncoder(&out)
+			enc.SetEscapeHTML(false)
+			if err := enc.Encode(s.json(value)); err == nil {
+				if s.observing {
+					return data, false
+				}
+				return bytes.TrimSuffix(out.Bytes(), []byte("\n")), false
+			}
+		}
+	}
+	if !utf8.Valid(data) {
+		return nil, true
+	}
+	return []byte(s.text(string(data))), false
+}
+
+// json fabricates the object keys and leaves of a decoded document;
+// booleans and nulls carry nothing identifying and are kept.
+func (s *synthesizer) json(value any) any {
+	switch typed := value.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(typed))
+		for key, child := range typed {
+			out[s.text(key)] = s.json(child)
+		}
+		return out
+	case []any:
+		out := make([]any, len(typed))
+		for i, child := range typed {
+			out[i] = s.json(child)
+		}
+		return out
+	case string:
+		if shifted, ok := s.timestamp(typed); ok {
+			return shifted
+		}
+		return s.text(typed)
+	case json.Number:
+		return json.Number(s.text(normalizeNumber(typed)))
+	default:
+		return typed
+	}
+}
+
+// normalizeNumber writes exponents out so that a number's words are all
+// digits and fabricated digits keep it a valid number.
+func normalizeNumber(n json.Number) string {
+	literal := n.String()
+	if !strings.ContainsAny(literal, "eE") {
+		return literal
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return literal
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// address maps each resolved address to its own address in 198.18.0.0/15,
+// skipping any that was captured.
+func (s *synthesizer) address(address string) string {
+	if s.observing {
+		s.strings[address] = true
+		return address
+	}
+	if fabricated, ok := s.addresses[address]; ok {
+		return fabricated
+	}
+	for n := len(s.addresses) + 1; ; n++ {
+		candidate := net.IPv4(198, 18+byte(n>>16&1), byte(n>>8), byte(n)).String()
+		if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+			candidate = fmt.Sprintf("2001:2::%x", n)
+		}
+		if !s.strings[candidate] && !fabricatedAddress(s.addresses, candidate) {
+			s.addresses[address] = candidate
+			return candidate
+		}
+	}
+}
+
+func fabricatedAddress(addresses map[string]string, candidate string) bool {
+	for _, fabricated := range addresses {
+		if fabricated == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+func decodedLen(encoded string) int {
+	data, _ := base64.StdEncoding.DecodeString(encoded)
+	return len(data)
+}
+
+func readEvents(path string) ([]event.Event, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var events []event.Event
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var e event.Event
+		if err := dec.Decode(&e); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+func writeEvents(path string, events []event.Event) error {
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, out.Bytes(), 0o600)
+}
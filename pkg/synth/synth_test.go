@@ -0,0 +1,186 @@
+package synth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+
+	"infernosim/pkg/event"
+	"infernosim/pkg/message"
+	"infernosim/pkg/privacy"
+	"infernosim/pkg/replaydriver"
+)
+
+func writeLog(t *testing.T, path string, events []event.Event) {
+	t.Helper()
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	for i, e := range events {
+		e.Sequence = int64(i + 1)
+		if err := enc.Encode(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(path, out.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func b64(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+func TestSynthesizeFabricatesEveryCapturedValue(t *testing.T) {
+	src := t.TempDir()
+	base := time.Date(2026, 3, 4, 14, 2, 0, 0, time.UTC)
+	at := func(ms int) time.Time { return base.Add(time.Duration(ms) * time.Millisecond) }
+	jsonType := map[string][]string{"Content-Type": {"application/json"}}
+	loginTrace, orderTrace := "0123456789abcdef0123456789abcdef", "fedcba9876543210fedcba9876543210"
+	writeLog(t, filepath.Join(src, "inbound.log"), []event.Event{
+		{ID: "a1", Type: "InboundRequest", TraceID: loginTrace, Timestamp: at(0), Method: "POST", URL: "/login", Headers: jsonType, BodyB64: b64(`{"user":"alice@example.com","password":"hunter2"}`)},
+		{ID: "a2", Type: "InboundResponse", TraceID: loginTrace, Timestamp: at(40), Status: 200, Headers: jsonType, BodyB64: b64(`{"token":"tok-abc123","expires":"2026-03-04T15:00:00Z","ttl":3600}`)},
+		{ID: "a3", Type: "InboundRequest", TraceID: orderTrace, Timestamp: at(250), Method: "GET", URL: "/orders/98765?tenant=acme", Headers: map[string][]string{"Authorization": {"Bearer tok-abc123"}}},
+		{ID: "a4", Type: "InboundResponse", TraceID: orderTrace, Timestamp: at(300), Status: 200, Headers: jsonType, BodyB64: b64(`{"id":98765,"items":[{"sku":"SKU-1","qty":2,"price":12.5},{"sku":"SKU-2","qty":1,"price":3}],"paid":true,"note":null}`)},
+		{ID: "a5", Type: "InboundRequest", TraceID: "profileTrace", Timestamp: at(500), Method: "GET", URL: "/users/alice/profile"},
+		{ID: "a6", Type: "InboundResponse", TraceID: "profileTrace", Timestamp: at(520), Status: 502, Headers: jsonType, BodyB64: b64(`{"reason":"upstream unavailable"}`)},
+	})
+	writeLog(t, filepath.Join(src, "outbound.log"), []event.Event{
+		{ID: "b1", Type: "OutboundCall", Timestamp: at(260), Service: "inventory.internal", Method: "GET", URL: "http://inventory.internal/stock/SKU-1?warehouse=berlin-7", Headers: map[string][]string{"X-Inferno-Traceid": {orderTrace}}, Status: 200, ResponseCaptured: true, ResponseHeaders: jsonType, ResponseBodyB64: b64(`{"available":5}`)},
+		{ID: "b2", Type: "OutboundCall", Timestamp: at(505), Service: "10.44.3.7:443", Method: "GET", URL: "https://10.44.3.7:443/avatars/alice", Error: "dial tcp 10.44.3.7:443: connection refused by alice"},
+	})
+	logger, err := message.NewLogger(filepath.Join(src, "messages.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := message.New("orders", []byte("98765"), []byte(`{"order":98765,"email":"alice@example.com"}`), nil)
+	record.Timestamp = at(280)
+	if err := logger.Write(record); err != nil {
+		t.Fatal(err)
+	}
+	if err := logger.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := replaydriver.WriteMetadata(src, replaydriver.IncidentMetadata{CapturedAt: base, Env: "staging-eu", Host: "prod-node-17", Listen: ":8080", Forward: "http://orders-api.internal:9000", InboundCount: 3, OutboundCount: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	tokenizer, err := privacy.NewTokenizer([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(t.TempDir(), "synthetic")
+	result, err := Synthesize(src, dst, Options{Tokenizer: tokenizer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Inbound != 3 || result.Outbound != 2 || result.Messages != 1 || len(result.Endpoints) != 5 || result.Shift < 365*24*time.Hour {
+		t.Fatalf("result = %+v", result)
+	}
+
+	inbound, err := replaydriver.LoadInboundEvents(filepath.Join(dst, "inbound.log"))
+	if err != nil || len(inbound) != 3 {
+		t.Fatalf("inbound = %+v, %v", inbound, err)
+	}
+	outbound, err := readEvents(filepath.Join(dst, "outbound.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := message.Load(filepath.Join(dst, "messages.log"))
+	if err != nil || len(records) != 1 {
+		t.Fatalf("messages = %+v, %v", records, err)
+	}
+	var text strings.Builder
+	files, _ := os.ReadDir(dst)
+	for _, file := range files {
+		data, _ := os.ReadFile(filepath.Join(dst, file.Name()))
+		text.Write(data)
+		text.WriteByte('\n')
+	}
+	for _, e := range append(inbound, outbound...) {
+		for _, encoded := range []string{e.BodyB64, e.ResponseBodyB64} {
+			body, _ := base64.StdEncoding.DecodeString(encoded)
+			text.Write(body)
+		}
+	}
+	key, _ := records[0].Key()
+	payload, _ := records[0].Payload()
+	text.Write(key)
+	text.WriteByte('\n')
+	text.Write(payload)
+	for _, captured := range []string{"2026-03-04T15:00:00Z", "10.44.3.7", loginTrace, orderTrace} {
+		if strings.Contains(text.String(), captured) {
+			t.Fatalf("captured value %q appears in the synthetic incident", captured)
+		}
+	}
+	// Every word of a captured value, from hosts and path segments to JSON
+	// keys, errors, and metadata, must be fabricated.
+	written := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(text.String(), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		written[word] = true
+	}
+	for _, captured := range []string{
+		"alice", "example", "hunter2", "abc123", "98765", "acme", "SKU", "berlin", "prod", "node",
+		"users", "profile", "login", "orders", "stock", "avatars", "inventory", "internal", "warehouse", "tenant",
+		"user", "password", "token", "expires", "ttl", "items", "sku", "qty", "price", "paid", "note", "available",
+		"order", "email", "reason", "upstream", "unavailable", "dial", "tcp", "connection", "refused",
+		"staging", "api", "profileTrace",
+	} {
+		if written[captured] {
+			t.Fatalf("captured word %q appears in the synthetic incident", captured)
+		}
+	}
+
+	if got, want := inbound[1].Timestamp.Sub(inbound[0].Timestamp), 250*time.Millisecond; got != want {
+		t.Fatalf("request gap = %s, want %s", got, want)
+	}
+	fields := func(e event.Event) map[string]any {
+		body, _ := base64.StdEncoding.DecodeString(e.ResponseBodyB64)
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		var fields map[string]any
+		if err := dec.Decode(&fields); err != nil {
+			t.Fatalf("synthetic body %s: %v", body, err)
+		}
+		return fields
+	}
+	credential := strings.TrimPrefix(inbound[1].Headers["Authorization"][0], "Bearer ")
+	if !slices.Contains(slices.Collect(maps.Values(fields(inbound[0]))), any(credential)) {
+		t.Fatalf("bearer token %q is not the fabricated login token in %+v", credential, fields(inbound[0]))
+	}
+	var id json.Number
+	var items []any
+	var paid bool
+	for _, value := range fields(inbound[1]) {
+		switch typed := value.(type) {
+		case json.Number:
+			id = typed
+		case []any:
+			items = typed
+		case bool:
+			paid = typed
+		}
+	}
+	segments := strings.Split(strings.Split(inbound[1].URL, "?")[0], "/")
+	if segments[len(segments)-1] != id.String() || !paid || len(items) != 2 {
+		t.Fatalf("order %+v does not match URL %s", fields(inbound[1]), inbound[1].URL)
+	}
+	if outbound[1].Service != strings.TrimPrefix(outbound[1].URL, "https://")[:len(outbound[1].Service)] || !strings.HasSuffix(outbound[1].Service, ":443") {
+		t.Fatalf("service %s does not match URL %s", outbound[1].Service, outbound[1].URL)
+	}
+
+	again := filepath.Join(t.TempDir(), "again")
+	if _, err := Synthesize(src, again, Options{Tokenizer: tokenizer}); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := os.ReadFile(filepath.Join(dst, "inbound.log"))
+	second, _ := os.ReadFile(filepath.Join(again, "inbound.log"))
+	if !bytes.Equal(first, second) {
+		t.Fatal("the same key fabricated different incidents")
+	}
+}